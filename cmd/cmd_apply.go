@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"wci/internal"
+	"wci/utils"
+)
+
+var (
+	applyStrategy   string
+	applyOnConflict string
+	applyDryRun     bool
+	applySet        []string
+	applyValues     string
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply [number] [script...]",
+	Short: "Inject several scripts into the selected savegame at once",
+	Long: `Injects the given scripts of the catalogue (e.g. 'biter_killer', see 'wci scripts list') into the selected savegame ZIP file based on the
+savegame number obtained from the 'list' command. Scripts declared with '-- @depends' in a script header are
+injected too, and every script is placed after the scripts it depends on or lists with '-- @after'.
+All scripts are applied in memory and the savegame is written once; if any script fails, nothing is written.
+Scripts that are already injected are skipped. --set key=value and --values file.yaml fill in script parameters;
+a key may be qualified with a script name (--set biter_killer.radius=500) to address one script only.
+--dry-run prints a diff of the changes instead of writing.`,
+	Args: cobra.MinimumNArgs(2), // Requires the savegame number and at least one script
+	Run: func(cmd *cobra.Command, args []string) {
+		saveGameZipPath, err := resolveListedSaveGame(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+
+		strategy, err := utils.ParseInjectionStrategy(applyStrategy)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+
+		onConflict, err := utils.ParseEventConflictMode(applyOnConflict)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+
+		params, err := parseParamFlags(applySet, applyValues)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+
+		catalogue, err := loadScriptCatalogue()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+		printShadowNotes(catalogue, args[1:])
+
+		options := utils.InjectOptions{Strategy: strategy, EventConflicts: onConflict, DryRun: dryRunOutput(applyDryRun), Params: params}
+		results, err := internal.InjectScripts(currentOS, saveGameZipPath, catalogue, args[1:], options)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\nThe savegame '%s' was not changed.\n", err, saveGameZipPath)
+			os.Exit(1)
+		}
+
+		printInjectedScripts(results)
+		if applyDryRun {
+			printDryRunNotice(saveGameZipPath)
+			return
+		}
+		fmt.Printf("Successfully applied %d script(s) to '%s'.\n", len(results), saveGameZipPath)
+	},
+}
+
+// printInjectedScripts lists the scripts in the order they were injected, each with the variant picked for the
+// save's Factorio version, its lint warnings and what the migration to that version could not migrate.
+func printInjectedScripts(results []utils.InjectedScript) {
+	for i, result := range results {
+		note := ""
+		switch {
+		case result.Skipped:
+			note = " (already injected, skipped)"
+		case result.Dependency:
+			note = " (dependency)"
+		}
+		fmt.Printf("  %d. %s v%s -> %s%s\n", i+1, result.Name, result.Version, result.File, note)
+		if result.Variant != "" {
+			fmt.Printf("     variant %s for the save's Factorio version\n", result.Variant)
+		}
+		for _, finding := range result.Findings {
+			fmt.Printf("     %s\n", finding)
+		}
+		if result.Migration != nil {
+			fmt.Printf("     migrated to the save's Factorio version, %d renames\n", len(result.Migration.Rewritten))
+			for _, note := range result.Migration.Unmigrated {
+				fmt.Printf("     not migrated: %s\n", note)
+			}
+		}
+	}
+}
+
+func init() {
+	applyCmd.Flags().StringVar(&applyStrategy, "strategy", string(utils.StrategyAppend), "Injection strategy: append, require or event_handler")
+	applyCmd.Flags().StringVar(&applyOnConflict, "on-conflict", string(utils.EventConflictRefuse), "Handling of clashing event registrations: refuse or chain")
+	applyCmd.Flags().BoolVar(&applyDryRun, "dry-run", false, "Print a diff of the changes without writing the savegame")
+	applyCmd.Flags().StringArrayVar(&applySet, "set", nil, "Set a script parameter (key=value or script.key=value), repeatable")
+	applyCmd.Flags().StringVar(&applyValues, "values", "", "YAML file with script parameter values")
+	rootCmd.AddCommand(applyCmd)
+}
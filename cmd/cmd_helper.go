@@ -3,7 +3,11 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"wci/internal"
+	"wci/utils"
 )
 
 // saveListedSaveGames saves the listedSaveGames map to a file
@@ -42,3 +46,132 @@ func loadListedSaveGames() error {
 
 	return nil
 }
+
+// resolveListedSaveGame maps a savegame number from the 'list' command to its ZIP file name.
+func resolveListedSaveGame(arg string) (string, error) {
+	// Ensure savegames were listed before this command
+	if len(listedSaveGames) == 0 {
+		return "", fmt.Errorf("no savegames listed, run 'wci list' first")
+	}
+
+	// Parse the input number
+	var saveGameNumber int
+	if _, err := fmt.Sscanf(arg, "%d", &saveGameNumber); err != nil {
+		return "", fmt.Errorf("invalid savegame number '%s', please provide a valid number", arg)
+	}
+
+	// Validate the savegame number
+	saveGamePath, exists := listedSaveGames[saveGameNumber]
+	if !exists {
+		return "", fmt.Errorf("savegame number '%d' not found, run 'wci list' to see available savegames", saveGameNumber)
+	}
+
+	return saveGamePath, nil
+}
+
+// selectSaveGames resolves savegame numbers from the 'list' command, or every listed savegame when all is set.
+func selectSaveGames(args []string, all bool) ([]string, error) {
+	if all {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("savegame numbers cannot be combined with --all")
+		}
+		if len(listedSaveGames) == 0 {
+			return nil, fmt.Errorf("no savegames listed, run 'wci list' first")
+		}
+		numbers := make([]int, 0, len(listedSaveGames))
+		for number := range listedSaveGames {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+
+		saveGames := make([]string, 0, len(numbers))
+		for _, number := range numbers {
+			saveGames = append(saveGames, listedSaveGames[number])
+		}
+		return saveGames, nil
+	}
+
+	if len(args) == 0 {
+		return nil, fmt.Errorf("provide at least one savegame number or use --all")
+	}
+	saveGames := make([]string, 0, len(args))
+	for _, arg := range args {
+		saveGame, err := resolveListedSaveGame(arg)
+		if err != nil {
+			return nil, err
+		}
+		saveGames = append(saveGames, saveGame)
+	}
+	return saveGames, nil
+}
+
+// parseAnchorFlags builds the anchor selected by the --before, --after or --replace flags.
+// It returns nil when none of them is set.
+func parseAnchorFlags(before, after, replace string) (*utils.Anchor, error) {
+	switch {
+	case before != "":
+		return utils.NewAnchor(utils.AnchorBefore, before)
+	case after != "":
+		return utils.NewAnchor(utils.AnchorAfter, after)
+	case replace != "":
+		return utils.NewAnchor(utils.AnchorReplace, replace)
+	}
+	return nil, nil
+}
+
+// dryRunOutput returns the writer that receives the change preview of a --dry-run, or nil to write the savegame.
+func dryRunOutput(dryRun bool) io.Writer {
+	if dryRun {
+		return os.Stdout
+	}
+	return nil
+}
+
+// printDryRunNotice tells the user that a dry run left the savegame untouched.
+func printDryRunNotice(saveGameZipPath string) {
+	fmt.Printf("Dry run: '%s' was not modified.\n", saveGameZipPath)
+}
+
+// parseParamFlags collects script parameter values from a --values YAML file and --set assignments.
+// Values given with --set override the file.
+func parseParamFlags(assignments []string, valuesFile string) (map[string]any, error) {
+	values := make(map[string]any)
+	if valuesFile != "" {
+		fileValues, err := utils.LoadParamValues(valuesFile)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range fileValues {
+			values[key] = value
+		}
+	}
+
+	setValues, err := utils.ParseParamAssignments(assignments)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range setValues {
+		values[key] = value
+	}
+	return values, nil
+}
+
+// loadScriptCatalogue builds the script catalogue from the given files, the --script-dir flags, WCI_SCRIPT_PATH,
+// the user's script directory and the embedded scripts.
+func loadScriptCatalogue(files ...string) (*utils.ScriptCatalogue, error) {
+	return internal.LoadScriptCatalogue(scriptDirs, files...)
+}
+
+// printShadowNotes tells the user which scripts hide a script of the same name from a lower-precedence source.
+func printShadowNotes(catalogue *utils.ScriptCatalogue, scriptNames []string) {
+	for _, scriptName := range scriptNames {
+		for _, shadowed := range catalogue.ShadowsOf(utils.ScriptNameFromFile(scriptName)) {
+			fmt.Fprintf(os.Stderr, "Note: using '%s' from %s, which shadows %s.\n", shadowed.Name, shadowed.ShadowedBy, shadowed.Location)
+		}
+	}
+}
+
+// loadConfig reads the configuration file given with --config, WCI_CONFIG or the user's default.
+func loadConfig() (*utils.Config, error) {
+	return utils.LoadConfig(configPath)
+}
@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"wci/internal"
+	"wci/utils"
+)
+
+var (
+	injectFile       string
+	injectAllSaves   bool
+	injectStrategy   string
+	injectOnConflict string
+	injectRenames    []string
+	injectTarget     string
+	injectBefore     string
+	injectAfter      string
+	injectReplace    string
+	injectSet        []string
+	injectValues     string
+	injectDryRun     bool
+	injectOutput     string
+)
+
+// injectReport is the outcome of injecting a script into one savegame, as printed by --output json.
+type injectReport struct {
+	SaveGame string                 `json:"savegame"`
+	Scripts  []utils.InjectedScript `json:"scripts,omitempty"`
+	DryRun   bool                   `json:"dryRun,omitempty"`
+	Diff     string                 `json:"diff,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+}
+
+var injectCmd = &cobra.Command{
+	Use:   "inject [script] [number...]",
+	Short: "Inject a script of the catalogue or a local Lua file into the selected savegames",
+	Long: `Injects a script into the selected savegame ZIP files based on the savegame numbers obtained from the 'list'
+command, or into every listed savegame with --all. Each savegame is changed on its own: a failure leaves that
+savegame untouched, is reported and makes the command exit with status 1 after the other savegames are done.
+The script is looked up by name in the catalogue (see 'wci scripts list'): the --script-dir directories first,
+then the WCI_SCRIPT_PATH directories, ~/.config/wci/scripts and finally the scripts embedded into wci.
+--file ./my.lua injects a local file instead; it takes precedence over every catalogue script of the same name and
+may depend on catalogue scripts. A note is printed when the injected script shadows another one.
+
+Every script takes the same flags:
+  --strategy append (default) appends the script to 'control.lua'; require writes it to 'wci/<script>.lua' and
+  loads it with a single require line; event_handler packages it as an event_handler lib registered with add_lib.
+  --on-conflict chain routes event registrations that clash with control.lua through a dispatcher calling both
+  handlers instead of refusing.
+  --rename-command old=new registers a console command of the script under another name.
+  --target picks another file of the save (e.g. scenario/freeplay.lua) and --before, --after or --replace place
+  the block at the single line matched by a regular expression instead of the end of the file.
+  --set key=value and --values file.yaml fill in the script's '-- @param' parameters (see 'wci scripts show').
+  --dry-run prints a diff of the changes instead of writing the savegames.
+  --output json prints the result of every savegame as JSON, including the diff of a dry run.`,
+	Example: `  wci inject biter_killer 1 3
+  wci inject biter_killer --all --set radius=256 --dry-run
+  wci inject --file ./my_script.lua 2`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if injectFile == "" && len(args) == 0 {
+			return fmt.Errorf("provide the name of the script to inject or use --file")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if injectFile != "" {
+			runInject(utils.ScriptNameFromFile(injectFile), []string{injectFile}, args)
+			return
+		}
+		runInject(args[0], nil, args[1:])
+	},
+}
+
+// runInject injects one script into every selected savegame with the options given by the inject flags.
+func runInject(scriptName string, files []string, saveArgs []string) {
+	if injectOutput != "table" && injectOutput != "json" {
+		fmt.Fprintf(os.Stderr, "Error: unknown output format '%s' (supported: table, json).\n", injectOutput)
+		os.Exit(1)
+	}
+
+	saveGames, err := selectSaveGames(saveArgs, injectAllSaves)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+		os.Exit(1)
+	}
+
+	options, err := injectOptionsFromFlags()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+		os.Exit(1)
+	}
+
+	catalogue, err := loadScriptCatalogue(files...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+		os.Exit(1)
+	}
+	if _, err := catalogue.Find(scriptName); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+		os.Exit(1)
+	}
+	printShadowNotes(catalogue, []string{scriptName})
+
+	failed := false
+	reports := make([]injectReport, 0, len(saveGames))
+	for _, saveGame := range saveGames {
+		report := injectReport{SaveGame: saveGame, DryRun: injectDryRun}
+		var diff bytes.Buffer
+		if injectDryRun {
+			options.DryRun = io.Writer(os.Stdout)
+			if injectOutput == "json" {
+				options.DryRun = &diff
+			}
+		}
+
+		results, err := internal.InjectScripts(currentOS, saveGame, catalogue, []string{scriptName}, options)
+		report.Scripts, report.Diff = results, diff.String()
+		if err != nil {
+			failed = true
+			report.Error = err.Error()
+		}
+		reports = append(reports, report)
+
+		if injectOutput == "table" {
+			printInjectReport(scriptName, report)
+		}
+	}
+
+	if injectOutput == "json" {
+		printJSON(reports)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// injectOptionsFromFlags builds the injection options shared by every script from the inject flags.
+// The dry-run writer is left to the caller.
+func injectOptionsFromFlags() (utils.InjectOptions, error) {
+	strategy, err := utils.ParseInjectionStrategy(injectStrategy)
+	if err != nil {
+		return utils.InjectOptions{}, err
+	}
+	onConflict, err := utils.ParseEventConflictMode(injectOnConflict)
+	if err != nil {
+		return utils.InjectOptions{}, err
+	}
+	renames, err := utils.ParseCommandRenames(injectRenames)
+	if err != nil {
+		return utils.InjectOptions{}, err
+	}
+	anchor, err := parseAnchorFlags(injectBefore, injectAfter, injectReplace)
+	if err != nil {
+		return utils.InjectOptions{}, err
+	}
+	params, err := parseParamFlags(injectSet, injectValues)
+	if err != nil {
+		return utils.InjectOptions{}, err
+	}
+
+	return utils.InjectOptions{
+		Strategy:       strategy,
+		EventConflicts: onConflict,
+		CommandRenames: renames,
+		Target:         injectTarget,
+		Anchor:         anchor,
+		Params:         params,
+	}, nil
+}
+
+// printInjectReport prints the outcome of injecting a script into one savegame.
+func printInjectReport(scriptName string, report injectReport) {
+	if report.Error != "" {
+		fmt.Fprintf(os.Stderr, "Error: %s\nThe savegame '%s' was not changed.\n", report.Error, report.SaveGame)
+		return
+	}
+
+	fmt.Printf("%s:\n", report.SaveGame)
+	printInjectedScripts(report.Scripts)
+	if report.DryRun {
+		printDryRunNotice(report.SaveGame)
+		return
+	}
+	fmt.Printf("Successfully injected '%s' into '%s'.\n", scriptName, report.SaveGame)
+}
+
+// addInjectFlags registers the flags every script is injected with on an inject command.
+func addInjectFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&injectAllSaves, "all", false, "Inject into every savegame from the last 'list' output")
+	cmd.Flags().StringVar(&injectStrategy, "strategy", string(utils.StrategyAppend), "Injection strategy: append, require or event_handler")
+	cmd.Flags().StringVar(&injectOnConflict, "on-conflict", string(utils.EventConflictRefuse), "Handling of clashing event registrations: refuse or chain")
+	cmd.Flags().StringArrayVar(&injectRenames, "rename-command", nil, "Register a console command under a new name (old=new), repeatable")
+	cmd.Flags().StringVar(&injectTarget, "target", "", "Path of the file inside the save to inject into (default control.lua)")
+	cmd.Flags().StringVar(&injectBefore, "before", "", "Insert before the line matched by this regular expression")
+	cmd.Flags().StringVar(&injectAfter, "after", "", "Insert after the line matched by this regular expression")
+	cmd.Flags().StringVar(&injectReplace, "replace", "", "Replace the lines matched by this regular expression")
+	cmd.Flags().StringArrayVar(&injectSet, "set", nil, "Set a script parameter (key=value), repeatable")
+	cmd.Flags().StringVar(&injectValues, "values", "", "YAML file with script parameter values")
+	cmd.Flags().BoolVar(&injectDryRun, "dry-run", false, "Print a diff of the changes without writing the savegames")
+	cmd.Flags().StringVarP(&injectOutput, "output", "o", "table", "Output format: table or json")
+	cmd.MarkFlagsMutuallyExclusive("before", "after", "replace")
+}
+
+func init() {
+	injectCmd.Flags().StringVar(&injectFile, "file", "", "Inject this local Lua file instead of a catalogue script")
+	addInjectFlags(injectCmd)
+	rootCmd.AddCommand(injectCmd)
+}
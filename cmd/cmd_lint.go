@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"wci/lua"
+	"wci/utils"
+)
+
+var (
+	lintRules    string
+	lintFactorio string
+)
+
+var lintCmd = &cobra.Command{
+	Use:   "lint [file|script]...",
+	Short: "Check Lua scripts for syntax errors, desyncs, slow code and Factorio API misuse",
+	Long: `Parses Lua files, script package directories or scripts of the catalogue with the Lua 5.2 grammar Factorio
+uses and checks them with the rules selected by --rules, a comma separated list of groups and rule IDs:
+
+  desync  desync-upvalue    (error)   a file-level local is changed in a function instead of kept in storage
+          desync-on-load    (error)   on_load changes storage or uses game
+          desync-pairs      (warning) pairs iterates a table kept outside storage
+  perf    perf-tick-search  (warning) an on_tick handler searches the map
+          perf-concat-loop  (info)    a string is built with '..' in a loop
+  api     api-event         (error)   defines.events names an event the Factorio versions do not have
+          api-method        (error)   a LuaSurface or LuaForce method the Factorio versions do not have is called
+          api-filter        (error)   a find_entities_filtered style filter has a key the Factorio versions do not know
+
+The api rules are a partial check. They use the Factorio runtime API bundled with wci, for the versions given by
+--factorio or else those the script declares with '-- @factorio', every bundled version if it declares none. The
+bundled API is a hand-written subset of runtime-api.json: events, LuaSurface and LuaForce. Names it does not
+know at all are only reported as warnings and a clean result does not prove every name exists; names a bundled
+version has but a checked version lacks, such as 1.1-only API in a script for 2.0, are errors. The complete API of
+a version can be bundled with tools/trim_factorio_api.
+
+Findings are reported as file:line:column. A '-- wci-lint: ignore <rule>' comment at the end of a line suppresses
+the rule on that line, on a line of its own it suppresses the rule on the next line; without a rule it suppresses
+every rule. Every injection makes the same checks and stops on syntax errors and findings of severity error.
+Scripts with parameters are checked as rendered with their default values.`,
+	Args: cobra.MinimumNArgs(1), // Requires at least one file or script
+	Run: func(cmd *cobra.Command, args []string) {
+		rules, err := utils.SelectLintRules(lintRules)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+		factorio, err := utils.ParseVersionRange(lintFactorio)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+
+		// Arguments naming a file or directory on disk are checked as such, others are looked up by name
+		var files []string
+		for _, arg := range args {
+			if _, err := os.Stat(arg); err == nil {
+				files = append(files, arg)
+			}
+		}
+		catalogue, err := loadScriptCatalogue(files...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+
+		failed := false
+		for _, arg := range args {
+			script, err := catalogue.Find(arg)
+			var findings []utils.LintFinding
+			if err == nil {
+				findings, err = utils.LintScript(script, rules, factorio)
+			}
+			var syntaxErr *lua.SyntaxError
+			switch {
+			case errors.As(err, &syntaxErr):
+				fmt.Fprintln(os.Stderr, syntaxErr)
+				failed = true
+			case err != nil:
+				fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+				failed = true
+			case len(findings) == 0:
+				fmt.Printf("%s: OK\n", script.Location)
+			}
+			for _, finding := range findings {
+				fmt.Println(finding)
+				if finding.Severity == utils.SeverityError {
+					failed = true
+				}
+			}
+		}
+		for _, rule := range rules {
+			if rule.Group == utils.LintGroupAPI {
+				fmt.Println("Note: the api rules are a partial check, the bundled Factorio API is a subset of runtime-api.json.")
+				break
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	lintCmd.Flags().StringVar(&lintRules, "rules", utils.LintGroupDesync+","+utils.LintGroupPerf+","+utils.LintGroupAPI, "Rule groups and rule IDs to check, comma separated; empty for the syntax check only")
+	lintCmd.Flags().StringVar(&lintFactorio, "factorio", "", "Factorio versions to check the api rules against, e.g. 2.0 or \">=1.1\"; defaults to the script's @factorio range")
+	rootCmd.AddCommand(lintCmd)
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+	"wci/utils"
+)
+
+var (
+	migrateTo    string
+	migrateWrite bool
+)
+
+var migrateScriptCmd = &cobra.Command{
+	Use:   "migrate-script [file|script]...",
+	Short: "Rewrite scripts for a newer Factorio version",
+	Long: `Rewrites Lua files, script package directories or scripts of the catalogue for the Factorio version given by
+--to. The rewrite works on the syntax tree, so strings and comments stay as they are. For 2.0 it applies:
+
+  global                                  -> storage
+  game.write_file, game.table_to_json ...  -> helpers.write_file, helpers.table_to_json ...
+  game.entity_prototypes ...               -> prototypes.entity ...
+  game.get_filtered_entity_prototypes ...  -> prototypes.get_entity_filtered ...
+  game.active_mods                        -> script.active_mods
+  defines.events.on_entity_destroyed      -> defines.events.on_object_destroyed
+  script.register_on_entity_destroyed     -> script.register_on_object_destroyed
+
+Constructs that need more than a rename, such as force.evolution_factor, are listed as not migrated. The migrated
+code is printed, or written back to the files with --write; a "-- @factorio" range in the script is left for you
+to update. Injections and upgrades apply the same migration to
+the rendered script by themselves when the save was written by Factorio 2.0 or later, so one script can serve old
+and new saves.`,
+	Args: cobra.MinimumNArgs(1), // Requires at least one file or script
+	Run: func(cmd *cobra.Command, args []string) {
+		// Arguments naming a file or directory on disk are migrated as such, others are looked up by name
+		var files []string
+		onDisk := make(map[string]bool)
+		for _, arg := range args {
+			if _, err := os.Stat(arg); err == nil {
+				files = append(files, arg)
+				onDisk[arg] = true
+			}
+		}
+		catalogue, err := loadScriptCatalogue(files...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+
+		for _, arg := range args {
+			script, err := catalogue.Find(arg)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+				os.Exit(1)
+			}
+			if migrateWrite && !onDisk[arg] && script.Source == utils.SourceEmbedded {
+				fmt.Fprintf(os.Stderr, "Error: '%s' is embedded into wci and cannot be written, migrate a copy.\n", script.Name)
+				os.Exit(1)
+			}
+			migrated, err := utils.MigrateScript(script, migrateTo)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+				os.Exit(1)
+			}
+
+			for _, file := range migrated {
+				for _, note := range file.Rewritten {
+					fmt.Fprintf(os.Stderr, "migrated: %s\n", note)
+				}
+				for _, note := range file.Unmigrated {
+					fmt.Fprintf(os.Stderr, "not migrated: %s\n", note)
+				}
+
+				switch {
+				case migrateWrite && len(file.Rewritten) > 0:
+					if err := os.WriteFile(file.Path, []byte(file.Code), 0644); err != nil {
+						fmt.Fprintf(os.Stderr, "Error: failed to write '%s': %v.\n", file.Path, err)
+						os.Exit(1)
+					}
+					fmt.Printf("%s: %d renames written\n", file.Path, len(file.Rewritten))
+				case migrateWrite:
+					fmt.Printf("%s: nothing to migrate\n", file.Path)
+				case len(migrated) > 1:
+					// Several files are told apart by a header, like head(1) does
+					fmt.Printf("==> %s <==\n%s", file.Path, file.Code)
+					if !strings.HasSuffix(file.Code, "\n") {
+						fmt.Println()
+					}
+				default:
+					fmt.Print(file.Code)
+				}
+			}
+		}
+	},
+}
+
+func init() {
+	migrateScriptCmd.Flags().StringVar(&migrateTo, "to", "2.0", "Factorio version to migrate the scripts to")
+	migrateScriptCmd.Flags().BoolVarP(&migrateWrite, "write", "w", false, "Write the migrated code back to the script files instead of printing it")
+	rootCmd.AddCommand(migrateScriptCmd)
+}
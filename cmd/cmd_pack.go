@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"wci/utils"
+)
+
+var (
+	packAllowUnsigned bool
+	packOutput        string
+	packName          string
+	packVersion       string
+	packDescription   string
+	packAuthor        string
+	packKeyFile       string
+)
+
+var packCmd = &cobra.Command{
+	Use:   "pack",
+	Short: "Install and verify signed script packs",
+	Long: `Script packs are .wcipack files, ZIP archives holding a pack.json manifest with the SHA-256 of every file, the
+scripts under scripts/ and a pack.sig ed25519 signature of the manifest. Packs are installed into the user script
+directory ~/.config/wci/scripts, where every command finds their scripts. Signatures are checked against the
+trusted_keys of the configuration file:
+
+  trusted_keys:
+    - name: alice
+      key: <base64 public key printed by 'wci pack keygen'>
+
+Unsigned packs and packs whose signature matches no trusted key are refused unless --allow-unsigned is given. A pack
+whose files do not match its manifest is always refused. Everything works offline on local files.`,
+}
+
+var packInstallCmd = &cobra.Command{
+	Use:   "install [file.wcipack]",
+	Short: "Verify a script pack and install its scripts",
+	Args:  cobra.ExactArgs(1), // Requires the pack file
+	Run: func(cmd *cobra.Command, args []string) {
+		pack, signer := readVerifiedPack(args[0], packAllowUnsigned)
+
+		installed, err := utils.InstallScriptPack(pack, signer)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Installed pack '%s' %s with the scripts %s.\n", installed.Name, installed.Version, strings.Join(installed.Scripts(), ", "))
+	},
+}
+
+var packUninstallCmd = &cobra.Command{
+	Use:   "uninstall [name]",
+	Short: "Remove the scripts of an installed script pack",
+	Args:  cobra.ExactArgs(1), // Requires the pack name
+	Run: func(cmd *cobra.Command, args []string) {
+		pack, err := utils.UninstallScriptPack(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Uninstalled pack '%s' %s.\n", pack.Name, pack.Version)
+	},
+}
+
+var packListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the installed script packs",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		packs, err := utils.ListInstalledPacks()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+
+		if packOutput == "json" {
+			printJSON(packs)
+			return
+		}
+		if len(packs) == 0 {
+			fmt.Println("No script packs installed.")
+			return
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "NAME\tVERSION\tSIGNED BY\tINSTALLED\tSCRIPTS\t")
+		for _, pack := range packs {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t\n", pack.Name, pack.Version, orDefault(pack.Signer, "(unsigned)"),
+				pack.InstalledAt.Format(time.RFC3339), strings.Join(pack.Scripts(), ", "))
+		}
+		writer.Flush()
+	},
+}
+
+var packVerifyCmd = &cobra.Command{
+	Use:   "verify [file.wcipack]",
+	Short: "Check the files and signature of a script pack without installing it",
+	Args:  cobra.ExactArgs(1), // Requires the pack file
+	Run: func(cmd *cobra.Command, args []string) {
+		pack, signer := readVerifiedPack(args[0], false)
+		fmt.Printf("Pack       : %s %s\n", pack.Manifest.Name, pack.Manifest.Version)
+		if pack.Manifest.Description != "" {
+			fmt.Printf("Description: %s\n", pack.Manifest.Description)
+		}
+		if pack.Manifest.Author != "" {
+			fmt.Printf("Author     : %s\n", pack.Manifest.Author)
+		}
+		fmt.Printf("Scripts    : %s\n", strings.Join(pack.Manifest.Scripts(), ", "))
+		fmt.Printf("Files      : %d, all match the manifest\n", len(pack.Manifest.Files))
+		fmt.Printf("Signature  : valid, signed by trusted key '%s'\n", signer)
+	},
+}
+
+var packBuildCmd = &cobra.Command{
+	Use:   "build [script-dir] [file.wcipack]",
+	Short: "Build a script pack from a script directory",
+	Long: `Writes the scripts of a directory, single Lua files and script package directories, into a .wcipack file.
+With --key, the manifest is signed with the private key written by 'wci pack keygen'.`,
+	Args: cobra.ExactArgs(2), // Requires the script directory and the pack file
+	Run: func(cmd *cobra.Command, args []string) {
+		manifest := utils.PackManifest{Name: packName, Version: packVersion, Description: packDescription, Author: packAuthor}
+		if manifest.Name == "" {
+			manifest.Name = filepath.Base(filepath.Clean(args[0]))
+		}
+
+		var signingKey ed25519.PrivateKey
+		if packKeyFile != "" {
+			data, err := os.ReadFile(packKeyFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: failed to read key: %v.\n", err)
+				os.Exit(1)
+			}
+			key, err := utils.ParseSigningKey(string(data))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: '%s': %v.\n", packKeyFile, err)
+				os.Exit(1)
+			}
+			signingKey = key
+		}
+
+		if err := utils.BuildScriptPack(args[0], manifest, signingKey, args[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+		if signingKey == nil {
+			fmt.Printf("Built unsigned pack '%s'.\n", args[1])
+			return
+		}
+		fmt.Printf("Built and signed pack '%s'.\n", args[1])
+	},
+}
+
+var packKeygenCmd = &cobra.Command{
+	Use:   "keygen [key-file]",
+	Short: "Create a key pair for signing script packs",
+	Args:  cobra.ExactArgs(1), // Requires the private key file
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, err := os.Stat(args[0]); err == nil {
+			fmt.Fprintf(os.Stderr, "Error: '%s' already exists.\n", args[0])
+			os.Exit(1)
+		}
+		publicKey, privateKey, err := utils.GenerateSigningKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(args[0], []byte(privateKey+"\n"), 0600); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to write key: %v.\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Private key written to '%s', keep it secret.\n", args[0])
+		fmt.Printf("Public key: %s\n\nAdd it to the configuration of everyone installing your packs:\n\n", publicKey)
+		fmt.Printf("trusted_keys:\n  - name: %s\n    key: %s\n", strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0])), publicKey)
+	},
+}
+
+// readVerifiedPack reads a script pack and checks its signature against the trusted keys of the configuration.
+// It exits unless the pack is valid, or allowUnsigned is set and only the signature is missing or untrusted.
+func readVerifiedPack(packPath string, allowUnsigned bool) (*utils.ScriptPack, string) {
+	pack, err := utils.ReadScriptPack(packPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+		os.Exit(1)
+	}
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+		os.Exit(1)
+	}
+
+	signer, err := utils.VerifyScriptPack(pack, config.TrustedKeys)
+	switch {
+	case err == nil:
+		return pack, signer
+	case allowUnsigned && (errors.Is(err, utils.ErrPackUnsigned) || errors.Is(err, utils.ErrPackUntrusted)):
+		fmt.Fprintf(os.Stderr, "Warning: %v, continuing because of --allow-unsigned.\n", err)
+		return pack, ""
+	case errors.Is(err, utils.ErrPackUnsigned) || errors.Is(err, utils.ErrPackUntrusted):
+		fmt.Fprintf(os.Stderr, "Error: %v. Add the signer's key to trusted_keys in '%s' or pass --allow-unsigned.\n", err, config.Path)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+	}
+	os.Exit(1)
+	return nil, ""
+}
+
+func init() {
+	packInstallCmd.Flags().BoolVar(&packAllowUnsigned, "allow-unsigned", false, "Install packs without a signature of a trusted key")
+	packListCmd.Flags().StringVarP(&packOutput, "output", "o", "table", "Output format: table or json")
+	packListCmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if packOutput != "table" && packOutput != "json" {
+			return fmt.Errorf("unknown output format '%s' (supported: table, json)", packOutput)
+		}
+		return nil
+	}
+	packBuildCmd.Flags().StringVar(&packName, "name", "", "Pack name (default the directory name)")
+	packBuildCmd.Flags().StringVar(&packVersion, "version", "1.0.0", "Pack version")
+	packBuildCmd.Flags().StringVar(&packDescription, "description", "", "Pack description")
+	packBuildCmd.Flags().StringVar(&packAuthor, "author", "", "Pack author")
+	packBuildCmd.Flags().StringVar(&packKeyFile, "key", "", "Private key file to sign the pack with")
+	packCmd.AddCommand(packInstallCmd, packUninstallCmd, packListCmd, packVerifyCmd, packBuildCmd, packKeygenCmd)
+	rootCmd.AddCommand(packCmd)
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"wci/utils"
+)
+
+var (
+	patchReverse bool
+	patchFuzz    int
+	patchDryRun  bool
+)
+
+var patchCmd = &cobra.Command{
+	Use:   "patch [number] [file.patch]",
+	Short: "Apply a unified diff to the files of the selected savegame",
+	Long: `Applies a unified diff (as written by 'diff -u' or 'git diff') to the files inside the selected savegame ZIP
+file based on the savegame number obtained from the 'list' command. Paths in the patch may omit the save's top-level
+folder. Hunks are found even if the file moved by a few lines; --fuzz sets how many context lines may be ignored at
+each end of a hunk. --reverse undoes a previously applied patch. If any hunk does not apply, the save is not changed.
+--dry-run prints a diff of the changes instead of writing the savegame.`,
+	Args: cobra.ExactArgs(2), // Requires the savegame number and the patch file
+	Run: func(cmd *cobra.Command, args []string) {
+		saveGameZipPath, err := resolveListedSaveGame(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+
+		patchData, err := os.ReadFile(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading patch file: %v\n", err)
+			os.Exit(1)
+		}
+
+		options := utils.PatchOptions{Reverse: patchReverse, Fuzz: patchFuzz, DryRun: dryRunOutput(patchDryRun)}
+		results, err := utils.PatchCodeInZip(currentOS, saveGameZipPath, patchData, options)
+		if errors.Is(err, utils.ErrPatchRejected) {
+			fmt.Fprintf(os.Stderr, "Error: %v\nThe savegame '%s' was not changed.\n", err, saveGameZipPath)
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error patching '%s': %v\n", saveGameZipPath, err)
+			os.Exit(1)
+		}
+
+		printPatchResults(results)
+		if patchDryRun {
+			printDryRunNotice(saveGameZipPath)
+			return
+		}
+		fmt.Printf("Successfully patched '%s'.\n", saveGameZipPath)
+	},
+}
+
+// printPatchResults lists the patched files and every hunk that did not apply at its recorded position.
+func printPatchResults(results []utils.PatchedFile) {
+	for _, result := range results {
+		action := "patched"
+		switch {
+		case result.Created:
+			action = "created"
+		case result.Deleted:
+			action = "deleted"
+		}
+		if patchDryRun {
+			action = "would be " + action
+		}
+		fmt.Printf("%s %s\n", action, result.File)
+
+		for i, hunk := range result.Hunks {
+			if hunk.Offset != 0 || hunk.Fuzz != 0 {
+				fmt.Printf("  hunk #%d applied at line %d (offset %+d, fuzz %d)\n", i+1, hunk.Line, hunk.Offset, hunk.Fuzz)
+			}
+		}
+	}
+}
+
+func init() {
+	patchCmd.Flags().BoolVarP(&patchReverse, "reverse", "R", false, "Undo the patch instead of applying it")
+	patchCmd.Flags().IntVarP(&patchFuzz, "fuzz", "F", utils.DefaultPatchFuzz, "Context lines that may be ignored at each end of a hunk")
+	patchCmd.Flags().BoolVar(&patchDryRun, "dry-run", false, "Print a diff of the changes without writing the savegame")
+	rootCmd.AddCommand(patchCmd)
+}
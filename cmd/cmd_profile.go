@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"wci/internal"
+	"wci/utils"
+)
+
+var (
+	profileOutput string
+	profileSet    []string
+	profileValues string
+	profileDryRun bool
+)
+
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Apply named bundles of scripts from the configuration file",
+	Long: `Profiles are named bundles of scripts, with their parameter values and order, defined in the configuration
+file: the file given with --config, the WCI_CONFIG file or ~/.config/wci/config.yaml. For example:
+
+  profiles:
+    megabase:
+      description: Fresh megabase setup
+      strategy: require
+      scripts:
+        - name: biter_killer
+          params:
+            radius: 500
+        - waypoint_teleport
+
+'profile apply' injects all scripts of a profile into a savegame at once, and records the profile in the savegame's
+manifest so that 'wci status' shows it.`,
+}
+
+var profileListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the configured profiles",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := loadConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+
+		if profileOutput == "json" {
+			printJSON(config)
+			return
+		}
+		if len(config.Profiles) == 0 {
+			fmt.Printf("No profiles defined in '%s'.\n", config.Path)
+			return
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "NAME\tSCRIPTS\tSTRATEGY\tDESCRIPTION\t")
+		for _, name := range config.ProfileNames() {
+			profile := config.Profiles[name]
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t\n", name, strings.Join(profile.ScriptNames(), ", "),
+				orDefault(profile.Strategy, string(utils.StrategyAppend)), profile.Description)
+		}
+		writer.Flush()
+	},
+}
+
+var profileApplyCmd = &cobra.Command{
+	Use:   "apply [profile] [number]",
+	Short: "Inject every script of a profile into the selected savegame",
+	Long: `Injects the scripts of a profile, in the listed order and with the profile's parameter values, into the selected
+savegame ZIP file based on the savegame number obtained from the 'list' command. Scripts the profile's scripts
+depend on are injected with them. Application is all-or-nothing: the archive is written once, and not at all if any
+script fails. Scripts that are already injected are skipped.
+--set key=value and --values file.yaml override the profile's parameter values; an unqualified key overrides the
+value of every script of the profile, script.key the value of one script. --dry-run prints a diff instead of
+writing the savegame.`,
+	Args: cobra.ExactArgs(2), // Requires the profile name and the savegame number
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := loadConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+		profile, err := config.Profile(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+
+		saveGameZipPath, err := resolveListedSaveGame(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+
+		params, err := parseParamFlags(profileSet, profileValues)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+
+		catalogue, err := loadScriptCatalogue()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+		printShadowNotes(catalogue, profile.ScriptNames())
+
+		options := utils.InjectOptions{DryRun: dryRunOutput(profileDryRun), Params: params}
+		results, err := internal.ApplyProfile(currentOS, saveGameZipPath, catalogue, args[0], profile, options)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\nThe savegame '%s' was not changed.\n", err, saveGameZipPath)
+			os.Exit(1)
+		}
+
+		printInjectedScripts(results)
+		if profileDryRun {
+			printDryRunNotice(saveGameZipPath)
+			return
+		}
+		fmt.Printf("Successfully applied profile '%s' to '%s'.\n", args[0], saveGameZipPath)
+	},
+}
+
+// orDefault returns fallback for an empty value.
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func init() {
+	profileListCmd.Flags().StringVarP(&profileOutput, "output", "o", "table", "Output format: table or json")
+	profileListCmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if profileOutput != "table" && profileOutput != "json" {
+			return fmt.Errorf("unknown output format '%s' (supported: table, json)", profileOutput)
+		}
+		return nil
+	}
+	profileApplyCmd.Flags().StringArrayVar(&profileSet, "set", nil, "Override a script parameter (key=value or script.key=value), repeatable")
+	profileApplyCmd.Flags().StringVar(&profileValues, "values", "", "YAML file with script parameter values overriding the profile")
+	profileApplyCmd.Flags().BoolVar(&profileDryRun, "dry-run", false, "Print a diff of the changes without writing the savegame")
+	profileCmd.AddCommand(profileListCmd, profileApplyCmd)
+	rootCmd.AddCommand(profileCmd)
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"wci/utils"
+)
+
+var removeDryRun bool
+
+var removeCmd = &cobra.Command{
+	Use:   "remove [script] [number]",
+	Short: "Remove an injected Lua script from the selected savegame",
+	Long: `Removes the marker block of an injected Lua script (e.g. 'biter_killer') from the selected savegame ZIP file
+based on the savegame number obtained from the 'list' command. The block is found in whichever Lua file it was placed.
+The command refuses to remove a block whose content was edited by hand after injection.
+--dry-run prints a diff of the changes instead of writing the savegame.`,
+	Args: cobra.ExactArgs(2), // Requires the script name and the savegame number
+	Run: func(cmd *cobra.Command, args []string) {
+		scriptName := utils.ScriptNameFromFile(args[0])
+
+		saveGameZipPath, err := resolveListedSaveGame(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+
+		options := utils.RemoveOptions{DryRun: dryRunOutput(removeDryRun)}
+		err = utils.RemoveCodeFromZipWithOptions(currentOS, saveGameZipPath, scriptName, options)
+		if errors.Is(err, utils.ErrScriptModified) {
+			fmt.Fprintf(os.Stderr, "Error: refusing to remove '%s' from '%s': %v\nRestore the original block or edit the save manually.\n", scriptName, saveGameZipPath, err)
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error removing '%s' from '%s': %v\n", scriptName, saveGameZipPath, err)
+			os.Exit(1)
+		}
+
+		if removeDryRun {
+			printDryRunNotice(saveGameZipPath)
+			return
+		}
+		fmt.Printf("Successfully removed '%s' from '%s'.\n", scriptName, saveGameZipPath)
+	},
+}
+
+func init() {
+	removeCmd.Flags().BoolVar(&removeDryRun, "dry-run", false, "Print a diff of the changes without writing the savegame")
+	rootCmd.AddCommand(removeCmd)
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"wci/utils"
+)
+
+var scriptsOutput string
+
+var scriptsCmd = &cobra.Command{
+	Use:   "scripts",
+	Short: "Browse the scripts available for injection",
+	Long: `Lists the scripts wci can inject and shows their metadata: version, description, author, console commands,
+events, supported Factorio versions, parameters and dependencies, as declared by the '-- @' tags of each script
+header. Scripts come from, in order of precedence, the --script-dir directories, the WCI_SCRIPT_PATH directories,
+~/.config/wci/scripts and the scripts embedded into wci; a script hides scripts of the same name from later
+sources, which 'scripts list' reports as shadowed. --output json prints the same information as JSON.`,
+}
+
+var scriptsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the available scripts",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		catalogue, err := loadScriptCatalogue()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+
+		if scriptsOutput == "json" {
+			printJSON(catalogue)
+			return
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "NAME\tVERSION\tSOURCE\tFACTORIO\tCOMMANDS\tDESCRIPTION\t")
+		for _, script := range catalogue.Scripts {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t\n", script.Name, script.Version, script.Source, scriptFactorio(script.ScriptMetadata),
+				strings.Join(scriptCommandNames(script.Commands), ", "), script.Description)
+		}
+		writer.Flush()
+
+		for _, shadowed := range catalogue.Shadowed {
+			fmt.Printf("Shadowed: %s (%s) is hidden by %s\n", shadowed.Name, shadowed.Location, shadowed.ShadowedBy)
+		}
+	},
+}
+
+var scriptsShowCmd = &cobra.Command{
+	Use:   "show [name]",
+	Short: "Show the metadata of a script",
+	Args:  cobra.ExactArgs(1), // Requires the script name
+	Run: func(cmd *cobra.Command, args []string) {
+		catalogue, err := loadScriptCatalogue()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+		script, err := catalogue.Find(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+
+		if scriptsOutput == "json" {
+			printJSON(script)
+			return
+		}
+		printScriptMetadata(script.ScriptMetadata)
+		fmt.Printf("Source     : %s (%s)\n", script.Source, script.Location)
+		for _, shadowed := range catalogue.ShadowsOf(script.Name) {
+			fmt.Printf("Shadows    : %s\n", shadowed.Location)
+		}
+	},
+}
+
+// printScriptMetadata prints every field of a script's metadata, followed by its parameters and changelog.
+func printScriptMetadata(script utils.ScriptMetadata) {
+	fmt.Printf("Name       : %s\n", script.Name)
+	fmt.Printf("Version    : %s\n", script.Version)
+	fmt.Printf("Description: %s\n", script.Description)
+	fmt.Printf("Author     : %s\n", script.Author)
+	fmt.Printf("Factorio   : %s\n", scriptFactorio(script))
+	fmt.Printf("Path       : %s\n", script.Path)
+	for _, variant := range script.Variants {
+		fmt.Printf("Variant    : %s  (Factorio %s)\n", variant.Path, orAny(variant.Factorio))
+	}
+	for _, command := range script.Commands {
+		fmt.Printf("Command    : /%s  %s\n", command.Name, command.Help)
+	}
+	if len(script.Events) > 0 {
+		fmt.Printf("Events     : %s\n", strings.Join(script.Events, ", "))
+	}
+	if script.Runtime {
+		fmt.Printf("Runtime    : state in storage.wci.%s\n", script.Name)
+	}
+	if len(script.Depends) > 0 {
+		fmt.Printf("Depends on : %s\n", strings.Join(script.Depends, ", "))
+	}
+	if len(script.After) > 0 {
+		fmt.Printf("Runs after : %s\n", strings.Join(script.After, ", "))
+	}
+
+	if len(script.Params) > 0 {
+		fmt.Println("\nParameters:")
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "  NAME\tTYPE\tDEFAULT\tDESCRIPTION\t")
+		for _, param := range script.Params {
+			defaultValue := "(required)"
+			if !param.Required() {
+				defaultValue = fmt.Sprintf("%v", param.Default)
+			}
+			fmt.Fprintf(writer, "  %s\t%s\t%s\t%s\t\n", param.Name, param.Type, defaultValue, param.Description)
+		}
+		writer.Flush()
+	}
+
+	if len(script.Changelog) > 0 {
+		fmt.Println("\nChangelog:")
+		for _, entry := range script.Changelog {
+			fmt.Printf("  %s  %s\n", entry.Version, entry.Text)
+		}
+	}
+}
+
+// scriptCommandNames returns the names of the console commands a script adds.
+func scriptCommandNames(commands []utils.ScriptCommand) []string {
+	names := make([]string, 0, len(commands))
+	for _, command := range commands {
+		names = append(names, "/"+command.Name)
+	}
+	return names
+}
+
+// scriptFactorio returns the Factorio versions a script supports, joining those of its variants.
+func scriptFactorio(script utils.ScriptMetadata) string {
+	if len(script.Variants) == 0 {
+		return orAny(script.Factorio)
+	}
+	versions := make([]string, 0, len(script.Variants))
+	for _, variant := range script.Variants {
+		versions = append(versions, orAny(variant.Factorio))
+	}
+	return strings.Join(versions, ", ")
+}
+
+// orAny returns "any" for an empty version range.
+func orAny(versionRange string) string {
+	if versionRange == "" {
+		return "any"
+	}
+	return versionRange
+}
+
+// printJSON prints a value as indented JSON.
+func printJSON(value any) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding JSON: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(data))
+}
+
+func init() {
+	scriptsCmd.PersistentFlags().StringVarP(&scriptsOutput, "output", "o", "table", "Output format: table or json")
+	scriptsCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if scriptsOutput != "table" && scriptsOutput != "json" {
+			return fmt.Errorf("unknown output format '%s' (supported: table, json)", scriptsOutput)
+		}
+		return nil
+	}
+	scriptsCmd.AddCommand(scriptsListCmd, scriptsShowCmd)
+	rootCmd.AddCommand(scriptsCmd)
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"wci/utils"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status [number]",
+	Short: "Show which scripts are in the selected savegame",
+	Long: `Reads the wci-manifest.json of the selected savegame ZIP file, based on the savegame number obtained from the
+'list' command, and cross-checks it against the injected blocks of its Lua files. Every script is reported as
+ok, modified (edited by hand after injection), mismatch (differs from the manifest), missing (recorded but not
+found) or unrecorded (found but not in the manifest). The command exits with status 2 if anything is not ok.`,
+	Args: cobra.ExactArgs(1), // Requires the savegame number
+	Run: func(cmd *cobra.Command, args []string) {
+		saveGameZipPath, err := resolveListedSaveGame(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+
+		status, err := utils.CheckSaveStatus(currentOS, saveGameZipPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading the status of '%s': %v\n", saveGameZipPath, err)
+			os.Exit(1)
+		}
+
+		printSaveStatus(saveGameZipPath, status)
+		if !status.Consistent() {
+			os.Exit(2)
+		}
+	},
+}
+
+// printSaveStatus prints the manifest summary and one table row per script.
+func printSaveStatus(saveGameZipPath string, status utils.SaveStatus) {
+	fmt.Printf("Savegame: %s\n", saveGameZipPath)
+	if status.Manifest == nil {
+		fmt.Printf("Manifest: none (expected at %s)\n", status.ManifestPath)
+	} else {
+		fmt.Printf("Manifest: %s, written by wci %s at %s, %d recorded change(s)\n", status.ManifestPath,
+			status.Manifest.WCIVersion, status.Manifest.UpdatedAt.Format(time.RFC3339), len(status.Manifest.History))
+		for _, event := range status.Manifest.ProfileEvents() {
+			fmt.Printf("Profile : %s, applied at %s (%s)\n", event.Profile, event.Timestamp.Format(time.RFC3339), strings.Join(event.Scripts, ", "))
+		}
+	}
+
+	if len(status.Scripts) == 0 {
+		fmt.Println("No injected scripts.")
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "SCRIPT\tVERSION\tSTRATEGY\tTARGET\tSTATE\t")
+	for _, script := range status.Scripts {
+		state := string(script.State)
+		if script.Detail != "" {
+			state += " (" + script.Detail + ")"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t\n", script.Name, script.Version, script.Strategy, script.Target, state)
+	}
+	writer.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"wci/internal"
+	"wci/utils"
+)
+
+var (
+	upgradeCheckOnly bool
+	upgradeAllSaves  bool
+	upgradeDryRun    bool
+)
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade [number...]",
+	Short: "Upgrade injected scripts in savegames to the catalogue versions",
+	Long: `Finds injected scripts whose version is older than the script in the catalogue (the embedded scripts and the
+script directories, see 'wci scripts list') and replaces them in place.
+Savegames are selected by the numbers obtained from the 'list' command, or all listed savegames with --all.
+Use --check to list outdated savegames without writing anything, or --dry-run to also see a diff of the changes.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		saveGames, err := selectSaveGames(args, upgradeAllSaves)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+
+		catalogue, err := loadScriptCatalogue()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+
+		failed := false
+		outdated := 0
+		for _, saveGame := range saveGames {
+			upgrades, err := internal.UpgradeScripts(currentOS, saveGame, catalogue, utils.UpgradeOptions{
+				CheckOnly: upgradeCheckOnly,
+				DryRun:    dryRunOutput(upgradeDryRun),
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error upgrading '%s': %v\n", saveGame, err)
+				failed = true
+				continue
+			}
+			outdated += len(upgrades)
+			printUpgradeSummary(saveGame, upgrades)
+		}
+
+		if upgradeCheckOnly {
+			fmt.Printf("%d outdated script(s) found. Run 'wci upgrade' without --check to apply.\n", outdated)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// printUpgradeSummary prints the per-savegame changelog of the performed or pending upgrades.
+func printUpgradeSummary(saveGame string, upgrades []utils.ScriptUpgrade) {
+	if len(upgrades) == 0 {
+		fmt.Printf("%s: up to date\n", saveGame)
+		return
+	}
+
+	action := "upgraded"
+	if upgradeCheckOnly {
+		action = "outdated"
+	} else if upgradeDryRun {
+		action = "would upgrade"
+	}
+	fmt.Printf("%s:\n", saveGame)
+	for _, upgrade := range upgrades {
+		fmt.Printf("  %s %s %s -> %s (%s)\n", action, upgrade.Script, upgrade.FromVersion, upgrade.ToVersion, upgrade.File)
+		for _, entry := range upgrade.Changelog {
+			fmt.Printf("    - %s: %s\n", entry.Version, entry.Text)
+		}
+		for _, finding := range upgrade.Findings {
+			fmt.Printf("    %s\n", finding)
+		}
+		for _, note := range upgrade.Unmigrated {
+			fmt.Printf("    not migrated: %s\n", note)
+		}
+	}
+}
+
+func init() {
+	upgradeCmd.Flags().BoolVar(&upgradeCheckOnly, "check", false, "List outdated scripts without modifying savegames")
+	upgradeCmd.Flags().BoolVar(&upgradeDryRun, "dry-run", false, "Print a diff of the upgrades without modifying savegames")
+	upgradeCmd.Flags().BoolVar(&upgradeAllSaves, "all", false, "Upgrade every savegame from the last 'list' output")
+	rootCmd.AddCommand(upgradeCmd)
+}
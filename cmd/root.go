@@ -11,6 +11,8 @@ import (
 var (
 	currentOS       = runtime.GOOS
 	listedSaveGames map[int]string
+	scriptDirs      []string           // script directories given with --script-dir, highest precedence first
+	configPath      string             // configuration file given with --config
 	saveGamesFile   = "savegames.json" // File to store savegames data
 )
 
@@ -18,6 +20,58 @@ var rootCmd = &cobra.Command{
 	Use:   "wci",
 	Short: "Inject Lua scripts into Factorio savegames",
 	Long:  "Warp Code Injector (wci) modifies Factorio savegames by injecting custom Lua scripts.",
+	Example: `  # List all savegames
+  wci list
+
+  # Inject the biter killer script into one or more savegames
+  wci inject biter_killer 2
+  wci inject biter_killer 2 3 5
+
+  # Inject the biter killer with its own command name, limited to 500 tiles
+  wci inject biter_killer 2 --set command=purge --set radius=500
+
+  # Inject a local Lua file, or a script from a team script directory
+  wci inject --file ./my_script.lua 2
+  wci inject my_script 2 --script-dir ./team-scripts
+
+  # Inject several scripts and their dependencies at once
+  wci apply 2 biter_killer
+
+  # Remove the biter killer script from a savegame
+  wci remove biter_killer 2
+
+  # List savegames with outdated scripts, then upgrade them
+  wci upgrade --all --check
+  wci upgrade --all
+
+  # Inject the scripts of a profile from ~/.config/wci/config.yaml
+  wci profile list
+  wci profile apply megabase 2
+
+  # Install a signed script pack shared by the team
+  wci pack verify team.wcipack
+  wci pack install team.wcipack
+
+  # Check a script for syntax errors and multiplayer desyncs before injecting it
+  wci lint ./my_script.lua --rules desync,perf
+  wci lint ./my_script.lua --factorio 2.0
+
+  # Rewrite a script written for Factorio 1.1 for 2.0
+  wci migrate-script ./my_script.lua --to 2.0 --write
+
+  # Review the changes before writing the savegame
+  wci inject biter_killer 2 --dry-run
+
+  # Apply a scenario tweak, then undo it
+  wci patch 2 freeplay-items.patch
+  wci patch 2 freeplay-items.patch --reverse
+
+  # Browse the available scripts
+  wci scripts list
+  wci scripts show biter_killer --output json
+
+  # Show which scripts a savegame contains
+  wci status 2`,
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println("Welcome to Warp Code Injector! Use 'wci --help' to see available commands.")
 	},
@@ -25,28 +79,12 @@ var rootCmd = &cobra.Command{
 
 func init() {
 	rootCmd.CompletionOptions.DisableDefaultCmd = false
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "Configuration file (default $WCI_CONFIG or ~/.config/wci/config.yaml)")
+	rootCmd.PersistentFlags().StringArrayVar(&scriptDirs, "script-dir", nil, "Directory with additional scripts, takes precedence over WCI_SCRIPT_PATH and ~/.config/wci/scripts (repeatable)")
 }
 
 // Execute is the entry point for the CLI application
 func Execute() error {
-
-	rootCmd.SetUsageTemplate(`
-Usage:
-  wci [command]
-
-Available Commands:
-  list       List all savegames
-  add-biter-killer   Injects the biter killer script
-  clean      Cleans up temporary files
-
-Examples:
-  # List all savegames
-  wci list
-
-  # Inject the biter killer script into a savegame
-  wci add-biter-killer 2
-`)
-
 	// Load listedSaveGames from file at startup
 	if err := loadListedSaveGames(); err != nil {
 		fmt.Printf("Failed to load savegames data: %v\n", err)
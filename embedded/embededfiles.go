@@ -2,5 +2,22 @@ package embedded
 
 import "embed"
 
+// LuaInjectionsDir is the directory inside LuaInjections that holds the injectable scripts.
+const LuaInjectionsDir = "lua_injections"
+
 //go:embed lua_injections/*
 var LuaInjections embed.FS
+
+// FactorioAPIDir is the directory inside FactorioAPI that holds the runtime API descriptions, one
+// runtime-api-<version>.json per supported Factorio version. tools/trim_factorio_api builds them from the
+// runtime-api.json of a Factorio installation.
+const FactorioAPIDir = "factorio_api"
+
+//go:embed factorio_api/*.json
+var FactorioAPI embed.FS
+
+// LuaRuntime is the runtime placed in front of injected scripts that declare "-- @runtime". It is the body of a
+// function called with the name and version of the script, and returns the script's wci table.
+//
+//go:embed lua_runtime/wci_runtime.lua
+var LuaRuntime string
@@ -1,7 +1,23 @@
+-- @name biter_killer
+-- @version 1.1.0
+-- @description Destroys all biters, spawners and worms on the player's surface, or within a radius around the
+-- @description player, without deactivating achievements.
+-- @author KnightRider2070
+-- @command cleanup_biters Destroys all biters, spawners, and worms on the player's current surface.
+-- @factorio >=1.1
+-- @changelog 1.1.0 Command name, search radius, messages and announcements are configurable with --set.
+-- @param command:string=cleanup_biters Name of the console command.
+-- @param radius:number=0 Only destroy enemies within this many tiles of the player; 0 cleans the whole surface.
+-- @param force:string=enemy Force whose entities are destroyed.
+-- @param announce_each:bool=true Print a line for every destroyed entity.
+-- @param anecdotes:bool=true Finish with a Star Trek anecdote.
+-- @param summary:string="Cleanup completed. Total enemies destroyed: " Text printed before the number of destroyed entities.
+
 -- Function to handle the cleanup logic
 local function cleanup_biters(player)
     local surface = player.surface
     local destroyed_count = 0
+{{- if .anecdotes }}
 
     -- Fun Star Trek Anecdotes
     local anecdotes = {
@@ -15,7 +31,28 @@ local function cleanup_biters(player)
         "If Q were here, he’d snap his fingers and clean this up in no time.",
         "Biters aren’t part of the prime directive—engage!"
     }
+{{- end }}
 
+    -- Destroy each enemy entity found
+    local function destroy_all(enemies)
+        for _, enemy in pairs(enemies) do
+            if enemy and enemy.valid then
+{{- if .announce_each }}
+                game.print("[INFO] Destroying: " .. enemy.name .. " at (" .. math.floor(enemy.position.x) .. ", " .. math.floor(enemy.position.y) .. ")")
+{{- end }}
+                enemy.destroy()
+                destroyed_count = destroyed_count + 1
+            end
+        end
+    end
+{{ if gt .radius 0.0 }}
+    -- Find all enemy entities (biters, spawners, worms) around the player
+    destroy_all(surface.find_entities_filtered({
+        position = player.position,
+        radius = {{ lua .radius }},
+        force = {{ lua .force }}
+    }))
+{{- else }}
     -- Iterate over all chunks on the surface
     for chunk in surface.get_chunks() do
         local area = {
@@ -24,31 +61,22 @@ local function cleanup_biters(player)
         }
 
         -- Find all enemy entities (biters, spawners, worms) in the chunk
-        local enemies = surface.find_entities_filtered({
+        destroy_all(surface.find_entities_filtered({
             area = area,
-            force = "enemy"
-        })
-
-        -- Destroy each enemy entity found
-        for _, enemy in pairs(enemies) do
-            if enemy and enemy.valid then
-                game.print("[INFO] Destroying: " .. enemy.name .. " at (" .. math.floor(enemy.position.x) .. ", " .. math.floor(enemy.position.y) .. ")")
-                enemy.destroy()
-                destroyed_count = destroyed_count + 1
-            end
-        end
+            force = {{ lua .force }}
+        }))
     end
+{{- end }}
 
-    -- Select a random Star Trek anecdote
-    local random_anecdote = anecdotes[math.random(1, #anecdotes)]
-
-    -- Final report with humor
-    game.print("[SUCCESS] Cleanup completed. Total enemies destroyed: " .. destroyed_count)
-    game.print("[FUN] " .. random_anecdote)
+    -- Final report
+    game.print("[SUCCESS] " .. {{ lua .summary }} .. destroyed_count)
+{{- if .anecdotes }}
+    game.print("[FUN] " .. anecdotes[math.random(1, #anecdotes)])
+{{- end }}
 end
 
 -- Register a custom command to trigger the cleanup
-commands.add_command("cleanup_biters", "Destroys all biters, spawners, and worms on the player's current surface.", function(cmd)
+commands.add_command({{ lua .command }}, "Destroys all biters, spawners, and worms on the player's current surface.", function(cmd)
     local player = game.get_player(cmd.player_index)
 
     -- Ensure the command is run by a valid player
@@ -59,4 +87,4 @@ commands.add_command("cleanup_biters", "Destroys all biters, spawners, and worms
 
     game.print("[INFO] Cleanup command triggered by " .. player.name .. ". Starting cleanup...")
     cleanup_biters(player)
-end)
\ No newline at end of file
+end)
@@ -0,0 +1,88 @@
+-- Runtime of scripts injected by wci that declare "-- @runtime". wci places it in front of the script as
+--
+--     local wci = (function(...) <this file> end)("<script name>", "<script version>")
+--
+-- Factorio does not run script.on_init for a control.lua that is added to an existing save, so the state of
+-- the script is set up lazily instead: the first call of wci.data() in an event handler or command creates
+-- storage.wci[<script name>] and runs the wci.on_init handlers; after the script was upgraded, it runs the
+-- wci.migration handlers of every version up to the injected one. The version the state was last set up for is
+-- kept in storage.wci_versions[<script name>].
+local name, version = ...
+
+local wci = {name = name, version = version}
+-- The handlers are registered while the script loads, so every peer fills these tables the same way, and they are
+-- only read afterwards
+local initializers = {}
+local migrations = {}
+
+-- Compares two dotted version strings numerically, returns -1, 0 or 1
+local function compare_versions(a, b)
+    local parts_a, parts_b = {}, {}
+    for part in string.gmatch(a, "%d+") do
+        parts_a[#parts_a + 1] = tonumber(part)
+    end
+    for part in string.gmatch(b, "%d+") do
+        parts_b[#parts_b + 1] = tonumber(part)
+    end
+    for i = 1, math.max(#parts_a, #parts_b) do
+        local x, y = parts_a[i] or 0, parts_b[i] or 0
+        if x ~= y then
+            return x < y and -1 or 1
+        end
+    end
+    return 0
+end
+
+-- Registers a handler that sets up the state the first time the script runs in a save: handler(data)
+function wci.on_init(handler)
+    initializers[#initializers + 1] = handler -- wci-lint: ignore desync-upvalue
+end
+
+-- Registers a handler that brings the state of an older version up to to_version: handler(data, from_version).
+-- Migrations run in version order, each one once.
+function wci.migration(to_version, handler)
+    migrations[#migrations + 1] = {version = to_version, handler = handler} -- wci-lint: ignore desync-upvalue
+end
+
+-- Returns the state of the script, storage.wci[<script name>], setting it up or migrating it first if needed.
+-- Setting up writes to storage, which is not allowed while on_load runs.
+function wci.data()
+    -- global is the name of storage before Factorio 2.0
+    local root = storage or global
+    local versions = root.wci_versions
+    local data = root.wci and root.wci[name]
+    if data and versions and versions[name] == version then
+        return data
+    end
+    if not game then
+        error("wci: the state of '" .. name .. "' cannot be set up in on_load, call wci.data() in an event handler or command")
+    end
+
+    root.wci = root.wci or {}
+    root.wci_versions = root.wci_versions or {}
+    if not data then
+        data = {}
+        root.wci[name] = data
+        for _, handler in ipairs(initializers) do
+            handler(data)
+        end
+    else
+        local from_version = root.wci_versions[name] or "0"
+        local ordered = {}
+        for i, migration in ipairs(migrations) do
+            ordered[i] = migration
+        end
+        table.sort(ordered, function(a, b)
+            return compare_versions(a.version, b.version) < 0
+        end)
+        for _, migration in ipairs(ordered) do
+            if compare_versions(migration.version, from_version) > 0 and compare_versions(migration.version, version) <= 0 then
+                migration.handler(data, from_version)
+            end
+        end
+    end
+    root.wci_versions[name] = version
+    return data
+end
+
+return wci
@@ -66,3 +66,19 @@ func ReadEmbeddedFile(fs embed.FS, dirName, fileName string) ([]byte, error) {
 		Msg("Successfully read embedded file")
 	return content, nil
 }
+
+// ListLuaInjectionPaths returns the paths of all embedded Lua scripts, relative to the root of LuaInjections.
+func ListLuaInjectionPaths() ([]string, error) {
+	fileNames, err := ListEmbeddedFiles(LuaInjections, LuaInjectionsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, fileName := range fileNames {
+		if path.Ext(fileName) == ".lua" {
+			paths = append(paths, path.Join(LuaInjectionsDir, fileName))
+		}
+	}
+	return paths, nil
+}
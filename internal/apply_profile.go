@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"wci/utils"
+)
+
+// ApplyProfile injects the scripts of a profile, in the listed order and with the profile's parameter values,
+// into the savegame ZIP file. Values in options.Params override those of the profile. The scripts are injected in
+// one transaction: if any of them fails, the savegame is not changed.
+func ApplyProfile(osName, saveGameZipName string, catalogue *utils.ScriptCatalogue, profileName string, profile utils.Profile, options utils.InjectOptions) ([]utils.InjectedScript, error) {
+	log.Info().
+		Str("saveGameZipName", saveGameZipName).
+		Str("profile", profileName).
+		Msg("Starting to apply profile")
+
+	strategy, err := utils.ParseInjectionStrategy(profile.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	onConflict, err := utils.ParseEventConflictMode(profile.OnConflict)
+	if err != nil {
+		return nil, err
+	}
+	options.Strategy = strategy
+	options.EventConflicts = onConflict
+	options.Params = profile.ParamValues(options.Params)
+	options.Profile = profileName
+
+	results, err := InjectScripts(osName, saveGameZipName, catalogue, profile.ScriptNames(), options)
+	if err != nil {
+		return nil, fmt.Errorf("profile '%s': %w", profileName, err)
+	}
+
+	log.Info().
+		Str("saveGameZipName", saveGameZipName).
+		Str("profile", profileName).
+		Int("scriptCount", len(results)).
+		Msg("Successfully applied profile")
+	return results, nil
+}
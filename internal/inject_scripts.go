@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"wci/utils"
+)
+
+// InjectScripts injects the named catalogue scripts, and the scripts they depend on, next to control.lua in the
+// savegame ZIP file. The archive is written once, and not at all if any script fails.
+func InjectScripts(osName, saveGameZipName string, catalogue *utils.ScriptCatalogue, scriptNames []string, options utils.InjectOptions) ([]utils.InjectedScript, error) {
+	log.Info().
+		Str("saveGameZipName", saveGameZipName).
+		Strs("scripts", scriptNames).
+		Msg("Starting to inject scripts")
+
+	scriptPaths := make([]string, 0, len(scriptNames))
+	for _, scriptName := range scriptNames {
+		script, err := catalogue.Find(scriptName)
+		if err != nil {
+			return nil, err
+		}
+		scriptPaths = append(scriptPaths, script.FileName())
+	}
+
+	results, err := utils.InjectScriptsIntoZip(osName, saveGameZipName, scriptPaths, "control.lua", catalogue.FS(), options)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("saveGameZipName", saveGameZipName).
+			Msg("Failed to inject scripts")
+		return nil, fmt.Errorf("failed to inject scripts into '%s': %w", saveGameZipName, err)
+	}
+
+	log.Info().
+		Str("saveGameZipName", saveGameZipName).
+		Int("scriptCount", len(results)).
+		Msg("Successfully injected scripts")
+	return results, nil
+}
@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"fmt"
+	"wci/embedded"
+	"wci/utils"
+)
+
+// EmbeddedScriptSource returns the source of the scripts compiled into wci.
+func EmbeddedScriptSource() utils.ScriptSource {
+	return utils.ScriptSource{
+		Kind:     utils.SourceEmbedded,
+		Location: "embedded",
+		FS:       embedded.LuaInjections,
+		Dir:      embedded.LuaInjectionsDir,
+	}
+}
+
+// LoadScriptCatalogue builds the catalogue of injectable scripts. In order of precedence, a script comes from
+// one of the given files, the --script-dir directories, the WCI_SCRIPT_PATH directories, the user's script
+// directory, or the scripts embedded into wci.
+func LoadScriptCatalogue(scriptDirs []string, files ...string) (*utils.ScriptCatalogue, error) {
+	var sources []utils.ScriptSource
+	for _, file := range files {
+		source, err := utils.FileScriptSource(file)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+
+	userSources, err := utils.UserScriptSources(scriptDirs)
+	if err != nil {
+		return nil, err
+	}
+	sources = append(sources, userSources...)
+	sources = append(sources, EmbeddedScriptSource())
+
+	catalogue, err := utils.BuildScriptCatalogue(sources)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load scripts: %w", err)
+	}
+	return catalogue, nil
+}
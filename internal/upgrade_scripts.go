@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"wci/utils"
+)
+
+// UpgradeScripts replaces injected scripts in the savegame ZIP file that are older than the catalogue versions.
+// With options.CheckOnly set, the outdated scripts are reported without rewriting the savegame.
+func UpgradeScripts(osName, saveGameZipName string, catalogue *utils.ScriptCatalogue, options utils.UpgradeOptions) ([]utils.ScriptUpgrade, error) {
+	log.Info().
+		Str("saveGameZipName", saveGameZipName).
+		Bool("checkOnly", options.CheckOnly).
+		Msg("Starting to upgrade scripts")
+
+	scriptPaths := make([]string, 0, len(catalogue.Scripts))
+	for _, script := range catalogue.Scripts {
+		scriptPaths = append(scriptPaths, script.FileName())
+	}
+
+	upgrades, err := utils.UpgradeCodeInZipWithOptions(osName, saveGameZipName, catalogue.FS(), scriptPaths, options)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("saveGameZipName", saveGameZipName).
+			Msg("Failed to upgrade scripts")
+		return nil, fmt.Errorf("failed to upgrade scripts in '%s': %w", saveGameZipName, err)
+	}
+
+	log.Info().
+		Str("saveGameZipName", saveGameZipName).
+		Int("upgradeCount", len(upgrades)).
+		Msg("Finished upgrading scripts")
+	return upgrades, nil
+}
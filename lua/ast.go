@@ -0,0 +1,282 @@
+package lua
+
+// Node is an element of the syntax tree of a Lua chunk.
+type Node interface {
+	Pos() Position // position of the first token of the node
+	End() int      // byte offset just after the last token of the node
+}
+
+// Expr is an expression node.
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// Stmt is a statement node.
+type Stmt interface {
+	Node
+	stmtNode()
+}
+
+// span records where a node starts and ends in the source.
+type span struct {
+	Start Position
+	Stop  int
+}
+
+// Pos returns the position of the first token of the node.
+func (s span) Pos() Position { return s.Start }
+
+// End returns the byte offset just after the last token of the node.
+func (s span) End() int { return s.Stop }
+
+// Chunk is a parsed Lua file, the body of an anonymous vararg function.
+type Chunk struct {
+	File string
+	Body *Block
+}
+
+// Block is a sequence of statements; a return statement can only be the last one.
+type Block struct {
+	span
+	Stmts []Stmt
+}
+
+type (
+	// NilExpr is the constant nil.
+	NilExpr struct{ span }
+
+	// TrueExpr is the constant true.
+	TrueExpr struct{ span }
+
+	// FalseExpr is the constant false.
+	FalseExpr struct{ span }
+
+	// VarargExpr is "...", the extra arguments of a vararg function.
+	VarargExpr struct{ span }
+
+	// NumberExpr is a numeral as written in the source.
+	NumberExpr struct {
+		span
+		Text string
+	}
+
+	// StringExpr is a string literal; Value holds the decoded content.
+	StringExpr struct {
+		span
+		Value string
+	}
+
+	// NameExpr is a variable name.
+	NameExpr struct {
+		span
+		Name string
+	}
+
+	// IndexExpr is "object[key]", or "object.name" with Dot set and Key a *StringExpr holding the name.
+	IndexExpr struct {
+		span
+		Object Expr
+		Key    Expr
+		Dot    bool
+	}
+
+	// CallExpr is a function call "fn(args)", "fn{table}" or "fn 'string'".
+	CallExpr struct {
+		span
+		Func Expr
+		Args []Expr
+	}
+
+	// MethodCallExpr is a method call "object:method(args)".
+	MethodCallExpr struct {
+		span
+		Object    Expr
+		Method    string
+		MethodPos Position
+		Args      []Expr
+	}
+
+	// FunctionExpr is a function body, anonymous or of a function statement.
+	FunctionExpr struct {
+		span
+		Params []*NameExpr
+		Vararg bool
+		Body   *Block
+	}
+
+	// TableExpr is a table constructor.
+	TableExpr struct {
+		span
+		Fields []*TableField
+	}
+
+	// BinaryExpr is a binary operation such as "a + b", "a .. b" or "a and b".
+	BinaryExpr struct {
+		span
+		Op          string
+		Left, Right Expr
+	}
+
+	// UnaryExpr is "-a", "not a" or "#a".
+	UnaryExpr struct {
+		span
+		Op      string
+		Operand Expr
+	}
+
+	// ParenExpr is an expression in parentheses, which truncates a call to its first value.
+	ParenExpr struct {
+		span
+		Inner Expr
+	}
+)
+
+// TableField is a field of a table constructor: "[key] = value", "name = value" with NameKey set and Key a
+// *StringExpr holding the name, or a positional "value" with a nil Key.
+type TableField struct {
+	span
+	Key     Expr
+	Value   Expr
+	NameKey bool
+}
+
+type (
+	// LocalStmt is "local names = values".
+	LocalStmt struct {
+		span
+		Names  []*NameExpr
+		Values []Expr
+	}
+
+	// AssignStmt is "targets = values"; every target is a *NameExpr or an *IndexExpr.
+	AssignStmt struct {
+		span
+		Targets []Expr
+		Values  []Expr
+	}
+
+	// CallStmt is a function or method call used as a statement.
+	CallStmt struct {
+		span
+		Call Expr
+	}
+
+	// DoStmt is "do ... end".
+	DoStmt struct {
+		span
+		Body *Block
+	}
+
+	// WhileStmt is "while cond do ... end".
+	WhileStmt struct {
+		span
+		Cond Expr
+		Body *Block
+	}
+
+	// RepeatStmt is "repeat ... until cond"; the condition sees the locals of the body.
+	RepeatStmt struct {
+		span
+		Body *Block
+		Cond Expr
+	}
+
+	// IfStmt is "if cond then ... elseif cond then ... else ... end"; Else is nil without an else branch.
+	IfStmt struct {
+		span
+		Clauses []*IfClause
+		Else    *Block
+	}
+
+	// NumericForStmt is "for var = start, limit, step do ... end"; Step is nil if omitted.
+	NumericForStmt struct {
+		span
+		Var                *NameExpr
+		Start, Limit, Step Expr
+		Body               *Block
+	}
+
+	// GenericForStmt is "for names in values do ... end".
+	GenericForStmt struct {
+		span
+		Names  []*NameExpr
+		Values []Expr
+		Body   *Block
+	}
+
+	// FunctionStmt is "function name.field:method() ... end". Name is a *NameExpr or a chain of *IndexExpr; with
+	// Method set, the last key is the method name and the function takes the implicit parameter self.
+	FunctionStmt struct {
+		span
+		Name   Expr
+		Method bool
+		Func   *FunctionExpr
+	}
+
+	// LocalFunctionStmt is "local function name() ... end".
+	LocalFunctionStmt struct {
+		span
+		Name *NameExpr
+		Func *FunctionExpr
+	}
+
+	// ReturnStmt is "return values".
+	ReturnStmt struct {
+		span
+		Values []Expr
+	}
+
+	// BreakStmt is "break".
+	BreakStmt struct{ span }
+
+	// GotoStmt is "goto label".
+	GotoStmt struct {
+		span
+		Label string
+	}
+
+	// LabelStmt is "::name::".
+	LabelStmt struct {
+		span
+		Name string
+	}
+)
+
+// IfClause is the condition and body of an "if" or "elseif" branch.
+type IfClause struct {
+	Cond Expr
+	Body *Block
+}
+
+func (*NilExpr) exprNode()        {}
+func (*TrueExpr) exprNode()       {}
+func (*FalseExpr) exprNode()      {}
+func (*VarargExpr) exprNode()     {}
+func (*NumberExpr) exprNode()     {}
+func (*StringExpr) exprNode()     {}
+func (*NameExpr) exprNode()       {}
+func (*IndexExpr) exprNode()      {}
+func (*CallExpr) exprNode()       {}
+func (*MethodCallExpr) exprNode() {}
+func (*FunctionExpr) exprNode()   {}
+func (*TableExpr) exprNode()      {}
+func (*BinaryExpr) exprNode()     {}
+func (*UnaryExpr) exprNode()      {}
+func (*ParenExpr) exprNode()      {}
+
+func (*LocalStmt) stmtNode()         {}
+func (*AssignStmt) stmtNode()        {}
+func (*CallStmt) stmtNode()          {}
+func (*DoStmt) stmtNode()            {}
+func (*WhileStmt) stmtNode()         {}
+func (*RepeatStmt) stmtNode()        {}
+func (*IfStmt) stmtNode()            {}
+func (*NumericForStmt) stmtNode()    {}
+func (*GenericForStmt) stmtNode()    {}
+func (*FunctionStmt) stmtNode()      {}
+func (*LocalFunctionStmt) stmtNode() {}
+func (*ReturnStmt) stmtNode()        {}
+func (*BreakStmt) stmtNode()         {}
+func (*GotoStmt) stmtNode()          {}
+func (*LabelStmt) stmtNode()         {}
@@ -0,0 +1,444 @@
+// Package lua implements a lexer for Lua 5.2 source code as used by Factorio scripts.
+package lua
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TokenType identifies the kind of a lexical token.
+type TokenType int
+
+const (
+	TokenEOF TokenType = iota
+	TokenName
+	TokenKeyword
+	TokenNumber
+	TokenString
+	TokenSymbol
+	TokenComment
+)
+
+// String returns a readable name for the token type.
+func (t TokenType) String() string {
+	switch t {
+	case TokenEOF:
+		return "end of file"
+	case TokenName:
+		return "name"
+	case TokenKeyword:
+		return "keyword"
+	case TokenNumber:
+		return "number"
+	case TokenString:
+		return "string"
+	case TokenSymbol:
+		return "symbol"
+	case TokenComment:
+		return "comment"
+	default:
+		return "unknown"
+	}
+}
+
+// Position is a location in a Lua source file. Line and Column are 1-based, Offset is a byte offset.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+// String formats the position as "line:column".
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Token is a single lexical element of Lua source code.
+// Text is the raw source text; for strings Value holds the decoded content, for symbols the Lua 5.2 operator
+// the symbol stands for.
+type Token struct {
+	Type  TokenType
+	Text  string
+	Value string
+	Pos   Position
+	End   int // byte offset just after the token
+}
+
+// SyntaxError reports a lexical or grammatical error at a position in a Lua source file.
+type SyntaxError struct {
+	File    string
+	Pos     Position
+	Message string
+}
+
+// Error formats the error as "file:line:column: message".
+func (e *SyntaxError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("%d:%d: %s", e.Pos.Line, e.Pos.Column, e.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Pos.Line, e.Pos.Column, e.Message)
+}
+
+// keywords lists the reserved words of Lua 5.2.
+var keywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true,
+	"false": true, "for": true, "function": true, "goto": true, "if": true, "in": true,
+	"local": true, "nil": true, "not": true, "or": true, "repeat": true, "return": true,
+	"then": true, "true": true, "until": true, "while": true,
+}
+
+// symbols lists the operators and punctuation of Lua 5.2 and Factorio's "!=", longest first so the lexer matches
+// greedily.
+var symbols = []string{
+	"...", "..", "==", "~=", "!=", "<=", ">=", "::",
+	"+", "-", "*", "/", "%", "^", "#", "<", ">", "=",
+	"(", ")", "{", "}", "[", "]", ";", ":", ",", ".",
+}
+
+// symbolAliases maps the symbols Factorio's Lua adds to the Lua 5.2 operator they stand for.
+var symbolAliases = map[string]string{"!=": "~="}
+
+// IsKeyword reports whether name is a reserved word of Lua 5.2.
+func IsKeyword(name string) bool {
+	return keywords[name]
+}
+
+// lexer turns Lua source code into tokens.
+type lexer struct {
+	file   string
+	source string
+	offset int
+	line   int
+	column int
+}
+
+// Tokenize splits Lua 5.2 source code into tokens. Comments are included as TokenComment tokens,
+// whitespace is dropped. The last token is always TokenEOF.
+func Tokenize(file, source string) ([]Token, error) {
+	l := &lexer{file: file, source: source, line: 1, column: 1}
+
+	// A leading "#!" line is skipped like the reference interpreter does
+	if strings.HasPrefix(source, "#") {
+		for l.offset < len(l.source) && l.source[l.offset] != '\n' {
+			l.advance(1)
+		}
+	}
+
+	var tokens []Token
+	for {
+		token, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+		if token.Type == TokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+// position returns the current position of the lexer.
+func (l *lexer) position() Position {
+	return Position{Offset: l.offset, Line: l.line, Column: l.column}
+}
+
+// errorf builds a SyntaxError at the given position.
+func (l *lexer) errorf(pos Position, format string, args ...interface{}) error {
+	return &SyntaxError{File: l.file, Pos: pos, Message: fmt.Sprintf(format, args...)}
+}
+
+// peek returns the byte at offset+n, or 0 past the end of the source.
+func (l *lexer) peek(n int) byte {
+	if l.offset+n < len(l.source) {
+		return l.source[l.offset+n]
+	}
+	return 0
+}
+
+// advance moves the lexer n bytes forward while tracking lines and columns.
+func (l *lexer) advance(n int) {
+	for i := 0; i < n && l.offset < len(l.source); i++ {
+		if l.source[l.offset] == '\n' {
+			l.line++
+			l.column = 1
+		} else {
+			l.column++
+		}
+		l.offset++
+	}
+}
+
+// next reads the next token from the source.
+func (l *lexer) next() (Token, error) {
+	// Skip whitespace
+	for l.offset < len(l.source) {
+		c := l.source[l.offset]
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' && c != '\f' && c != '\v' {
+			break
+		}
+		l.advance(1)
+	}
+
+	start := l.position()
+	if l.offset >= len(l.source) {
+		return Token{Type: TokenEOF, Pos: start, End: l.offset}, nil
+	}
+
+	c := l.source[l.offset]
+	switch {
+	case c == '-' && l.peek(1) == '-':
+		return l.comment(start)
+	case isNameStart(c):
+		for l.offset < len(l.source) && isNameChar(l.source[l.offset]) {
+			l.advance(1)
+		}
+		text := l.source[start.Offset:l.offset]
+		tokenType := TokenName
+		if keywords[text] {
+			tokenType = TokenKeyword
+		}
+		return Token{Type: tokenType, Text: text, Value: text, Pos: start, End: l.offset}, nil
+	case isDigit(c) || (c == '.' && isDigit(l.peek(1))):
+		return l.number(start)
+	case c == '"' || c == '\'':
+		return l.shortString(start, c)
+	case c == '[' && (l.peek(1) == '[' || l.peek(1) == '='):
+		if level := l.longBracketLevel(); level >= 0 {
+			value, err := l.longBracket(start, level, "string")
+			if err != nil {
+				return Token{}, err
+			}
+			return Token{Type: TokenString, Text: l.source[start.Offset:l.offset], Value: value, Pos: start, End: l.offset}, nil
+		}
+	}
+
+	for _, symbol := range symbols {
+		if strings.HasPrefix(l.source[l.offset:], symbol) {
+			l.advance(len(symbol))
+			value := symbol
+			if alias, ok := symbolAliases[symbol]; ok {
+				value = alias
+			}
+			return Token{Type: TokenSymbol, Text: symbol, Value: value, Pos: start, End: l.offset}, nil
+		}
+	}
+
+	return Token{}, l.errorf(start, "unexpected symbol '%c'", c)
+}
+
+// comment reads a short "-- ..." or long "--[[ ... ]]" comment.
+func (l *lexer) comment(start Position) (Token, error) {
+	l.advance(2)
+	if l.peek(0) == '[' {
+		if level := l.longBracketLevel(); level >= 0 {
+			value, err := l.longBracket(start, level, "comment")
+			if err != nil {
+				return Token{}, err
+			}
+			return Token{Type: TokenComment, Text: l.source[start.Offset:l.offset], Value: value, Pos: start, End: l.offset}, nil
+		}
+	}
+	for l.offset < len(l.source) && l.source[l.offset] != '\n' {
+		l.advance(1)
+	}
+	text := l.source[start.Offset:l.offset]
+	return Token{Type: TokenComment, Text: text, Value: strings.TrimPrefix(text, "--"), Pos: start, End: l.offset}, nil
+}
+
+// longBracketLevel returns the level of a long bracket "[==[" at the current offset, or -1 if there is none.
+func (l *lexer) longBracketLevel() int {
+	level := 0
+	for l.peek(1+level) == '=' {
+		level++
+	}
+	if l.peek(0) == '[' && l.peek(1+level) == '[' {
+		return level
+	}
+	return -1
+}
+
+// longBracket reads a long bracket of the given level and returns its content.
+func (l *lexer) longBracket(start Position, level int, what string) (string, error) {
+	l.advance(level + 2)
+	// A line break directly after the opening bracket is not part of the content
+	if l.peek(0) == '\r' {
+		l.advance(1)
+	}
+	if l.peek(0) == '\n' {
+		l.advance(1)
+	}
+
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(l.source[l.offset:], closing)
+	if end < 0 {
+		return "", l.errorf(start, "unfinished long %s", what)
+	}
+	value := l.source[l.offset : l.offset+end]
+	l.advance(end + len(closing))
+	return value, nil
+}
+
+// number reads a decimal or hexadecimal numeral, including fractions and exponents.
+func (l *lexer) number(start Position) (Token, error) {
+	exponent := "eE"
+	if l.peek(0) == '0' && (l.peek(1) == 'x' || l.peek(1) == 'X') {
+		l.advance(2)
+		exponent = "pP"
+	}
+	for l.offset < len(l.source) {
+		c := l.source[l.offset]
+		if strings.IndexByte(exponent, c) >= 0 && (l.peek(1) == '+' || l.peek(1) == '-') {
+			l.advance(2)
+			continue
+		}
+		if !isNameChar(c) && c != '.' {
+			break
+		}
+		l.advance(1)
+	}
+
+	text := l.source[start.Offset:l.offset]
+	if !validNumber(text) {
+		return Token{}, l.errorf(start, "malformed number near '%s'", text)
+	}
+	return Token{Type: TokenNumber, Text: text, Value: text, Pos: start, End: l.offset}, nil
+}
+
+// shortString reads a quoted string and decodes its escape sequences.
+func (l *lexer) shortString(start Position, quote byte) (Token, error) {
+	l.advance(1)
+	var value strings.Builder
+	for {
+		if l.offset >= len(l.source) || l.source[l.offset] == '\n' {
+			return Token{}, l.errorf(start, "unfinished string")
+		}
+		c := l.source[l.offset]
+		if c == quote {
+			l.advance(1)
+			break
+		}
+		if c != '\\' {
+			value.WriteByte(c)
+			l.advance(1)
+			continue
+		}
+
+		escapePos := l.position()
+		l.advance(1)
+		e := l.peek(0)
+		switch e {
+		case 'a':
+			value.WriteByte('\a')
+		case 'b':
+			value.WriteByte('\b')
+		case 'f':
+			value.WriteByte('\f')
+		case 'n':
+			value.WriteByte('\n')
+		case 'r':
+			value.WriteByte('\r')
+		case 't':
+			value.WriteByte('\t')
+		case 'v':
+			value.WriteByte('\v')
+		case '\\', '"', '\'', '\n':
+			value.WriteByte(e)
+		case 'x':
+			if !isHexDigit(l.peek(1)) || !isHexDigit(l.peek(2)) {
+				return Token{}, l.errorf(escapePos, "hexadecimal digit expected in escape sequence")
+			}
+			value.WriteByte(hexValue(l.peek(1))<<4 | hexValue(l.peek(2)))
+			l.advance(2)
+		case 'z':
+			l.advance(1)
+			for l.offset < len(l.source) && strings.IndexByte(" \t\r\n\f\v", l.source[l.offset]) >= 0 {
+				l.advance(1)
+			}
+			continue
+		default:
+			if !isDigit(e) {
+				return Token{}, l.errorf(escapePos, "invalid escape sequence '\\%c'", e)
+			}
+			code := 0
+			digits := 0
+			for digits < 3 && isDigit(l.peek(0)) {
+				code = code*10 + int(l.peek(0)-'0')
+				digits++
+				l.advance(1)
+			}
+			if code > 255 {
+				return Token{}, l.errorf(escapePos, "decimal escape too large")
+			}
+			value.WriteByte(byte(code))
+			continue
+		}
+		l.advance(1)
+	}
+
+	return Token{Type: TokenString, Text: l.source[start.Offset:l.offset], Value: value.String(), Pos: start, End: l.offset}, nil
+}
+
+// validNumber checks the shape of a numeral read by the lexer.
+func validNumber(text string) bool {
+	lower := strings.ToLower(text)
+	digits := "0123456789"
+	exponent := byte('e')
+	if strings.HasPrefix(lower, "0x") {
+		lower = lower[2:]
+		digits = "0123456789abcdef"
+		exponent = 'p'
+		if lower == "" {
+			return false
+		}
+	}
+
+	mantissa, exp, hasExponent := strings.Cut(lower, string(exponent))
+	intPart, fraction, _ := strings.Cut(mantissa, ".")
+	if intPart+fraction == "" || strings.Count(mantissa, ".") > 1 {
+		return false
+	}
+	for _, c := range intPart + fraction {
+		if !strings.ContainsRune(digits, c) {
+			return false
+		}
+	}
+	if hasExponent {
+		exp = strings.TrimLeft(exp, "+-")
+		if exp == "" {
+			return false
+		}
+		for _, c := range exp {
+			if c < '0' || c > '9' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case isDigit(c):
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
@@ -0,0 +1,670 @@
+package lua
+
+import "fmt"
+
+// binaryPriority holds the left and right priority of each binary operator, as in the reference implementation.
+// A right priority below the left one makes the operator right associative.
+var binaryPriority = map[string][2]int{
+	"or":  {1, 1},
+	"and": {2, 2},
+	"<":   {3, 3}, ">": {3, 3}, "<=": {3, 3}, ">=": {3, 3}, "~=": {3, 3}, "==": {3, 3},
+	"..": {5, 4},
+	"+":  {6, 6}, "-": {6, 6},
+	"*": {7, 7}, "/": {7, 7}, "%": {7, 7},
+	"^": {10, 9},
+}
+
+// unaryPriority is the priority of "not", "#" and unary "-": above every binary operator but "^".
+const unaryPriority = 8
+
+// parser builds the syntax tree of a chunk from its tokens, comments removed.
+type parser struct {
+	file    string
+	tokens  []Token
+	pos     int
+	lastEnd int // byte offset just after the last consumed token
+	fn      *funcState
+}
+
+// funcState tracks the function being parsed.
+type funcState struct {
+	parent *funcState
+	vararg bool
+	block  *blockState
+}
+
+// blockState tracks the labels and locals of a block and the gotos that still look for their label.
+type blockState struct {
+	parent *blockState
+	loop   bool
+	labels map[string]*labelState
+	gotos  []pendingGoto
+	locals []string // names of the locals declared in the block so far, in order
+}
+
+// labelState is a label of a block; active is the number of the block's locals in scope at the label.
+type labelState struct {
+	pos    Position
+	active int
+}
+
+// pendingGoto is a goto looking for its label; active is the number of locals of the block it is in that are in
+// scope at the goto, or were when the block of the goto was entered.
+type pendingGoto struct {
+	stmt   *GotoStmt
+	active int
+}
+
+// Parse parses Lua 5.2 source code into a chunk. The first lexical or grammatical error is returned as a
+// *SyntaxError, including the errors the Lua compiler reports beyond the grammar: "..." outside a vararg function,
+// "break" outside a loop, duplicate labels, "goto" without a visible label and "goto" into the scope of a local.
+func Parse(file, source string) (chunk *Chunk, err error) {
+	tokens, err := Tokenize(file, source)
+	if err != nil {
+		return nil, err
+	}
+	code := tokens[:0]
+	for _, token := range tokens {
+		if token.Type != TokenComment {
+			code = append(code, token)
+		}
+	}
+
+	p := &parser{file: file, tokens: code, fn: &funcState{vararg: true}}
+	defer func() {
+		if r := recover(); r != nil {
+			syntaxErr, ok := r.(*SyntaxError)
+			if !ok {
+				panic(r)
+			}
+			chunk, err = nil, syntaxErr
+		}
+	}()
+
+	body := p.block(false)
+	if p.tok().Type != TokenEOF {
+		p.fail("'<eof>' expected near %s", p.near())
+	}
+	return &Chunk{File: file, Body: body}, nil
+}
+
+// tok returns the current token.
+func (p *parser) tok() Token {
+	return p.tokens[p.pos]
+}
+
+// peek returns the token after the current one.
+func (p *parser) peek() Token {
+	if p.pos+1 < len(p.tokens) {
+		return p.tokens[p.pos+1]
+	}
+	return p.tokens[len(p.tokens)-1]
+}
+
+// next consumes the current token and returns it.
+func (p *parser) next() Token {
+	token := p.tokens[p.pos]
+	if token.Type != TokenEOF {
+		p.pos++
+	}
+	p.lastEnd = token.End
+	return token
+}
+
+// is reports whether the current token is the given keyword or symbol.
+func (p *parser) is(text string) bool {
+	token := p.tok()
+	return (token.Type == TokenKeyword || token.Type == TokenSymbol) && token.Text == text
+}
+
+// accept consumes the current token if it is the given keyword or symbol.
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// expect consumes the given keyword or symbol, or fails.
+func (p *parser) expect(text string) Token {
+	if !p.is(text) {
+		p.fail("'%s' expected near %s", text, p.near())
+	}
+	return p.next()
+}
+
+// expectMatch consumes the keyword or symbol closing a construct opened by who on the given line, or fails.
+func (p *parser) expectMatch(text, who string, line int) {
+	if p.accept(text) {
+		return
+	}
+	if line == p.tok().Pos.Line {
+		p.fail("'%s' expected near %s", text, p.near())
+	}
+	p.fail("'%s' expected (to close '%s' at line %d) near %s", text, who, line, p.near())
+}
+
+// name consumes a name, or fails.
+func (p *parser) name() *NameExpr {
+	token := p.tok()
+	if token.Type != TokenName {
+		p.fail("<name> expected near %s", p.near())
+	}
+	p.next()
+	return &NameExpr{span: span{token.Pos, token.End}, Name: token.Text}
+}
+
+// near describes the current token for an error message.
+func (p *parser) near() string {
+	token := p.tok()
+	if token.Type == TokenEOF {
+		return "<eof>"
+	}
+	return "'" + token.Text + "'"
+}
+
+// fail stops parsing with a syntax error at the current token.
+func (p *parser) fail(format string, args ...interface{}) {
+	p.failAt(p.tok().Pos, format, args...)
+}
+
+// failAt stops parsing with a syntax error at the given position.
+func (p *parser) failAt(pos Position, format string, args ...interface{}) {
+	panic(&SyntaxError{File: p.file, Pos: pos, Message: fmt.Sprintf(format, args...)})
+}
+
+// spanFrom returns the span from start to the end of the last consumed token.
+func (p *parser) spanFrom(start Position) span {
+	return span{start, p.lastEnd}
+}
+
+// openBlock starts a new scope for labels; loop marks the body of a loop, which "break" can leave.
+func (p *parser) openBlock(loop bool) {
+	p.fn.block = &blockState{parent: p.fn.block, loop: loop, labels: make(map[string]*labelState)}
+}
+
+// closeBlock ends the current scope. Gotos without a label in it look for one in the enclosing block, from the
+// scope the block was entered in; at the end of a function, a goto still looking for its label is an error. Like
+// Lua 5.2, a goto may not jump forward into the scope of a local.
+func (p *parser) closeBlock() {
+	block := p.fn.block
+	var pending []pendingGoto
+	for _, jump := range block.gotos {
+		label, ok := block.labels[jump.stmt.Label]
+		if !ok {
+			pending = append(pending, jump)
+			continue
+		}
+		if label.active > jump.active {
+			p.failAt(jump.stmt.Pos(), "<goto %s> at line %d jumps into the scope of local '%s'",
+				jump.stmt.Label, jump.stmt.Pos().Line, block.locals[jump.active])
+		}
+	}
+	p.fn.block = block.parent
+	if block.parent != nil {
+		for _, jump := range pending {
+			jump.active = len(block.parent.locals)
+			block.parent.gotos = append(block.parent.gotos, jump)
+		}
+		return
+	}
+	if len(pending) > 0 {
+		stmt := pending[0].stmt
+		p.failAt(stmt.Pos(), "no visible label '%s' for <goto> at line %d", stmt.Label, stmt.Pos().Line)
+	}
+}
+
+// declareLocals brings locals into the scope of the current block.
+func (p *parser) declareLocals(names ...*NameExpr) {
+	for _, name := range names {
+		p.fn.block.locals = append(p.fn.block.locals, name.Name)
+	}
+}
+
+// block parses the statements of a block in a scope of its own.
+func (p *parser) block(loop bool) *Block {
+	p.openBlock(loop)
+	block := p.statements()
+	p.closeBlock()
+	return block
+}
+
+// statements parses statements up to the end of the enclosing block, a return statement being the last.
+func (p *parser) statements() *Block {
+	start := p.tok().Pos
+	block := &Block{}
+	var trailing []*LabelStmt // labels followed by no other statement yet
+	for !p.blockFollow() {
+		if p.is("return") {
+			block.Stmts = append(block.Stmts, p.returnStmt())
+			trailing = nil
+			break
+		}
+		stmt := p.statement()
+		if stmt == nil {
+			continue
+		}
+		block.Stmts = append(block.Stmts, stmt)
+		if label, ok := stmt.(*LabelStmt); ok {
+			trailing = append(trailing, label)
+		} else {
+			trailing = nil
+		}
+	}
+
+	// Labels at the end of a block are outside the scope of its locals, except before "until", where the locals
+	// are still in scope for the condition
+	if !p.is("until") {
+		for _, label := range trailing {
+			p.fn.block.labels[label.Name].active = 0
+		}
+	}
+	block.span = span{start, max(p.lastEnd, start.Offset)}
+	return block
+}
+
+// blockFollow reports whether the current token ends a block.
+func (p *parser) blockFollow() bool {
+	return p.tok().Type == TokenEOF || p.is("else") || p.is("elseif") || p.is("end") || p.is("until")
+}
+
+// statement parses a statement; an empty statement ";" returns nil.
+func (p *parser) statement() Stmt {
+	start := p.tok().Pos
+	switch {
+	case p.accept(";"):
+		return nil
+	case p.is("if"):
+		return p.ifStmt()
+	case p.is("while"):
+		p.next()
+		cond := p.expr()
+		p.expect("do")
+		body := p.block(true)
+		p.expectMatch("end", "while", start.Line)
+		return &WhileStmt{span: p.spanFrom(start), Cond: cond, Body: body}
+	case p.is("do"):
+		p.next()
+		body := p.block(false)
+		p.expectMatch("end", "do", start.Line)
+		return &DoStmt{span: p.spanFrom(start), Body: body}
+	case p.is("for"):
+		return p.forStmt()
+	case p.is("repeat"):
+		p.next()
+		// The condition is parsed inside the body's scope, it sees the body's locals
+		p.openBlock(true)
+		body := p.statements()
+		p.expectMatch("until", "repeat", start.Line)
+		cond := p.expr()
+		p.closeBlock()
+		return &RepeatStmt{span: p.spanFrom(start), Body: body, Cond: cond}
+	case p.is("function"):
+		return p.functionStmt()
+	case p.is("local"):
+		p.next()
+		if p.accept("function") {
+			name := p.name()
+			p.declareLocals(name)
+			fn := p.funcBody(start)
+			return &LocalFunctionStmt{span: p.spanFrom(start), Name: name, Func: fn}
+		}
+		stmt := &LocalStmt{Names: []*NameExpr{p.name()}}
+		for p.accept(",") {
+			stmt.Names = append(stmt.Names, p.name())
+		}
+		if p.accept("=") {
+			stmt.Values = p.exprList()
+		}
+		p.declareLocals(stmt.Names...)
+		stmt.span = p.spanFrom(start)
+		return stmt
+	case p.is("::"):
+		p.next()
+		name := p.name()
+		p.expect("::")
+		if previous, ok := p.fn.block.labels[name.Name]; ok {
+			p.failAt(start, "label '%s' already defined on line %d", name.Name, previous.pos.Line)
+		}
+		p.fn.block.labels[name.Name] = &labelState{pos: start, active: len(p.fn.block.locals)}
+		return &LabelStmt{span: p.spanFrom(start), Name: name.Name}
+	case p.is("break"):
+		p.next()
+		if !p.inLoop() {
+			p.failAt(start, "<break> at line %d not inside a loop", start.Line)
+		}
+		return &BreakStmt{span: p.spanFrom(start)}
+	case p.is("goto"):
+		p.next()
+		stmt := &GotoStmt{Label: p.name().Name}
+		stmt.span = p.spanFrom(start)
+		p.fn.block.gotos = append(p.fn.block.gotos, pendingGoto{stmt: stmt, active: len(p.fn.block.locals)})
+		return stmt
+	default:
+		return p.exprStmt()
+	}
+}
+
+// inLoop reports whether a block of the current function is the body of a loop.
+func (p *parser) inLoop() bool {
+	for block := p.fn.block; block != nil; block = block.parent {
+		if block.loop {
+			return true
+		}
+	}
+	return false
+}
+
+// ifStmt parses "if cond then ... {elseif cond then ...} [else ...] end".
+func (p *parser) ifStmt() Stmt {
+	start := p.next().Pos
+	stmt := &IfStmt{}
+	for {
+		cond := p.expr()
+		p.expect("then")
+		stmt.Clauses = append(stmt.Clauses, &IfClause{Cond: cond, Body: p.block(false)})
+		if !p.accept("elseif") {
+			break
+		}
+	}
+	if p.accept("else") {
+		stmt.Else = p.block(false)
+	}
+	p.expectMatch("end", "if", start.Line)
+	stmt.span = p.spanFrom(start)
+	return stmt
+}
+
+// forStmt parses a numeric or a generic for loop.
+func (p *parser) forStmt() Stmt {
+	start := p.next().Pos
+	first := p.name()
+	switch {
+	case p.accept("="):
+		stmt := &NumericForStmt{Var: first, Start: p.expr()}
+		p.expect(",")
+		stmt.Limit = p.expr()
+		if p.accept(",") {
+			stmt.Step = p.expr()
+		}
+		p.expect("do")
+		stmt.Body = p.block(true)
+		p.expectMatch("end", "for", start.Line)
+		stmt.span = p.spanFrom(start)
+		return stmt
+	case p.is(",") || p.is("in"):
+		stmt := &GenericForStmt{Names: []*NameExpr{first}}
+		for p.accept(",") {
+			stmt.Names = append(stmt.Names, p.name())
+		}
+		p.expect("in")
+		stmt.Values = p.exprList()
+		p.expect("do")
+		stmt.Body = p.block(true)
+		p.expectMatch("end", "for", start.Line)
+		stmt.span = p.spanFrom(start)
+		return stmt
+	default:
+		p.fail("'=' or 'in' expected near %s", p.near())
+		return nil
+	}
+}
+
+// functionStmt parses "function name{.field}[:method] body".
+func (p *parser) functionStmt() Stmt {
+	start := p.next().Pos
+	stmt := &FunctionStmt{Name: p.name()}
+	for p.is(".") || p.is(":") {
+		method := p.next().Text == ":"
+		key := p.name()
+		stmt.Name = &IndexExpr{
+			span:   span{stmt.Name.Pos(), key.End()},
+			Object: stmt.Name,
+			Key:    &StringExpr{span: key.span, Value: key.Name},
+			Dot:    true,
+		}
+		if method {
+			stmt.Method = true
+			break
+		}
+	}
+	stmt.Func = p.funcBody(start)
+	stmt.span = p.spanFrom(start)
+	return stmt
+}
+
+// funcBody parses the parameters and body of a function whose definition starts at start.
+func (p *parser) funcBody(start Position) *FunctionExpr {
+	fn := &FunctionExpr{}
+	p.expect("(")
+	if !p.is(")") {
+		for {
+			if p.accept("...") {
+				fn.Vararg = true
+				break
+			}
+			fn.Params = append(fn.Params, p.name())
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	p.expect(")")
+
+	p.fn = &funcState{parent: p.fn, vararg: fn.Vararg}
+	fn.Body = p.block(false)
+	p.fn = p.fn.parent
+	p.expectMatch("end", "function", start.Line)
+	fn.span = p.spanFrom(start)
+	return fn
+}
+
+// returnStmt parses "return [values] [;]".
+func (p *parser) returnStmt() Stmt {
+	start := p.next().Pos
+	stmt := &ReturnStmt{}
+	if !p.blockFollow() && !p.is(";") {
+		stmt.Values = p.exprList()
+	}
+	p.accept(";")
+	stmt.span = p.spanFrom(start)
+	return stmt
+}
+
+// exprStmt parses an assignment or a function call.
+func (p *parser) exprStmt() Stmt {
+	start := p.tok().Pos
+	first := p.suffixedExpr()
+	if !p.is("=") && !p.is(",") {
+		switch first.(type) {
+		case *CallExpr, *MethodCallExpr:
+			return &CallStmt{span: p.spanFrom(start), Call: first}
+		}
+		p.fail("syntax error near %s", p.near())
+	}
+
+	stmt := &AssignStmt{Targets: []Expr{p.assignable(first)}}
+	for p.accept(",") {
+		stmt.Targets = append(stmt.Targets, p.assignable(p.suffixedExpr()))
+	}
+	p.expect("=")
+	stmt.Values = p.exprList()
+	stmt.span = p.spanFrom(start)
+	return stmt
+}
+
+// assignable returns target if a value can be assigned to it, or fails.
+func (p *parser) assignable(target Expr) Expr {
+	switch target.(type) {
+	case *NameExpr, *IndexExpr:
+		return target
+	}
+	p.fail("syntax error near %s", p.near())
+	return nil
+}
+
+// exprList parses one or more expressions separated by commas.
+func (p *parser) exprList() []Expr {
+	exprs := []Expr{p.expr()}
+	for p.accept(",") {
+		exprs = append(exprs, p.expr())
+	}
+	return exprs
+}
+
+// expr parses an expression.
+func (p *parser) expr() Expr {
+	return p.subExpr(0)
+}
+
+// subExpr parses an expression whose binary operators bind tighter than limit.
+func (p *parser) subExpr(limit int) Expr {
+	var left Expr
+	if p.is("not") || p.is("-") || p.is("#") {
+		op := p.next()
+		operand := p.subExpr(unaryPriority)
+		left = &UnaryExpr{span: span{op.Pos, operand.End()}, Op: op.Text, Operand: operand}
+	} else {
+		left = p.simpleExpr()
+	}
+
+	for {
+		op := p.tok()
+		priority, ok := binaryPriority[op.Value]
+		if !ok || (op.Type != TokenKeyword && op.Type != TokenSymbol) || priority[0] <= limit {
+			return left
+		}
+		p.next()
+		right := p.subExpr(priority[1])
+		left = &BinaryExpr{span: span{left.Pos(), right.End()}, Op: op.Value, Left: left, Right: right}
+	}
+}
+
+// simpleExpr parses a constant, a vararg, a table constructor, a function or a suffixed expression.
+func (p *parser) simpleExpr() Expr {
+	token := p.tok()
+	switch {
+	case token.Type == TokenNumber:
+		p.next()
+		return &NumberExpr{span: span{token.Pos, token.End}, Text: token.Text}
+	case token.Type == TokenString:
+		p.next()
+		return &StringExpr{span: span{token.Pos, token.End}, Value: token.Value}
+	case p.accept("nil"):
+		return &NilExpr{span{token.Pos, token.End}}
+	case p.accept("true"):
+		return &TrueExpr{span{token.Pos, token.End}}
+	case p.accept("false"):
+		return &FalseExpr{span{token.Pos, token.End}}
+	case p.is("..."):
+		if !p.fn.vararg {
+			p.fail("cannot use '...' outside a vararg function near '...'")
+		}
+		p.next()
+		return &VarargExpr{span{token.Pos, token.End}}
+	case p.is("{"):
+		return p.tableExpr()
+	case p.accept("function"):
+		return p.funcBody(token.Pos)
+	default:
+		return p.suffixedExpr()
+	}
+}
+
+// primaryExpr parses a name or an expression in parentheses.
+func (p *parser) primaryExpr() Expr {
+	token := p.tok()
+	switch {
+	case token.Type == TokenName:
+		return p.name()
+	case p.accept("("):
+		inner := p.expr()
+		p.expectMatch(")", "(", token.Pos.Line)
+		return &ParenExpr{span: p.spanFrom(token.Pos), Inner: inner}
+	default:
+		p.fail("unexpected symbol near %s", p.near())
+		return nil
+	}
+}
+
+// suffixedExpr parses a primary expression followed by field accesses, indexing and calls.
+func (p *parser) suffixedExpr() Expr {
+	start := p.tok().Pos
+	expr := p.primaryExpr()
+	for {
+		switch {
+		case p.accept("."):
+			key := p.name()
+			expr = &IndexExpr{span: p.spanFrom(start), Object: expr, Key: &StringExpr{span: key.span, Value: key.Name}, Dot: true}
+		case p.accept("["):
+			key := p.expr()
+			p.expect("]")
+			expr = &IndexExpr{span: p.spanFrom(start), Object: expr, Key: key}
+		case p.accept(":"):
+			method := p.name()
+			args := p.callArgs()
+			expr = &MethodCallExpr{span: p.spanFrom(start), Object: expr, Method: method.Name, MethodPos: method.Pos(), Args: args}
+		case p.is("(") || p.is("{") || p.tok().Type == TokenString:
+			args := p.callArgs()
+			expr = &CallExpr{span: p.spanFrom(start), Func: expr, Args: args}
+		default:
+			return expr
+		}
+	}
+}
+
+// callArgs parses the arguments of a call: a list in parentheses, a table constructor or a string literal.
+func (p *parser) callArgs() []Expr {
+	token := p.tok()
+	switch {
+	case token.Type == TokenString:
+		p.next()
+		return []Expr{&StringExpr{span: span{token.Pos, token.End}, Value: token.Value}}
+	case p.is("{"):
+		return []Expr{p.tableExpr()}
+	case p.accept("("):
+		var args []Expr
+		if !p.is(")") {
+			args = p.exprList()
+		}
+		p.expectMatch(")", "(", token.Pos.Line)
+		return args
+	default:
+		p.fail("function arguments expected near %s", p.near())
+		return nil
+	}
+}
+
+// tableExpr parses a table constructor.
+func (p *parser) tableExpr() Expr {
+	start := p.expect("{").Pos
+	table := &TableExpr{}
+	for !p.is("}") {
+		fieldStart := p.tok().Pos
+		field := &TableField{}
+		switch {
+		case p.tok().Type == TokenName && p.peek().Type == TokenSymbol && p.peek().Text == "=":
+			key := p.name()
+			p.next()
+			field.Key = &StringExpr{span: key.span, Value: key.Name}
+			field.NameKey = true
+			field.Value = p.expr()
+		case p.accept("["):
+			field.Key = p.expr()
+			p.expect("]")
+			p.expect("=")
+			field.Value = p.expr()
+		default:
+			field.Value = p.expr()
+		}
+		field.span = p.spanFrom(fieldStart)
+		table.Fields = append(table.Fields, field)
+		if !p.accept(",") && !p.accept(";") {
+			break
+		}
+	}
+	p.expectMatch("}", "{", start.Line)
+	table.span = p.spanFrom(start)
+	return table
+}
@@ -0,0 +1,136 @@
+package lua
+
+// Inspect walks the syntax tree below node in source order, calling visit for every node. If visit returns false,
+// the children of that node are skipped. Nil nodes are not visited.
+func Inspect(node Node, visit func(Node) bool) {
+	if node == nil || !visit(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Block:
+		for _, stmt := range n.Stmts {
+			Inspect(stmt, visit)
+		}
+	case *IndexExpr:
+		Inspect(n.Object, visit)
+		Inspect(n.Key, visit)
+	case *CallExpr:
+		Inspect(n.Func, visit)
+		inspectExprs(n.Args, visit)
+	case *MethodCallExpr:
+		Inspect(n.Object, visit)
+		inspectExprs(n.Args, visit)
+	case *FunctionExpr:
+		for _, param := range n.Params {
+			Inspect(param, visit)
+		}
+		Inspect(n.Body, visit)
+	case *TableExpr:
+		for _, field := range n.Fields {
+			Inspect(field, visit)
+		}
+	case *TableField:
+		if n.Key != nil {
+			Inspect(n.Key, visit)
+		}
+		Inspect(n.Value, visit)
+	case *BinaryExpr:
+		Inspect(n.Left, visit)
+		Inspect(n.Right, visit)
+	case *UnaryExpr:
+		Inspect(n.Operand, visit)
+	case *ParenExpr:
+		Inspect(n.Inner, visit)
+	case *LocalStmt:
+		for _, name := range n.Names {
+			Inspect(name, visit)
+		}
+		inspectExprs(n.Values, visit)
+	case *AssignStmt:
+		inspectExprs(n.Targets, visit)
+		inspectExprs(n.Values, visit)
+	case *CallStmt:
+		Inspect(n.Call, visit)
+	case *DoStmt:
+		Inspect(n.Body, visit)
+	case *WhileStmt:
+		Inspect(n.Cond, visit)
+		Inspect(n.Body, visit)
+	case *RepeatStmt:
+		Inspect(n.Body, visit)
+		Inspect(n.Cond, visit)
+	case *IfStmt:
+		for _, clause := range n.Clauses {
+			Inspect(clause.Cond, visit)
+			Inspect(clause.Body, visit)
+		}
+		if n.Else != nil {
+			Inspect(n.Else, visit)
+		}
+	case *NumericForStmt:
+		Inspect(n.Var, visit)
+		Inspect(n.Start, visit)
+		Inspect(n.Limit, visit)
+		if n.Step != nil {
+			Inspect(n.Step, visit)
+		}
+		Inspect(n.Body, visit)
+	case *GenericForStmt:
+		for _, name := range n.Names {
+			Inspect(name, visit)
+		}
+		inspectExprs(n.Values, visit)
+		Inspect(n.Body, visit)
+	case *FunctionStmt:
+		Inspect(n.Name, visit)
+		Inspect(n.Func, visit)
+	case *LocalFunctionStmt:
+		Inspect(n.Name, visit)
+		Inspect(n.Func, visit)
+	case *ReturnStmt:
+		inspectExprs(n.Values, visit)
+	}
+}
+
+// inspectExprs walks a list of expressions.
+func inspectExprs(exprs []Expr, visit func(Node) bool) {
+	for _, expr := range exprs {
+		Inspect(expr, visit)
+	}
+}
+
+// RootName returns the variable an expression indexes into: "storage" for storage.players[index].name, or "" if
+// the expression does not start with a name.
+func RootName(expr Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *NameExpr:
+			return e.Name
+		case *IndexExpr:
+			expr = e.Object
+		case *ParenExpr:
+			expr = e.Inner
+		default:
+			return ""
+		}
+	}
+}
+
+// DottedName returns the name of a chain of field accesses such as "defines.events.on_tick", or "" if the
+// expression is anything else.
+func DottedName(expr Expr) string {
+	switch e := expr.(type) {
+	case *NameExpr:
+		return e.Name
+	case *IndexExpr:
+		key, ok := e.Key.(*StringExpr)
+		if !e.Dot || !ok {
+			return ""
+		}
+		if object := DottedName(e.Object); object != "" {
+			return object + "." + key.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const anchorTestControl = "local handler = require(\"event_handler\")\n" +
+	"handler.add_lib(require(\"freeplay\"))\n" +
+	"handler.add_lib(require(\"silo-script\"))\n"
+
+// planAnchored injects a one line script at the given anchor of anchorTestControl.
+func planAnchored(t *testing.T, mode utils.AnchorMode, expression string) (string, error) {
+	anchor, err := utils.NewAnchor(mode, expression)
+	assert.NoError(t, err)
+	luaFiles := map[string][]byte{"control.lua": []byte(anchorTestControl)}
+	injection := utils.ScriptInjection{Name: "probe", Version: "1.0.0", Code: "print('probe')", Anchor: anchor}
+
+	changes, err := utils.PlanInjection(luaFiles, "control.lua", injection)
+	return string(changes.Modified["control.lua"]), err
+}
+
+// TestAnchorPlacement tests that blocks are inserted before, after or instead of the matched line.
+func TestAnchorPlacement(t *testing.T) {
+	before, err := planAnchored(t, utils.AnchorBefore, `freeplay`)
+	assert.NoError(t, err)
+	block, err := utils.FindInjectionBlock(before, "probe")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, block.BeginLine)
+	assert.Equal(t, "before", block.Attributes["anchor"])
+
+	after, err := planAnchored(t, utils.AnchorAfter, `^local handler`)
+	assert.NoError(t, err)
+	block, err = utils.FindInjectionBlock(after, "probe")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, block.BeginLine)
+
+	replaced, err := planAnchored(t, utils.AnchorReplace, `silo-script`)
+	assert.NoError(t, err)
+	assert.NotContains(t, replaced, "silo-script")
+	block, err = utils.FindInjectionBlock(replaced, "probe")
+	assert.NoError(t, err)
+	assert.Equal(t, "replace", block.Attributes["anchor"])
+	assert.NotEmpty(t, block.Attributes["replaced"])
+}
+
+// TestAnchorMatchCount tests that anchors must match exactly once.
+func TestAnchorMatchCount(t *testing.T) {
+	_, err := planAnchored(t, utils.AnchorAfter, `on_tick`)
+	assert.ErrorIs(t, err, utils.ErrAnchorNotFound)
+	assert.Contains(t, err.Error(), "--after /on_tick/ in 'control.lua'")
+
+	_, err = planAnchored(t, utils.AnchorAfter, `add_lib`)
+	assert.ErrorIs(t, err, utils.ErrAnchorAmbiguous)
+	assert.Contains(t, err.Error(), "matches 2 times (lines 2, 3)")
+
+	_, err = utils.NewAnchor(utils.AnchorBefore, `(`)
+	assert.ErrorContains(t, err, "invalid anchor expression")
+}
+
+// TestAnchorInsideInjectedBlock tests that an anchor may not split an existing block.
+func TestAnchorInsideInjectedBlock(t *testing.T) {
+	anchor, err := utils.NewAnchor(utils.AnchorAfter, `print\('other'\)`)
+	assert.NoError(t, err)
+	control := "local x = 1\n" + utils.BuildInjectionBlock("other", "1.0.0", "print('other')", nil)
+	luaFiles := map[string][]byte{"control.lua": []byte(control)}
+	injection := utils.ScriptInjection{Name: "probe", Version: "1.0.0", Code: "print('probe')", Anchor: anchor}
+
+	_, err = utils.PlanInjection(luaFiles, "control.lua", injection)
+	assert.ErrorContains(t, err, "matches inside the injected block 'other' (lines 2-4)")
+}
+
+// TestAnchoredInjectionRoundTrip tests injecting into a scenario module with --target and removing the block again.
+func TestAnchoredInjectionRoundTrip(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+	freeplay := "local freeplay = {}\nlocal created_items = function()\n  return {}\nend\nreturn freeplay\n"
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{
+		"TestSave/control.lua":           anchorTestControl,
+		"TestSave/scenario/freeplay.lua": freeplay,
+	}))
+
+	scripts := fstest.MapFS{"probe.lua": {Data: []byte("created_items = function()\n  return {[\"iron-plate\"] = 8}\nend")}}
+	anchor, err := utils.NewAnchor(utils.AnchorReplace, `(?s)^local created_items.*?^end$`)
+	assert.NoError(t, err)
+	options := utils.InjectOptions{Target: "scenario/freeplay.lua", Anchor: anchor}
+	assert.NoError(t, utils.InjectCodeIntoZipWithOptions("windows", "TestSave.zip", "probe.lua", "control.lua", scripts, options))
+
+	content, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/scenario/freeplay.lua")
+	assert.NoError(t, err)
+	block, err := utils.FindInjectionBlock(string(content), "probe")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, block.BeginLine)
+	assert.Contains(t, string(content), "return freeplay\n")
+	assert.NotContains(t, string(content), "return {}")
+
+	// The replaced lines come back when the script is removed
+	assert.NoError(t, utils.RemoveCodeFromZip("windows", "TestSave.zip", "probe"))
+	content, err = utils.ReadFileFromZip(saveGameZipPath, "TestSave/scenario/freeplay.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, freeplay, string(content))
+
+	control, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, anchorTestControl, string(control))
+}
+
+// TestResolveFileInZip tests that a path inside the save must name exactly one file.
+func TestResolveFileInZip(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "TestSave.zip")
+	assert.NoError(t, createTestZip(zipPath, map[string]string{
+		"TestSave/control.lua":          "",
+		"TestSave/scenario/control.lua": "",
+		"TestSave/mycontrol.lua":        "",
+	}))
+
+	name, err := utils.ResolveFileInZip(zipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, "TestSave/control.lua", name)
+
+	name, err = utils.ResolveFileInZip(zipPath, "scenario/control.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, "TestSave/scenario/control.lua", name)
+
+	_, err = utils.ResolveFileInZip(zipPath, "control.lua")
+	assert.ErrorContains(t, err, "matches several files")
+
+	_, err = utils.ResolveFileInZip(zipPath, "missing.lua")
+	assert.ErrorContains(t, err, "not found")
+}
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const patcherTestFreeplay = "local freeplay = {}\n" +
+	"\n" +
+	"local created_items = function()\n" +
+	"  return\n" +
+	"  {\n" +
+	"    [\"iron-plate\"] = 8,\n" +
+	"    [\"wood\"] = 1,\n" +
+	"  }\n" +
+	"end\n" +
+	"\n" +
+	"return freeplay\n"
+
+const patcherTestPatch = `diff --git a/scenario/freeplay.lua b/scenario/freeplay.lua
+index 1111111..2222222 100644
+--- a/scenario/freeplay.lua
++++ b/scenario/freeplay.lua
+@@ -4,6 +4,7 @@ local created_items = function()
+   return
+   {
+     ["iron-plate"] = 8,
+-    ["wood"] = 1,
++    ["wood"] = 10,
++    ["stone-furnace"] = 1,
+   }
+ end
+`
+
+const patcherTestPatched = "local freeplay = {}\n" +
+	"\n" +
+	"local created_items = function()\n" +
+	"  return\n" +
+	"  {\n" +
+	"    [\"iron-plate\"] = 8,\n" +
+	"    [\"wood\"] = 10,\n" +
+	"    [\"stone-furnace\"] = 1,\n" +
+	"  }\n" +
+	"end\n" +
+	"\n" +
+	"return freeplay\n"
+
+// TestPatchCodeInZip tests applying a git diff to a save and undoing it with reverse.
+func TestPatchCodeInZip(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{
+		"TestSave/control.lua":           "require(\"scenario.freeplay\")\n",
+		"TestSave/scenario/freeplay.lua": patcherTestFreeplay,
+	}))
+
+	results, err := utils.PatchCodeInZip("windows", "TestSave.zip", []byte(patcherTestPatch), utils.PatchOptions{Fuzz: utils.DefaultPatchFuzz})
+	assert.NoError(t, err)
+	assert.Equal(t, []utils.PatchedFile{{
+		File:  "TestSave/scenario/freeplay.lua",
+		Hunks: []utils.HunkResult{{Line: 4}},
+	}}, results)
+
+	content, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/scenario/freeplay.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, patcherTestPatched, string(content))
+
+	// Applying the same patch again is rejected, reversing it restores the original
+	_, err = utils.PatchCodeInZip("windows", "TestSave.zip", []byte(patcherTestPatch), utils.PatchOptions{})
+	assert.ErrorIs(t, err, utils.ErrPatchRejected)
+
+	_, err = utils.PatchCodeInZip("windows", "TestSave.zip", []byte(patcherTestPatch), utils.PatchOptions{Reverse: true})
+	assert.NoError(t, err)
+	content, err = utils.ReadFileFromZip(saveGameZipPath, "TestSave/scenario/freeplay.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, patcherTestFreeplay, string(content))
+}
+
+// TestApplyPatchOffsetAndFuzz tests that hunks are found at another line and with differing outer context.
+func TestApplyPatchOffsetAndFuzz(t *testing.T) {
+	patches, err := utils.ParsePatch([]byte(patcherTestPatch))
+	assert.NoError(t, err)
+
+	// Three lines were added at the top of the file
+	moved := "-- a\n-- b\n-- c\n" + patcherTestFreeplay
+	patched, results, err := utils.ApplyPatch([]byte(moved), patches[0], 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "-- a\n-- b\n-- c\n"+patcherTestPatched, string(patched))
+	assert.Equal(t, []utils.HunkResult{{Line: 7, Offset: 3}}, results)
+
+	// The outermost context line differs, which only fuzz accepts
+	edited := []byte("local freeplay = {}\n\nlocal created_items = function()\n  return -- items\n" + patcherTestFreeplay[len("local freeplay = {}\n\nlocal created_items = function()\n  return\n"):])
+	_, _, err = utils.ApplyPatch(edited, patches[0], 0)
+	assert.ErrorIs(t, err, utils.ErrPatchRejected)
+	assert.Contains(t, err.Error(), "hunk #1 (line 4) of 'scenario/freeplay.lua'")
+
+	_, results, err = utils.ApplyPatch(edited, patches[0], 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, results[0].Fuzz)
+	assert.Equal(t, 5, results[0].Line)
+}
+
+// TestPatchCreatesAndDeletesFiles tests patches against /dev/null and the missing newline marker.
+func TestPatchCreatesAndDeletesFiles(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{
+		"TestSave/control.lua": "print('control')\n",
+		"TestSave/old.lua":     "print('old')",
+	}))
+
+	patch := "--- /dev/null\n+++ b/new.lua\n@@ -0,0 +1 @@\n+print('new')\n" +
+		"--- a/old.lua\n+++ /dev/null\n@@ -1 +0,0 @@\n-print('old')\n\\ No newline at end of file\n"
+	results, err := utils.PatchCodeInZip("windows", "TestSave.zip", []byte(patch), utils.PatchOptions{})
+	assert.NoError(t, err)
+	assert.True(t, results[0].Created)
+	assert.True(t, results[1].Deleted)
+
+	assert.ElementsMatch(t, []string{"TestSave/control.lua", "TestSave/new.lua", "TestSave/wci-manifest.json"}, zipEntryNames(t, saveGameZipPath))
+	content, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/new.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, "print('new')\n", string(content))
+}
+
+// TestParsePatchErrors tests that malformed patches are rejected.
+func TestParsePatchErrors(t *testing.T) {
+	_, err := utils.ParsePatch([]byte("just text\n"))
+	assert.ErrorContains(t, err, "no unified diff found")
+
+	_, err = utils.ParsePatch([]byte("--- a/x.lua\n+++ b/x.lua\n@@ -1,2 +1,2 @@\n-a\n+b\n"))
+	assert.ErrorContains(t, err, "hunk is shorter than its header")
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRemoveCodeFromZip tests that an injected block is cut out and the original content restored.
+func TestRemoveCodeFromZip(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+
+	original := "local handler = require(\"event_handler\")\n"
+	control := original + "\n" + utils.BuildInjectionBlock("biter_killer", "1.0.0", "print('bk')", nil) + "\n"
+	other := "-- freeplay\n" + utils.BuildInjectionBlock("other", "1.0.0", "print('other')", nil)
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{
+		"TestSave/control.lua":  control,
+		"TestSave/freeplay.lua": other,
+	}))
+
+	assert.NoError(t, utils.RemoveCodeFromZip("windows", "TestSave.zip", "biter_killer"))
+
+	content, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, original, string(content))
+
+	// Blocks of other scripts are left alone
+	content, err = utils.ReadFileFromZip(saveGameZipPath, "TestSave/freeplay.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, other, string(content))
+
+	// Removing again reports that the script is gone
+	err = utils.RemoveCodeFromZip("windows", "TestSave.zip", "biter_killer")
+	assert.ErrorIs(t, err, utils.ErrScriptNotInjected)
+}
+
+// TestRemoveCodeFromZipRefusesEditedBlock tests that a hand-edited block is not removed.
+func TestRemoveCodeFromZipRefusesEditedBlock(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+
+	block := utils.BuildInjectionBlock("biter_killer", "1.0.0", "print('bk')", nil)
+	control := "original\n" + strings.Replace(block, "'bk'", "'edited'", 1)
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{"TestSave/control.lua": control}))
+
+	err := utils.RemoveCodeFromZip("windows", "TestSave.zip", "biter_killer")
+	assert.ErrorIs(t, err, utils.ErrScriptModified)
+	assert.Contains(t, err.Error(), "TestSave/control.lua")
+
+	content, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, control, string(content))
+}
+
+// TestRemoveCodeFromZipChained tests that injecting a script with chained events and removing it again leaves
+// control.lua byte for byte as it was.
+func TestRemoveCodeFromZipChained(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+	scripts := fstest.MapFS{"ticker.lua": {Data: []byte("-- @version 1.0.0\nscript.on_event(defines.events.on_tick, function(e) end)\n")}}
+
+	control := "script.on_init(function() end)\nscript.on_event(defines.events.on_tick, function(e) end)\n"
+	for _, strategy := range []utils.InjectionStrategy{utils.StrategyAppend, utils.StrategyRequire} {
+		assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{"TestSave/control.lua": control}))
+		options := utils.InjectOptions{Strategy: strategy, EventConflicts: utils.EventConflictChain}
+		_, err := utils.InjectScriptsIntoZip("windows", "TestSave.zip", []string{"ticker.lua"}, "control.lua", scripts, options)
+		assert.NoError(t, err)
+		content, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+		assert.NoError(t, err)
+		assert.Contains(t, string(content), "wci_dispatch.on_event(defines.events.on_tick", strategy)
+
+		assert.NoError(t, utils.RemoveCodeFromZip("windows", "TestSave.zip", "ticker"))
+		content, err = utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+		assert.NoError(t, err)
+		assert.Equal(t, control, string(content), strategy)
+	}
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCompareVersions tests numeric comparison of dotted versions.
+func TestCompareVersions(t *testing.T) {
+	assert.Equal(t, -1, utils.CompareVersions("1.9.2", "1.10.0"))
+	assert.Equal(t, 0, utils.CompareVersions("1.2", "1.2.0"))
+	assert.Equal(t, 1, utils.CompareVersions("v2.0.0", "1.99.99"))
+}
+
+// TestUpgradeCodeInZip tests that outdated blocks are reported in check mode and replaced otherwise.
+func TestUpgradeCodeInZip(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+
+	control := "original\n\n" + utils.BuildInjectionBlock("biter_killer", "1.0.0", "print('v1')", nil) + "\nafter\n"
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{"TestSave/control.lua": control}))
+
+	scriptDir := t.TempDir()
+	script := "-- @version 1.2.0\n-- @changelog 1.1.0 Faster cleanup\n-- @changelog 1.2.0 Fixed crash\nprint('v2')"
+	assert.NoError(t, os.WriteFile(filepath.Join(scriptDir, "biter_killer.lua"), []byte(script), 0644))
+	scripts := []string{"biter_killer.lua"}
+
+	// Check mode reports the upgrade without writing
+	upgrades, err := utils.UpgradeCodeInZip("windows", "TestSave.zip", os.DirFS(scriptDir), scripts, true)
+	assert.NoError(t, err)
+	assert.Len(t, upgrades, 1)
+	assert.Equal(t, "1.0.0", upgrades[0].FromVersion)
+	assert.Equal(t, "1.2.0", upgrades[0].ToVersion)
+	assert.Equal(t, "TestSave/control.lua", upgrades[0].File)
+	assert.Len(t, upgrades[0].Changelog, 2)
+
+	content, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, control, string(content))
+
+	// Upgrading replaces the block in place
+	_, err = utils.UpgradeCodeInZip("windows", "TestSave.zip", os.DirFS(scriptDir), scripts, false)
+	assert.NoError(t, err)
+
+	content, err = utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "original\n\n-- WCI:BEGIN biter_killer v1.2.0"))
+	assert.True(t, strings.HasSuffix(string(content), "-- WCI:END biter_killer\n\nafter\n"))
+	assert.NotContains(t, string(content), "print('v1')")
+
+	// A second run finds nothing to do
+	upgrades, err = utils.UpgradeCodeInZip("windows", "TestSave.zip", os.DirFS(scriptDir), scripts, true)
+	assert.NoError(t, err)
+	assert.Empty(t, upgrades)
+}
+
+// TestUpgradeCodeInZipLintGate tests that a new version failing the lint gate of injections leaves the save untouched.
+func TestUpgradeCodeInZipLintGate(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+
+	control := "original\n\n" + utils.BuildInjectionBlock("counter", "1.0.0", "print('v1')", nil) + "\n"
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{"TestSave/control.lua": control}))
+
+	scriptDir := t.TempDir()
+	script := "-- @version 1.1.0\nlocal count = 0\nscript.on_event(defines.events.on_tick, function() count = count + 1 end)\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(scriptDir, "counter.lua"), []byte(script), 0644))
+	scripts := []string{"counter.lua"}
+
+	_, err := utils.UpgradeCodeInZip("windows", "TestSave.zip", os.DirFS(scriptDir), scripts, false)
+	assert.ErrorIs(t, err, utils.ErrLintFailed)
+	assert.ErrorContains(t, err, "desync-upvalue")
+
+	content, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, control, string(content))
+
+	// The suppression comment of injections is honoured
+	script = strings.Replace(script, "end)\n", "end) -- wci-lint: ignore desync-upvalue\n", 1)
+	assert.NoError(t, os.WriteFile(filepath.Join(scriptDir, "counter.lua"), []byte(script), 0644))
+	upgrades, err := utils.UpgradeCodeInZip("windows", "TestSave.zip", os.DirFS(scriptDir), scripts, false)
+	assert.NoError(t, err)
+	assert.Len(t, upgrades, 1)
+}
+
+// TestUpgradeCodeInZipSkipsScriptsNotInSave tests that catalogue scripts the save does not contain are not loaded,
+// so one without a variant for the save's Factorio version does not stop the upgrade of the others.
+func TestUpgradeCodeInZipSkipsScriptsNotInSave(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+
+	control := "original\n\n" + utils.BuildInjectionBlock("a", "1.0.0", "print('v1')", nil) + "\n"
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{
+		"TestSave/control.lua": control,
+		"TestSave/level.dat0":  levelData(t, 1, 1, 110, true),
+	}))
+	scripts := fstest.MapFS{
+		"a.lua":      {Data: []byte("-- @version 1.1.0\nprint('v2')\n")},
+		"only20.lua": {Data: []byte("-- @version 1.0.0\n-- @factorio >=2.0\nprint('2.0')\n")},
+	}
+
+	upgrades, err := utils.UpgradeCodeInZip("windows", "TestSave.zip", scripts, []string{"a.lua", "only20.lua"}, false)
+	assert.NoError(t, err)
+	assert.Len(t, upgrades, 1)
+	assert.Equal(t, "a", upgrades[0].Script)
+
+	content, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "print('v2')")
+}
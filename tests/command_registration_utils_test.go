@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"testing"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCommandCollisionRefused tests that a script adding an existing command aborts the injection with a report.
+func TestCommandCollisionRefused(t *testing.T) {
+	luaFiles := map[string][]byte{
+		"control.lua": []byte("commands.add_command(\"cleanup_biters\", \"help\", function(c) end)\n"),
+	}
+	injection := utils.ScriptInjection{
+		Name:    "biter_killer",
+		Version: "1.0.0",
+		Code:    "commands.add_command(\"cleanup_biters\", \"Kills biters\", function(c) end)",
+	}
+
+	_, err := utils.PlanInjection(luaFiles, "control.lua", injection)
+	assert.ErrorIs(t, err, utils.ErrNameCollision)
+	assert.Contains(t, err.Error(), "command 'cleanup_biters': control.lua:1:1 and biter_killer.lua:1:1 (use --rename-command cleanup_biters=<new name>)")
+}
+
+// TestCommandRenameResolvesCollision tests that a renamed command is injected and keeps its name on upgrade.
+func TestCommandRenameResolvesCollision(t *testing.T) {
+	luaFiles := map[string][]byte{
+		"control.lua": []byte("commands.add_command(\"cleanup_biters\", \"help\", function(c) end)\n"),
+	}
+	injection := utils.ScriptInjection{
+		Name:           "biter_killer",
+		Version:        "1.0.0",
+		Code:           "commands.add_command(\"cleanup_biters\", \"Kills biters\", function(c) end)",
+		CommandRenames: map[string]string{"cleanup_biters": "purge"},
+	}
+
+	changes, err := utils.PlanInjection(luaFiles, "control.lua", injection)
+	assert.NoError(t, err)
+	updated := string(changes.Modified["control.lua"])
+
+	block, err := utils.FindInjectionBlock(updated, "biter_killer")
+	assert.NoError(t, err)
+	assert.Equal(t, "cleanup_biters:purge", block.Attributes["rename"])
+	assert.Contains(t, block.Body, "commands.add_command(\"purge\"")
+	assert.False(t, block.Modified())
+
+	// The rename recorded in the marker is applied to the new version as well
+	locations, err := utils.FindInjectedScript(map[string][]byte{"control.lua": []byte(updated)}, "biter_killer")
+	assert.NoError(t, err)
+	upgraded, err := utils.RenderUpgradedBlock(locations[0], "1.1.0", "commands.add_command(\"cleanup_biters\", \"Kills all biters\", function(c) end)", "")
+	assert.NoError(t, err)
+	assert.Contains(t, upgraded, "commands.add_command(\"purge\", \"Kills all biters\"")
+
+	// Renaming a command the script does not add is an error
+	injection.CommandRenames = map[string]string{"missing": "other"}
+	_, err = utils.PlanInjection(luaFiles, "control.lua", injection)
+	assert.ErrorContains(t, err, "script does not add a command named 'missing'")
+}
+
+// TestRemoteInterfaceCollision tests that remote interfaces of other injected blocks are checked.
+func TestRemoteInterfaceCollision(t *testing.T) {
+	existing := utils.BuildInjectionBlock("other", "1.0.0", "remote.add_interface('wci', {})", nil)
+	luaFiles := map[string][]byte{
+		"control.lua":      []byte("script.on_init(function() end)\n"),
+		"wci/other.lua":    []byte(existing + "\n"),
+		"scenario/lib.lua": []byte("remote.add_interface(\"lib_only\", {})\n"),
+	}
+	injection := utils.ScriptInjection{
+		Name:    "api",
+		Version: "1.0.0",
+		Code:    "remote.add_interface(\"wci\", {})\nremote.add_interface(\"lib_only\", {})",
+	}
+
+	_, err := utils.PlanInjection(luaFiles, "control.lua", injection)
+	assert.ErrorIs(t, err, utils.ErrNameCollision)
+	assert.Contains(t, err.Error(), "remote interface 'wci': wci/other.lua:2:1 and api.lua:1:1")
+	assert.NotContains(t, err.Error(), "lib_only")
+}
+
+// TestParseCommandRenames tests the parsing of --rename-command values.
+func TestParseCommandRenames(t *testing.T) {
+	renames, err := utils.ParseCommandRenames([]string{"cleanup_biters=purge", "a=b-c"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"cleanup_biters": "purge", "a": "b-c"}, renames)
+
+	_, err = utils.ParseCommandRenames([]string{"cleanup_biters"})
+	assert.Error(t, err)
+	_, err = utils.ParseCommandRenames([]string{"a=has space"})
+	assert.Error(t, err)
+	_, err = utils.ParseCommandRenames([]string{"a=b", "a=c"})
+	assert.ErrorContains(t, err, "renamed more than once")
+}
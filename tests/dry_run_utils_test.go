@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestUnifiedDiff tests the hunks written for a changed file.
+func TestUnifiedDiff(t *testing.T) {
+	oldContent := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	newContent := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk"
+
+	expected := "--- a/control.lua\n" +
+		"+++ b/control.lua\n" +
+		"@@ -1,5 +1,5 @@\n" +
+		" a\n" +
+		"-b\n" +
+		"+B\n" +
+		" c\n" +
+		" d\n" +
+		" e\n" +
+		"@@ -8,3 +8,4 @@\n" +
+		" h\n" +
+		" i\n" +
+		" j\n" +
+		"+k\n" +
+		"\\ No newline at end of file\n"
+	assert.Equal(t, expected, utils.UnifiedDiff("control.lua", "control.lua", []byte(oldContent), []byte(newContent)))
+	assert.Equal(t, "", utils.UnifiedDiff("control.lua", "control.lua", []byte(oldContent), []byte(oldContent)))
+	assert.Equal(t, "--- /dev/null\n+++ b/new.lua\n@@ -0,0 +1 @@\n+x\n", utils.UnifiedDiff("", "new.lua", nil, []byte("x\n")))
+}
+
+// rewrittenLuaFiles returns two Lua files of the given length that have no line in common.
+func rewrittenLuaFiles(lines int) ([]byte, []byte) {
+	var oldContent, newContent strings.Builder
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&oldContent, "local old_%d = %d\n", i, i)
+		fmt.Fprintf(&newContent, "local new_%d = %d\n", i, i)
+	}
+	return []byte(oldContent.String()), []byte(newContent.String())
+}
+
+// TestUnifiedDiffMemory tests that the diff of a large rewritten file needs memory linear in its length.
+func TestUnifiedDiffMemory(t *testing.T) {
+	oldContent, newContent := rewrittenLuaFiles(8000)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	diff := utils.UnifiedDiff("control.lua", "control.lua", oldContent, newContent)
+	runtime.ReadMemStats(&after)
+
+	assert.Equal(t, 8000, strings.Count(diff, "\n-local old_"))
+	assert.Equal(t, 8000, strings.Count(diff, "\n+local new_"))
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(32<<20))
+}
+
+// BenchmarkUnifiedDiff measures the diff of a rewritten file of 2,000 lines.
+func BenchmarkUnifiedDiff(b *testing.B) {
+	oldContent, newContent := rewrittenLuaFiles(2000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		utils.UnifiedDiff("control.lua", "control.lua", oldContent, newContent)
+	}
+}
+
+// TestUnifiedDiffRoundTrip tests that the diffs written by UnifiedDiff apply with ApplyPatch.
+func TestUnifiedDiffRoundTrip(t *testing.T) {
+	cases := [][2]string{
+		{"", "one\ntwo\n"},
+		{"one\ntwo\n", ""},
+		{"x\ny\nz\n", "y\nz\nx\n"},
+		{"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n", "1\n3\n4\n5\n6\n7\n8\nextra\n9\n10\n11\n12\n14\n"},
+		{"no newline", "no newline\n"},
+	}
+	for _, c := range cases {
+		diff := utils.UnifiedDiff("f.lua", "f.lua", []byte(c[0]), []byte(c[1]))
+		patches, err := utils.ParsePatch([]byte(diff))
+		assert.NoError(t, err, diff)
+		patched, _, err := utils.ApplyPatch([]byte(c[0]), patches[0], 0)
+		assert.NoError(t, err, diff)
+		assert.Equal(t, c[1], string(patched), diff)
+	}
+}
+
+// TestInjectDryRun tests that a dry run prints the changes and leaves the archive untouched.
+func TestInjectDryRun(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{
+		"TestSave/control.lua": "script.on_init(function() end)\n",
+	}))
+	before, err := os.ReadFile(saveGameZipPath)
+	assert.NoError(t, err)
+
+	scripts := fstest.MapFS{"probe.lua": {Data: []byte("-- @version 1.0.0\nprint('probe')\n")}}
+	var preview bytes.Buffer
+	options := utils.InjectOptions{Strategy: utils.StrategyRequire, DryRun: &preview}
+	assert.NoError(t, utils.InjectCodeIntoZipWithOptions("windows", "TestSave.zip", "probe.lua", "control.lua", scripts, options))
+
+	after, err := os.ReadFile(saveGameZipPath)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	output := preview.String()
+	assert.Contains(t, output, "--- a/TestSave/control.lua\n+++ b/TestSave/control.lua\n@@ -1 +1,6 @@\n script.on_init(function() end)\n+\n+-- WCI:BEGIN probe v1.0.0")
+	assert.Contains(t, output, "--- /dev/null\n+++ b/TestSave/wci/probe.lua\n")
+	assert.Contains(t, output, "+print('probe')\n")
+	assert.Contains(t, output, "Added entries:\n  + TestSave/wci-manifest.json\n  + TestSave/wci/probe.lua\n")
+}
+
+// TestRemoveDryRun tests that removed entries are listed by a dry run.
+func TestRemoveDryRun(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+	module := utils.BuildInjectionBlock("probe", "1.0.0", "print('probe')", map[string]string{"strategy": "require"})
+	loader := utils.BuildInjectionBlock("probe", "1.0.0", "require(\"wci.probe\")", map[string]string{"strategy": "require", "module": "wci.probe"})
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{
+		"TestSave/control.lua":   "local x = 1\n\n" + loader,
+		"TestSave/wci/probe.lua": module,
+	}))
+
+	var preview bytes.Buffer
+	assert.NoError(t, utils.RemoveCodeFromZipWithOptions("windows", "TestSave.zip", "probe", utils.RemoveOptions{DryRun: &preview}))
+	assert.Contains(t, preview.String(), "-require(\"wci.probe\")\n")
+	assert.Contains(t, preview.String(), "Removed entries:\n  - TestSave/wci/probe.lua\n")
+	assert.NotContains(t, preview.String(), "+++ b/TestSave/wci/probe.lua")
+	assert.ElementsMatch(t, []string{"TestSave/control.lua", "TestSave/wci/probe.lua"}, zipEntryNames(t, saveGameZipPath))
+}
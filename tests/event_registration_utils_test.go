@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFindEventRegistrations tests that registrations are found in code but not in strings or comments.
+func TestFindEventRegistrations(t *testing.T) {
+	source := "-- script.on_event(defines.events.on_tick, f)\n" +
+		"local s = \"script.on_init(f)\"\n" +
+		"script.on_event({defines.events.on_player_created, defines.events.on_player_joined_game}, f)\n" +
+		"script.on_nth_tick(60, g)\n" +
+		"script.on_event(defines.events.on_built_entity, h, {{filter = \"type\", type = \"inserter\"}})\n" +
+		"script.on_init(function() end)\n"
+
+	registrations, err := utils.FindEventRegistrations("control.lua", source)
+	assert.NoError(t, err)
+
+	var keys []string
+	for _, registration := range registrations {
+		keys = append(keys, registration.Key())
+	}
+	assert.Equal(t, []string{
+		"on_event defines.events.on_player_created",
+		"on_event defines.events.on_player_joined_game",
+		"on_nth_tick 60",
+		"on_event defines.events.on_built_entity",
+		"on_init",
+	}, keys)
+	assert.Equal(t, 3, registrations[0].Pos.Line)
+	assert.True(t, registrations[3].HasFilters)
+}
+
+// TestEventConflictRefuse tests that conflicting registrations abort the injection with a report.
+func TestEventConflictRefuse(t *testing.T) {
+	luaFiles := map[string][]byte{
+		"control.lua": []byte("script.on_event(defines.events.on_tick, function(e) end)\n"),
+	}
+	injection := utils.ScriptInjection{
+		Name:    "ticker",
+		Version: "1.0.0",
+		Code:    "script.on_event(defines.events.on_tick, function(e) end)",
+	}
+
+	_, err := utils.PlanInjection(luaFiles, "control.lua", injection)
+	assert.ErrorIs(t, err, utils.ErrEventConflict)
+	assert.Contains(t, err.Error(), "on_event defines.events.on_tick: control.lua:1:1 and ticker.lua:1:1")
+}
+
+// TestEventConflictChain tests that conflicting registrations are routed through the dispatcher.
+func TestEventConflictChain(t *testing.T) {
+	control := "script.on_init(function() end)\nscript.on_event(defines.events.on_tick, function(e) end)\n"
+	luaFiles := map[string][]byte{"control.lua": []byte(control)}
+	injection := utils.ScriptInjection{
+		Name:           "ticker",
+		Version:        "1.0.0",
+		Code:           "script.on_event(defines.events.on_tick, function(e) end)\nscript.on_nth_tick(60, function() end)",
+		EventConflicts: utils.EventConflictChain,
+	}
+
+	changes, err := utils.PlanInjection(luaFiles, "control.lua", injection)
+	assert.NoError(t, err)
+	updated := string(changes.Modified["control.lua"])
+
+	// The dispatcher is defined first, only the clashing call of control.lua is rewritten
+	assert.True(t, strings.HasPrefix(updated, "-- WCI:BEGIN wci_dispatch v1.0.0"))
+	assert.Contains(t, updated, "\nscript.on_init(function() end)\nwci_dispatch.on_event(defines.events.on_tick, function(e) end)\n")
+
+	// Every registration of the script goes through the dispatcher
+	block, err := utils.FindInjectionBlock(updated, "ticker")
+	assert.NoError(t, err)
+	assert.Equal(t, "chain", block.Attributes["events"])
+	assert.Contains(t, block.Body, "wci_dispatch.on_event(defines.events.on_tick")
+	assert.Contains(t, block.Body, "wci_dispatch.on_nth_tick(60")
+	assert.NotContains(t, block.Body, "script.on_")
+
+	// A second chained script reuses the dispatcher and leaves dispatched calls alone
+	luaFiles["control.lua"] = []byte(updated)
+	injection.Name = "ticker2"
+	changes, err = utils.PlanInjection(luaFiles, "control.lua", injection)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(changes.Modified["control.lua"]), "-- WCI:BEGIN wci_dispatch"))
+}
+
+// TestEventConflictUnchainable tests that conflicts the dispatcher cannot resolve are refused even in chain mode.
+func TestEventConflictUnchainable(t *testing.T) {
+	luaFiles := map[string][]byte{
+		"control.lua": []byte("local handler = require(\"event_handler\")\nhandler.add_lib(require(\"freeplay\"))\n"),
+	}
+	injection := utils.ScriptInjection{
+		Name:           "setup",
+		Version:        "1.0.0",
+		Code:           "script.on_init(function() end)",
+		EventConflicts: utils.EventConflictChain,
+	}
+
+	_, err := utils.PlanInjection(luaFiles, "control.lua", injection)
+	assert.ErrorIs(t, err, utils.ErrEventConflict)
+	assert.Contains(t, err.Error(), "on_init: control.lua:1:17 and setup.lua:1:1 (cannot chain: registered inside the event_handler library")
+
+	// The event_handler strategy is not affected
+	injection.Strategy = utils.StrategyEventHandler
+	_, err = utils.PlanInjection(luaFiles, "control.lua", injection)
+	assert.NoError(t, err)
+}
+
+// TestEventConflictChainRemoval tests that removing the last chained script restores the target byte for byte.
+func TestEventConflictChainRemoval(t *testing.T) {
+	control := "script.on_init(function() end)\nscript.on_event(defines.events.on_tick, function(e) end)\n"
+	luaFiles := map[string][]byte{"control.lua": []byte(control)}
+	injection := utils.ScriptInjection{
+		Name:           "ticker",
+		Version:        "1.0.0",
+		Code:           "script.on_event(defines.events.on_tick, function(e) end)",
+		EventConflicts: utils.EventConflictChain,
+	}
+	for _, name := range []string{"ticker", "ticker2"} {
+		injection.Name = name
+		changes, err := utils.PlanInjection(luaFiles, "control.lua", injection)
+		assert.NoError(t, err)
+		luaFiles["control.lua"] = changes.Modified["control.lua"]
+	}
+
+	// The dispatcher stays while a chained script still uses it
+	changes, err := utils.PlanRemoval(luaFiles, "ticker")
+	assert.NoError(t, err)
+	luaFiles["control.lua"] = changes.Modified["control.lua"]
+	assert.Contains(t, string(luaFiles["control.lua"]), "-- WCI:BEGIN wci_dispatch")
+	assert.Contains(t, string(luaFiles["control.lua"]), "\nwci_dispatch.on_event(defines.events.on_tick")
+
+	changes, err = utils.PlanRemoval(luaFiles, "ticker2")
+	assert.NoError(t, err)
+	assert.Equal(t, control, string(changes.Modified["control.lua"]))
+}
+
+// TestEventConflictEventHandlerTarget tests that event handlers appended to a control.lua run by event_handler are
+// refused, as event_handler registers the events of its libs again on init and load.
+func TestEventConflictEventHandlerTarget(t *testing.T) {
+	control := "local handler = require(\"event_handler\")\nhandler.add_lib(require(\"freeplay\"))\nhandler.add_lib(require(\"silo-script\"))\n"
+	luaFiles := map[string][]byte{"control.lua": []byte(control)}
+	injection := utils.ScriptInjection{
+		Name:           "greeter",
+		Version:        "1.0.0",
+		Code:           "script.on_event(defines.events.on_player_created, function(e) end)\nscript.on_nth_tick(60, function() end)",
+		EventConflicts: utils.EventConflictChain,
+	}
+
+	for _, strategy := range []utils.InjectionStrategy{utils.StrategyAppend, utils.StrategyRequire} {
+		injection.Strategy = strategy
+		_, err := utils.PlanInjection(luaFiles, "control.lua", injection)
+		assert.ErrorIs(t, err, utils.ErrEventConflict)
+		assert.Contains(t, err.Error(), "on_event defines.events.on_player_created: control.lua:1:17 and greeter.lua:1:1 (cannot chain: "+
+			"event_handler registers the events of its libs again")
+		assert.Contains(t, err.Error(), "on_nth_tick 60: control.lua:1:17 and greeter.lua:2:1")
+		assert.Contains(t, err.Error(), "--strategy event_handler")
+	}
+
+	injection.Strategy = utils.StrategyEventHandler
+	_, err := utils.PlanInjection(luaFiles, "control.lua", injection)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBundledFactorioAPIs tests reading the bundled runtime APIs in the layouts of 1.1 and 2.0.
+func TestBundledFactorioAPIs(t *testing.T) {
+	apis, err := utils.BundledFactorioAPIs()
+	assert.NoError(t, err)
+	assert.Len(t, apis, 2)
+	v11, v20 := apis[0], apis[1]
+	assert.Equal(t, "1.1", v11.Version)
+	assert.Equal(t, "2.0", v20.Version)
+
+	assert.True(t, v11.Events["on_entity_destroyed"])
+	assert.False(t, v20.Events["on_entity_destroyed"])
+	assert.True(t, v20.Events["on_object_destroyed"])
+	assert.True(t, v11.HasMethod("LuaForce", "get_item_launched"))
+	assert.False(t, v20.HasMethod("LuaForce", "get_item_launched"))
+
+	// 1.1 lists the filter keys as parameters, 2.0 in the EntitySearchFilters concept
+	for _, api := range apis {
+		method := api.Method("LuaSurface", "find_entities_filtered")
+		assert.True(t, method.TakesTable)
+		assert.True(t, method.TableKeys["area"])
+		assert.Equal(t, api == v20, method.TableKeys["quality"])
+	}
+
+	range20, err := utils.ParseVersionRange(">=2.0")
+	assert.NoError(t, err)
+	selected, err := utils.FactorioAPIsFor(range20)
+	assert.NoError(t, err)
+	assert.Equal(t, []*utils.FactorioAPI{v20}, selected)
+
+	_, err = utils.ParseFactorioAPI([]byte(`{"application": "factorio", "stage": "prototype"}`))
+	assert.EqualError(t, err, "not a Factorio runtime API description")
+}
+
+// TestLintFactorioAPI tests the api rules: errors for API of another version, warnings for unknown names.
+func TestLintFactorioAPI(t *testing.T) {
+	source := `script.on_event(defines.events.on_entity_destroyed, function() end)
+script.on_event(defines.events.on_made_up, function() end)
+script.on_event(defines.events.on_player_created, function(event)
+  local player = game.get_player(event.player_index)
+  player.surface.find_entities_filtered{type = "unit", quality = "normal", names = {"x"}}
+  player.force.get_item_launched("satellite")
+  local surface = game.surfaces[1]
+  surface:frobnicate()
+  local force = "enemy"
+  force.whatever()
+end)
+`
+	apis, err := utils.BundledFactorioAPIs()
+	assert.NoError(t, err)
+	rules, err := utils.SelectLintRules("api")
+	assert.NoError(t, err)
+
+	findings, err := utils.LintLuaCode("control.lua", []byte(source), rules, apis[1:])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"api-event:1", "api-event:2", "api-filter:5", "api-method:6", "api-method:8"}, lintRuleLines(findings))
+	assert.Equal(t, "control.lua:1:32: error: event 'defines.events.on_entity_destroyed' does not exist in "+
+		"Factorio 2.0, only in 1.1 [api-event]", findings[0].String())
+	assert.Equal(t, utils.SeverityWarning, findings[1].Severity)
+	assert.Equal(t, "unknown key 'names' of 'LuaSurface.find_entities_filtered', it is not in the Factorio 2.0 "+
+		"API bundled with wci, which is a partial subset of runtime-api.json; check the name in the official API "+
+		"documentation", findings[2].Message)
+	assert.Equal(t, utils.SeverityError, findings[3].Severity)
+	assert.Equal(t, utils.SeverityWarning, findings[4].Severity)
+
+	// Against 1.1, the 2.0 filter key is the error
+	findings, err = utils.LintLuaCode("control.lua", []byte(source), rules, apis[:1])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"api-event:2", "api-filter:5", "api-filter:5", "api-method:8"}, lintRuleLines(findings))
+	assert.Equal(t, utils.SeverityError, findings[1].Severity)
+
+	// Without versions to check against, the api rules find nothing
+	findings, err = utils.LintLuaCode("control.lua", []byte(source), rules, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, findings)
+}
+
+// TestInjectChecksFactorioAPI tests that a script using API the Factorio versions it declares lack is refused.
+func TestInjectChecksFactorioAPI(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{"TestSave/control.lua": "local x = 1\n"}))
+	code := "script.on_event(defines.events.on_entity_destroyed, function() end)\n"
+	scripts := fstest.MapFS{
+		"legacy.lua": {Data: []byte("-- @factorio 1.1\n" + code)},
+		"ported.lua": {Data: []byte("-- @factorio >=2.0\n" + code)},
+	}
+
+	_, err := utils.InjectScriptsIntoZip("windows", "TestSave.zip", []string{"ported.lua"}, "control.lua", scripts, utils.InjectOptions{})
+	assert.ErrorIs(t, err, utils.ErrLintFailed)
+	assert.ErrorContains(t, err, "ported.lua:2:32: error: event 'defines.events.on_entity_destroyed' does not exist in Factorio 2.0")
+
+	results, err := utils.InjectScriptsIntoZip("windows", "TestSave.zip", []string{"legacy.lua"}, "control.lua", scripts, utils.InjectOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, results[0].Findings)
+}
+
+// TestTrimFactorioAPI tests that a runtime API trimmed for bundling reads like the full file.
+func TestTrimFactorioAPI(t *testing.T) {
+	full := `{
+ "application": "factorio", "stage": "runtime", "application_version": "2.0.28", "api_version": 5,
+ "classes": [
+  {"name": "LuaSurface", "description": "A surface", "methods": [
+   {"name": "find_entities_filtered", "description": "Finds entities", "parameters": [{"name": "filter", "type": "EntitySearchFilters"}]},
+   {"name": "get_tile", "parameters": [{"name": "x", "type": "int"}, {"name": "y", "type": "int"}]}
+  ]},
+  {"name": "LuaGameScript", "methods": [
+   {"name": "print", "parameters": [{"name": "message", "type": "LocalisedString"}]},
+   {"name": "create_inventory", "format": {"takes_table": true}, "parameters": [{"name": "size", "type": "uint16"}]}
+  ]}
+ ],
+ "defines": [
+  {"name": "events", "values": [{"name": "on_tick", "description": "Every tick"}, {"name": "on_object_destroyed"}]},
+  {"name": "direction", "values": [{"name": "north"}]}
+ ],
+ "concepts": [
+  {"name": "EntitySearchFilters", "type": {"complex_type": "table", "parameters": [{"name": "area", "type": "BoundingBox"}, {"name": "quality", "type": "QualityID"}]}},
+  {"name": "LocalisedString", "type": "string"}
+ ]
+}`
+	trimmed, err := utils.TrimFactorioAPI([]byte(full))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(trimmed), "description")
+	assert.NotContains(t, string(trimmed), "north")
+
+	expected, err := utils.ParseFactorioAPI([]byte(full))
+	assert.NoError(t, err)
+	actual, err := utils.ParseFactorioAPI(trimmed)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+	assert.Equal(t, "2.0.28", actual.Version)
+	assert.True(t, actual.Method("LuaSurface", "find_entities_filtered").TableKeys["quality"])
+
+	// Trimming is reproducible
+	again, err := utils.TrimFactorioAPI(trimmed)
+	assert.NoError(t, err)
+	assert.Equal(t, string(trimmed), string(again))
+}
@@ -2,7 +2,14 @@ package tests
 
 import (
 	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
 	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 // createTestZip creates a sample ZIP file for testing.
@@ -29,3 +36,31 @@ func createTestZip(filePath string, files map[string]string) error {
 
 	return nil
 }
+
+// setupSaveGameDir creates a Windows-style savegame directory in a temporary location and returns its path.
+func setupSaveGameDir(t *testing.T) string {
+	tempDir := t.TempDir()
+	t.Setenv("APPDATA", filepath.Join(tempDir, "appdata"))
+
+	saveGameDir := filepath.Join(tempDir, "appdata", "Factorio", "saves")
+	assert.NoError(t, os.MkdirAll(saveGameDir, 0755))
+	return saveGameDir
+}
+
+// levelData returns the start of a savegame's level data as written by the given Factorio version, zlib compressed
+// like the level.dat0 of current saves or raw like level.dat of old ones.
+func levelData(t *testing.T, major, minor, patch uint16, compressed bool) string {
+	var raw bytes.Buffer
+	assert.NoError(t, binary.Write(&raw, binary.LittleEndian, []uint16{major, minor, patch, 1}))
+	raw.WriteString("map data")
+	if !compressed {
+		return raw.String()
+	}
+
+	var packed bytes.Buffer
+	writer := zlib.NewWriter(&packed)
+	_, err := writer.Write(raw.Bytes())
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	return packed.String()
+}
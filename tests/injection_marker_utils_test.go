@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBuildAndParseInjectionBlock tests that a built block can be parsed back with its metadata.
+func TestBuildAndParseInjectionBlock(t *testing.T) {
+	block := utils.BuildInjectionBlock("biter_killer", "1.2.0", "print('hello')", nil)
+	content := "-- scenario code\n" + block + "-- more scenario code\n"
+
+	assert.True(t, strings.HasPrefix(block, "-- WCI:BEGIN biter_killer v1.2.0 sha256="))
+	assert.True(t, strings.HasSuffix(block, "-- WCI:END biter_killer\n"))
+
+	blocks, err := utils.ParseInjectionBlocks(content)
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 1)
+	assert.Equal(t, "biter_killer", blocks[0].Name)
+	assert.Equal(t, "1.2.0", blocks[0].Version)
+	assert.Equal(t, "print('hello')\n", blocks[0].Body)
+	assert.Equal(t, 2, blocks[0].BeginLine)
+	assert.Equal(t, 4, blocks[0].EndLine)
+	assert.Equal(t, block, content[blocks[0].Start:blocks[0].End])
+	assert.False(t, blocks[0].Modified())
+
+	// Editing the body by hand is detected through the recorded hash
+	edited := strings.Replace(content, "hello", "hullo", 1)
+	found, err := utils.FindInjectionBlock(edited, "biter_killer")
+	assert.NoError(t, err)
+	assert.NotNil(t, found)
+	assert.True(t, found.Modified())
+
+	// Unknown scripts are reported as absent
+	found, err = utils.FindInjectionBlock(content, "other")
+	assert.NoError(t, err)
+	assert.Nil(t, found)
+}
+
+// TestParseInjectionBlocksErrors tests that broken marker structures are rejected.
+func TestParseInjectionBlocksErrors(t *testing.T) {
+	block := utils.BuildInjectionBlock("a", "1.0.0", "x = 1", nil)
+
+	testCases := map[string]string{
+		"unterminated": strings.Replace(block, "-- WCI:END a\n", "", 1),
+		"mismatched":   strings.Replace(block, "-- WCI:END a", "-- WCI:END b", 1),
+		"orphan end":   "-- WCI:END a\n",
+		"nested":       strings.Replace(block, "x = 1\n", utils.BuildInjectionBlock("b", "1.0.0", "y = 2", nil), 1),
+		"malformed":    "-- WCI:BEGIN a\n-- WCI:END a\n",
+	}
+
+	for name, content := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := utils.ParseInjectionBlocks(content)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestParseScriptVersion tests reading the @version header of a script.
+func TestParseScriptVersion(t *testing.T) {
+	assert.Equal(t, "1.2.0", utils.ParseScriptVersion([]byte("-- @version 1.2.0\n\nlocal x = 1\n")))
+	assert.Equal(t, "0.0.0", utils.ParseScriptVersion([]byte("local x = 1\n-- @version 1.2.0\n")))
+	assert.Equal(t, "biter_killer", utils.ScriptNameFromFile("lua_injections/biter_killer.lua"))
+}
+
+// TestInjectCodeIntoZipIsIdempotent tests that a changed script is not appended a second time.
+func TestInjectCodeIntoZipIsIdempotent(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	tempDir := t.TempDir()
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{"TestSave/control.lua": "original content"}))
+
+	scriptPath := filepath.Join(tempDir, "biter_killer.lua")
+	assert.NoError(t, os.WriteFile(scriptPath, []byte("-- @version 1.0.0\nprint('v1')"), 0644))
+	assert.NoError(t, utils.InjectCodeIntoZip("windows", "TestSave.zip", "biter_killer.lua", "control.lua", os.DirFS(tempDir)))
+
+	// Change the script and inject again
+	assert.NoError(t, os.WriteFile(scriptPath, []byte("-- @version 1.1.0\nprint('v2')"), 0644))
+	assert.NoError(t, utils.InjectCodeIntoZip("windows", "TestSave.zip", "biter_killer.lua", "control.lua", os.DirFS(tempDir)))
+
+	content, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(content), "-- WCI:BEGIN biter_killer"))
+
+	block, err := utils.FindInjectionBlock(string(content), "biter_killer")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.0.0", block.Version)
+	assert.Contains(t, block.Body, "print('v1')")
+}
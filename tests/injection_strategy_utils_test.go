@@ -0,0 +1,172 @@
+package tests
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// zipEntryNames returns the names of all entries in a ZIP file.
+func zipEntryNames(t *testing.T, zipPath string) []string {
+	zipReader, err := zip.OpenReader(zipPath)
+	assert.NoError(t, err)
+	defer zipReader.Close()
+
+	var names []string
+	for _, file := range zipReader.File {
+		names = append(names, file.Name)
+	}
+	return names
+}
+
+// TestRequireStrategyLifecycle tests injecting, upgrading and removing a script as a separate module.
+func TestRequireStrategyLifecycle(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+	original := "local handler = require(\"event_handler\")\n"
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{"TestSave/control.lua": original}))
+
+	scriptDir := t.TempDir()
+	scriptPath := filepath.Join(scriptDir, "biter_killer.lua")
+	assert.NoError(t, os.WriteFile(scriptPath, []byte("-- @version 1.0.0\nprint('v1')"), 0644))
+
+	options := utils.InjectOptions{Strategy: utils.StrategyRequire}
+	err := utils.InjectCodeIntoZipWithOptions("windows", "TestSave.zip", "biter_killer.lua", "control.lua", os.DirFS(scriptDir), options)
+	assert.NoError(t, err)
+
+	// control.lua only gains a require line, the script lives in its own module
+	control, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	assert.Contains(t, string(control), "require(\"wci.biter_killer\")")
+	assert.NotContains(t, string(control), "print('v1')")
+
+	module, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/wci/biter_killer.lua")
+	assert.NoError(t, err)
+	assert.Contains(t, string(module), "print('v1')")
+
+	// Upgrading rewrites the module and bumps the loader version
+	assert.NoError(t, os.WriteFile(scriptPath, []byte("-- @version 1.1.0\nprint('v2')"), 0644))
+	upgrades, err := utils.UpgradeCodeInZip("windows", "TestSave.zip", os.DirFS(scriptDir), []string{"biter_killer.lua"}, false)
+	assert.NoError(t, err)
+	assert.Len(t, upgrades, 1)
+	assert.Equal(t, "TestSave/wci/biter_killer.lua", upgrades[0].File)
+
+	control, err = utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	assert.Contains(t, string(control), "-- WCI:BEGIN biter_killer v1.1.0")
+	assert.Contains(t, string(control), "module=wci.biter_killer")
+
+	module, err = utils.ReadFileFromZip(saveGameZipPath, "TestSave/wci/biter_killer.lua")
+	assert.NoError(t, err)
+	assert.Contains(t, string(module), "print('v2')")
+
+	// Removing drops the require line and deletes the module file
+	assert.NoError(t, utils.RemoveCodeFromZip("windows", "TestSave.zip", "biter_killer"))
+
+	control, err = utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, original, string(control))
+	assert.ElementsMatch(t, []string{"TestSave/control.lua", "TestSave/wci-manifest.json"}, zipEntryNames(t, saveGameZipPath))
+}
+
+// TestParseInjectionStrategy tests parsing strategy names from the command line.
+func TestParseInjectionStrategy(t *testing.T) {
+	strategy, err := utils.ParseInjectionStrategy("")
+	assert.NoError(t, err)
+	assert.Equal(t, utils.StrategyAppend, strategy)
+
+	strategy, err = utils.ParseInjectionStrategy("require")
+	assert.NoError(t, err)
+	assert.Equal(t, utils.StrategyRequire, strategy)
+
+	_, err = utils.ParseInjectionStrategy("prepend")
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "append, require, event_handler"))
+}
+
+// TestEventHandlerStrategy tests that a script is packaged as a lib and registered next to the existing libs.
+func TestEventHandlerStrategy(t *testing.T) {
+	control := "local handler = require(\"event_handler\")\n" +
+		"handler.add_lib(require(\"freeplay\"))\n" +
+		"handler.add_lib(require(\"silo-script\"))\n" +
+		"\n-- scenario code\n"
+	luaFiles := map[string][]byte{"TestSave/control.lua": []byte(control)}
+
+	injection := utils.ScriptInjection{
+		Name:     "biter_killer",
+		Version:  "1.0.0",
+		Code:     "script.on_event(defines.events.on_player_created, function(e) end)",
+		Strategy: utils.StrategyEventHandler,
+	}
+	changes, err := utils.PlanInjection(luaFiles, "TestSave/control.lua", injection)
+	assert.NoError(t, err)
+
+	// The loader is placed directly after the last add_lib call
+	updated := string(changes.Modified["TestSave/control.lua"])
+	libIndex := strings.Index(updated, "handler.add_lib(require(\"silo-script\"))\n-- WCI:BEGIN biter_killer")
+	assert.GreaterOrEqual(t, libIndex, 0)
+	assert.Contains(t, updated, "require(\"event_handler\").add_lib(require(\"wci.biter_killer\"))\n-- WCI:END biter_killer\n\n-- scenario code\n")
+
+	// The module returns a lib table and records registrations in it
+	module := string(changes.Modified["TestSave/wci/biter_killer.lua"])
+	assert.Contains(t, module, "local wci_lib = {events = {}, on_nth_tick = {}}")
+	assert.Contains(t, module, "script.on_event(defines.events.on_player_created")
+	assert.Contains(t, module, "return wci_lib")
+
+	// A second lib is registered after the first injected loader
+	luaFiles["TestSave/control.lua"] = []byte(updated)
+	luaFiles["TestSave/wci/biter_killer.lua"] = []byte(module)
+	injection.Name = "other"
+	changes, err = utils.PlanInjection(luaFiles, "TestSave/control.lua", injection)
+	assert.NoError(t, err)
+	updated = string(changes.Modified["TestSave/control.lua"])
+	assert.Contains(t, updated, "-- WCI:END biter_killer\n-- WCI:BEGIN other")
+
+	// Removal restores the original control.lua and drops both modules
+	luaFiles["TestSave/control.lua"] = []byte(updated)
+	luaFiles["TestSave/wci/other.lua"] = changes.Modified["TestSave/wci/other.lua"]
+	for _, name := range []string{"other", "biter_killer"} {
+		removal, err := utils.PlanRemoval(luaFiles, name)
+		assert.NoError(t, err)
+		assert.Len(t, removal.Removed, 1)
+		luaFiles["TestSave/control.lua"] = removal.Modified["TestSave/control.lua"]
+		delete(luaFiles, removal.Removed[0])
+	}
+	assert.Equal(t, control, string(luaFiles["TestSave/control.lua"]))
+}
+
+// TestEventHandlerStrategyRequiresEventHandler tests that scenarios without event_handler are rejected.
+func TestEventHandlerStrategyRequiresEventHandler(t *testing.T) {
+	luaFiles := map[string][]byte{"control.lua": []byte("script.on_init(function() end)\n")}
+	injection := utils.ScriptInjection{Name: "a", Version: "1.0.0", Code: "x = 1", Strategy: utils.StrategyEventHandler}
+
+	_, err := utils.PlanInjection(luaFiles, "control.lua", injection)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "event_handler")
+}
+
+// TestEventHandlerStrategyRefusesFilters tests that a script passing event filters is not packaged as a lib, which
+// would drop them.
+func TestEventHandlerStrategyRefusesFilters(t *testing.T) {
+	luaFiles := map[string][]byte{"control.lua": []byte("local handler = require(\"event_handler\")\n")}
+	injection := utils.ScriptInjection{
+		Name:     "a",
+		Version:  "1.0.0",
+		Code:     "script.on_event(defines.events.on_built_entity, function(e) end, {{filter = \"name\", name = \"radar\"}})",
+		Strategy: utils.StrategyEventHandler,
+	}
+
+	_, err := utils.PlanInjection(luaFiles, "control.lua", injection)
+	assert.EqualError(t, err, "a.lua:1:1: script.on_event is called with event filters, which the event_handler "+
+		"library cannot register; use the append or require strategy")
+
+	// Outside event_handler the filters are passed to script.on_event as they are
+	injection.Strategy = utils.StrategyRequire
+	_, err = utils.PlanInjection(map[string][]byte{"control.lua": []byte("-- scenario\n")}, "control.lua", injection)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,48 @@
+package tests
+
+import (
+	"testing"
+	"wci/lua"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTokenize tests that the lexer separates code from strings and comments.
+func TestTokenize(t *testing.T) {
+	source := "local s = \"script.on_event(\\\"x\\\")\" -- script.on_init\n" +
+		"--[==[ long\ncomment ]==]\n" +
+		"local n = 0x1F + 1.5e-3 .. [[long\nstring]]\n"
+
+	tokens, err := lua.Tokenize("test.lua", source)
+	assert.NoError(t, err)
+
+	var types []lua.TokenType
+	var texts []string
+	for _, token := range tokens {
+		types = append(types, token.Type)
+		texts = append(texts, token.Text)
+	}
+	assert.Equal(t, []string{
+		"local", "s", "=", "\"script.on_event(\\\"x\\\")\"", "-- script.on_init",
+		"--[==[ long\ncomment ]==]",
+		"local", "n", "=", "0x1F", "+", "1.5e-3", "..", "[[long\nstring]]", "",
+	}, texts)
+	assert.Equal(t, lua.TokenString, types[3])
+	assert.Equal(t, "script.on_event(\"x\")", tokens[3].Value)
+	assert.Equal(t, lua.TokenComment, types[4])
+	assert.Equal(t, "long\nstring", tokens[13].Value)
+	assert.Equal(t, 4, tokens[6].Pos.Line)
+	assert.Equal(t, lua.TokenEOF, types[len(types)-1])
+}
+
+// TestTokenizeErrors tests that lexical errors carry file, line and column.
+func TestTokenizeErrors(t *testing.T) {
+	_, err := lua.Tokenize("bad.lua", "local a = 1\nlocal b = \"unterminated\n")
+	assert.EqualError(t, err, "bad.lua:2:11: unfinished string")
+
+	_, err = lua.Tokenize("bad.lua", "x = 3..4")
+	assert.EqualError(t, err, "bad.lua:1:5: malformed number near '3..4'")
+
+	_, err = lua.Tokenize("bad.lua", "x = 1 @ 2")
+	assert.EqualError(t, err, "bad.lua:1:7: unexpected symbol '@'")
+}
@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lintRuleLines returns "rule:line" for every finding.
+func lintRuleLines(findings []utils.LintFinding) []string {
+	var ruleLines []string
+	for _, finding := range findings {
+		ruleLines = append(ruleLines, fmt.Sprintf("%s:%d", finding.Rule, finding.Line))
+	}
+	return ruleLines
+}
+
+// TestLintLuaCode tests every rule, the exceptions for storage and on_load, and suppression comments.
+func TestLintLuaCode(t *testing.T) {
+	source := `local counts = {}
+local data
+local names = {"a", "b"}
+local function on_tick(event)
+  counts[event.tick] = true
+  for _, entity in pairs(counts) do end
+  for _, name in ipairs(names) do end
+  data.ticks = (data.ticks or 0) + 1
+  local found = game.surfaces[1].find_entities_filtered{type = "unit"}
+  local text = ""
+  for i = 1, 10 do text = text .. i end
+end
+script.on_init(function() storage.data = {}; data = storage.data end)
+script.on_load(function() data = storage.data; storage.loaded = true; game.print("x") end)
+script.on_event(defines.events.on_tick, on_tick)
+script.on_event(defines.events.on_built_entity, function()
+  table.insert(counts, 1) -- wci-lint: ignore desync-upvalue
+  -- wci-lint: ignore desync
+  counts = {}
+  names = nil
+end)
+`
+	rules, err := utils.SelectLintRules("desync,perf")
+	assert.NoError(t, err)
+	findings, err := utils.LintLuaCode("control.lua", []byte(source), rules, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"desync-upvalue:5", "desync-pairs:6", "perf-tick-search:9", "perf-concat-loop:11",
+		"desync-on-load:14", "desync-on-load:14", "desync-upvalue:20",
+	}, lintRuleLines(findings))
+	assert.Equal(t, "control.lua:5:3: error: the table in file-level local 'counts' is changed in a function; it is not "+
+		"saved with the map and desyncs multiplayer games, keep it in storage [desync-upvalue]", findings[0].String())
+	assert.Equal(t, utils.SeverityInfo, findings[3].Severity)
+
+	// Only the selected rules are applied
+	rules, err = utils.SelectLintRules("perf-tick-search")
+	assert.NoError(t, err)
+	findings, err = utils.LintLuaCode("control.lua", []byte(source), rules, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"perf-tick-search:9"}, lintRuleLines(findings))
+
+	_, err = utils.SelectLintRules("desync,style")
+	assert.ErrorContains(t, err, "unknown lint rule 'style'")
+
+	_, err = utils.LintLuaCode("control.lua", []byte("if x then"), nil, nil)
+	assert.EqualError(t, err, "control.lua:1:10: 'end' expected near <eof>")
+}
+
+// TestInjectLintGate tests that lint errors stop an injection and warnings are reported with the result.
+func TestInjectLintGate(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{"TestSave/control.lua": "local x = 1\n"}))
+	scripts := fstest.MapFS{
+		"counter.lua": {Data: []byte("local count = 0\nscript.on_event(defines.events.on_tick, function() count = count + 1 end)\n")},
+		"scanner.lua": {Data: []byte("script.on_event(defines.events.on_tick, function()\n  game.surfaces[1].find_entities()\nend)\n")},
+	}
+
+	_, err := utils.InjectScriptsIntoZip("windows", "TestSave.zip", []string{"counter.lua"}, "control.lua", scripts, utils.InjectOptions{})
+	assert.ErrorIs(t, err, utils.ErrLintFailed)
+	assert.ErrorContains(t, err, "counter.lua:2:52: error: file-level local 'count' is changed in a function")
+	content, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, "local x = 1\n", string(content))
+
+	results, err := utils.InjectScriptsIntoZip("windows", "TestSave.zip", []string{"scanner.lua"}, "control.lua", scripts, utils.InjectOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, results[0].Findings, 1)
+	assert.Equal(t, "perf-tick-search", results[0].Findings[0].Rule)
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"testing"
+	"wci/lua"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParse tests that the parser accepts the whole Lua 5.2 grammar and builds the expected tree.
+func TestParse(t *testing.T) {
+	source := `local a, b = 1, 2; local c
+local t = {1, 2; x = 3, ["y"] = 4, f = function(...) return ... end, {nested = true},}
+function t.f.g:method(x, ...) return self, x, select('#', ...) end
+local function fact(n) if n <= 1 then return 1 else return n * fact(n - 1) end end
+a.b.c = 1; a[1], a.b = 2, 3
+print "hi"; print [[long]]; f{}:g"x"(1)[2].x = 5
+local x = -2 ^ -3 .. "a" .. "b" == "c" and not #t or (1 + 2) * 3
+for i = 1, 10, 2 do if i == 3 then break elseif i == 4 then goto continue end ::continue:: end
+for k, v in pairs(t) do while true do break end repeat local z = 1 until z == 1 end
+do goto skip end
+::skip::
+script.on_event(defines.events.on_tick, function(event) global.x = (global.x or 0) + 1 end)
+return a, b;
+`
+	chunk, err := lua.Parse("test.lua", source)
+	assert.NoError(t, err)
+	assert.Len(t, chunk.Body.Stmts, 17)
+
+	method := chunk.Body.Stmts[3].(*lua.FunctionStmt)
+	assert.True(t, method.Method)
+	assert.Equal(t, "method", method.Name.(*lua.IndexExpr).Key.(*lua.StringExpr).Value)
+	assert.True(t, method.Func.Vararg)
+
+	// "^" binds tighter than unary minus, ".." is right associative and "or" binds loosest
+	local := chunk.Body.Stmts[10].(*lua.LocalStmt)
+	or := local.Values[0].(*lua.BinaryExpr)
+	assert.Equal(t, "or", or.Op)
+	and := or.Left.(*lua.BinaryExpr)
+	assert.Equal(t, "and", and.Op)
+	equal := and.Left.(*lua.BinaryExpr)
+	assert.Equal(t, "==", equal.Op)
+	concat := equal.Left.(*lua.BinaryExpr)
+	assert.Equal(t, "..", concat.Op)
+	assert.Equal(t, "..", concat.Right.(*lua.BinaryExpr).Op)
+	assert.Equal(t, "-", concat.Left.(*lua.UnaryExpr).Op)
+	assert.Equal(t, "^", concat.Left.(*lua.UnaryExpr).Operand.(*lua.BinaryExpr).Op)
+
+	call := chunk.Body.Stmts[15].(*lua.CallStmt).Call.(*lua.CallExpr)
+	assert.Equal(t, 12, call.Pos().Line)
+	assert.Equal(t, "script.on_event", source[call.Func.Pos().Offset:call.Func.End()])
+	assert.IsType(t, &lua.ReturnStmt{}, chunk.Body.Stmts[16])
+}
+
+// TestParseErrors tests that syntax errors carry file, line and column and read like those of the Lua compiler.
+func TestParseErrors(t *testing.T) {
+	cases := map[string]string{
+		"if a then\n  print(a)\n":               "bad.lua:3:1: 'end' expected (to close 'if' at line 1) near <eof>",
+		"local function f() return 1 x = 2 end": "bad.lua:1:29: 'end' expected near 'x'",
+		"f() = 1":                               "bad.lua:1:5: syntax error near '='",
+		"x = \n":                                "bad.lua:2:1: unexpected symbol near <eof>",
+		"local t = {1 2}":                       "bad.lua:1:14: '}' expected near '2'",
+		"for i do end":                          "bad.lua:1:7: '=' or 'in' expected near 'do'",
+		"local 1 = 2":                           "bad.lua:1:7: <name> expected near '1'",
+		"return 1; x = 2":                       "bad.lua:1:11: '<eof>' expected near 'x'",
+		"function f() return ... end":           "bad.lua:1:21: cannot use '...' outside a vararg function near '...'",
+		"while x do local function f() break end end": "bad.lua:1:31: <break> at line 1 not inside a loop",
+		"goto nowhere":                             "bad.lua:1:1: no visible label 'nowhere' for <goto> at line 1",
+		"::a:: ::a::":                              "bad.lua:1:7: label 'a' already defined on line 1",
+		"x = 'unfinished":                          "bad.lua:1:5: unfinished string",
+		"goto f; local x; ::f:: print(x)":          "bad.lua:1:1: <goto f> at line 1 jumps into the scope of local 'x'",
+		"do goto f end local x ::f:: print(x)":     "bad.lua:1:4: <goto f> at line 1 jumps into the scope of local 'x'",
+		"repeat goto f; local x; ::f:: until x":    "bad.lua:1:8: <goto f> at line 1 jumps into the scope of local 'x'",
+		"goto f; local function g() end ::f:: g()": "bad.lua:1:1: <goto f> at line 1 jumps into the scope of local 'g'",
+	}
+	for source, expected := range cases {
+		_, err := lua.Parse("bad.lua", source)
+		assert.EqualError(t, err, expected, source)
+	}
+}
+
+// TestParseGotoScopes tests the gotos Lua 5.2 accepts around locals: backward jumps and jumps to a label at the end
+// of a block, which is outside the scope of the block's locals.
+func TestParseGotoScopes(t *testing.T) {
+	sources := []string{
+		"local x ::top:: x = 1 goto top",
+		"do goto f; local x; ::f:: end",
+		"goto f; local x; ::f:: ;",
+		"while true do goto continue; local y = 1; ::continue:: ::next:: end",
+		"local x goto f; ::f:: print(x)",
+	}
+	for _, source := range sources {
+		_, err := lua.Parse("good.lua", source)
+		assert.NoError(t, err, source)
+	}
+}
+
+// TestParseNotEqual tests that Factorio's "!=" is read as "~=".
+func TestParseNotEqual(t *testing.T) {
+	source := "local same = a != b"
+	chunk, err := lua.Parse("test.lua", source)
+	assert.NoError(t, err)
+
+	compare := chunk.Body.Stmts[0].(*lua.LocalStmt).Values[0].(*lua.BinaryExpr)
+	assert.Equal(t, "~=", compare.Op)
+	assert.Equal(t, "a != b", source[compare.Pos().Offset:compare.End()])
+}
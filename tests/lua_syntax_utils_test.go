@@ -0,0 +1,58 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestInjectChecksLuaSyntax tests that neither a broken script nor a broken result is written into a savegame.
+func TestInjectChecksLuaSyntax(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+	scripts := fstest.MapFS{
+		"broken.lua": {Data: []byte("-- @version 1.0.0\nlocal x = 1\nif x then\n  print(x)\n")},
+		"hello.lua":  {Data: []byte("-- @version 1.0.0\nprint('hello')\n")},
+	}
+
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{"TestSave/control.lua": "local x = 1\n"}))
+	_, err := utils.InjectScriptsIntoZip("windows", "TestSave.zip", []string{"broken.lua"}, "control.lua", scripts, utils.InjectOptions{})
+	assert.ErrorContains(t, err, "broken.lua:5:1: 'end' expected (to close 'if' at line 3) near <eof>")
+
+	// Code appended after a return statement breaks the file
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{"TestSave/control.lua": "local M = {}\nreturn M\n"}))
+	_, err = utils.InjectScriptsIntoZip("windows", "TestSave.zip", []string{"hello.lua"}, "control.lua", scripts, utils.InjectOptions{})
+	assert.ErrorContains(t, err, "the resulting Lua code does not parse: TestSave/control.lua:6:1: '<eof>' expected near 'print'")
+	content, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, "local M = {}\nreturn M\n", string(content))
+
+	// A file that did not parse before is not the injection's fault
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{"TestSave/control.lua": "placeholder content"}))
+	_, err = utils.InjectScriptsIntoZip("windows", "TestSave.zip", []string{"hello.lua"}, "control.lua", scripts, utils.InjectOptions{})
+	assert.NoError(t, err)
+}
+
+// TestLintScript tests checking catalogue scripts, templates rendered with their defaults.
+func TestLintScript(t *testing.T) {
+	catalogue, err := utils.BuildScriptCatalogue([]utils.ScriptSource{{Kind: utils.SourceFlag, Location: "team", Dir: "scripts", FS: fstest.MapFS{
+		"scripts/greet.lua":      {Data: []byte("-- @param name:string Who to greet\n-- @param loud:bool=false\nprint({{ lua .name }}{{ if .loud }} .. '!'{{ end }})\n")},
+		"scripts/radar/init.lua": {Data: []byte("local gui = require('gui')\n")},
+		"scripts/radar/gui.lua":  {Data: []byte("return {\n  a = 1\n  b = 2\n}\n")},
+	}}})
+	assert.NoError(t, err)
+
+	greet, err := catalogue.Find("greet")
+	assert.NoError(t, err)
+	findings, err := utils.LintScript(greet, nil, utils.VersionRange{})
+	assert.NoError(t, err)
+	assert.Empty(t, findings)
+
+	radar, err := catalogue.Find("radar")
+	assert.NoError(t, err)
+	_, err = utils.LintScript(radar, nil, utils.VersionRange{})
+	assert.EqualError(t, err, filepath.Join("team", "radar", "gui.lua")+":3:3: '}' expected (to close '{' at line 1) near 'b'")
+}
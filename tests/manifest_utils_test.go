@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"wci/config"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestManifestRecordsModifications tests that inject and remove keep the manifest and its history up to date.
+func TestManifestRecordsModifications(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{"TestSave/control.lua": "local x = 1\n"}))
+
+	scripts := fstest.MapFS{
+		"alpha.lua": {Data: []byte("-- @version 1.0.0\nprint('alpha')\n")},
+		"beta.lua":  {Data: []byte("-- @version 2.1.0\nprint('beta')\n")},
+	}
+	_, err := utils.InjectScriptsIntoZip("windows", "TestSave.zip", []string{"alpha.lua"}, "control.lua", scripts, utils.InjectOptions{})
+	assert.NoError(t, err)
+
+	manifest, manifestPath, err := utils.ReadManifest(saveGameZipPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "TestSave/wci-manifest.json", manifestPath)
+	if assert.NotNil(t, manifest) {
+		assert.Equal(t, config.VersionNumber, manifest.WCIVersion)
+		if assert.Len(t, manifest.Scripts, 1) {
+			alpha := manifest.Scripts[0]
+			assert.Equal(t, "alpha", alpha.Name)
+			assert.Equal(t, "1.0.0", alpha.Version)
+			assert.Equal(t, string(utils.StrategyAppend), alpha.Strategy)
+			assert.Equal(t, "TestSave/control.lua", alpha.Target)
+			assert.Len(t, alpha.Hash, 64)
+			assert.False(t, alpha.InjectedAt.IsZero())
+		}
+		assert.Equal(t, []string{"alpha"}, manifest.History[0].Scripts)
+	}
+	alphaInjectedAt := manifest.Scripts[0].InjectedAt
+
+	_, err = utils.InjectScriptsIntoZip("windows", "TestSave.zip", []string{"beta.lua"}, "control.lua", scripts, utils.InjectOptions{Strategy: utils.StrategyRequire})
+	assert.NoError(t, err)
+	assert.NoError(t, utils.RemoveCodeFromZip("windows", "TestSave.zip", "alpha"))
+
+	manifest, _, err = utils.ReadManifest(saveGameZipPath)
+	assert.NoError(t, err)
+	if assert.Len(t, manifest.Scripts, 1) {
+		beta := manifest.Scripts[0]
+		assert.Equal(t, "beta", beta.Name)
+		assert.Equal(t, string(utils.StrategyRequire), beta.Strategy)
+		assert.Equal(t, "TestSave/control.lua", beta.Target)
+		assert.Equal(t, "TestSave/wci/beta.lua", beta.Module)
+		assert.False(t, beta.InjectedAt.Before(alphaInjectedAt))
+	}
+	actions := make([]string, 0, len(manifest.History))
+	for _, event := range manifest.History {
+		actions = append(actions, event.Action)
+	}
+	assert.Equal(t, []string{utils.ManifestActionInject, utils.ManifestActionInject, utils.ManifestActionRemove}, actions)
+}
+
+// TestCheckSaveStatus tests that status reports hand edits, scripts missing from the save and unrecorded scripts.
+func TestCheckSaveStatus(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{"TestSave/control.lua": "local x = 1\n"}))
+
+	scripts := fstest.MapFS{
+		"alpha.lua": {Data: []byte("-- @version 1.0.0\nprint('alpha')\n")},
+		"beta.lua":  {Data: []byte("-- @version 1.0.0\nprint('beta')\n")},
+	}
+	_, err := utils.InjectScriptsIntoZip("windows", "TestSave.zip", []string{"alpha.lua", "beta.lua"}, "control.lua", scripts, utils.InjectOptions{})
+	assert.NoError(t, err)
+
+	status, err := utils.CheckSaveStatus("windows", "TestSave.zip")
+	assert.NoError(t, err)
+	assert.True(t, status.Consistent())
+	assert.Len(t, status.Scripts, 2)
+
+	// Edit alpha by hand, drop beta and add an unrecorded block, keeping the manifest as it was
+	control, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	manifest, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/wci-manifest.json")
+	assert.NoError(t, err)
+
+	edited := strings.Replace(string(control), "print('alpha')", "print('edited')", 1)
+	betaStart := strings.Index(edited, "-- WCI:BEGIN beta")
+	betaEnd := strings.Index(edited, "-- WCI:END beta\n") + len("-- WCI:END beta\n")
+	gamma := utils.BuildInjectionBlock("gamma", "3.0.0", "print('gamma')\n", nil)
+	edited = edited[:betaStart] + edited[betaEnd:] + gamma
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{
+		"TestSave/control.lua":       edited,
+		"TestSave/wci-manifest.json": string(manifest),
+	}))
+
+	status, err = utils.CheckSaveStatus("windows", "TestSave.zip")
+	assert.NoError(t, err)
+	assert.False(t, status.Consistent())
+	states := make(map[string]utils.ScriptState)
+	for _, script := range status.Scripts {
+		states[script.Name] = script.State
+	}
+	assert.Equal(t, map[string]utils.ScriptState{
+		"alpha": utils.ScriptStateModified,
+		"beta":  utils.ScriptStateMissing,
+		"gamma": utils.ScriptStateUnrecorded,
+	}, states)
+}
@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"wci/internal"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLoadConfigProfiles tests reading profiles from the configuration file and rejecting invalid ones.
+func TestLoadConfigProfiles(t *testing.T) {
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	t.Setenv(utils.ConfigPathEnv, "")
+
+	// Without a configuration file there are no profiles
+	config, err := utils.LoadConfig("")
+	assert.NoError(t, err)
+	assert.Empty(t, config.Profiles)
+	_, err = config.Profile("megabase")
+	assert.ErrorContains(t, err, "unknown profile 'megabase' (no profiles defined in")
+
+	configPath := filepath.Join(configHome, "wci", utils.ConfigFileName)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(configPath), 0755))
+	assert.NoError(t, os.WriteFile(configPath, []byte(`profiles:
+  megabase:
+    description: Fresh megabase setup
+    strategy: require
+    scripts:
+      - name: report
+        params:
+          radius: 500
+      - lib
+`), 0644))
+
+	config, err = utils.LoadConfig("")
+	assert.NoError(t, err)
+	profile, err := config.Profile("megabase")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"report", "lib"}, profile.ScriptNames())
+	assert.Equal(t, "require", profile.Strategy)
+	assert.Equal(t, map[string]any{"report.radius": 500}, profile.ParamValues(nil))
+	assert.Equal(t, map[string]any{"radius": 10}, profile.ParamValues(map[string]any{"radius": 10}))
+
+	_, err = config.Profile("other")
+	assert.ErrorContains(t, err, "unknown profile 'other' (available: megabase)")
+
+	// An explicit configuration file has to exist and is checked
+	_, err = utils.LoadConfig(filepath.Join(configHome, "missing.yaml"))
+	assert.ErrorContains(t, err, "failed to read configuration")
+
+	invalidPath := filepath.Join(configHome, "invalid.yaml")
+	assert.NoError(t, os.WriteFile(invalidPath, []byte("profiles:\n  twice:\n    scripts: [lib, lib]\n"), 0644))
+	_, err = utils.LoadConfig(invalidPath)
+	assert.ErrorContains(t, err, "profile 'twice': script 'lib' is listed more than once")
+
+	assert.NoError(t, os.WriteFile(invalidPath, []byte("profiles:\n  odd:\n    strategy: paste\n    scripts: [lib]\n"), 0644))
+	_, err = utils.LoadConfig(invalidPath)
+	assert.ErrorContains(t, err, "unknown injection strategy 'paste'")
+}
+
+// TestApplyProfile tests that a profile is injected in one transaction and recorded in the manifest.
+func TestApplyProfile(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{"TestSave/control.lua": "local x = 1\n"}))
+
+	catalogue, err := utils.BuildScriptCatalogue([]utils.ScriptSource{{Kind: utils.SourceEmbedded, Location: "embedded", Dir: "lua", FS: fstest.MapFS{
+		"lua/report.lua": {Data: []byte("-- @version 1.0.0\n-- @param radius:int=0 Radius.\nprint('report {{ .radius }}')\n")},
+		"lua/lib.lua":    {Data: []byte("-- @version 0.5.0\nprint('lib')\n")},
+	}}})
+	assert.NoError(t, err)
+
+	profile := utils.Profile{Scripts: []utils.ProfileScript{{Name: "report", Params: map[string]any{"radius": 500}}, {Name: "lib"}}}
+
+	// A failing script leaves the savegame untouched
+	failing := profile
+	failing.Scripts = append(append([]utils.ProfileScript{}, profile.Scripts...), utils.ProfileScript{Name: "unknown"})
+	_, err = internal.ApplyProfile("windows", "TestSave.zip", catalogue, "megabase", failing, utils.InjectOptions{})
+	assert.ErrorContains(t, err, "profile 'megabase': unknown script 'unknown'")
+	_, err = internal.ApplyProfile("windows", "TestSave.zip", catalogue, "megabase", profile, utils.InjectOptions{Params: map[string]any{"report.radius": "far"}})
+	assert.Error(t, err)
+	assert.Equal(t, []string{"TestSave/control.lua"}, zipEntryNames(t, saveGameZipPath))
+
+	results, err := internal.ApplyProfile("windows", "TestSave.zip", catalogue, "megabase", profile, utils.InjectOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"report", "lib"}, []string{results[0].Name, results[1].Name})
+
+	content, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	assert.True(t, strings.Index(string(content), "print('report 500')") < strings.Index(string(content), "print('lib')"))
+
+	manifest, _, err := utils.ReadManifest(saveGameZipPath)
+	assert.NoError(t, err)
+	events := manifest.ProfileEvents()
+	assert.Len(t, events, 1)
+	assert.Equal(t, "megabase", events[0].Profile)
+	assert.Equal(t, []string{"report", "lib"}, events[0].Scripts)
+}
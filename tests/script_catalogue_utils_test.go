@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"wci/internal"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeScript writes a Lua script into dir.
+func writeScript(t *testing.T, dir, name, code string) string {
+	t.Helper()
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	scriptPath := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(scriptPath, []byte(code), 0644))
+	return scriptPath
+}
+
+// TestScriptCataloguePrecedence tests that --file, --script-dir, WCI_SCRIPT_PATH, the user directory and the
+// embedded scripts are layered in that order and that hidden scripts are reported.
+func TestScriptCataloguePrecedence(t *testing.T) {
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	userDir := filepath.Join(configHome, "wci", "scripts")
+	envDir := t.TempDir()
+	flagDir := t.TempDir()
+	t.Setenv(utils.ScriptPathEnv, envDir)
+
+	writeScript(t, userDir, "biter_killer.lua", "-- @version 9.0.0\n")
+	writeScript(t, userDir, "radar.lua", "-- @version 1.0.0\n")
+	writeScript(t, envDir, "radar.lua", "-- @version 2.0.0\n")
+	writeScript(t, flagDir, "radar.lua", "-- @version 3.0.0\n")
+	localFile := writeScript(t, t.TempDir(), "radar.lua", "-- @version 4.0.0\n")
+
+	catalogue, err := internal.LoadScriptCatalogue([]string{flagDir})
+	assert.NoError(t, err)
+	radar, err := catalogue.Find("radar")
+	assert.NoError(t, err)
+	assert.Equal(t, "3.0.0", radar.Version)
+	assert.Equal(t, utils.SourceFlag, radar.Source)
+	assert.Equal(t, filepath.Join(flagDir, "radar.lua"), radar.Location)
+
+	biterKiller, err := catalogue.Find("biter_killer")
+	assert.NoError(t, err)
+	assert.Equal(t, utils.SourceUser, biterKiller.Source)
+
+	shadowed := catalogue.ShadowsOf("radar")
+	assert.Len(t, shadowed, 2)
+	assert.Equal(t, utils.SourceEnv, shadowed[0].Source)
+	assert.Equal(t, utils.SourceUser, shadowed[1].Source)
+	assert.Equal(t, radar.Location, shadowed[0].ShadowedBy)
+	assert.Equal(t, utils.SourceEmbedded, catalogue.ShadowsOf("biter_killer")[0].Source)
+
+	// A single file wins over every directory
+	catalogue, err = internal.LoadScriptCatalogue([]string{flagDir}, localFile)
+	assert.NoError(t, err)
+	radar, err = catalogue.Find("radar")
+	assert.NoError(t, err)
+	assert.Equal(t, "4.0.0", radar.Version)
+	assert.Equal(t, localFile, radar.Location)
+
+	_, err = internal.LoadScriptCatalogue([]string{filepath.Join(flagDir, "missing")})
+	assert.ErrorContains(t, err, "missing")
+	_, err = internal.LoadScriptCatalogue(nil, filepath.Join(flagDir, "notes.txt"))
+	assert.ErrorContains(t, err, "is not a Lua file")
+}
+
+// TestInjectScriptFromCatalogue tests that a script of one source can depend on a script of another.
+func TestInjectScriptFromCatalogue(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{"TestSave/control.lua": "local x = 1\n"}))
+
+	user := utils.ScriptSource{Kind: utils.SourceFlag, Location: "team", Dir: "scripts", FS: fstest.MapFS{
+		"scripts/report.lua": {Data: []byte("-- @version 1.0.0\n-- @depends lib\nprint('report')\n")},
+	}}
+	base := utils.ScriptSource{Kind: utils.SourceEmbedded, Location: "embedded", Dir: "lua", FS: fstest.MapFS{
+		"lua/lib.lua":    {Data: []byte("-- @version 0.5.0\nprint('lib')\n")},
+		"lua/report.lua": {Data: []byte("-- @version 0.1.0\nprint('old report')\n")},
+	}}
+	catalogue, err := utils.BuildScriptCatalogue([]utils.ScriptSource{user, base})
+	assert.NoError(t, err)
+	assert.Equal(t, []utils.ShadowedScript{{Name: "report", Source: utils.SourceEmbedded, Location: "embedded:lua/report.lua", ShadowedBy: "team/report.lua"}}, catalogue.Shadowed)
+
+	results, err := internal.InjectScripts("windows", "TestSave.zip", catalogue, []string{"report"}, utils.InjectOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []utils.InjectedScript{
+		{Name: "lib", Version: "0.5.0", File: "TestSave/control.lua", Dependency: true},
+		{Name: "report", Version: "1.0.0", File: "TestSave/control.lua"},
+	}, results)
+
+	content, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(string(content), "print('report')"))
+	assert.False(t, strings.Contains(string(content), "old report"))
+
+	_, err = internal.InjectScripts("windows", "TestSave.zip", catalogue, []string{"unknown"}, utils.InjectOptions{})
+	assert.ErrorContains(t, err, "unknown script 'unknown' (available: lib, report)")
+}
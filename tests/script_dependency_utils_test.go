@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// scriptNames returns the names of scripts in order.
+func scriptNames(scripts []utils.ScriptFile) []string {
+	names := make([]string, 0, len(scripts))
+	for _, script := range scripts {
+		names = append(names, script.Name)
+	}
+	return names
+}
+
+// TestParseScriptDependencies tests reading @depends and @after from the script header only.
+func TestParseScriptDependencies(t *testing.T) {
+	code := "-- @version 1.0.0\n-- @depends storage_lib, util\n-- @depends gui\n-- @after biter_killer\nlocal x = 1\n-- @depends ignored\n"
+	depends, after := utils.ParseScriptDependencies([]byte(code))
+	assert.Equal(t, []string{"storage_lib", "util", "gui"}, depends)
+	assert.Equal(t, []string{"biter_killer"}, after)
+}
+
+// TestResolveScriptOrder tests that dependencies are pulled in and ordered before the scripts needing them.
+func TestResolveScriptOrder(t *testing.T) {
+	scripts := fstest.MapFS{
+		"lua/app.lua":    {Data: []byte("-- @depends lib\nprint('app')\n")},
+		"lua/lib.lua":    {Data: []byte("-- @depends base\nprint('lib')\n")},
+		"lua/base.lua":   {Data: []byte("print('base')\n")},
+		"lua/report.lua": {Data: []byte("-- @after app, missing\nprint('report')\n")},
+		"lua/loop_a.lua": {Data: []byte("-- @depends loop_b\n")},
+		"lua/loop_b.lua": {Data: []byte("-- @depends loop_a\n")},
+	}
+	none := func(string) bool { return false }
+
+	ordered, err := utils.ResolveScriptOrder(scripts, []string{"lua/report.lua", "lua/app.lua"}, "", none)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"base", "lib", "app", "report"}, scriptNames(ordered))
+	assert.True(t, ordered[0].Dependency)
+	assert.False(t, ordered[3].Dependency)
+
+	// A dependency that is already injected is not loaded again
+	ordered, err = utils.ResolveScriptOrder(scripts, []string{"lua/lib.lua"}, "", func(name string) bool { return name == "base" })
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"lib"}, scriptNames(ordered))
+
+	_, err = utils.ResolveScriptOrder(scripts, []string{"lua/loop_a.lua"}, "", none)
+	assert.ErrorIs(t, err, utils.ErrDependencyCycle)
+	assert.ErrorContains(t, err, "between loop_a, loop_b")
+
+	missing := fstest.MapFS{"lua/app.lua": {Data: []byte("-- @depends lib\n")}}
+	_, err = utils.ResolveScriptOrder(missing, []string{"lua/app.lua"}, "", none)
+	assert.ErrorContains(t, err, "script 'app' depends on 'lib', which is neither available nor injected")
+}
+
+// TestInjectScriptsIntoZip tests that all scripts are written in dependency order with one rewrite.
+func TestInjectScriptsIntoZip(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{"TestSave/control.lua": "local x = 1\n"}))
+
+	scripts := fstest.MapFS{
+		"app.lua": {Data: []byte("-- @version 2.0.0\n-- @depends lib\nprint('app')\n")},
+		"lib.lua": {Data: []byte("-- @version 1.0.0\nprint('lib')\n")},
+	}
+	results, err := utils.InjectScriptsIntoZip("windows", "TestSave.zip", []string{"app.lua"}, "control.lua", scripts, utils.InjectOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []utils.InjectedScript{
+		{Name: "lib", Version: "1.0.0", File: "TestSave/control.lua", Dependency: true},
+		{Name: "app", Version: "2.0.0", File: "TestSave/control.lua"},
+	}, results)
+
+	content, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	assert.Less(t, strings.Index(string(content), "WCI:BEGIN lib"), strings.Index(string(content), "WCI:BEGIN app"))
+
+	// Running again skips both scripts
+	results, err = utils.InjectScriptsIntoZip("windows", "TestSave.zip", []string{"app.lua", "lib.lua"}, "control.lua", scripts, utils.InjectOptions{})
+	assert.NoError(t, err)
+	assert.True(t, results[0].Skipped)
+	assert.True(t, results[1].Skipped)
+}
+
+// TestInjectScriptsIntoZipIsTransactional tests that a failing script leaves the archive untouched.
+func TestInjectScriptsIntoZipIsTransactional(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{"TestSave/control.lua": "local x = 1\n"}))
+	before, err := os.ReadFile(saveGameZipPath)
+	assert.NoError(t, err)
+
+	// The second script registers the same event as the first one, which is refused
+	scripts := fstest.MapFS{
+		"first.lua":  {Data: []byte("script.on_event(defines.events.on_tick, function() end)\n")},
+		"second.lua": {Data: []byte("script.on_event(defines.events.on_tick, function(event) log(event.tick) end)\n")},
+	}
+	_, err = utils.InjectScriptsIntoZip("windows", "TestSave.zip", []string{"first.lua", "second.lua"}, "control.lua", scripts, utils.InjectOptions{})
+	assert.ErrorIs(t, err, utils.ErrEventConflict)
+	assert.ErrorContains(t, err, "failed to inject 'second'")
+
+	after, err := os.ReadFile(saveGameZipPath)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+}
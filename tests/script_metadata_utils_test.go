@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"testing"
+	"testing/fstest"
+	"wci/internal"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseScriptMetadata tests reading every header tag into the metadata struct.
+func TestParseScriptMetadata(t *testing.T) {
+	code := `-- @name radar
+-- @version 2.0.1
+-- @description Reveals the map
+-- @description around every player.
+-- @author Someone
+-- @command reveal Reveals the map.
+-- @command hide
+-- @events on_tick, on_player_created
+-- @factorio 1.1, >=2.0
+-- @param radius:int=128 Radius in chunks.
+-- @depends util
+-- @changelog 2.0.1 Fixed reveal on new surfaces.
+-- @todo not a metadata tag
+local x = 1
+-- @author ignored after the header
+`
+	metadata, err := utils.ParseScriptMetadata("scripts/radar.lua", []byte(code))
+	assert.NoError(t, err)
+	assert.Equal(t, utils.ScriptMetadata{
+		Name:        "radar",
+		Version:     "2.0.1",
+		Description: "Reveals the map around every player.",
+		Author:      "Someone",
+		Commands:    []utils.ScriptCommand{{Name: "reveal", Help: "Reveals the map."}, {Name: "hide"}},
+		Events:      []string{"on_tick", "on_player_created"},
+		Factorio:    "1.1, >=2.0",
+		Params:      []utils.ScriptParam{{Name: "radius", Type: utils.ParamInt, Default: int64(128), Description: "Radius in chunks."}},
+		Depends:     []string{"util"},
+		Changelog:   []utils.ChangelogEntry{{Version: "2.0.1", Text: "Fixed reveal on new surfaces."}},
+		Path:        "scripts/radar.lua",
+	}, metadata)
+
+	_, err = utils.ParseScriptMetadata("scripts/other.lua", []byte(code))
+	assert.ErrorContains(t, err, "declares the name 'radar', which does not match its file name")
+	_, err = utils.ParseScriptMetadata("x.lua", []byte("-- @factorio >=one\n"))
+	assert.ErrorContains(t, err, "invalid version constraint '>=one'")
+}
+
+// TestVersionRange tests prefix, comparison and alternative constraints.
+func TestVersionRange(t *testing.T) {
+	cases := []struct {
+		expression string
+		version    string
+		contains   bool
+	}{
+		{"", "1.1.110", true},
+		{"1.1", "1.1.110", true},
+		{"1.1", "1.10.0", false},
+		{"1.1, 2.0", "2.0.15", true},
+		{">=1.1 <2.0", "2.0.0", false},
+		{">= 1.1 < 2.0", "1.1.107", true},
+		{"<1.1 || >=2.0", "1.1.0", false},
+		{">2.0", "2.0.1", true},
+	}
+	for _, c := range cases {
+		versionRange, err := utils.ParseVersionRange(c.expression)
+		assert.NoError(t, err, c.expression)
+		assert.Equal(t, c.contains, versionRange.Contains(c.version), "%s contains %s", c.expression, c.version)
+	}
+
+	_, err := utils.ParseVersionRange("1.1,")
+	assert.ErrorContains(t, err, "empty alternative")
+}
+
+// TestLoadScriptCatalogue tests loading the metadata of a directory of scripts.
+func TestLoadScriptCatalogue(t *testing.T) {
+	scripts := fstest.MapFS{
+		"scripts/zeta.lua":   {Data: []byte("-- @version 1.0.0\n")},
+		"scripts/alpha.lua":  {Data: []byte("-- @version 0.1.0\n-- @description First.\n")},
+		"scripts/readme.txt": {Data: []byte("not a script")},
+	}
+	catalogue, err := utils.LoadScriptCatalogue(scripts, "scripts")
+	assert.NoError(t, err)
+	assert.Len(t, catalogue, 2)
+	assert.Equal(t, "alpha", catalogue[0].Name)
+	assert.Equal(t, "zeta", catalogue[1].Name)
+
+	// Every embedded script has a valid header
+	embeddedCatalogue, err := utils.BuildScriptCatalogue([]utils.ScriptSource{internal.EmbeddedScriptSource()})
+	assert.NoError(t, err)
+	biterKiller, err := embeddedCatalogue.Find("biter_killer")
+	assert.NoError(t, err)
+	assert.Equal(t, []utils.ScriptCommand{{Name: "cleanup_biters", Help: "Destroys all biters, spawners, and worms on the player's current surface."}}, biterKiller.Commands)
+	assert.NotEmpty(t, biterKiller.Description)
+}
@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// migrationPositions returns "line:column" of every note.
+func migrationPositions(notes []utils.MigrationNote) []string {
+	var positions []string
+	for _, note := range notes {
+		positions = append(positions, fmt.Sprintf("%d:%d", note.Line, note.Column))
+	}
+	return positions
+}
+
+// TestMigrateLuaCode tests the renames of Factorio 2.0 and the constructs left for migrating by hand.
+func TestMigrateLuaCode(t *testing.T) {
+	source := `script.on_init(function()
+  global.players = global.players or {}
+  game.write_file("players.json", game.table_to_json(global.players))
+  local recipe = game.recipe_prototypes["iron-gear-wheel"]
+  local units = game.get_filtered_entity_prototypes{{filter = "type", type = "unit"}}
+  if game.active_mods["base"] then print("global stays in strings") end -- and global in comments
+  local evolution = game.forces.enemy.evolution_factor
+end)
+script.on_event(defines.events.on_entity_destroyed, function(event) end)
+`
+	migration, err := utils.MigrateLuaCode("control.lua", source, "2.0")
+	assert.NoError(t, err)
+	assert.Equal(t, `script.on_init(function()
+  storage.players = storage.players or {}
+  helpers.write_file("players.json", helpers.table_to_json(storage.players))
+  local recipe = prototypes.recipe["iron-gear-wheel"]
+  local units = prototypes.get_entity_filtered{{filter = "type", type = "unit"}}
+  if script.active_mods["base"] then print("global stays in strings") end -- and global in comments
+  local evolution = game.forces.enemy.evolution_factor
+end)
+script.on_event(defines.events.on_object_destroyed, function(event) end)
+`, migration.Code)
+	assert.Equal(t, []string{"2:3", "2:20", "3:3", "3:35", "3:54", "4:18", "5:17", "6:6", "9:32"}, migrationPositions(migration.Rewritten))
+	assert.Equal(t, "control.lua:3:3: 'game.write_file' is 'helpers.write_file' since Factorio 2.0", migration.Rewritten[2].String())
+	assert.Len(t, migration.Unmigrated, 1)
+	assert.Contains(t, migration.Unmigrated[0].String(), "control.lua:7:39: LuaForce.evolution_factor was replaced")
+
+	// Migrated code stays as it is, and so does code for a save of 1.1
+	again, err := utils.MigrateLuaCode("control.lua", migration.Code, "2.0.28")
+	assert.NoError(t, err)
+	assert.Equal(t, migration.Code, again.Code)
+	assert.Empty(t, again.Rewritten)
+	old, err := utils.MigrateLuaCode("control.lua", source, "1.1.110")
+	assert.NoError(t, err)
+	assert.Equal(t, source, old.Code)
+
+	// A local named global is not the global table, the note lists the uses left as they are
+	shadowed, err := utils.MigrateLuaCode("control.lua", "local global = {}\nglobal.x = 1\n\nprint(global.x)\n", "2.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "local global = {}\nglobal.x = 1\n\nprint(global.x)\n", shadowed.Code)
+	assert.Equal(t, []string{"2:1"}, migrationPositions(shadowed.Unmigrated))
+	assert.Equal(t, "control.lua:2:1: 'global' on line 2, 4 is left as it is, as 'global' is declared local at 1:7",
+		shadowed.Unmigrated[0].String())
+
+	storage, err := utils.MigrateLuaCode("control.lua", "local storage = {}\nglobal.x = storage\n", "2.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "local storage = {}\nglobal.x = storage\n", storage.Code)
+	assert.Equal(t, "control.lua:2:1: 'global' on line 2 is left as it is, as 'storage' is declared local at 1:7",
+		storage.Unmigrated[0].String())
+
+	_, err = utils.MigrateLuaCode("control.lua", "if x then", "2.0")
+	assert.EqualError(t, err, "control.lua:1:10: 'end' expected near <eof>")
+}
+
+// TestMigrateLuaCodeGameAliases tests that uses of game through a local, and places game is handed on, are noted.
+func TestMigrateLuaCodeGameAliases(t *testing.T) {
+	source := `local g = game
+g.write_file("a.txt", "")
+local force = g.forces.player
+remember(game)
+game.print(g.table_to_json({}))
+`
+	migration, err := utils.MigrateLuaCode("control.lua", source, "2.0")
+	assert.NoError(t, err)
+	assert.Equal(t, source, migration.Code)
+	assert.Empty(t, migration.Rewritten)
+	assert.Equal(t, []string{"2:3", "4:10", "5:14"}, migrationPositions(migration.Unmigrated))
+	assert.Equal(t, "control.lua:2:3: 'g.write_file' uses game through the local 'g', which is not migrated; "+
+		"'game.write_file' moved in Factorio 2.0", migration.Unmigrated[0].String())
+	assert.Contains(t, migration.Unmigrated[1].String(), "game is handed on here")
+
+	// A local named game is not the global, its moved fields are listed in one note
+	shadowed, err := utils.MigrateLuaCode("control.lua", "local game = {}\ngame.write_file()\ngame.item_prototypes = {}\n", "2.0")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2:1"}, migrationPositions(shadowed.Unmigrated))
+	assert.Equal(t, "control.lua:2:1: 'game' on line 2, 3 is left as it is, as 'game' is declared local at 1:7",
+		shadowed.Unmigrated[0].String())
+}
+
+// TestMigrateScript tests migrating the files of a catalogue script as written.
+func TestMigrateScript(t *testing.T) {
+	catalogue, err := utils.BuildScriptCatalogue([]utils.ScriptSource{{Kind: utils.SourceFlag, Location: "team", Dir: "scripts", FS: fstest.MapFS{
+		"scripts/radar/init.lua": {Data: []byte("local gui = require('gui')\nglobal.radars = {}\n")},
+		"scripts/radar/gui.lua":  {Data: []byte("return {show = function() game.write_file('radar.txt', '') end}\n")},
+		"scripts/greet.lua":      {Data: []byte("-- @param loud:bool=false\nprint('hi'{{ if .loud }} .. '!'{{ end }})\n")},
+	}}})
+	assert.NoError(t, err)
+
+	radar, err := catalogue.Find("radar")
+	assert.NoError(t, err)
+	files, err := utils.MigrateScript(radar, "2.0")
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	assert.Equal(t, filepath.Join("team", "radar", "init.lua"), files[0].Path)
+	assert.Equal(t, "local gui = require('gui')\nstorage.radars = {}\n", files[0].Code)
+	assert.Equal(t, "return {show = function() helpers.write_file('radar.txt', '') end}\n", files[1].Code)
+
+	greet, err := catalogue.Find("greet")
+	assert.NoError(t, err)
+	_, err = utils.MigrateScript(greet, "2.0")
+	assert.ErrorContains(t, err, "script 'greet' is a template that is no valid Lua before rendering")
+
+	_, err = utils.MigrateScript(radar, "1.1")
+	assert.ErrorIs(t, err, utils.ErrNoMigration)
+}
+
+// TestReadSaveGameVersion tests reading the version from compressed and raw level data.
+func TestReadSaveGameVersion(t *testing.T) {
+	dir := t.TempDir()
+	cases := map[string]struct {
+		files   map[string]string
+		version string
+	}{
+		"compressed": {map[string]string{"Save/level.dat0": levelData(t, 2, 0, 28, true), "Save/level-init.dat": levelData(t, 1, 1, 110, true)}, "2.0.28"},
+		"raw":        {map[string]string{"Save/level.dat": levelData(t, 0, 16, 51, false)}, "0.16.51"},
+		"init only":  {map[string]string{"Save/level-init.dat": levelData(t, 1, 1, 110, true)}, "1.1.110"},
+	}
+	for name, c := range cases {
+		zipPath := filepath.Join(dir, name+".zip")
+		assert.NoError(t, createTestZip(zipPath, c.files))
+		version, err := utils.ReadSaveGameVersion(zipPath)
+		assert.NoError(t, err, name)
+		assert.Equal(t, c.version, version, name)
+	}
+
+	zipPath := filepath.Join(dir, "none.zip")
+	assert.NoError(t, createTestZip(zipPath, map[string]string{"Save/control.lua": ""}))
+	_, err := utils.ReadSaveGameVersion(zipPath)
+	assert.ErrorIs(t, err, utils.ErrSaveVersionUnknown)
+}
+
+// TestInjectMigratesScripts tests that scripts are migrated when injected into a save of Factorio 2.0 only.
+func TestInjectMigratesScripts(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	scripts := fstest.MapFS{"counter.lua": {Data: []byte("-- @version 1.0.0\nscript.on_init(function() global.count = game.forces.enemy.evolution_factor end)\n")}}
+
+	for version, expected := range map[uint16]string{0: "global.count", 1: "global.count", 2: "storage.count"} {
+		files := map[string]string{"Save/control.lua": "local x = 1\n", "Save/level.dat0": levelData(t, version, 0, 0, true)}
+		if version == 0 {
+			delete(files, "Save/level.dat0")
+		}
+		assert.NoError(t, createTestZip(filepath.Join(saveGameDir, "Save.zip"), files))
+		results, err := utils.InjectScriptsIntoZip("windows", "Save.zip", []string{"counter.lua"}, "control.lua", scripts, utils.InjectOptions{})
+		assert.NoError(t, err)
+		content, err := utils.ReadFileFromZip(filepath.Join(saveGameDir, "Save.zip"), "Save/control.lua")
+		assert.NoError(t, err)
+		assert.Contains(t, string(content), expected)
+
+		if version == 2 {
+			assert.Len(t, results[0].Migration.Rewritten, 1)
+			assert.Len(t, results[0].Migration.Unmigrated, 1)
+		} else {
+			assert.Nil(t, results[0].Migration)
+		}
+	}
+}
+
+func TestInjectLintsMigratedScripts(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	scripts := fstest.MapFS{"cleanup.lua": {Data: []byte("-- @version 1.0.0\n-- @factorio >=1.1\n" +
+		"script.on_event(defines.events.on_entity_destroyed, function(event) end)\n")}}
+
+	// The event was renamed in 2.0; the lint gate checks the migrated code against the save's version
+	for version, expected := range map[[2]uint16]string{{1, 1}: "on_entity_destroyed", {2, 0}: "on_object_destroyed"} {
+		files := map[string]string{"Save/control.lua": "local x = 1\n", "Save/level.dat0": levelData(t, version[0], version[1], 0, true)}
+		assert.NoError(t, createTestZip(filepath.Join(saveGameDir, "Save.zip"), files))
+		results, err := utils.InjectScriptsIntoZip("windows", "Save.zip", []string{"cleanup.lua"}, "control.lua", scripts, utils.InjectOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, results[0].Findings)
+		content, err := utils.ReadFileFromZip(filepath.Join(saveGameDir, "Save.zip"), "Save/control.lua")
+		assert.NoError(t, err)
+		assert.Contains(t, string(content), "defines.events."+expected)
+	}
+}
@@ -0,0 +1,213 @@
+package tests
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildTestPack writes a script directory with the given files and builds a pack of it.
+func buildTestPack(t *testing.T, files map[string]string, key ed25519.PrivateKey) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, code := range files {
+		writeScript(t, filepath.Join(dir, filepath.Dir(name)), filepath.Base(name), code)
+	}
+	packPath := filepath.Join(t.TempDir(), "team"+utils.ScriptPackExtension)
+	assert.NoError(t, utils.BuildScriptPack(dir, utils.PackManifest{Name: "team", Version: "1.0.0"}, key, packPath))
+	return packPath
+}
+
+// TestVerifyScriptPack tests that only untampered packs signed by a trusted key verify.
+func TestVerifyScriptPack(t *testing.T) {
+	publicKey, privateKey, err := utils.GenerateSigningKey()
+	assert.NoError(t, err)
+	key, err := utils.ParseSigningKey(privateKey)
+	assert.NoError(t, err)
+	trusted := []utils.TrustedKey{{Name: "alice", Key: publicKey}}
+
+	files := map[string]string{"hello.lua": "-- @version 0.1.0\nprint(1)\n", "radar/init.lua": "require('gui')\n", "radar/gui.lua": "return {}\n"}
+	packPath := buildTestPack(t, files, key)
+	pack, err := utils.ReadScriptPack(packPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hello", "radar"}, pack.Manifest.Scripts())
+	signer, err := utils.VerifyScriptPack(pack, trusted)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", signer)
+
+	// A malformed key is skipped and the keys after it are tried
+	malformed := utils.TrustedKey{Name: "broken", Key: "not base64"}
+	signer, err = utils.VerifyScriptPack(pack, append([]utils.TrustedKey{malformed}, trusted...))
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", signer)
+
+	otherPublicKey, _, err := utils.GenerateSigningKey()
+	assert.NoError(t, err)
+	_, err = utils.VerifyScriptPack(pack, []utils.TrustedKey{{Name: "bob", Key: otherPublicKey}})
+	assert.ErrorIs(t, err, utils.ErrPackUntrusted)
+
+	unsigned, err := utils.ReadScriptPack(buildTestPack(t, files, nil))
+	assert.NoError(t, err)
+	_, err = utils.VerifyScriptPack(unsigned, trusted)
+	assert.ErrorIs(t, err, utils.ErrPackUnsigned)
+
+	// A changed script no longer matches the manifest
+	contents, err := utils.ReadFilesFromZip(packPath, func(string) bool { return true })
+	assert.NoError(t, err)
+	entries := make(map[string]string)
+	for name, content := range contents {
+		entries[name] = string(content)
+	}
+	entries["scripts/hello.lua"] = "-- @version 0.1.0\nprint(2)\n"
+	assert.NoError(t, createTestZip(packPath, entries))
+	_, err = utils.ReadScriptPack(packPath)
+	assert.ErrorIs(t, err, utils.ErrPackTampered)
+
+	// A changed manifest no longer matches the signature
+	entries["scripts/hello.lua"] = files["hello.lua"]
+	entries["pack.json"] = entries["pack.json"][:len(entries["pack.json"])-2] + ",\"author\":\"mallory\"}\n"
+	assert.NoError(t, createTestZip(packPath, entries))
+	pack, err = utils.ReadScriptPack(packPath)
+	assert.NoError(t, err)
+	_, err = utils.VerifyScriptPack(pack, trusted)
+	assert.ErrorIs(t, err, utils.ErrPackUntrusted)
+
+	_, err = (utils.TrustedKey{Name: "short", Key: base64.StdEncoding.EncodeToString([]byte("short"))}).PublicKey()
+	assert.ErrorContains(t, err, "trusted key 'short' is not a base64 encoded ed25519 public key")
+}
+
+// TestInstallScriptPack tests installing a pack into the user script directory, upgrading and uninstalling it.
+func TestInstallScriptPack(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	scriptDir, err := utils.UserScriptDir()
+	assert.NoError(t, err)
+
+	pack, err := utils.ReadScriptPack(buildTestPack(t, map[string]string{
+		"hello.lua": "-- @version 0.1.0\nprint(1)\n", "radar/init.lua": "require('gui')\n", "radar/gui.lua": "return {}\n",
+	}, nil))
+	assert.NoError(t, err)
+
+	// Files of the user are never overwritten
+	writeScript(t, scriptDir, "hello.lua", "-- mine\n")
+	_, err = utils.InstallScriptPack(pack, "")
+	assert.ErrorContains(t, err, "already exists and was not installed by a pack")
+	assert.NoError(t, os.Remove(filepath.Join(scriptDir, "hello.lua")))
+
+	installed, err := utils.InstallScriptPack(pack, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", installed.Signer)
+	assert.FileExists(t, filepath.Join(scriptDir, "radar", "gui.lua"))
+
+	packs, err := utils.ListInstalledPacks()
+	assert.NoError(t, err)
+	assert.Len(t, packs, 1)
+	assert.Equal(t, []string{"hello", "radar"}, packs[0].Scripts())
+
+	// A new version replaces the files of the old one
+	upgraded, err := utils.ReadScriptPack(buildTestPack(t, map[string]string{"hello.lua": "-- @version 0.2.0\nprint(2)\n"}, nil))
+	assert.NoError(t, err)
+	_, err = utils.InstallScriptPack(upgraded, "")
+	assert.NoError(t, err)
+	assert.NoDirExists(t, filepath.Join(scriptDir, "radar"))
+
+	broken, err := utils.ReadScriptPack(buildTestPack(t, map[string]string{"radar/init.lua": "require('missing')\n"}, nil))
+	assert.NoError(t, err)
+	_, err = utils.InstallScriptPack(broken, "")
+	assert.ErrorIs(t, err, utils.ErrModuleNotFound)
+
+	_, err = utils.UninstallScriptPack("team")
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(scriptDir, "hello.lua"))
+	_, err = utils.UninstallScriptPack("team")
+	assert.ErrorContains(t, err, "script pack 'team' is not installed (no packs installed)")
+}
+
+// TestInstallScriptPackRollsBack tests that a failing install leaves the files of the installed version in place.
+func TestInstallScriptPackRollsBack(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	scriptDir, err := utils.UserScriptDir()
+	assert.NoError(t, err)
+
+	pack, err := utils.ReadScriptPack(buildTestPack(t, map[string]string{"hello.lua": "-- @version 0.1.0\nprint(1)\n"}, nil))
+	assert.NoError(t, err)
+	_, err = utils.InstallScriptPack(pack, "")
+	assert.NoError(t, err)
+
+	// A file of the user where the new version needs a directory fails the install after hello.lua was moved
+	writeScript(t, scriptDir, "radar", "mine\n")
+	upgraded, err := utils.ReadScriptPack(buildTestPack(t, map[string]string{
+		"hello.lua": "-- @version 0.2.0\nprint(2)\n", "radar/init.lua": "print(3)\n",
+	}, nil))
+	assert.NoError(t, err)
+	_, err = utils.InstallScriptPack(upgraded, "")
+	assert.ErrorContains(t, err, "failed to create")
+
+	content, err := os.ReadFile(filepath.Join(scriptDir, "hello.lua"))
+	assert.NoError(t, err)
+	assert.Equal(t, "-- @version 0.1.0\nprint(1)\n", string(content))
+	entries, err := os.ReadDir(scriptDir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2, "the staging directory is removed")
+	packs, err := utils.ListInstalledPacks()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hello"}, packs[0].Scripts())
+}
+
+// writeRawPack writes a pack whose entries are given in order, duplicates included, with a manifest listing the
+// hash of the first entry of every name.
+func writeRawPack(t *testing.T, entries [][2]string) string {
+	t.Helper()
+	manifest := utils.PackManifest{Name: "team", Version: "1.0.0", Files: make(map[string]string)}
+	for _, entry := range entries {
+		if _, listed := manifest.Files[entry[0]]; !listed {
+			sum := sha256.Sum256([]byte(entry[1]))
+			manifest.Files[entry[0]] = hex.EncodeToString(sum[:])
+		}
+	}
+	data, err := json.Marshal(manifest)
+	assert.NoError(t, err)
+
+	packPath := filepath.Join(t.TempDir(), "team"+utils.ScriptPackExtension)
+	file, err := os.Create(packPath)
+	assert.NoError(t, err)
+	defer file.Close()
+	writer := zip.NewWriter(file)
+	defer writer.Close()
+	for _, entry := range append([][2]string{{"pack.json", string(data)}}, entries...) {
+		entryWriter, err := writer.CreateHeader(&zip.FileHeader{Name: entry[0], Method: zip.Store})
+		assert.NoError(t, err)
+		_, err = entryWriter.Write([]byte(entry[1]))
+		assert.NoError(t, err)
+	}
+	return packPath
+}
+
+// TestScriptPackRejectsUnsafeEntries tests that packs with entries escaping scripts/ or listed twice are refused
+// before anything is verified or installed; pack verify and pack install both read the pack with ReadScriptPack.
+func TestScriptPackRejectsUnsafeEntries(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	for _, name := range []string{`scripts/..\..\evil.lua`, "scripts/../evil.lua", "scripts/C:evil.lua", "evil.lua"} {
+		_, err := utils.ReadScriptPack(writeRawPack(t, [][2]string{{name, "print('evil')\n"}}))
+		assert.ErrorContains(t, err, "is not a file inside scripts/", name)
+	}
+
+	// The manifest lists the first copy, the second one would be what gets installed
+	duplicated := writeRawPack(t, [][2]string{{"scripts/hello.lua", "print(1)\n"}, {"scripts/hello.lua", "print('evil')\n"}})
+	_, err := utils.ReadScriptPack(duplicated)
+	assert.ErrorIs(t, err, utils.ErrPackTampered)
+	assert.ErrorContains(t, err, "'scripts/hello.lua' is in the pack more than once")
+
+	scriptDir, err := utils.UserScriptDir()
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(scriptDir, "hello.lua"))
+	assert.NoFileExists(t, filepath.Join(filepath.Dir(scriptDir), "evil.lua"))
+}
@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"wci/internal"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// radarPackage returns a script package whose entry module requires two modules, one of them nested.
+func radarPackage(version string) fstest.MapFS {
+	return fstest.MapFS{
+		"scripts/radar/init.lua":     {Data: []byte("-- @version " + version + "\nlocal gui = require(\"gui\")\nlocal util = require(\"util\")\ngui.show()\n")},
+		"scripts/radar/gui.lua":      {Data: []byte("local state = require 'ui.state'\nreturn {show = function() return state end}\n")},
+		"scripts/radar/ui/state.lua": {Data: []byte("-- require(\"ignored\") in a comment\nreturn {}\n")},
+		"scripts/radar/unused.lua":   {Data: []byte("return {}\n")},
+	}
+}
+
+// TestLoadScriptPackage tests resolving the modules of a package and rewriting their requires.
+func TestLoadScriptPackage(t *testing.T) {
+	script, err := utils.LoadScriptFile(radarPackage("1.0.0"), "scripts/radar/init.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, "radar", script.Name)
+	assert.Contains(t, string(script.Code), `local gui = require("wci.radar.gui")`)
+	assert.Contains(t, string(script.Code), `require("util")`)
+
+	assert.Len(t, script.Modules, 2)
+	assert.Equal(t, "gui", script.Modules[0].Name)
+	assert.Equal(t, "gui.lua", script.Modules[0].Path)
+	assert.Contains(t, string(script.Modules[0].Code), `local state = require "wci.radar.ui.state"`)
+	assert.Equal(t, "ui.state", script.Modules[1].Name)
+	assert.Equal(t, "ui/state.lua", script.Modules[1].Path)
+
+	missing := fstest.MapFS{
+		"radar/init.lua": {Data: []byte("require('gui')\n")},
+		"radar/gui.lua":  {Data: []byte("\nrequire('state')\n")},
+	}
+	_, err = utils.LoadScriptFile(missing, "radar/init.lua")
+	assert.ErrorIs(t, err, utils.ErrModuleNotFound)
+	assert.ErrorContains(t, err, "gui.lua:2 of package 'radar' requires 'state'")
+
+	circular := fstest.MapFS{
+		"radar/init.lua":  {Data: []byte("require('gui')\n")},
+		"radar/gui.lua":   {Data: []byte("require('state')\n")},
+		"radar/state.lua": {Data: []byte("require('gui')\n")},
+	}
+	_, err = utils.LoadScriptFile(circular, "radar/init.lua")
+	assert.ErrorIs(t, err, utils.ErrModuleCycle)
+	assert.ErrorContains(t, err, "gui -> state -> gui")
+}
+
+// TestInjectScriptPackage tests injecting, upgrading and removing a package with its module files.
+func TestInjectScriptPackage(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{"TestSave/control.lua": "local x = 1\n"}))
+
+	source := func(files fstest.MapFS) *utils.ScriptCatalogue {
+		catalogue, err := utils.BuildScriptCatalogue([]utils.ScriptSource{{Kind: utils.SourceFlag, Location: "team", Dir: "scripts", FS: files}})
+		assert.NoError(t, err)
+		return catalogue
+	}
+	catalogue := source(radarPackage("1.0.0"))
+	radar, err := catalogue.Find("radar")
+	assert.NoError(t, err)
+	assert.Equal(t, "radar/init.lua", radar.FileName())
+
+	_, err = internal.InjectScripts("windows", "TestSave.zip", catalogue, []string{"radar"}, utils.InjectOptions{Strategy: utils.StrategyRequire})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"TestSave/control.lua", "TestSave/wci-manifest.json", "TestSave/wci/radar.lua",
+		"TestSave/wci/radar/gui.lua", "TestSave/wci/radar/ui/state.lua",
+	}, zipEntryNames(t, saveGameZipPath))
+
+	module, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/wci/radar/gui.lua")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(module), "-- WCI:BEGIN radar v1.0.0 "))
+	assert.Contains(t, string(module), "part=gui")
+
+	manifest, _, err := utils.ReadManifest(saveGameZipPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"TestSave/wci/radar/gui.lua", "TestSave/wci/radar/ui/state.lua"}, manifest.Scripts[0].Modules)
+
+	// The new version drops ui/state.lua and adds log.lua
+	upgraded := radarPackage("1.1.0")
+	upgraded["scripts/radar/gui.lua"] = &fstest.MapFile{Data: []byte("local log = require('log')\nreturn {show = log}\n")}
+	upgraded["scripts/radar/log.lua"] = &fstest.MapFile{Data: []byte("return print\n")}
+	upgrades, err := internal.UpgradeScripts("windows", "TestSave.zip", source(upgraded), utils.UpgradeOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, upgrades, 1)
+	assert.ElementsMatch(t, []string{
+		"TestSave/control.lua", "TestSave/wci-manifest.json", "TestSave/wci/radar.lua",
+		"TestSave/wci/radar/gui.lua", "TestSave/wci/radar/log.lua",
+	}, zipEntryNames(t, saveGameZipPath))
+	module, err = utils.ReadFileFromZip(saveGameZipPath, "TestSave/wci/radar/gui.lua")
+	assert.NoError(t, err)
+	assert.Contains(t, string(module), `require("wci.radar.log")`)
+
+	assert.NoError(t, utils.RemoveCodeFromZip("windows", "TestSave.zip", "radar"))
+	assert.ElementsMatch(t, []string{"TestSave/control.lua", "TestSave/wci-manifest.json"}, zipEntryNames(t, saveGameZipPath))
+}
@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"wci/embedded"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// counterScript returns a script of the given version that keeps its state with the wci runtime.
+func counterScript(version string) string {
+	return "-- @version " + version + "\n-- @runtime\n" + `wci.on_init(function(data) data.count = 0 end)
+wci.migration("1.1.0", function(data) data.total = data.count end)
+script.on_event(defines.events.on_tick, function() local data = wci.data() data.count = data.count + 1 end)
+`
+}
+
+// TestScriptRuntime tests that scripts declaring "-- @runtime" get the runtime bound to their name and version,
+// on injection and on upgrade, and that other scripts do not.
+func TestScriptRuntime(t *testing.T) {
+	assert.NoError(t, utils.CheckLuaSyntax("wci_runtime.lua", []byte(embedded.LuaRuntime)))
+	assert.True(t, utils.UsesScriptRuntime([]byte(counterScript("1.0.0"))))
+	assert.False(t, utils.UsesScriptRuntime([]byte("-- @version 1.0.0\nprint('-- @runtime')\n")))
+
+	metadata, err := utils.ParseScriptMetadata("counter.lua", []byte(counterScript("1.0.0")))
+	assert.NoError(t, err)
+	assert.True(t, metadata.Runtime)
+
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "Save.zip")
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{
+		"Save/control.lua": "local x = 1\n",
+		"Save/level.dat0":  levelData(t, 2, 0, 28, true),
+	}))
+	scriptDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(scriptDir, "counter.lua"), []byte(counterScript("1.0.0")), 0644))
+	scripts := fstest.MapFS{"plain.lua": {Data: []byte("-- @version 1.0.0\nprint('plain')\n")}}
+
+	_, err = utils.InjectScriptsIntoZip("windows", "Save.zip", []string{"counter.lua"}, "control.lua", os.DirFS(scriptDir), utils.InjectOptions{})
+	assert.NoError(t, err)
+	_, err = utils.InjectScriptsIntoZip("windows", "Save.zip", []string{"plain.lua"}, "control.lua", scripts, utils.InjectOptions{})
+	assert.NoError(t, err)
+	content, err := utils.ReadFileFromZip(saveGameZipPath, "Save/control.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(content), "local wci = (function(...)\n"))
+	assert.Contains(t, string(content), "\nend)(\"counter\", \"1.0.0\")\n-- @version 1.0.0\n-- @runtime\n")
+	// The runtime finds the state under the name of either Factorio version, the migration to 2.0 leaves it alone
+	assert.Contains(t, string(content), "local root = storage or global\n")
+
+	// An upgrade binds the runtime to the new version, whose migrations then run on the next event
+	assert.NoError(t, os.WriteFile(filepath.Join(scriptDir, "counter.lua"), []byte(counterScript("1.1.0")), 0644))
+	_, err = utils.UpgradeCodeInZip("windows", "Save.zip", os.DirFS(scriptDir), []string{"counter.lua"}, false)
+	assert.NoError(t, err)
+	content, err = utils.ReadFileFromZip(saveGameZipPath, "Save/control.lua")
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "\nend)(\"counter\", \"1.1.0\")\n")
+	assert.NotContains(t, string(content), "\"1.0.0\")")
+	assert.NoError(t, utils.CheckLuaSyntax("control.lua", content))
+}
+
+// TestScriptRuntimeLint tests that the lint gate checks the runtime with the script and reports findings of the
+// script at the script's own lines.
+func TestScriptRuntimeLint(t *testing.T) {
+	for _, version := range []string{"1.1", "2.0"} {
+		versions, err := utils.ParseVersionRange(version)
+		assert.NoError(t, err)
+		apis, err := utils.FactorioAPIsFor(versions)
+		assert.NoError(t, err)
+		findings, err := utils.LintLuaCode("wci_runtime.lua", []byte(embedded.LuaRuntime), utils.LintRules(), apis)
+		assert.NoError(t, err)
+		assert.Empty(t, findings, version)
+	}
+
+	saveGameDir := setupSaveGameDir(t)
+	assert.NoError(t, createTestZip(filepath.Join(saveGameDir, "Save.zip"), map[string]string{"Save/control.lua": "local x = 1\n"}))
+	scriptDir := t.TempDir()
+	code := counterScript("1.0.0") + "local ticks = 0\nscript.on_nth_tick(60, function() ticks = ticks + 1 end)\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(scriptDir, "counter.lua"), []byte(code), 0644))
+
+	_, err := utils.InjectScriptsIntoZip("windows", "Save.zip", []string{"counter.lua"}, "control.lua", os.DirFS(scriptDir), utils.InjectOptions{})
+	assert.ErrorIs(t, err, utils.ErrLintFailed)
+	assert.ErrorContains(t, err, "counter.lua:7:35: error: file-level local 'ticks' is changed in a function")
+}
@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"wci/embedded"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const templateTestScript = `-- @version 1.0.0
+-- @param command:string=purge Name of the command.
+-- @param radius:number=32 Search radius.
+-- @param limit:int Required entity limit.
+-- @param verbose:bool=false Print details.
+-- @param greeting:string="Hello there" Message on start.
+commands.add_command({{ lua .command }}, "", function() end)
+local radius, limit = {{ .radius }}, {{ .limit }}
+{{- if .verbose }}
+game.print({{ lua .greeting }})
+{{- end }}
+`
+
+// TestParseScriptParams tests reading the parameter schema from the script header.
+func TestParseScriptParams(t *testing.T) {
+	params, err := utils.ParseScriptParams([]byte(templateTestScript))
+	assert.NoError(t, err)
+	assert.Equal(t, []utils.ScriptParam{
+		{Name: "command", Type: utils.ParamString, Default: "purge", Description: "Name of the command."},
+		{Name: "radius", Type: utils.ParamNumber, Default: 32.0, Description: "Search radius."},
+		{Name: "limit", Type: utils.ParamInt, Description: "Required entity limit."},
+		{Name: "verbose", Type: utils.ParamBool, Default: false, Description: "Print details."},
+		{Name: "greeting", Type: utils.ParamString, Default: "Hello there", Description: "Message on start."},
+	}, params)
+	assert.True(t, params[2].Required())
+
+	_, err = utils.ParseScriptParams([]byte("-- @param radius:float=1\n"))
+	assert.ErrorContains(t, err, "unknown type 'float'")
+	_, err = utils.ParseScriptParams([]byte("-- @param radius:int=abc\n"))
+	assert.ErrorContains(t, err, "expected an int, got 'abc'")
+	_, err = utils.ParseScriptParams([]byte("-- @param\n"))
+	assert.ErrorContains(t, err, "malformed parameter declaration")
+}
+
+// TestRenderScriptTemplate tests rendering with defaults, typed values and Lua string quoting.
+func TestRenderScriptTemplate(t *testing.T) {
+	rendered, err := utils.RenderScriptTemplate("killer", templateTestScript, map[string]any{
+		"limit":    "10",
+		"verbose":  true,
+		"greeting": "Say \"hi\"\n\\o/",
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, rendered, `commands.add_command("purge", "", function() end)`)
+	assert.Contains(t, rendered, "local radius, limit = 32, 10\ngame.print(\"Say \\\"hi\\\"\\n\\\\o/\")\n")
+
+	_, err = utils.RenderScriptTemplate("killer", templateTestScript, nil)
+	assert.ErrorIs(t, err, utils.ErrInvalidParam)
+	assert.ErrorContains(t, err, "requires a value for 'limit'")
+
+	_, err = utils.RenderScriptTemplate("killer", templateTestScript, map[string]any{"limit": 1, "radious": 5})
+	assert.ErrorContains(t, err, "does not declare 'radious' (declared: command, greeting, limit, radius, verbose)")
+
+	// Scripts without parameters are plain Lua, even with template-like text
+	plain := "local t = {{1, 2}}\n"
+	rendered, err = utils.RenderScriptTemplate("plain", plain, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, rendered)
+}
+
+// TestResolveScriptParams tests scoping of keys to scripts and type checks of values.
+func TestResolveScriptParams(t *testing.T) {
+	scripts := []utils.ScriptFile{
+		{Name: "a", Params: []utils.ScriptParam{{Name: "radius", Type: utils.ParamNumber, Default: 1.0}}},
+		{Name: "b", Params: []utils.ScriptParam{{Name: "radius", Type: utils.ParamInt, Default: int64(1)}, {Name: "loud", Type: utils.ParamBool, Default: false}}},
+	}
+
+	resolved, err := utils.ResolveScriptParams(scripts, map[string]any{"radius": 5, "b.radius": "7", "loud": "true"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]any{
+		"a": {"radius": 5.0},
+		"b": {"radius": int64(7), "loud": true},
+	}, resolved)
+
+	_, err = utils.ResolveScriptParams(scripts, map[string]any{"speed": 1})
+	assert.ErrorContains(t, err, "no selected script declares 'speed'")
+	_, err = utils.ResolveScriptParams(scripts, map[string]any{"c.radius": 1})
+	assert.ErrorContains(t, err, "'c.radius' addresses script 'c', which is not selected")
+	_, err = utils.ResolveScriptParams(scripts, map[string]any{"radius": 2.5})
+	assert.ErrorIs(t, err, utils.ErrInvalidParam)
+	assert.ErrorContains(t, err, "'radius' of script 'b': expected an int, got 2.5")
+	_, err = utils.ResolveScriptParams(scripts, map[string]any{"loud": "maybe"})
+	assert.ErrorContains(t, err, "expected a bool, got 'maybe'")
+}
+
+// TestLoadParamValues tests reading global and per-script values from YAML.
+func TestLoadParamValues(t *testing.T) {
+	valuesPath := filepath.Join(t.TempDir(), "values.yaml")
+	assert.NoError(t, os.WriteFile(valuesPath, []byte("radius: 500\nbiter_killer:\n  command: purge\n  anecdotes: false\n"), 0644))
+
+	values, err := utils.LoadParamValues(valuesPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{"radius": 500, "biter_killer.command": "purge", "biter_killer.anecdotes": false}, values)
+
+	assignments, err := utils.ParseParamAssignments([]string{"radius=500", "biter_killer.command=a=b"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{"radius": "500", "biter_killer.command": "a=b"}, assignments)
+	_, err = utils.ParseParamAssignments([]string{"radius"})
+	assert.ErrorContains(t, err, "expected key=value")
+}
+
+// TestInjectWithParamsAndUpgrade tests that the values are recorded in the block and reused by an upgrade.
+func TestInjectWithParamsAndUpgrade(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "TestSave.zip")
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{"TestSave/control.lua": "local x = 1\n"}))
+
+	scripts := fstest.MapFS{"killer.lua": {Data: []byte(templateTestScript)}}
+	options := utils.InjectOptions{Params: map[string]any{"limit": "3", "command": "zap"}}
+	_, err := utils.InjectScriptsIntoZip("windows", "TestSave.zip", []string{"killer.lua"}, "control.lua", scripts, options)
+	assert.NoError(t, err)
+
+	content, err := utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `commands.add_command("zap", "", function() end)`)
+	block, err := utils.FindInjectionBlock(string(content), "killer")
+	assert.NoError(t, err)
+	assert.False(t, block.Modified())
+
+	manifest, _, err := utils.ReadManifest(saveGameZipPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "zap", manifest.Scripts[0].Params["command"])
+
+	// The new version drops nothing the user set and picks up its new default for radius
+	upgraded := strings.Replace(strings.Replace(templateTestScript, "1.0.0", "1.1.0", 1), "radius:number=32", "radius:number=64", 1)
+	scriptDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(scriptDir, "killer.lua"), []byte(upgraded), 0644))
+	_, err = utils.UpgradeCodeInZip("windows", "TestSave.zip", os.DirFS(scriptDir), []string{"killer.lua"}, false)
+	assert.NoError(t, err)
+
+	content, err = utils.ReadFileFromZip(saveGameZipPath, "TestSave/control.lua")
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "-- WCI:BEGIN killer v1.1.0")
+	assert.Contains(t, string(content), `commands.add_command("zap", "", function() end)`)
+	assert.Contains(t, string(content), "local radius, limit = 64, 3\n")
+
+	// A value of the wrong type fails before the save is touched
+	before, err := os.ReadFile(saveGameZipPath)
+	assert.NoError(t, err)
+	_, err = utils.InjectScriptsIntoZip("windows", "TestSave.zip", []string{"killer.lua"}, "control.lua", scripts,
+		utils.InjectOptions{Params: map[string]any{"limit": "many"}})
+	assert.ErrorIs(t, err, utils.ErrInvalidParam)
+	after, err := os.ReadFile(saveGameZipPath)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+}
+
+// TestEmbeddedScriptTemplates tests that every embedded script renders with its defaults.
+func TestEmbeddedScriptTemplates(t *testing.T) {
+	scriptPaths, err := embedded.ListLuaInjectionPaths()
+	assert.NoError(t, err)
+	for _, scriptPath := range scriptPaths {
+		script, err := utils.LoadScriptFile(embedded.LuaInjections, scriptPath)
+		assert.NoError(t, err)
+		_, err = utils.RenderScriptTemplate(script.Name, string(script.Code), nil)
+		assert.NoError(t, err, script.Name)
+	}
+
+	script, err := utils.LoadScriptFile(embedded.LuaInjections, path.Join(embedded.LuaInjectionsDir, "biter_killer.lua"))
+	assert.NoError(t, err)
+	rendered, err := utils.RenderScriptTemplate(script.Name, string(script.Code), map[string]any{"radius": 500.0, "command": "purge", "anecdotes": false})
+	assert.NoError(t, err)
+	assert.Contains(t, rendered, `commands.add_command("purge",`)
+	assert.Contains(t, rendered, "radius = 500,")
+	assert.NotContains(t, rendered, "surface.get_chunks()")
+	assert.NotContains(t, rendered, "local anecdotes")
+}
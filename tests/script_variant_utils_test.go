@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"wci/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// variantScripts returns scripts with variants for Factorio 1.1 and 2.0, and a script limited to 2.0.
+func variantScripts() fstest.MapFS {
+	return fstest.MapFS{
+		"lua/biter_killer.1.1.lua":       {Data: []byte("-- @version 1.0.0\nprint('global')\n")},
+		"lua/biter_killer.2.0.lua":       {Data: []byte("-- @version 1.0.0\nprint('storage')\n")},
+		"lua/radar.lua":                  {Data: []byte("-- @factorio >=2.0\nprint('radar')\n")},
+		"lua/mixed.lua":                  {Data: []byte("print('any')\n")},
+		"lua/mixed.2.0/init.lua":         {Data: []byte("-- @factorio >=2.0 <3\nprint(require('gui'))\n")},
+		"lua/mixed.2.0/gui.lua":          {Data: []byte("return 'gui'\n")},
+		"lua/biter_killer_extra.2.0.lua": {Data: []byte("-- @depends biter_killer\n")},
+	}
+}
+
+// TestSelectScriptVariant tests picking the variant of a script for a Factorio version.
+func TestSelectScriptVariant(t *testing.T) {
+	scripts := variantScripts()
+	cases := []struct {
+		file, factorio, selected string
+	}{
+		{"lua/biter_killer.lua", "1.1.110", "lua/biter_killer.1.1.lua"},
+		{"lua/biter_killer.lua", "2.0.28", "lua/biter_killer.2.0.lua"},
+		{"lua/radar.lua", "2.1.0", "lua/radar.lua"},
+		{"lua/radar.lua", "", "lua/radar.lua"},
+		{"lua/mixed.lua", "1.1.110", "lua/mixed.lua"},
+		{"lua/mixed.lua", "2.0.28", "lua/mixed.2.0/init.lua"},
+		{"lua/mixed.lua", "3.0.0", "lua/mixed.lua"},
+		{"lua/mixed.lua", "", "lua/mixed.lua"},
+		{"lua/missing.lua", "2.0.28", "lua/missing.lua"},
+	}
+	for _, c := range cases {
+		selected, err := utils.SelectScriptVariant(scripts, c.file, c.factorio)
+		assert.NoError(t, err, c.file+" "+c.factorio)
+		assert.Equal(t, c.selected, selected, c.file+" "+c.factorio)
+	}
+
+	_, err := utils.SelectScriptVariant(scripts, "lua/biter_killer.lua", "0.18.47")
+	assert.ErrorIs(t, err, utils.ErrNoScriptVariant)
+	assert.ErrorContains(t, err, "script 'biter_killer' supports Factorio 1.1 (lua/biter_killer.1.1.lua), "+
+		"2.0 (lua/biter_killer.2.0.lua), the savegame was written by 0.18.47")
+	_, err = utils.SelectScriptVariant(scripts, "lua/radar.lua", "1.1.110")
+	assert.ErrorContains(t, err, "script 'radar' supports Factorio >=2.0, the savegame was written by 1.1.110")
+	_, err = utils.SelectScriptVariant(scripts, "lua/biter_killer.lua", "")
+	assert.ErrorContains(t, err, "the savegame does not tell its version")
+}
+
+// TestScriptCatalogueVariants tests that the variants of a script are listed once and served by the catalogue.
+func TestScriptCatalogueVariants(t *testing.T) {
+	assert.Equal(t, "biter_killer", utils.ScriptNameFromFile("lua/biter_killer.2.0.lua"))
+	assert.Equal(t, "mixed", utils.ScriptNameFromFile("lua/mixed.2.0/init.lua"))
+
+	catalogue, err := utils.BuildScriptCatalogue([]utils.ScriptSource{{Kind: utils.SourceFlag, Location: "team", Dir: "lua", FS: variantScripts()}})
+	assert.NoError(t, err)
+	var names []string
+	for _, script := range catalogue.Scripts {
+		names = append(names, script.Name)
+	}
+	assert.Equal(t, []string{"biter_killer", "biter_killer_extra", "mixed", "radar"}, names)
+
+	biterKiller, err := catalogue.Find("biter_killer")
+	assert.NoError(t, err)
+	assert.Equal(t, "lua/biter_killer.2.0.lua", biterKiller.Path)
+	assert.Equal(t, []utils.ScriptVariant{
+		{Path: "lua/biter_killer.1.1.lua", Factorio: "1.1"},
+		{Path: "lua/biter_killer.2.0.lua", Factorio: "2.0"},
+	}, biterKiller.Variants)
+	mixed, err := catalogue.Find("mixed")
+	assert.NoError(t, err)
+	assert.Equal(t, "lua/mixed.lua", mixed.Path)
+	assert.Equal(t, []utils.ScriptVariant{
+		{Path: "lua/mixed.lua"},
+		{Path: "lua/mixed.2.0/init.lua", Factorio: ">=2.0 <3"},
+	}, mixed.Variants)
+
+	// The catalogue serves every variant under its own name, with the modules of a package variant
+	selected, err := utils.SelectScriptVariant(catalogue.FS(), mixed.FileName(), "2.0.28")
+	assert.NoError(t, err)
+	assert.Equal(t, "mixed.2.0/init.lua", selected)
+	script, err := utils.LoadScriptFile(catalogue.FS(), selected)
+	assert.NoError(t, err)
+	assert.Equal(t, "mixed", script.Name)
+	assert.Len(t, script.Modules, 1)
+}
+
+// TestInjectPicksScriptVariant tests that the variant for the save's Factorio version is injected, dependencies
+// included, and that a save no variant supports is left untouched.
+func TestInjectPicksScriptVariant(t *testing.T) {
+	saveGameDir := setupSaveGameDir(t)
+	saveGameZipPath := filepath.Join(saveGameDir, "Save.zip")
+	catalogue, err := utils.BuildScriptCatalogue([]utils.ScriptSource{{Kind: utils.SourceFlag, Location: "team", Dir: "lua", FS: variantScripts()}})
+	assert.NoError(t, err)
+
+	for version, expected := range map[uint16]string{1: "print('global')", 2: "print('storage')"} {
+		assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{
+			"Save/control.lua": "local x = 1\n",
+			"Save/level.dat0":  levelData(t, version, version%2, 0, true),
+		}))
+		results, err := utils.InjectScriptsIntoZip("windows", "Save.zip", []string{"biter_killer.lua"}, "control.lua", catalogue.FS(), utils.InjectOptions{})
+		assert.NoError(t, err)
+		content, err := utils.ReadFileFromZip(saveGameZipPath, "Save/control.lua")
+		assert.NoError(t, err)
+		assert.Contains(t, string(content), expected)
+		assert.Equal(t, "biter_killer", results[0].Name)
+		assert.Contains(t, results[0].Variant, "biter_killer.")
+	}
+
+	// The dependency of a 2.0-only script is picked for the save as well
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{
+		"Save/control.lua": "local x = 1\n",
+		"Save/level.dat0":  levelData(t, 2, 0, 28, true),
+	}))
+	results, err := utils.InjectScriptsIntoZip("windows", "Save.zip", []string{"biter_killer_extra.lua"}, "control.lua", catalogue.FS(), utils.InjectOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.Equal(t, "biter_killer.2.0.lua", results[0].Variant)
+
+	assert.NoError(t, createTestZip(saveGameZipPath, map[string]string{
+		"Save/control.lua": "local x = 1\n",
+		"Save/level.dat0":  levelData(t, 1, 1, 110, true),
+	}))
+	_, err = utils.InjectScriptsIntoZip("windows", "Save.zip", []string{"radar.lua"}, "control.lua", catalogue.FS(), utils.InjectOptions{})
+	assert.ErrorIs(t, err, utils.ErrNoScriptVariant)
+	assert.ErrorContains(t, err, "script 'radar' supports Factorio >=2.0, the savegame was written by 1.1.110")
+	content, err := utils.ReadFileFromZip(saveGameZipPath, "Save/control.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, "local x = 1\n", string(content))
+}
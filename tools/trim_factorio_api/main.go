@@ -0,0 +1,55 @@
+// Command trim_factorio_api builds the runtime API files wci bundles in embedded/factorio_api from the
+// runtime-api.json Factorio ships in its doc-html directory:
+//
+//	go run ./tools/trim_factorio_api <factorio>/doc-html/runtime-api.json embedded/factorio_api
+//
+// The file is written as runtime-api-<major>.<minor>.json, trimmed to what the lint rules read, see
+// utils.TrimFactorioAPI. Running it again on the same input gives the same file.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"wci/utils"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "Usage: trim_factorio_api <runtime-api.json> <output directory>")
+		os.Exit(2)
+	}
+	if err := trim(os.Args[1], os.Args[2]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+		os.Exit(1)
+	}
+}
+
+// trim writes the trimmed runtime API of input into outputDir, named after its major and minor version.
+func trim(input, outputDir string) error {
+	data, err := os.ReadFile(input)
+	if err != nil {
+		return err
+	}
+	trimmed, err := utils.TrimFactorioAPI(data)
+	if err != nil {
+		return fmt.Errorf("'%s': %w", input, err)
+	}
+	api, err := utils.ParseFactorioAPI(trimmed)
+	if err != nil {
+		return err
+	}
+
+	parts := strings.Split(api.Version, ".")
+	if len(parts) < 2 {
+		return fmt.Errorf("'%s': unexpected application_version '%s'", input, api.Version)
+	}
+	output := filepath.Join(outputDir, fmt.Sprintf("runtime-api-%s.%s.json", parts[0], parts[1]))
+	if err := os.WriteFile(output, trimmed, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("%s: Factorio %s, %d events, %d classes\n", output, api.Version, len(api.Events), len(api.Classes))
+	return nil
+}
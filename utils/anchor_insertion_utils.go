@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AnchorMode decides where a block goes relative to the lines matched by an anchor.
+type AnchorMode string
+
+const (
+	// AnchorBefore inserts the block before the first matched line.
+	AnchorBefore AnchorMode = "before"
+	// AnchorAfter inserts the block after the last matched line.
+	AnchorAfter AnchorMode = "after"
+	// AnchorReplace replaces the matched lines with the block. Removing the block restores them.
+	AnchorReplace AnchorMode = "replace"
+)
+
+const (
+	// attributeAnchor records the anchor mode of a block in its BEGIN marker.
+	attributeAnchor = "anchor"
+	// attributeReplaced records the lines a replacing block took the place of, base64 encoded.
+	attributeReplaced = "replaced"
+)
+
+var (
+	// ErrAnchorNotFound is returned when an anchor expression matches nothing in the target file.
+	ErrAnchorNotFound = errors.New("anchor does not match")
+	// ErrAnchorAmbiguous is returned when an anchor expression matches more than once in the target file.
+	ErrAnchorAmbiguous = errors.New("anchor matches more than once")
+)
+
+// Anchor places a block at the lines of the target file matched by a regular expression.
+// The expression is compiled in multi-line mode, so ^ and $ match at line boundaries.
+type Anchor struct {
+	Mode       AnchorMode
+	Expression string
+	pattern    *regexp.Regexp
+}
+
+// anchorPlacement is the range of whole lines an anchored block is inserted at or replaces.
+type anchorPlacement struct {
+	start      int
+	end        int
+	attributes map[string]string
+}
+
+// NewAnchor compiles an anchor expression for the given mode.
+func NewAnchor(mode AnchorMode, expression string) (*Anchor, error) {
+	switch mode {
+	case AnchorBefore, AnchorAfter, AnchorReplace:
+	default:
+		return nil, fmt.Errorf("unknown anchor mode '%s' (supported: before, after, replace)", mode)
+	}
+	pattern, err := regexp.Compile("(?m)" + expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid anchor expression '%s': %w", expression, err)
+	}
+	return &Anchor{Mode: mode, Expression: expression, pattern: pattern}, nil
+}
+
+// String returns the anchor as it is written on the command line, e.g. "--after /^require/".
+func (a *Anchor) String() string {
+	return fmt.Sprintf("--%s /%s/", a.Mode, a.Expression)
+}
+
+// place locates the anchor in the content of targetPath. The match must be unique and must not touch
+// an injected block, since inserting into a block would break its hash.
+func (a *Anchor) place(targetPath, content string) (anchorPlacement, error) {
+	matches := a.pattern.FindAllStringIndex(content, -1)
+	if len(matches) == 0 {
+		return anchorPlacement{}, fmt.Errorf("%s in '%s': %w", a, targetPath, ErrAnchorNotFound)
+	}
+	if len(matches) > 1 {
+		lines := make([]string, 0, len(matches))
+		for _, match := range matches {
+			lines = append(lines, strconv.Itoa(strings.Count(content[:match[0]], "\n")+1))
+		}
+		return anchorPlacement{}, fmt.Errorf("%s in '%s' matches %d times (lines %s): %w",
+			a, targetPath, len(matches), strings.Join(lines, ", "), ErrAnchorAmbiguous)
+	}
+
+	// Extend the match to the whole lines it touches
+	start := strings.LastIndexByte(content[:matches[0][0]], '\n') + 1
+	last := matches[0][1]
+	if last > matches[0][0] && content[last-1] == '\n' {
+		last--
+	}
+	end := len(content)
+	if lineEnd := strings.IndexByte(content[last:], '\n'); lineEnd >= 0 {
+		end = last + lineEnd + 1
+	}
+
+	blocks, err := ParseInjectionBlocks(content)
+	if err != nil {
+		return anchorPlacement{}, err
+	}
+	for _, block := range blocks {
+		if start < block.End && end > block.Start {
+			return anchorPlacement{}, fmt.Errorf("%s in '%s' matches inside the injected block '%s' (lines %d-%d)",
+				a, targetPath, block.Name, block.BeginLine, block.EndLine)
+		}
+	}
+
+	placement := anchorPlacement{attributes: map[string]string{attributeAnchor: string(a.Mode)}}
+	switch a.Mode {
+	case AnchorBefore:
+		placement.start, placement.end = start, start
+	case AnchorAfter:
+		placement.start, placement.end = end, end
+	case AnchorReplace:
+		placement.start, placement.end = start, end
+		placement.attributes[attributeReplaced] = base64.RawURLEncoding.EncodeToString([]byte(content[start:end]))
+	}
+	return placement, nil
+}
+
+// insert places block into content at the placement.
+func (p anchorPlacement) insert(content []byte, block string) []byte {
+	text := string(content)
+	if p.start == len(text) && text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+		p.start, p.end = len(text), len(text)
+	}
+	return []byte(text[:p.start] + block + text[p.end:])
+}
+
+// withAttributes returns attributes extended by those of the placement.
+func (p *anchorPlacement) withAttributes(attributes map[string]string) map[string]string {
+	if p == nil {
+		return attributes
+	}
+	merged := make(map[string]string, len(attributes)+len(p.attributes))
+	for key, value := range attributes {
+		merged[key] = value
+	}
+	for key, value := range p.attributes {
+		merged[key] = value
+	}
+	return merged
+}
+
+// cutAnchoredBlock removes a block from content. Blocks that replaced lines put the original lines back.
+func cutAnchoredBlock(content string, block InjectionBlock) (string, error) {
+	encoded, replaced := block.Attributes[attributeReplaced]
+	if !replaced {
+		return CutInjectionBlock(content, block), nil
+	}
+	original, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("block '%s' (line %d) has an invalid replaced attribute: %w", block.Name, block.BeginLine, err)
+	}
+	return content[:block.Start] + string(original) + content[block.End:], nil
+}
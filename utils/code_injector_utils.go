@@ -1,92 +1,341 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"github.com/rs/zerolog/log"
+	"io"
 	"io/fs"
-	"path/filepath"
+	"maps"
+	"strings"
+	"wci/lua"
 )
 
+// InjectOptions controls how InjectCodeIntoZipWithOptions places a script inside a savegame.
+type InjectOptions struct {
+	Strategy       InjectionStrategy // how the script is placed; defaults to StrategyAppend
+	EventConflicts EventConflictMode // what to do with clashing event registrations; defaults to EventConflictRefuse
+	CommandRenames map[string]string // console commands to register under a different name, old -> new
+	Target         string            // path of the file inside the save to inject into; overrides targetFileName
+	Anchor         *Anchor           // where to place the block in the target file; nil keeps the strategy's default
+	DryRun         io.Writer         // when set, receives a diff of the planned changes instead of the ZIP being written
+	Params         map[string]any    // script parameter values by name or "script.name", see ResolveScriptParams
+	Profile        string            // profile the scripts belong to, recorded in the manifest
+}
+
 // InjectCodeIntoZip handles injecting code from an embedded file into a target file inside a savegame ZIP file.
 // Parameters:
 // - osName: the name of the operating system (e.g., "windows", "darwin").
 // - saveGameZipName: the name of the savegame ZIP file.
 // - embeddedFileName: the name of the embedded file containing the code to inject.
 // - targetFileName: the name of the target file inside the ZIP to which the code should be injected/appended.
-// InjectCodeIntoZip handles injecting code from an embedded file into a target file inside a savegame ZIP file.
+//
+// The code is wrapped in WCI:BEGIN/WCI:END markers recording the script name, version and hash. The markers,
+// not the raw script text, decide whether the script is already present in the target file.
 func InjectCodeIntoZip(osName, saveGameZipName, embeddedFileName, targetFileName string, fileSystem fs.FS) error {
-	// Retrieve the base savegame directory based on the OS
-	baseDir, err := GetSaveGameLocation(osName)
+	return InjectCodeIntoZipWithOptions(osName, saveGameZipName, embeddedFileName, targetFileName, fileSystem, InjectOptions{})
+}
+
+// InjectCodeIntoZipWithOptions works like InjectCodeIntoZip, with the placement of the script controlled by options.
+// Scripts the injected script depends on are injected with it, see InjectScriptsIntoZip.
+func InjectCodeIntoZipWithOptions(osName, saveGameZipName, embeddedFileName, targetFileName string, fileSystem fs.FS, options InjectOptions) error {
+	_, err := InjectScriptsIntoZip(osName, saveGameZipName, []string{embeddedFileName}, targetFileName, fileSystem, options)
+	return err
+}
+
+// InjectedScript reports what InjectScriptsIntoZip did with one script.
+type InjectedScript struct {
+	Name       string           `json:"name"`
+	Version    string           `json:"version"`
+	File       string           `json:"file"`                 // Lua file of the savegame the script was placed into or found in
+	Dependency bool             `json:"dependency,omitempty"` // the script was injected because another script depends on it
+	Skipped    bool             `json:"skipped,omitempty"`    // the script was already injected and left alone
+	Findings   []LintFinding    `json:"findings,omitempty"`   // lint warnings of the script, errors stop the injection
+	Migration  *ScriptMigration `json:"migration,omitempty"`  // renames applied for the save's Factorio version
+	Variant    string           `json:"variant,omitempty"`    // file of the script picked for the save's Factorio version
+}
+
+// InjectScriptsIntoZip injects several scripts into a savegame ZIP file in one transaction.
+// Parameters:
+// - osName: the name of the operating system (e.g., "windows", "darwin").
+// - saveGameZipName: the name of the savegame ZIP file.
+// - scriptFileNames: the scripts inside fileSystem to inject.
+// - targetFileName: the name of the target file inside the ZIP, unless options.Target is set.
+// - fileSystem: the file system containing the scripts.
+// - options: placement and conflict handling, shared by all scripts.
+//
+// Scripts declared with "-- @depends" are injected as well and every script is placed after the scripts it
+// depends on or is declared "-- @after". Scripts that ship variants for several Factorio versions are injected in
+// the variant for the version that wrote the save, see SelectScriptVariant. All scripts are applied in memory and the archive is written once; if any
+// script fails, nothing is written. Scripts that are already injected are skipped.
+func InjectScriptsIntoZip(osName, saveGameZipName string, scriptFileNames []string, targetFileName string, fileSystem fs.FS, options InjectOptions) ([]InjectedScript, error) {
+	saveGameZipPath, err := resolveSaveGamePath(osName, saveGameZipName)
 	if err != nil {
-		log.Error().
-			Err(err).
-			Str("osName", osName).
-			Msg("Failed to retrieve savegame directory")
-		return fmt.Errorf("failed to retrieve savegame directory for OS '%s': %w", osName, err)
+		return nil, err
 	}
 
-	// Construct the full path to the savegame ZIP file
-	saveGameZipPath := filepath.Join(baseDir, saveGameZipName)
-
 	log.Info().
 		Str("zipPath", saveGameZipPath).
-		Str("embeddedFileName", embeddedFileName).
+		Strs("scripts", scriptFileNames).
 		Str("targetFileName", targetFileName).
+		Str("strategy", string(options.Strategy)).
 		Msg("Starting to inject code into ZIP")
 
-	// Read the embedded code to inject from the provided file system
-	codeToInject, err := fs.ReadFile(fileSystem, embeddedFileName)
-	if err != nil {
-		log.Error().
-			Err(err).
-			Str("file", embeddedFileName).
-			Msg("Failed to read embedded file")
-		return fmt.Errorf("failed to read embedded file '%s': %w", embeddedFileName, err)
-	}
-
-	// Locate the target file in the ZIP
+	// Locate the target file in the ZIP, an explicit target must name exactly one file
 	log.Info().
 		Str("zipPath", saveGameZipPath).
 		Msg("Searching for the target file inside ZIP")
-	targetPathInZip, err := FindFileInZip(saveGameZipPath, targetFileName)
+	var targetPathInZip string
+	if options.Target != "" {
+		targetFileName = options.Target
+		targetPathInZip, err = ResolveFileInZip(saveGameZipPath, targetFileName)
+	} else {
+		targetPathInZip, err = FindFileInZip(saveGameZipPath, targetFileName)
+	}
 	if err != nil {
 		log.Error().
 			Err(err).
 			Str("file", targetFileName).
 			Msg("Failed to locate target file in ZIP")
-		return fmt.Errorf("failed to locate '%s' in ZIP: %w", targetFileName, err)
+		return nil, fmt.Errorf("failed to locate '%s' in ZIP: %w", targetFileName, err)
 	}
 
-	// Check if the code to inject already exists in the target file
-	exists, err := CheckCodeExistsInZip(saveGameZipPath, targetPathInZip, string(codeToInject))
+	// Read every Lua file, a script may already sit in the target or in a module of its own
+	luaFiles, err := ReadFilesFromZip(saveGameZipPath, func(name string) bool {
+		return IsLuaFile(name) || name == targetPathInZip
+	})
 	if err != nil {
 		log.Error().
 			Err(err).
-			Str("file", targetPathInZip).
-			Msg("Failed to check code existence in ZIP")
-		return fmt.Errorf("failed to check if code exists in '%s': %w", targetPathInZip, err)
+			Str("zipPath", saveGameZipPath).
+			Msg("Failed to read Lua files from ZIP")
+		return nil, fmt.Errorf("failed to read Lua files from '%s': %w", saveGameZipPath, err)
 	}
 
-	// If the code already exists, log a warning and exit
-	if exists {
+	// The files as read tell a file broken by the change from one that was broken before
+	original := maps.Clone(luaFiles)
+
+	// Scripts are picked and migrated for the Factorio version that wrote the save
+	factorio, err := ReadSaveGameVersion(saveGameZipPath)
+	if errors.Is(err, ErrSaveVersionUnknown) {
 		log.Warn().
-			Str("file", targetPathInZip).
-			Msg("Code snippet already exists in the target file")
-		return nil
+			Str("zipPath", saveGameZipPath).
+			Msg("Savegame has no level data, scripts are injected without migration")
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read the Factorio version of '%s': %w", saveGameZipPath, err)
+	}
+
+	// Dependencies already in the savegame need no script file
+	scripts, err := ResolveScriptOrder(fileSystem, scriptFileNames, factorio, func(name string) bool {
+		locations, err := FindInjectedScript(luaFiles, name)
+		return err == nil && len(locations) > 0
+	})
+	if err != nil {
+		log.Error().
+			Err(err).
+			Strs("scripts", scriptFileNames).
+			Msg("Failed to resolve script dependencies")
+		return nil, err
 	}
 
-	// Append the code to the target file
-	err = AppendToFileInZip(saveGameZipPath, targetPathInZip, string(codeToInject), saveGameZipPath)
+	// Parameter values are checked against every script before anything is planned
+	params, err := ResolveScriptParams(scripts, options.Params)
 	if err != nil {
+		return nil, err
+	}
+
+	// Apply every script to the in-memory files, later scripts see the earlier ones
+	changes := NewZipChanges()
+	var results []InjectedScript
+	usedRenames := make(map[string]bool)
+	for _, script := range scripts {
+		result, planned, err := planScriptFile(luaFiles, targetPathInZip, script, params[script.Name], factorio, options, usedRenames)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("file", targetPathInZip).
+				Str("script", script.Name).
+				Msg("Failed to plan script injection")
+			return nil, fmt.Errorf("failed to inject '%s' into '%s': %w", script.Name, targetPathInZip, err)
+		}
+		changes.Merge(planned)
+		planned.ApplyTo(luaFiles)
+		results = append(results, result)
+	}
+	for _, oldName := range sortedKeys(options.CommandRenames) {
+		if !usedRenames[oldName] {
+			return nil, fmt.Errorf("no selected script adds a command named '%s'", oldName)
+		}
+	}
+
+	if changes.Empty() {
+		return results, nil
+	}
+
+	if err := checkChangedLuaSyntax(original, changes); err != nil {
+		return nil, fmt.Errorf("failed to inject into '%s': %w", targetPathInZip, err)
+	}
+
+	// Record the injected scripts in the save's manifest
+	event := ManifestEvent{Action: ManifestActionInject, Profile: options.Profile}
+	for _, result := range results {
+		if !result.Skipped {
+			event.Scripts = append(event.Scripts, result.Name)
+		}
+	}
+	if err := recordManifest(saveGameZipPath, &changes, event); err != nil {
+		return nil, fmt.Errorf("failed to update the manifest of '%s': %w", saveGameZipPath, err)
+	}
+
+	// Write all scripts with a single rewrite of the archive
+	if err := writeZipChanges(saveGameZipPath, changes, options.DryRun); err != nil {
 		log.Error().
 			Err(err).
 			Str("file", targetPathInZip).
-			Msg("Failed to append code to file in ZIP")
-		return fmt.Errorf("failed to append code to '%s': %w", targetPathInZip, err)
+			Msg("Failed to write injected code to ZIP")
+		return nil, fmt.Errorf("failed to write injected code to '%s': %w", saveGameZipPath, err)
 	}
 
 	log.Info().
 		Str("file", targetPathInZip).
+		Int("scriptCount", len(results)).
+		Str("strategy", string(options.Strategy)).
+		Bool("dryRun", options.DryRun != nil).
 		Msg("Successfully injected code into the target file")
-	return nil
+	return results, nil
+}
+
+// planScriptFile plans the injection of one script, rendered with the given parameter values and migrated to the
+// Factorio version of the save, into the in-memory files. Command renames that apply to the script are recorded in
+// usedRenames.
+func planScriptFile(luaFiles map[string][]byte, targetPath string, script ScriptFile, params map[string]any, factorio string, options InjectOptions, usedRenames map[string]bool) (InjectedScript, ZipChanges, error) {
+	result := InjectedScript{
+		Name:       script.Name,
+		Version:    ParseScriptVersion(script.Code),
+		File:       targetPath,
+		Dependency: script.Dependency,
+	}
+	if scriptVariantVersion(script.Path) != "" {
+		result.Variant = script.Path
+	}
+
+	// Look for existing marker blocks of this script anywhere in the savegame
+	existing, err := FindInjectedScript(luaFiles, script.Name)
+	if err != nil {
+		return result, ZipChanges{}, err
+	}
+
+	// If the script is already injected, report what is there and leave it
+	if len(existing) > 0 {
+		log.Warn().
+			Str("file", existing[0].File).
+			Str("script", script.Name).
+			Str("installedVersion", existing[0].Block.Version).
+			Str("embeddedVersion", result.Version).
+			Str("strategy", string(existing[0].Strategy())).
+			Int("line", existing[0].Block.BeginLine).
+			Bool("modified", existing[0].Block.Modified()).
+			Msg("Script is already injected into the savegame")
+		result.File = existing[0].File
+		result.Skipped = true
+		return result, NewZipChanges(), nil
+	}
+
+	// Saves injected before markers were introduced contain the raw script text
+	if strings.Contains(string(luaFiles[targetPath]), string(script.Code)) {
+		log.Warn().
+			Str("file", targetPath).
+			Str("script", script.Name).
+			Msg("Unmarked copy of the script already exists in the target file")
+		result.Skipped = true
+		return result, NewZipChanges(), nil
+	}
+
+	// The script is rendered and migrated once; the lint gate checks the code that is written into the save
+	prepared, err := prepareScript(script, params, factorio)
+	if err != nil {
+		return result, ZipChanges{}, err
+	}
+	result.Findings = prepared.Findings
+	if len(prepared.Migration.Rewritten) > 0 || len(prepared.Migration.Unmigrated) > 0 {
+		result.Migration = &prepared.Migration
+	}
+
+	// Only the renames of commands this script adds apply to it
+	renames := make(map[string]string)
+	if len(options.CommandRenames) > 0 {
+		registrations, err := FindNameRegistrations(script.Path, prepared.Code)
+		if err != nil {
+			return result, ZipChanges{}, err
+		}
+		for _, registration := range registrations {
+			if newName, ok := options.CommandRenames[registration.Name]; ok && registration.Kind == NameKindCommand {
+				renames[registration.Name] = newName
+				usedRenames[registration.Name] = true
+			}
+		}
+	}
+
+	injection := ScriptInjection{
+		Name:           script.Name,
+		Version:        result.Version,
+		Code:           prepared.Code,
+		Strategy:       options.Strategy,
+		EventConflicts: options.EventConflicts,
+		CommandRenames: renames,
+		Anchor:         options.Anchor,
+		Params:         params,
+		Modules:        prepared.Modules,
+		Factorio:       factorio,
+		Prepared:       true,
+	}
+	changes, err := PlanInjection(luaFiles, targetPath, injection)
+	return result, changes, err
+}
+
+// prepareScript renders a script with its parameter values, migrates it to the Factorio version of the save and
+// runs the lint gate on the result: a script that does not parse, would desync multiplayer games or uses API the
+// targeted Factorio versions do not have is refused before it gets anywhere near the savegame. Warnings are
+// returned with the script.
+func prepareScript(script ScriptFile, params map[string]any, factorio string) (renderedScript, error) {
+	rendered, err := renderScript(script, params, factorio)
+	if err != nil {
+		return rendered, err
+	}
+	if rendered.Findings, err = lintRenderedScript(script, ParseScriptVersion(script.Code), rendered, factorio); err != nil {
+		return rendered, err
+	}
+	return rendered, nil
+}
+
+// lintRenderedScript runs the lint gate on a rendered script and the modules of its package, checking API use
+// against the Factorio version of the save. The script is checked as it goes into the save, with the runtime in
+// front when it uses it; positions in the runtime are reported against scriptRuntimeFile. It fails on findings of
+// SeverityError and returns the warnings.
+func lintRenderedScript(script ScriptFile, version string, rendered renderedScript, factorio string) ([]LintFinding, error) {
+	apis, err := saveFactorioAPIs(script, factorio)
+	if err != nil {
+		return nil, err
+	}
+	linted := script
+	linted.Modules = rendered.Modules
+	code := withScriptRuntime(script.Name, version, rendered.Code)
+	runtimeLines := strings.Count(code[:len(code)-len(rendered.Code)], "\n")
+	findings, err := lintScriptFile(linted, code, lintRules, apis)
+	var syntaxErr *lua.SyntaxError
+	if errors.As(err, &syntaxErr) && syntaxErr.File == script.Path {
+		syntaxErr.File, syntaxErr.Pos.Line = scriptRuntimeLine(script.Path, syntaxErr.Pos.Line, runtimeLines)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range findings {
+		if findings[i].File == script.Path {
+			findings[i].File, findings[i].Line = scriptRuntimeLine(script.Path, findings[i].Line, runtimeLines)
+		}
+	}
+	if err := lintErrors(findings); err != nil {
+		return nil, err
+	}
+	return findings, nil
 }
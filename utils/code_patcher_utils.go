@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultPatchFuzz is the number of context lines that may be ignored at each end of a hunk, as in GNU patch.
+const DefaultPatchFuzz = 2
+
+// PatchOptions controls how PatchCodeInZip applies a unified diff.
+type PatchOptions struct {
+	Reverse bool      // undo the patch instead of applying it
+	Fuzz    int       // context lines that may be ignored at each end of a hunk
+	DryRun  io.Writer // when set, receives a diff of the planned changes instead of the ZIP being written
+}
+
+// PatchedFile reports how a file patch was applied to an entry of the savegame.
+type PatchedFile struct {
+	File    string // path of the entry inside the savegame ZIP
+	Created bool
+	Deleted bool
+	Hunks   []HunkResult
+}
+
+// PatchCodeInZip applies a unified diff to the entries of a savegame ZIP file.
+// Parameters:
+// - osName: the name of the operating system (e.g., "windows", "darwin").
+// - saveGameZipName: the name of the savegame ZIP file.
+// - patchData: the unified diff, paths are matched like ResolveFileInZip after dropping leading folders if needed.
+// - options: reverse and fuzz settings.
+//
+// Either every hunk applies and the archive is rewritten once, or the archive is left untouched.
+func PatchCodeInZip(osName, saveGameZipName string, patchData []byte, options PatchOptions) ([]PatchedFile, error) {
+	saveGameZipPath, err := resolveSaveGamePath(osName, saveGameZipName)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("zipPath", saveGameZipPath).
+		Bool("reverse", options.Reverse).
+		Int("fuzz", options.Fuzz).
+		Msg("Starting to patch ZIP")
+
+	patches, err := ParsePatch(patchData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse patch: %w", err)
+	}
+	if options.Reverse {
+		for i := range patches {
+			patches[i] = patches[i].Reverse()
+		}
+	}
+
+	names, err := ListFilesInZip(saveGameZipPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files in '%s': %w", saveGameZipPath, err)
+	}
+	targets := make([]string, len(patches))
+	needed := make(map[string]bool)
+	for i, patch := range patches {
+		if targets[i], err = resolvePatchTarget(names, patch); err != nil {
+			return nil, err
+		}
+		needed[targets[i]] = true
+	}
+
+	files, err := ReadFilesFromZip(saveGameZipPath, func(name string) bool { return needed[name] })
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("zipPath", saveGameZipPath).
+			Msg("Failed to read patched files from ZIP")
+		return nil, fmt.Errorf("failed to read files from '%s': %w", saveGameZipPath, err)
+	}
+
+	changes, results, err := PlanPatch(files, patches, targets, options.Fuzz)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("zipPath", saveGameZipPath).
+			Msg("Patch does not apply")
+		return nil, err
+	}
+
+	event := ManifestEvent{Action: ManifestActionPatch}
+	for _, result := range results {
+		event.Files = append(event.Files, result.File)
+	}
+	if err := recordManifest(saveGameZipPath, &changes, event); err != nil {
+		return nil, fmt.Errorf("failed to update the manifest of '%s': %w", saveGameZipPath, err)
+	}
+
+	// Rewrite the archive with all patched files at once
+	if err := writeZipChanges(saveGameZipPath, changes, options.DryRun); err != nil {
+		log.Error().
+			Err(err).
+			Str("zipPath", saveGameZipPath).
+			Msg("Failed to rewrite ZIP file")
+		return nil, fmt.Errorf("failed to rewrite '%s': %w", saveGameZipPath, err)
+	}
+
+	log.Info().
+		Str("zipPath", saveGameZipPath).
+		Int("fileCount", len(results)).
+		Msg("Successfully patched ZIP")
+	return results, nil
+}
+
+// PlanPatch computes the changes a list of file patches makes to files, where targets[i] is the entry patches[i]
+// applies to. Several patches of the same entry apply on top of each other.
+func PlanPatch(files map[string][]byte, patches []FilePatch, targets []string, fuzz int) (ZipChanges, []PatchedFile, error) {
+	changes := NewZipChanges()
+	current := make(map[string][]byte, len(files))
+	for name, content := range files {
+		current[name] = content
+	}
+	deleted := make(map[string]bool)
+
+	var results []PatchedFile
+	for i, patch := range patches {
+		target := targets[i]
+		content, exists := current[target]
+		if patch.IsCreation() && exists {
+			return changes, nil, fmt.Errorf("patch creates '%s', which already exists: %w", target, ErrPatchRejected)
+		}
+		if !patch.IsCreation() && !exists {
+			return changes, nil, fmt.Errorf("file '%s' not found in ZIP", target)
+		}
+
+		patched, hunks, err := ApplyPatch(content, patch, fuzz)
+		if err != nil {
+			return changes, nil, err
+		}
+		if patch.IsDeletion() && len(patched) > 0 {
+			return changes, nil, fmt.Errorf("patch deletes '%s', but content is left after applying it: %w", target, ErrPatchRejected)
+		}
+
+		if patch.IsDeletion() {
+			delete(current, target)
+			delete(changes.Modified, target)
+			deleted[target] = true
+		} else {
+			current[target] = patched
+			changes.Modified[target] = patched
+			delete(deleted, target)
+		}
+		results = append(results, PatchedFile{File: target, Created: patch.IsCreation(), Deleted: patch.IsDeletion(), Hunks: hunks})
+	}
+
+	for _, name := range SortedFileNames(files) {
+		if deleted[name] {
+			changes.Removed = append(changes.Removed, name)
+		}
+	}
+	return changes, results, nil
+}
+
+// resolvePatchTarget maps the path of a file patch to an entry of the savegame. Leading folders of the path are
+// dropped one by one until it resolves, so patches made against an unpacked save or with "orig/" prefixes apply.
+// Created files are placed in the save's top-level folder.
+func resolvePatchTarget(names []string, patch FilePatch) (string, error) {
+	filePath := patch.Path()
+	if patch.IsCreation() {
+		if len(names) == 0 {
+			return filePath, nil
+		}
+		root, _, found := strings.Cut(names[0], "/")
+		if !found || strings.HasPrefix(filePath, root+"/") {
+			return filePath, nil
+		}
+		return root + "/" + filePath, nil
+	}
+
+	candidate := filePath
+	for {
+		name, err := resolvePathInSave(names, candidate)
+		if err == nil {
+			return name, nil
+		}
+		_, rest, more := strings.Cut(candidate, "/")
+		if !more {
+			return "", fmt.Errorf("patch target '%s': %w", filePath, err)
+		}
+		candidate = rest
+	}
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/rs/zerolog/log"
+)
+
+// RemoveOptions controls how RemoveCodeFromZipWithOptions writes the savegame.
+type RemoveOptions struct {
+	DryRun io.Writer // when set, receives a diff of the planned changes instead of the ZIP being written
+}
+
+// RemoveCodeFromZip removes the marker block of an injected script from a savegame ZIP file.
+// Every Lua file in the archive is searched, so the block is found wherever it was placed.
+// Parameters:
+// - osName: the name of the operating system (e.g., "windows", "darwin").
+// - saveGameZipName: the name of the savegame ZIP file.
+// - scriptName: the name of the injected script (e.g., "biter_killer").
+//
+// Module files created by the require strategy are deleted with their block. The archive is left untouched
+// if the script is not injected or if any of its blocks was edited by hand.
+func RemoveCodeFromZip(osName, saveGameZipName, scriptName string) error {
+	return RemoveCodeFromZipWithOptions(osName, saveGameZipName, scriptName, RemoveOptions{})
+}
+
+// RemoveCodeFromZipWithOptions works like RemoveCodeFromZip, with the writing of the savegame controlled by options.
+func RemoveCodeFromZipWithOptions(osName, saveGameZipName, scriptName string, options RemoveOptions) error {
+	saveGameZipPath, err := resolveSaveGamePath(osName, saveGameZipName)
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("zipPath", saveGameZipPath).
+		Str("script", scriptName).
+		Msg("Starting to remove injected code from ZIP")
+
+	luaFiles, err := ReadFilesFromZip(saveGameZipPath, IsLuaFile)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("zipPath", saveGameZipPath).
+			Msg("Failed to read Lua files from ZIP")
+		return fmt.Errorf("failed to read Lua files from '%s': %w", saveGameZipPath, err)
+	}
+
+	changes, err := PlanRemoval(luaFiles, scriptName)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("zipPath", saveGameZipPath).
+			Str("script", scriptName).
+			Msg("Failed to remove injected script")
+		return fmt.Errorf("failed to remove from '%s': %w", saveGameZipName, err)
+	}
+
+	event := ManifestEvent{Action: ManifestActionRemove, Scripts: []string{scriptName}}
+	if err := recordManifest(saveGameZipPath, &changes, event); err != nil {
+		return fmt.Errorf("failed to update the manifest of '%s': %w", saveGameZipPath, err)
+	}
+
+	// Rewrite the archive with the blocks removed
+	if err := writeZipChanges(saveGameZipPath, changes, options.DryRun); err != nil {
+		log.Error().
+			Err(err).
+			Str("zipPath", saveGameZipPath).
+			Msg("Failed to rewrite ZIP file")
+		return fmt.Errorf("failed to rewrite '%s': %w", saveGameZipPath, err)
+	}
+
+	log.Info().
+		Str("zipPath", saveGameZipPath).
+		Str("script", scriptName).
+		Int("modifiedCount", len(changes.Modified)).
+		Int("removedCount", len(changes.Removed)).
+		Msg("Successfully removed injected code from ZIP")
+	return nil
+}
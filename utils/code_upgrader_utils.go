@@ -0,0 +1,302 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ScriptUpgrade describes an injected script block that is older than the available script.
+type ScriptUpgrade struct {
+	Script      string
+	File        string // path of the Lua file inside the savegame ZIP
+	FromVersion string
+	ToVersion   string
+	Changelog   []ChangelogEntry
+	Unmigrated  []MigrationNote // constructs of the new version the migration to the save's Factorio version left
+	Findings    []LintFinding   // lint warnings of the new version, errors stop the upgrade
+}
+
+// UpgradeOptions controls whether UpgradeCodeInZipWithOptions writes the savegame.
+type UpgradeOptions struct {
+	CheckOnly bool      // report the outdated blocks without rewriting the archive
+	DryRun    io.Writer // when set, receives a diff of the planned changes instead of the ZIP being written
+}
+
+// UpgradeCodeInZip replaces injected script blocks that are older than the given scripts with the new version.
+// Parameters:
+// - osName: the name of the operating system (e.g., "windows", "darwin").
+// - saveGameZipName: the name of the savegame ZIP file.
+// - fileSystem: the file system containing the current scripts.
+// - scriptFileNames: the scripts inside fileSystem to compare against the injected blocks.
+// - checkOnly: when true, the outdated blocks are reported but the archive is not rewritten.
+//
+// The returned upgrades list every outdated block found. Blocks that were edited by hand, and new versions that
+// fail the lint gate of injections, abort the upgrade of the whole save, so the archive is either fully upgraded
+// or left untouched.
+func UpgradeCodeInZip(osName, saveGameZipName string, fileSystem fs.FS, scriptFileNames []string, checkOnly bool) ([]ScriptUpgrade, error) {
+	return UpgradeCodeInZipWithOptions(osName, saveGameZipName, fileSystem, scriptFileNames, UpgradeOptions{CheckOnly: checkOnly})
+}
+
+// UpgradeCodeInZipWithOptions works like UpgradeCodeInZip, with the writing of the savegame controlled by options.
+func UpgradeCodeInZipWithOptions(osName, saveGameZipName string, fileSystem fs.FS, scriptFileNames []string, options UpgradeOptions) ([]ScriptUpgrade, error) {
+	saveGameZipPath, err := resolveSaveGamePath(osName, saveGameZipName)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("zipPath", saveGameZipPath).
+		Bool("checkOnly", options.CheckOnly).
+		Msg("Checking injected scripts for upgrades")
+
+	luaFiles, err := ReadFilesFromZip(saveGameZipPath, IsLuaFile)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("zipPath", saveGameZipPath).
+			Msg("Failed to read Lua files from ZIP")
+		return nil, fmt.Errorf("failed to read Lua files from '%s': %w", saveGameZipPath, err)
+	}
+
+	// Kept for the syntax check of the upgraded files, see checkChangedLuaSyntax
+	original := maps.Clone(luaFiles)
+
+	// New versions are picked and migrated for the Factorio version that wrote the save, like injected scripts
+	factorio, err := ReadSaveGameVersion(saveGameZipPath)
+	if err != nil && !errors.Is(err, ErrSaveVersionUnknown) {
+		return nil, fmt.Errorf("failed to read the Factorio version of '%s': %w", saveGameZipPath, err)
+	}
+
+	var upgrades []ScriptUpgrade
+	changes := NewZipChanges()
+	for _, scriptFileName := range scriptFileNames {
+		// Only scripts in the save are loaded, a script without a variant for its version may be in the catalogue
+		scriptName := ScriptNameFromFile(scriptFileName)
+		locations, err := FindInjectedScript(luaFiles, scriptName)
+		if err != nil {
+			return nil, err
+		}
+		if len(locations) == 0 {
+			continue
+		}
+
+		script, err := loadScriptVariant(fileSystem, scriptFileName, factorio)
+		if errors.Is(err, ErrNoScriptVariant) {
+			return nil, err
+		}
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("file", scriptFileName).
+				Msg("Failed to read script file")
+			return nil, fmt.Errorf("failed to read script file '%s': %w", scriptFileName, err)
+		}
+		code := script.Code
+		scriptVersion := ParseScriptVersion(code)
+		var moduleNotes ScriptMigration
+		if factorio != "" {
+			if script.Modules, moduleNotes, err = migrateScriptModules(path.Dir(script.Path), script.Modules, factorio); err != nil {
+				return nil, fmt.Errorf("failed to migrate '%s': %w", scriptName, err)
+			}
+		}
+
+		seenFiles := make(map[string]bool)
+		upgraded := false
+		for _, location := range locations {
+			block := location.Block
+			if CompareVersions(block.Version, scriptVersion) >= 0 {
+				continue
+			}
+
+			// Replacing a hand-edited block would silently throw the edits away
+			if block.Modified() {
+				log.Error().
+					Str("file", location.File).
+					Str("script", scriptName).
+					Int("line", block.BeginLine).
+					Msg("Injected script was modified by hand")
+				return nil, fmt.Errorf("block '%s' in '%s' (lines %d-%d) does not match its recorded hash: %w",
+					scriptName, location.File, block.BeginLine, block.EndLine, ErrScriptModified)
+			}
+			if seenFiles[location.File] {
+				return nil, fmt.Errorf("script '%s' is injected more than once into '%s'", scriptName, location.File)
+			}
+
+			// Modules of a package are replaced by the module of the same name, or dropped with their file
+			if location.IsPart() {
+				if err := upgradePackageModule(luaFiles, &changes, location, script); err != nil {
+					return nil, err
+				}
+				seenFiles[location.File] = true
+				continue
+			}
+
+			// Loader blocks only change their version, the module block carries the script
+			upgraded = true
+			var newBlock string
+			if location.IsLoader() {
+				if newBlock, err = RenderUpgradedBlock(location, scriptVersion, string(code), factorio); err != nil {
+					return nil, fmt.Errorf("failed to render '%s' version %s: %w", scriptName, scriptVersion, err)
+				}
+			} else {
+				var upgrade ScriptUpgrade
+				if upgrade, newBlock, err = upgradeScriptBlock(location, script, scriptVersion, factorio); err != nil {
+					return nil, fmt.Errorf("failed to upgrade '%s' to version %s: %w", scriptName, scriptVersion, err)
+				}
+				upgrade.Unmigrated = append(upgrade.Unmigrated, moduleNotes.Unmigrated...)
+				upgrades = append(upgrades, upgrade)
+				log.Info().
+					Str("file", location.File).
+					Str("script", scriptName).
+					Str("fromVersion", upgrade.FromVersion).
+					Str("toVersion", upgrade.ToVersion).
+					Msg("Found outdated injected script")
+			}
+
+			// Later scripts are located in the already upgraded content
+			content := string(luaFiles[location.File])
+			luaFiles[location.File] = []byte(content[:block.Start] + newBlock + content[block.End:])
+			changes.Modified[location.File] = luaFiles[location.File]
+			seenFiles[location.File] = true
+		}
+
+		// Modules the new version of a package added are written next to the existing ones
+		if upgraded {
+			if err := addPackageModules(luaFiles, &changes, locations, script, scriptVersion); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if options.CheckOnly || changes.Empty() {
+		log.Info().
+			Str("zipPath", saveGameZipPath).
+			Int("outdatedCount", len(upgrades)).
+			Msg("Upgrade check completed without writing")
+		return upgrades, nil
+	}
+
+	if err := checkChangedLuaSyntax(original, changes); err != nil {
+		return nil, fmt.Errorf("failed to upgrade '%s': %w", saveGameZipPath, err)
+	}
+
+	event := ManifestEvent{Action: ManifestActionUpgrade}
+	for _, upgrade := range upgrades {
+		event.Scripts = append(event.Scripts, upgrade.Script)
+	}
+	if err := recordManifest(saveGameZipPath, &changes, event); err != nil {
+		return nil, fmt.Errorf("failed to update the manifest of '%s': %w", saveGameZipPath, err)
+	}
+
+	// Rewrite the archive with all upgraded blocks at once
+	if err := writeZipChanges(saveGameZipPath, changes, options.DryRun); err != nil {
+		log.Error().
+			Err(err).
+			Str("zipPath", saveGameZipPath).
+			Msg("Failed to rewrite ZIP file")
+		return nil, fmt.Errorf("failed to rewrite '%s': %w", saveGameZipPath, err)
+	}
+
+	log.Info().
+		Str("zipPath", saveGameZipPath).
+		Int("upgradeCount", len(upgrades)).
+		Msg("Successfully upgraded injected scripts")
+	return upgrades, nil
+}
+
+// upgradeScriptBlock renders the new version of a script for an injected block with the parameter values recorded
+// in it and migrates it to the Factorio version of the save. Like an injection, the result has to pass the lint
+// gate; the modules of a package are expected to be migrated already. It returns the upgrade and the new block.
+func upgradeScriptBlock(location InjectedScriptLocation, script ScriptFile, version, factorio string) (ScriptUpgrade, string, error) {
+	values, err := upgradedParamValues(script.Name, string(script.Code), location.Block.Attributes[attributeParams])
+	if err != nil {
+		return ScriptUpgrade{}, "", err
+	}
+	code := script
+	code.Modules = nil
+	rendered, err := renderScript(code, values, factorio)
+	if err != nil {
+		return ScriptUpgrade{}, "", err
+	}
+	rendered.Modules = script.Modules
+	findings, err := lintRenderedScript(script, version, rendered, factorio)
+	if err != nil {
+		return ScriptUpgrade{}, "", err
+	}
+	block, err := buildUpgradedBlock(location, version, rendered.Code, values)
+	if err != nil {
+		return ScriptUpgrade{}, "", err
+	}
+
+	return ScriptUpgrade{
+		Script:      script.Name,
+		File:        location.File,
+		FromVersion: location.Block.Version,
+		ToVersion:   version,
+		Changelog:   ChangelogBetween(ParseScriptChangelog(script.Code), location.Block.Version, version),
+		Unmigrated:  rendered.Migration.Unmigrated,
+		Findings:    findings,
+	}, block, nil
+}
+
+// upgradePackageModule replaces an outdated module block of a package with the module of the same name of the new
+// version. A module the new version no longer has is removed together with its file.
+func upgradePackageModule(luaFiles map[string][]byte, changes *ZipChanges, location InjectedScriptLocation, script ScriptFile) error {
+	version := ParseScriptVersion(script.Code)
+	for _, module := range script.Modules {
+		if module.Name != location.Block.Attributes[attributePart] {
+			continue
+		}
+		content := string(luaFiles[location.File])
+		luaFiles[location.File] = []byte(content[:location.Block.Start] + buildModuleBlock(script.Name, version, module) + content[location.Block.End:])
+		changes.Modified[location.File] = luaFiles[location.File]
+		return nil
+	}
+
+	remaining := string(luaFiles[location.File][:location.Block.Start]) + string(luaFiles[location.File][location.Block.End:])
+	if strings.TrimSpace(remaining) != "" {
+		return fmt.Errorf("module file '%s' of '%s' holds more than its module", location.File, script.Name)
+	}
+	delete(luaFiles, location.File)
+	delete(changes.Modified, location.File)
+	changes.Removed = append(changes.Removed, location.File)
+	return nil
+}
+
+// addPackageModules writes the modules of the new version of a package that the savegame does not have yet. They
+// go next to the target file of the package, the file holding its loader or, for the append strategy, its code.
+func addPackageModules(luaFiles map[string][]byte, changes *ZipChanges, locations []InjectedScriptLocation, script ScriptFile, version string) error {
+	present := make(map[string]bool)
+	targetPath := ""
+	for _, location := range locations {
+		switch {
+		case location.IsPart():
+			present[location.Block.Attributes[attributePart]] = true
+		case location.IsLoader() || location.Strategy() == StrategyAppend:
+			targetPath = location.File
+		}
+	}
+	if targetPath == "" {
+		return nil
+	}
+
+	for _, module := range script.Modules {
+		if present[module.Name] {
+			continue
+		}
+		modulePath := packageModulePath(targetPath, script.Name, module)
+		if _, taken := luaFiles[modulePath]; taken {
+			return fmt.Errorf("module file '%s' already exists in ZIP", modulePath)
+		}
+		luaFiles[modulePath] = []byte(buildModuleBlock(script.Name, version, module))
+		changes.Modified[modulePath] = luaFiles[modulePath]
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"wci/lua"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// NameKindCommand identifies a console command added with commands.add_command.
+	NameKindCommand = "command"
+	// NameKindRemoteInterface identifies a remote interface added with remote.add_interface.
+	NameKindRemoteInterface = "remote interface"
+	// attributeRename records the command renames applied to a script in its BEGIN marker.
+	attributeRename = "rename"
+)
+
+// ErrNameCollision is returned when a script adds a command or remote interface that is already registered.
+var ErrNameCollision = errors.New("duplicate command or remote interface names")
+
+var commandNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// nameRegistrationCalls maps "receiver.method" to the kind of name the call registers.
+var nameRegistrationCalls = map[string]string{
+	"commands.add_command": NameKindCommand,
+	"remote.add_interface": NameKindRemoteInterface,
+}
+
+// NameRegistration is a commands.add_command or remote.add_interface call with a literal name.
+type NameRegistration struct {
+	File  string
+	Kind  string
+	Name  string
+	Pos   lua.Position // position of the call
+	Start int          // offset of the name string literal
+	End   int          // offset just after the name string literal
+	Block string       // name of the WCI block containing the call, if any
+}
+
+// NameCollision pairs two registrations of the same command or remote interface name.
+type NameCollision struct {
+	Existing NameRegistration
+	Incoming NameRegistration
+}
+
+// FindNameRegistrations lists the commands and remote interfaces a Lua source file registers.
+// Only calls whose first argument is a string literal are reported.
+func FindNameRegistrations(file, source string) ([]NameRegistration, error) {
+	tokens, err := codeTokens(file, source)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := ParseInjectionBlocks(source)
+	if err != nil {
+		return nil, err
+	}
+
+	var registrations []NameRegistration
+	for i := 0; i+4 < len(tokens); i++ {
+		if tokens[i].Type != lua.TokenName || tokens[i+1].Text != "." || tokens[i+3].Text != "(" || tokens[i+4].Type != lua.TokenString {
+			continue
+		}
+		if i > 0 && (tokens[i-1].Text == "." || tokens[i-1].Text == ":") {
+			continue
+		}
+		kind, ok := nameRegistrationCalls[tokens[i].Text+"."+tokens[i+2].Text]
+		if !ok {
+			continue
+		}
+		registrations = append(registrations, NameRegistration{
+			File:  file,
+			Kind:  kind,
+			Name:  tokens[i+4].Value,
+			Pos:   tokens[i].Pos,
+			Start: tokens[i+4].Pos.Offset,
+			End:   tokens[i+4].End,
+			Block: blockAt(blocks, tokens[i].Pos.Offset),
+		})
+	}
+	return registrations, nil
+}
+
+// DetectNameCollisions compares the names the script registers with those of the target file, of every block
+// already injected into the savegame and with each other.
+func DetectNameCollisions(luaFiles map[string][]byte, targetPath, scriptFile, code string) ([]NameCollision, error) {
+	var existing []NameRegistration
+	for _, fileName := range SortedFileNames(luaFiles) {
+		registrations, err := FindNameRegistrations(fileName, string(luaFiles[fileName]))
+		if err != nil {
+			return nil, err
+		}
+		for _, registration := range registrations {
+			if fileName == targetPath || registration.Block != "" {
+				existing = append(existing, registration)
+			}
+		}
+	}
+
+	incoming, err := FindNameRegistrations(scriptFile, code)
+	if err != nil {
+		return nil, err
+	}
+
+	var collisions []NameCollision
+	for i, in := range incoming {
+		candidates := append(append([]NameRegistration{}, existing...), incoming[:i]...)
+		for _, ex := range candidates {
+			if ex.Kind == in.Kind && ex.Name == in.Name {
+				collisions = append(collisions, NameCollision{Existing: ex, Incoming: in})
+			}
+		}
+	}
+	return collisions, nil
+}
+
+// FormatNameCollisions renders a human readable report with one line per collision.
+func FormatNameCollisions(collisions []NameCollision) string {
+	var report strings.Builder
+	for _, collision := range collisions {
+		fmt.Fprintf(&report, "  %s '%s': %s:%s and %s:%s", collision.Incoming.Kind, collision.Incoming.Name,
+			collision.Existing.File, collision.Existing.Pos, collision.Incoming.File, collision.Incoming.Pos)
+		if collision.Incoming.Kind == NameKindCommand {
+			fmt.Fprintf(&report, " (use --rename-command %s=<new name>)", collision.Incoming.Name)
+		}
+		report.WriteString("\n")
+	}
+	return report.String()
+}
+
+// ParseCommandRenames converts "old=new" arguments into a rename map.
+func ParseCommandRenames(values []string) (map[string]string, error) {
+	renames := make(map[string]string)
+	for _, value := range values {
+		oldName, newName, ok := strings.Cut(value, "=")
+		if !ok || !commandNamePattern.MatchString(oldName) || !commandNamePattern.MatchString(newName) {
+			return nil, fmt.Errorf("invalid command rename '%s', expected old=new with letters, digits, '_' or '-'", value)
+		}
+		if _, duplicate := renames[oldName]; duplicate {
+			return nil, fmt.Errorf("command '%s' is renamed more than once", oldName)
+		}
+		renames[oldName] = newName
+	}
+	return renames, nil
+}
+
+// RenameCommands rewrites the names of commands.add_command calls in code. Every renamed command must exist.
+func RenameCommands(file, code string, renames map[string]string) (string, error) {
+	if len(renames) == 0 {
+		return code, nil
+	}
+
+	registrations, err := FindNameRegistrations(file, code)
+	if err != nil {
+		return "", err
+	}
+
+	found := make(map[string]bool)
+	sort.Slice(registrations, func(i, j int) bool { return registrations[i].Start > registrations[j].Start })
+	for _, registration := range registrations {
+		newName, ok := renames[registration.Name]
+		if registration.Kind != NameKindCommand || !ok {
+			continue
+		}
+		found[registration.Name] = true
+		code = code[:registration.Start] + strconv.Quote(newName) + code[registration.End:]
+	}
+
+	for _, oldName := range sortedKeys(renames) {
+		if !found[oldName] {
+			return "", fmt.Errorf("script does not add a command named '%s'", oldName)
+		}
+	}
+	return code, nil
+}
+
+// formatRenameAttribute encodes command renames as "old:new,old2:new2" for a BEGIN marker.
+func formatRenameAttribute(renames map[string]string) string {
+	pairs := make([]string, 0, len(renames))
+	for _, oldName := range sortedKeys(renames) {
+		pairs = append(pairs, oldName+":"+renames[oldName])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// parseRenameAttribute decodes the rename attribute of a BEGIN marker.
+func parseRenameAttribute(value string) map[string]string {
+	renames := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if oldName, newName, ok := strings.Cut(pair, ":"); ok {
+			renames[oldName] = newName
+		}
+	}
+	return renames
+}
+
+// checkNameCollisions renames the script's commands and aborts when a name is already taken.
+// It returns the code with renames applied.
+func checkNameCollisions(luaFiles map[string][]byte, targetPath string, injection ScriptInjection) (string, error) {
+	scriptFile := injection.Name + ".lua"
+	code, err := RenameCommands(scriptFile, injection.Code, injection.CommandRenames)
+	if err != nil {
+		return "", err
+	}
+
+	collisions, err := DetectNameCollisions(luaFiles, targetPath, scriptFile, code)
+	if err != nil {
+		return "", err
+	}
+	if len(collisions) > 0 {
+		return "", fmt.Errorf("%w:\n%s", ErrNameCollision, FormatNameCollisions(collisions))
+	}
+
+	log.Debug().
+		Str("script", injection.Name).
+		Int("renameCount", len(injection.CommandRenames)).
+		Msg("No command or remote interface collisions found")
+	return code, nil
+}
+
+// sortedKeys returns the keys of a map in lexical order.
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
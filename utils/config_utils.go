@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigPathEnv names a configuration file to use instead of the one in the user's configuration directory.
+const ConfigPathEnv = "WCI_CONFIG"
+
+// ConfigFileName is the name of the configuration file inside the user's configuration directory.
+const ConfigFileName = "config.yaml"
+
+// Config is the wci configuration file:
+//
+//	profiles:
+//	  megabase:
+//	    description: Fresh megabase setup
+//	    strategy: require              (optional, see InjectOptions)
+//	    scripts:
+//	      - name: biter_killer
+//	        params:
+//	          radius: 500
+//	      - waypoint_teleport          (a script without parameters)
+//	trusted_keys:                      (public keys that may sign script packs, see VerifyScriptPack)
+//	  - name: alice
+//	    key: 3q2+7w...                 (base64 ed25519 public key)
+type Config struct {
+	Profiles    map[string]Profile `yaml:"profiles" json:"profiles"`
+	TrustedKeys []TrustedKey       `yaml:"trusted_keys" json:"trusted_keys,omitempty"`
+	Path        string             `yaml:"-" json:"path"` // file the configuration was read from
+}
+
+// ConfigPath returns the configuration file to read: the given path, WCI_CONFIG, or config.yaml in the user's
+// configuration directory. It reports whether the file was chosen explicitly and therefore has to exist.
+func ConfigPath(configPath string) (string, bool, error) {
+	if configPath != "" {
+		return configPath, true, nil
+	}
+	if envPath := os.Getenv(ConfigPathEnv); envPath != "" {
+		return envPath, true, nil
+	}
+	configDir, err := UserConfigDir()
+	if err != nil {
+		return "", false, err
+	}
+	return filepath.Join(configDir, ConfigFileName), false, nil
+}
+
+// LoadConfig reads and checks the configuration file chosen by ConfigPath. A missing default file yields an
+// empty configuration.
+func LoadConfig(configPath string) (*Config, error) {
+	configPath, explicit, err := ConfigPath(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{Path: configPath}
+	data, err := os.ReadFile(configPath)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		log.Debug().Str("path", configPath).Msg("No configuration file, using defaults")
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration '%s': %w", configPath, err)
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration '%s': %w", configPath, err)
+	}
+
+	for _, name := range config.ProfileNames() {
+		if err := config.Profiles[name].validate(); err != nil {
+			return nil, fmt.Errorf("configuration '%s': profile '%s': %w", configPath, name, err)
+		}
+	}
+
+	for _, key := range config.TrustedKeys {
+		if _, err := key.PublicKey(); err != nil {
+			return nil, fmt.Errorf("configuration '%s': %w", configPath, err)
+		}
+	}
+
+	log.Debug().
+		Str("path", configPath).
+		Int("profileCount", len(config.Profiles)).
+		Int("trustedKeyCount", len(config.TrustedKeys)).
+		Msg("Loaded configuration")
+	return config, nil
+}
+
+// ProfileNames returns the names of the configured profiles, sorted.
+func (c *Config) ProfileNames() []string {
+	return sortedKeys(c.Profiles)
+}
+
+// Profile returns the profile of the given name.
+func (c *Config) Profile(name string) (Profile, error) {
+	profile, exists := c.Profiles[name]
+	if !exists {
+		if len(c.Profiles) == 0 {
+			return Profile{}, fmt.Errorf("unknown profile '%s' (no profiles defined in '%s')", name, c.Path)
+		}
+		return Profile{}, fmt.Errorf("unknown profile '%s' (available: %s)", name, strings.Join(c.ProfileNames(), ", "))
+	}
+	return profile, nil
+}
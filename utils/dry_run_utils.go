@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ZipPreview describes what a change set would do to a ZIP archive.
+type ZipPreview struct {
+	Diffs   []string // unified diffs of modified and added text entries, by entry name
+	Binary  []string // modified or added entries that are not text
+	Added   []string
+	Removed []string
+}
+
+// Empty reports whether the preview shows no change at all.
+func (p ZipPreview) Empty() bool {
+	return len(p.Diffs) == 0 && len(p.Binary) == 0 && len(p.Added) == 0 && len(p.Removed) == 0
+}
+
+// String renders the diffs followed by the lists of added and removed entries.
+func (p ZipPreview) String() string {
+	if p.Empty() {
+		return "No changes.\n"
+	}
+
+	var out strings.Builder
+	for _, diff := range p.Diffs {
+		out.WriteString(diff)
+	}
+	for _, name := range p.Binary {
+		fmt.Fprintf(&out, "Binary entry %s differs\n", name)
+	}
+	if len(p.Added) > 0 {
+		out.WriteString("Added entries:\n")
+		for _, name := range p.Added {
+			fmt.Fprintf(&out, "  + %s\n", name)
+		}
+	}
+	if len(p.Removed) > 0 {
+		out.WriteString("Removed entries:\n")
+		for _, name := range p.Removed {
+			fmt.Fprintf(&out, "  - %s\n", name)
+		}
+	}
+	return out.String()
+}
+
+// PreviewZipChanges compares a change set with the current entries of a ZIP archive without writing anything.
+func PreviewZipChanges(zipPath string, changes ZipChanges) (ZipPreview, error) {
+	var preview ZipPreview
+
+	names, err := ListFilesInZip(zipPath)
+	if err != nil {
+		return preview, err
+	}
+	existing := make(map[string]bool, len(names))
+	for _, name := range names {
+		existing[name] = true
+	}
+
+	original, err := ReadFilesFromZip(zipPath, func(name string) bool {
+		_, modified := changes.Modified[name]
+		return modified
+	})
+	if err != nil {
+		return preview, fmt.Errorf("failed to read files from '%s': %w", zipPath, err)
+	}
+
+	for _, name := range SortedFileNames(changes.Modified) {
+		content := changes.Modified[name]
+		oldName := name
+		if !existing[name] {
+			oldName = ""
+			preview.Added = append(preview.Added, name)
+		}
+		if !isTextContent(original[name]) || !isTextContent(content) {
+			if !bytes.Equal(original[name], content) {
+				preview.Binary = append(preview.Binary, name)
+			}
+			continue
+		}
+		if diff := UnifiedDiff(oldName, name, original[name], content); diff != "" {
+			preview.Diffs = append(preview.Diffs, diff)
+		}
+	}
+	for _, name := range changes.Removed {
+		if existing[name] {
+			preview.Removed = append(preview.Removed, name)
+		}
+	}
+	return preview, nil
+}
+
+// writeZipChanges rewrites the archive in place, or, when dryRun is set, writes a preview of the changes to it
+// and leaves the archive untouched.
+func writeZipChanges(zipPath string, changes ZipChanges, dryRun io.Writer) error {
+	if dryRun == nil {
+		return RewriteZipFile(zipPath, changes, zipPath)
+	}
+
+	preview, err := PreviewZipChanges(zipPath, changes)
+	if err != nil {
+		return err
+	}
+	log.Info().
+		Str("zipPath", zipPath).
+		Int("diffCount", len(preview.Diffs)).
+		Int("addedCount", len(preview.Added)).
+		Int("removedCount", len(preview.Removed)).
+		Msg("Dry run, ZIP file not written")
+	_, err = io.WriteString(dryRun, preview.String())
+	return err
+}
+
+// isTextContent reports whether content can be shown in a diff.
+func isTextContent(content []byte) bool {
+	return utf8.Valid(content) && bytes.IndexByte(content, 0) < 0
+}
@@ -0,0 +1,531 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"wci/lua"
+
+	"github.com/rs/zerolog/log"
+)
+
+// EventConflictMode decides what happens when an injected script registers an event the target already handles.
+type EventConflictMode string
+
+const (
+	// EventConflictRefuse aborts the injection and reports every conflicting registration.
+	EventConflictRefuse EventConflictMode = "refuse"
+	// EventConflictChain routes both registrations through the wci_dispatch helper so both handlers run.
+	EventConflictChain EventConflictMode = "chain"
+)
+
+const (
+	// dispatcherName is the global table and block name of the event dispatcher helper.
+	dispatcherName = "wci_dispatch"
+	// dispatcherVersion is the version recorded in the dispatcher block marker.
+	dispatcherVersion = "1.0.0"
+	// attributeEvents records in a BEGIN marker that the script's registrations go through the dispatcher.
+	attributeEvents = "events"
+)
+
+// ErrEventConflict is returned when the injected script registers an event that the target file already handles.
+var ErrEventConflict = errors.New("conflicting event registrations")
+
+// registrationKinds lists the LuaBootstrap methods that hold a single handler per event.
+var registrationKinds = map[string]bool{
+	"on_event":                 true,
+	"on_nth_tick":              true,
+	"on_init":                  true,
+	"on_load":                  true,
+	"on_configuration_changed": true,
+}
+
+// eventHandlerRegistrations lists the handlers the event_handler library registers for itself when required.
+var eventHandlerRegistrations = []string{"on_init", "on_load", "on_configuration_changed"}
+
+// dispatcherCode defines the wci_dispatch helper. Every registration routed through it is added to a list,
+// and a single function calling the whole list is registered with the real script object.
+const dispatcherCode = `wci_dispatch = wci_dispatch or (function()
+    local dispatch = {}
+    local handlers = {}
+
+    local function add(key, register, handler)
+        if handler == nil then
+            handlers[key] = nil
+            register(nil)
+            return
+        end
+        local list = handlers[key]
+        if not list then
+            list = {}
+            handlers[key] = list
+            register(function(...)
+                for _, callback in ipairs(list) do
+                    callback(...)
+                end
+            end)
+        end
+        list[#list + 1] = handler
+    end
+
+    function dispatch.on_event(event, handler)
+        if type(event) == "table" then
+            for _, id in ipairs(event) do
+                dispatch.on_event(id, handler)
+            end
+            return
+        end
+        add("event:" .. tostring(event), function(callback) script.on_event(event, callback) end, handler)
+    end
+
+    function dispatch.on_nth_tick(tick, handler)
+        add("nth_tick:" .. tostring(tick), function(callback) script.on_nth_tick(tick, callback) end, handler)
+    end
+
+    function dispatch.on_init(handler)
+        add("init", script.on_init, handler)
+    end
+
+    function dispatch.on_load(handler)
+        add("load", script.on_load, handler)
+    end
+
+    function dispatch.on_configuration_changed(handler)
+        add("configuration_changed", script.on_configuration_changed, handler)
+    end
+
+    return dispatch
+end)()`
+
+// EventRegistration is a call such as script.on_event(defines.events.on_tick, handler) found in Lua source.
+type EventRegistration struct {
+	File       string
+	Kind       string       // on_event, on_nth_tick, on_init, on_load or on_configuration_changed
+	Event      string       // source text of the event or tick argument, empty for single-handler kinds
+	Pos        lua.Position // position of the call
+	Start      int          // offset of the receiver ("script" or "wci_dispatch")
+	NameEnd    int          // offset just after the method name
+	HasFilters bool         // on_event was called with an event filter argument
+	Dispatched bool         // the call already goes through wci_dispatch
+	Library    bool         // registered implicitly by the event_handler library
+	Block      string       // name of the WCI block containing the call, if any
+}
+
+// Key identifies the handler slot a registration occupies.
+func (r EventRegistration) Key() string {
+	if r.Event == "" {
+		return r.Kind
+	}
+	return r.Kind + " " + r.Event
+}
+
+// EventConflict pairs a registration of the target file with one of the injected script for the same handler slot.
+type EventConflict struct {
+	Existing  EventRegistration
+	Incoming  EventRegistration
+	Chainable bool
+	Reason    string // why the conflict cannot be chained
+}
+
+// ParseEventConflictMode converts a user supplied mode into an EventConflictMode. An empty value selects refuse.
+func ParseEventConflictMode(value string) (EventConflictMode, error) {
+	switch EventConflictMode(value) {
+	case "", EventConflictRefuse:
+		return EventConflictRefuse, nil
+	case EventConflictChain:
+		return EventConflictChain, nil
+	default:
+		return "", fmt.Errorf("unknown event conflict mode '%s' (supported: refuse, chain)", value)
+	}
+}
+
+// FindEventRegistrations lists the script.on_* registrations in a Lua source file.
+// Calls routed through wci_dispatch are included and marked as Dispatched, and a require of the
+// event_handler library adds the handlers that library registers for itself.
+func FindEventRegistrations(file, source string) ([]EventRegistration, error) {
+	tokens, err := codeTokens(file, source)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := ParseInjectionBlocks(source)
+	if err != nil {
+		return nil, err
+	}
+
+	var registrations []EventRegistration
+	libraryFound := false
+	for i := 0; i+3 < len(tokens); i++ {
+		if isEventHandlerRequire(tokens, i) && !libraryFound {
+			libraryFound = true
+			for _, kind := range eventHandlerRegistrations {
+				registrations = append(registrations, EventRegistration{
+					File: file, Kind: kind, Pos: tokens[i].Pos, Start: tokens[i].Pos.Offset, NameEnd: tokens[i].End,
+					Library: true, Block: blockAt(blocks, tokens[i].Pos.Offset),
+				})
+			}
+			continue
+		}
+
+		receiver := tokens[i]
+		if receiver.Type != lua.TokenName || (receiver.Text != "script" && receiver.Text != dispatcherName) {
+			continue
+		}
+		if i > 0 && (tokens[i-1].Text == "." || tokens[i-1].Text == ":") {
+			continue
+		}
+		if blockAt(blocks, receiver.Pos.Offset) == dispatcherName {
+			continue
+		}
+		if tokens[i+1].Text != "." || tokens[i+2].Type != lua.TokenName || !registrationKinds[tokens[i+2].Text] || tokens[i+3].Text != "(" {
+			continue
+		}
+
+		arguments, _ := splitCallArguments(source, tokens, i+3)
+		registration := EventRegistration{
+			File:       file,
+			Kind:       tokens[i+2].Text,
+			Pos:        receiver.Pos,
+			Start:      receiver.Pos.Offset,
+			NameEnd:    tokens[i+2].End,
+			Dispatched: receiver.Text == dispatcherName,
+			Block:      blockAt(blocks, receiver.Pos.Offset),
+		}
+
+		switch registration.Kind {
+		case "on_event":
+			registration.HasFilters = len(arguments) > 2
+			if len(arguments) > 0 {
+				for _, event := range splitEventList(arguments[0]) {
+					expanded := registration
+					expanded.Event = event
+					registrations = append(registrations, expanded)
+				}
+			}
+		case "on_nth_tick":
+			if len(arguments) > 0 {
+				registration.Event = arguments[0]
+			}
+			registrations = append(registrations, registration)
+		default:
+			registrations = append(registrations, registration)
+		}
+	}
+
+	log.Trace().
+		Str("file", file).
+		Int("registrationCount", len(registrations)).
+		Msg("Found event registrations")
+	return registrations, nil
+}
+
+// DetectEventConflicts compares the registrations of the target file with those of the script to inject. In a
+// target that requires event_handler, every event and nth tick handler of the script is a conflict that cannot be
+// chained.
+func DetectEventConflicts(targetFile string, target []byte, scriptFile string, code []byte) ([]EventConflict, error) {
+	existing, err := FindEventRegistrations(targetFile, string(target))
+	if err != nil {
+		return nil, err
+	}
+	incoming, err := FindEventRegistrations(scriptFile, string(code))
+	if err != nil {
+		return nil, err
+	}
+
+	var library *EventRegistration
+	for i := range existing {
+		if existing[i].Library {
+			library = &existing[i]
+			break
+		}
+	}
+
+	var conflicts []EventConflict
+	for _, in := range incoming {
+		if in.Dispatched {
+			continue
+		}
+
+		// event_handler registers the events of its libs again in on_init and on_load, which replaces any
+		// handler registered beside it
+		if library != nil && (in.Kind == "on_event" || in.Kind == "on_nth_tick") {
+			conflicts = append(conflicts, EventConflict{
+				Existing: *library,
+				Incoming: in,
+				Reason: "event_handler registers the events of its libs again on init and load, replacing this " +
+					"handler; use the event_handler strategy (--strategy event_handler)",
+			})
+			continue
+		}
+		for _, ex := range existing {
+			if ex.Key() != in.Key() {
+				continue
+			}
+			conflict := EventConflict{Existing: ex, Incoming: in, Chainable: true}
+			switch {
+			case ex.Library:
+				conflict.Chainable = false
+				conflict.Reason = "registered inside the event_handler library, use the event_handler strategy"
+			case ex.HasFilters || in.HasFilters:
+				conflict.Chainable = false
+				conflict.Reason = "event filters cannot be combined by the dispatcher"
+			case ex.Block != "" && !ex.Dispatched:
+				conflict.Chainable = false
+				conflict.Reason = fmt.Sprintf("registered by the injected script '%s', which would have to be edited", ex.Block)
+			}
+			conflicts = append(conflicts, conflict)
+		}
+	}
+
+	sort.SliceStable(conflicts, func(i, j int) bool {
+		return conflicts[i].Incoming.Pos.Offset < conflicts[j].Incoming.Pos.Offset
+	})
+	return conflicts, nil
+}
+
+// FormatEventConflicts renders a human readable report with one line per conflict.
+func FormatEventConflicts(conflicts []EventConflict) string {
+	var report strings.Builder
+	for _, conflict := range conflicts {
+		fmt.Fprintf(&report, "  %s: %s:%s and %s:%s",
+			conflict.Incoming.Key(),
+			conflict.Existing.File, conflict.Existing.Pos,
+			conflict.Incoming.File, conflict.Incoming.Pos)
+		if !conflict.Chainable {
+			fmt.Fprintf(&report, " (cannot chain: %s)", conflict.Reason)
+		}
+		report.WriteString("\n")
+	}
+	return report.String()
+}
+
+// ChainEventRegistrations rewrites every script.on_* registration in code to go through wci_dispatch.
+func ChainEventRegistrations(file, code string) (string, error) {
+	registrations, err := FindEventRegistrations(file, code)
+	if err != nil {
+		return "", err
+	}
+
+	var direct []EventRegistration
+	for _, registration := range registrations {
+		if !registration.Dispatched && !registration.Library {
+			direct = append(direct, registration)
+		}
+	}
+	return rewriteRegistrations(code, direct, dispatcherName), nil
+}
+
+// applyEventConflictMode checks the target for registrations clashing with the script and, in chain mode,
+// returns the target content with the clashing calls routed through the dispatcher.
+// The returned flag reports whether the script's own registrations must be chained as well.
+func applyEventConflictMode(targetPath string, target []byte, injection ScriptInjection) ([]byte, bool, error) {
+	conflicts, err := DetectEventConflicts(targetPath, target, injection.Name+".lua", []byte(injection.Code))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(conflicts) == 0 {
+		return target, false, nil
+	}
+
+	if injection.EventConflicts != EventConflictChain {
+		return nil, false, fmt.Errorf("%w:\n%s", ErrEventConflict, FormatEventConflicts(conflicts))
+	}
+
+	var unchainable []EventConflict
+	var rewrites []EventRegistration
+	seen := make(map[int]bool)
+	for _, conflict := range conflicts {
+		if !conflict.Chainable {
+			unchainable = append(unchainable, conflict)
+			continue
+		}
+		if !conflict.Existing.Dispatched && !seen[conflict.Existing.Start] {
+			rewrites = append(rewrites, conflict.Existing)
+			seen[conflict.Existing.Start] = true
+		}
+	}
+	if len(unchainable) > 0 {
+		return nil, false, fmt.Errorf("%w:\n%s", ErrEventConflict, FormatEventConflicts(unchainable))
+	}
+
+	log.Info().
+		Str("file", targetPath).
+		Int("conflictCount", len(conflicts)).
+		Msg("Chaining conflicting event registrations through the dispatcher")
+
+	updated := rewriteRegistrations(string(target), rewrites, dispatcherName)
+	return []byte(ensureDispatcher(updated)), true, nil
+}
+
+// ensureDispatcher puts the wci_dispatch block at the top of content unless it is already present.
+func ensureDispatcher(content string) string {
+	if block, err := FindInjectionBlock(content, dispatcherName); err == nil && block != nil {
+		return content
+	}
+	return BuildInjectionBlock(dispatcherName, dispatcherVersion, dispatcherCode, nil) + content
+}
+
+// removeUnusedDispatcher undoes the chaining of event registrations once no injected script chains its events any
+// more: the wci_dispatch blocks are dropped and the registrations that were routed through the dispatcher for the
+// chained scripts go to script again, so the files read as before the first chained injection. luaFiles holds the
+// content before changes, the changed files are added to changes.
+func removeUnusedDispatcher(luaFiles map[string][]byte, changes *ZipChanges) error {
+	current := make(map[string]string)
+	for name, content := range luaFiles {
+		if modified, ok := changes.Modified[name]; ok {
+			content = modified
+		}
+		current[name] = string(content)
+	}
+	for _, name := range changes.Removed {
+		delete(current, name)
+	}
+
+	dispatchers := make(map[string]InjectionBlock)
+	for name, content := range current {
+		blocks, err := ParseInjectionBlocks(content)
+		if err != nil {
+			return fmt.Errorf("failed to parse markers in '%s': %w", name, err)
+		}
+		for _, block := range blocks {
+			if block.Attributes[attributeEvents] == string(EventConflictChain) {
+				return nil
+			}
+			if block.Name == dispatcherName {
+				dispatchers[name] = block
+			}
+		}
+	}
+
+	for name, block := range dispatchers {
+		if block.Modified() {
+			return fmt.Errorf("block '%s' in '%s' (lines %d-%d) does not match its recorded hash: %w",
+				dispatcherName, name, block.BeginLine, block.EndLine, ErrScriptModified)
+		}
+		content := CutInjectionBlock(current[name], block)
+		registrations, err := FindEventRegistrations(name, content)
+		if err != nil {
+			return err
+		}
+		var dispatched []EventRegistration
+		for _, registration := range registrations {
+			if registration.Dispatched && registration.Block == "" {
+				dispatched = append(dispatched, registration)
+			}
+		}
+		changes.Modified[name] = []byte(rewriteRegistrations(content, dispatched, "script"))
+
+		log.Info().
+			Str("file", name).
+			Int("registrationCount", len(dispatched)).
+			Msg("Removed the unused event dispatcher")
+	}
+	return nil
+}
+
+// rewriteRegistrations replaces the receiver of each registration call, "script" or "wci_dispatch", with receiver.
+func rewriteRegistrations(source string, registrations []EventRegistration, receiver string) string {
+	sorted := append([]EventRegistration{}, registrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start > sorted[j].Start })
+
+	lastStart := -1
+	for _, registration := range sorted {
+		// on_event with an event list yields one registration per event for the same call
+		if registration.Start == lastStart {
+			continue
+		}
+		lastStart = registration.Start
+		source = source[:registration.Start] + receiver + "." + registration.Kind + source[registration.NameEnd:]
+	}
+	return source
+}
+
+// codeTokens returns the tokens of a Lua source file without comments.
+func codeTokens(file, source string) ([]lua.Token, error) {
+	tokens, err := lua.Tokenize(file, source)
+	if err != nil {
+		return nil, err
+	}
+	code := tokens[:0]
+	for _, token := range tokens {
+		if token.Type != lua.TokenComment {
+			code = append(code, token)
+		}
+	}
+	return code, nil
+}
+
+// splitCallArguments returns the normalised source text of each argument of the call whose "(" is at tokens[open],
+// together with the index of the closing ")".
+func splitCallArguments(source string, tokens []lua.Token, open int) ([]string, int) {
+	var arguments []string
+	depth := 0
+	argumentStart := -1
+	flush := func(end int) {
+		if argumentStart >= 0 {
+			arguments = append(arguments, strings.Join(strings.Fields(source[argumentStart:end]), " "))
+		}
+		argumentStart = -1
+	}
+
+	for i := open + 1; i < len(tokens); i++ {
+		token := tokens[i]
+		switch token.Text {
+		case "(", "{", "[":
+			depth++
+		case ")", "}", "]":
+			if depth == 0 {
+				flush(tokens[i-1].End)
+				return arguments, i
+			}
+			depth--
+		case ",":
+			if depth == 0 {
+				flush(tokens[i-1].End)
+				continue
+			}
+		}
+		if token.Type == lua.TokenEOF {
+			break
+		}
+		if argumentStart < 0 {
+			argumentStart = token.Pos.Offset
+		}
+	}
+	return arguments, len(tokens) - 1
+}
+
+// splitEventList expands a "{a, b}" event list argument into its elements.
+func splitEventList(argument string) []string {
+	if !strings.HasPrefix(argument, "{") || !strings.HasSuffix(argument, "}") {
+		return []string{argument}
+	}
+	tokens, err := codeTokens("", argument)
+	if err != nil {
+		return []string{argument}
+	}
+	elements, _ := splitCallArguments(argument, tokens, 0)
+	return elements
+}
+
+// isEventHandlerRequire reports whether tokens[i] starts require("event_handler") or require "event_handler".
+func isEventHandlerRequire(tokens []lua.Token, i int) bool {
+	if tokens[i].Type != lua.TokenName || tokens[i].Text != "require" {
+		return false
+	}
+	next := i + 1
+	if tokens[next].Text == "(" {
+		next++
+	}
+	return next < len(tokens) && tokens[next].Type == lua.TokenString && tokens[next].Value == "event_handler"
+}
+
+// blockAt returns the name of the WCI block containing the offset, or an empty string.
+func blockAt(blocks []InjectionBlock, offset int) string {
+	for _, block := range blocks {
+		if offset >= block.Start && offset < block.End {
+			return block.Name
+		}
+	}
+	return ""
+}
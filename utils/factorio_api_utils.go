@@ -0,0 +1,340 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"wci/embedded"
+)
+
+// FactorioAPI is the part of the Factorio runtime API of one game version the linter checks scripts against.
+type FactorioAPI struct {
+	Version string                    // application version, e.g. "2.0.28", or the version in the file name, e.g. "2.0"
+	Events  map[string]bool           // names of defines.events
+	Classes map[string]*FactorioClass // by class name, e.g. "LuaSurface"
+}
+
+// FactorioClass is a class of the runtime API.
+type FactorioClass struct {
+	Name    string
+	Methods map[string]*FactorioMethod
+}
+
+// FactorioMethod is a method of a runtime API class. Methods that take a table, such as find_entities_filtered,
+// list the keys of the table as TableKeys.
+type FactorioMethod struct {
+	Name       string
+	TakesTable bool
+	TableKeys  map[string]bool
+}
+
+// HasMethod reports whether the API has the method of the class.
+func (a *FactorioAPI) HasMethod(class, method string) bool {
+	return a.Method(class, method) != nil
+}
+
+// Method returns the method of a class, or nil if the API has no such class or method.
+func (a *FactorioAPI) Method(class, method string) *FactorioMethod {
+	if c, ok := a.Classes[class]; ok {
+		return c.Methods[method]
+	}
+	return nil
+}
+
+// runtimeAPIDocument is the layout of the runtime-api.json files Factorio publishes. Only the fields the
+// linter reads are declared; api_version 4 (1.1) marks table arguments with takes_table, api_version 5 (2.0)
+// with format.takes_table or with a single parameter whose type is a table concept.
+type runtimeAPIDocument struct {
+	Application        string `json:"application"`
+	Stage              string `json:"stage"`
+	ApplicationVersion string `json:"application_version"`
+	Classes            []struct {
+		Name    string `json:"name"`
+		Methods []struct {
+			Name       string `json:"name"`
+			TakesTable bool   `json:"takes_table"`
+			Format     struct {
+				TakesTable bool `json:"takes_table"`
+			} `json:"format"`
+			Parameters []runtimeAPIParameter `json:"parameters"`
+		} `json:"methods"`
+	} `json:"classes"`
+	Defines []struct {
+		Name   string `json:"name"`
+		Values []struct {
+			Name string `json:"name"`
+		} `json:"values"`
+	} `json:"defines"`
+	Concepts []struct {
+		Name string          `json:"name"`
+		Type json.RawMessage `json:"type"`
+	} `json:"concepts"`
+}
+
+// runtimeAPIParameter is a parameter of a method or a field of a table type.
+type runtimeAPIParameter struct {
+	Name string          `json:"name"`
+	Type json.RawMessage `json:"type"`
+}
+
+// runtimeAPITableType is a complex type of the runtime API; only tables are of interest.
+type runtimeAPITableType struct {
+	ComplexType string                `json:"complex_type"`
+	Parameters  []runtimeAPIParameter `json:"parameters"`
+}
+
+// ParseFactorioAPI reads a runtime-api.json file as published by Factorio, or as trimmed by TrimFactorioAPI.
+func ParseFactorioAPI(data []byte) (*FactorioAPI, error) {
+	var document runtimeAPIDocument
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse runtime API: %w", err)
+	}
+	if document.Application != "factorio" || document.Stage != "runtime" {
+		return nil, fmt.Errorf("not a Factorio runtime API description")
+	}
+
+	// Table concepts such as EntitySearchFilters, by name
+	tableConcepts := make(map[string][]runtimeAPIParameter)
+	for _, concept := range document.Concepts {
+		var table runtimeAPITableType
+		if json.Unmarshal(concept.Type, &table) == nil && table.ComplexType == "table" {
+			tableConcepts[concept.Name] = table.Parameters
+		}
+	}
+
+	api := &FactorioAPI{
+		Version: document.ApplicationVersion,
+		Events:  make(map[string]bool),
+		Classes: make(map[string]*FactorioClass),
+	}
+	for _, define := range document.Defines {
+		if define.Name != "events" {
+			continue
+		}
+		for _, value := range define.Values {
+			api.Events[value.Name] = true
+		}
+	}
+	for _, class := range document.Classes {
+		apiClass := &FactorioClass{Name: class.Name, Methods: make(map[string]*FactorioMethod)}
+		for _, method := range class.Methods {
+			apiMethod := &FactorioMethod{Name: method.Name}
+			parameters := method.Parameters
+			if !method.TakesTable && !method.Format.TakesTable && len(parameters) == 1 {
+				var conceptName string
+				if json.Unmarshal(parameters[0].Type, &conceptName) == nil && tableConcepts[conceptName] != nil {
+					parameters = tableConcepts[conceptName]
+					apiMethod.TakesTable = true
+				}
+			} else {
+				apiMethod.TakesTable = method.TakesTable || method.Format.TakesTable
+			}
+			if apiMethod.TakesTable {
+				apiMethod.TableKeys = make(map[string]bool)
+				for _, parameter := range parameters {
+					apiMethod.TableKeys[parameter.Name] = true
+				}
+			}
+			apiClass.Methods[method.Name] = apiMethod
+		}
+		api.Classes[class.Name] = apiClass
+	}
+	return api, nil
+}
+
+// LoadFactorioAPIs reads every runtime-api-*.json file of a directory, sorted by version. A file without an
+// application_version has the version in its name, e.g. "2.0" for runtime-api-2.0.json.
+func LoadFactorioAPIs(fileSystem fs.FS, dir string) ([]*FactorioAPI, error) {
+	fileNames, err := fs.Glob(fileSystem, path.Join(dir, "runtime-api-*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var apis []*FactorioAPI
+	for _, fileName := range fileNames {
+		data, err := fs.ReadFile(fileSystem, fileName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read '%s': %w", fileName, err)
+		}
+		api, err := ParseFactorioAPI(data)
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %w", fileName, err)
+		}
+		if api.Version == "" {
+			api.Version = strings.TrimSuffix(strings.TrimPrefix(path.Base(fileName), "runtime-api-"), ".json")
+		}
+		apis = append(apis, api)
+	}
+	sort.Slice(apis, func(i, j int) bool { return CompareVersions(apis[i].Version, apis[j].Version) < 0 })
+	return apis, nil
+}
+
+// trimmedAPIDocument is the layout TrimFactorioAPI writes: the part of a runtime-api.json file ParseFactorioAPI
+// reads, with the table arguments of every method resolved to their keys.
+type trimmedAPIDocument struct {
+	Application        string             `json:"application"`
+	Stage              string             `json:"stage"`
+	ApplicationVersion string             `json:"application_version"`
+	APIVersion         int                `json:"api_version"`
+	Classes            []trimmedAPIClass  `json:"classes"`
+	Defines            []trimmedAPIDefine `json:"defines"`
+}
+
+// trimmedAPIClass is a class of a trimmed runtime API.
+type trimmedAPIClass struct {
+	Name    string             `json:"name"`
+	Methods []trimmedAPIMethod `json:"methods"`
+}
+
+// trimmedAPIMethod is a method of a trimmed runtime API; Parameters are the keys of the table it takes.
+type trimmedAPIMethod struct {
+	Name       string           `json:"name"`
+	TakesTable bool             `json:"takes_table,omitempty"`
+	Parameters []trimmedAPIName `json:"parameters,omitempty"`
+}
+
+// trimmedAPIDefine is a table of defines of a trimmed runtime API, only defines.events is kept.
+type trimmedAPIDefine struct {
+	Name   string           `json:"name"`
+	Values []trimmedAPIName `json:"values"`
+}
+
+// trimmedAPIName is a named entry of a trimmed runtime API.
+type trimmedAPIName struct {
+	Name string `json:"name"`
+}
+
+// TrimFactorioAPI reduces a runtime-api.json file as Factorio ships it in doc-html to what the linter reads: the
+// names of defines.events and of the methods of every class, and the keys of the methods taking a table. The
+// result is sorted by name, so the bundled files can be regenerated and compared.
+func TrimFactorioAPI(data []byte) ([]byte, error) {
+	api, err := ParseFactorioAPI(data)
+	if err != nil {
+		return nil, err
+	}
+	if api.Version == "" {
+		return nil, fmt.Errorf("the runtime API has no application_version")
+	}
+	var header struct {
+		APIVersion int `json:"api_version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("failed to parse runtime API: %w", err)
+	}
+
+	trimmed := trimmedAPIDocument{
+		Application:        "factorio",
+		Stage:              "runtime",
+		ApplicationVersion: api.Version,
+		APIVersion:         header.APIVersion,
+		Classes:            []trimmedAPIClass{},
+		Defines:            []trimmedAPIDefine{{Name: "events", Values: sortedAPINames(api.Events)}},
+	}
+	for _, className := range sortedKeys(api.Classes) {
+		class := trimmedAPIClass{Name: className}
+		methods := api.Classes[className].Methods
+		for _, methodName := range sortedKeys(methods) {
+			method := trimmedAPIMethod{Name: methodName, TakesTable: methods[methodName].TakesTable}
+			method.Parameters = sortedAPINames(methods[methodName].TableKeys)
+			class.Methods = append(class.Methods, method)
+		}
+		trimmed.Classes = append(trimmed.Classes, class)
+	}
+
+	encoded, err := json.MarshalIndent(trimmed, "", " ")
+	if err != nil {
+		return nil, err
+	}
+	return append(encoded, '\n'), nil
+}
+
+// sortedAPINames returns the names of a set sorted.
+func sortedAPINames(names map[string]bool) []trimmedAPIName {
+	var sorted []trimmedAPIName
+	for _, name := range sortedKeys(names) {
+		sorted = append(sorted, trimmedAPIName{Name: name})
+	}
+	return sorted
+}
+
+var (
+	bundledAPIsOnce sync.Once
+	bundledAPIs     []*FactorioAPI
+	bundledAPIsErr  error
+)
+
+// BundledFactorioAPIs returns the runtime APIs compiled into wci, sorted by version.
+func BundledFactorioAPIs() ([]*FactorioAPI, error) {
+	bundledAPIsOnce.Do(func() {
+		bundledAPIs, bundledAPIsErr = LoadFactorioAPIs(embedded.FactorioAPI, embedded.FactorioAPIDir)
+		if bundledAPIsErr != nil {
+			log.Error().
+				Err(bundledAPIsErr).
+				Msg("Failed to load the bundled Factorio runtime API")
+		}
+	})
+	return bundledAPIs, bundledAPIsErr
+}
+
+// FactorioAPIsFor returns the bundled runtime APIs of the versions in a range, all of them for an empty range.
+// A range that includes no bundled version, such as one for a future release, selects none.
+func FactorioAPIsFor(versions VersionRange) ([]*FactorioAPI, error) {
+	apis, err := BundledFactorioAPIs()
+	if err != nil {
+		return nil, err
+	}
+	var selected []*FactorioAPI
+	for _, api := range apis {
+		if versions.Contains(api.Version) {
+			selected = append(selected, api)
+		}
+	}
+	return selected, nil
+}
+
+// scriptFactorioAPIs returns the bundled runtime APIs of the Factorio versions a script declares with
+// "-- @factorio".
+func scriptFactorioAPIs(script ScriptFile) ([]*FactorioAPI, error) {
+	metadata, err := ParseScriptMetadata(script.Path, script.Code)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := metadata.FactorioRange()
+	if err != nil {
+		return nil, err
+	}
+	return FactorioAPIsFor(versions)
+}
+
+// saveFactorioAPIs returns the bundled runtime APIs a script is checked against before it goes into a save written
+// by the given Factorio version: the API of that major and minor version, as the script is migrated to it. Saves
+// of an unknown version, or of a version wci bundles no API for, check the versions the script declares.
+func saveFactorioAPIs(script ScriptFile, factorio string) ([]*FactorioAPI, error) {
+	if parts := strings.Split(factorio, "."); len(parts) >= 2 {
+		versions, err := ParseVersionRange(parts[0] + "." + parts[1])
+		if err != nil {
+			return nil, err
+		}
+		apis, err := FactorioAPIsFor(versions)
+		if err != nil || len(apis) > 0 {
+			return apis, err
+		}
+	}
+	return scriptFactorioAPIs(script)
+}
+
+// factorioVersions joins the versions of the given APIs for messages, e.g. "1.1.110, 2.0.28".
+func factorioVersions(apis []*FactorioAPI) string {
+	versions := make([]string, 0, len(apis))
+	for _, api := range apis {
+		versions = append(versions, api.Version)
+	}
+	return strings.Join(versions, ", ")
+}
@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// markerBeginPrefix starts the comment line that opens an injected script block.
+	markerBeginPrefix = "-- WCI:BEGIN"
+	// markerEndPrefix starts the comment line that closes an injected script block.
+	markerEndPrefix = "-- WCI:END"
+	// defaultScriptVersion is used for scripts that do not declare an @version header.
+	defaultScriptVersion = "0.0.0"
+)
+
+var (
+	// ErrScriptNotInjected is returned when a save contains no marker block for the requested script.
+	ErrScriptNotInjected = errors.New("script is not injected")
+	// ErrScriptModified is returned when the body of a marker block no longer matches its recorded hash.
+	ErrScriptModified = errors.New("injected script was modified by hand")
+
+	markerBeginPattern   = regexp.MustCompile(`^--\s*WCI:BEGIN\s+(\S+)\s+v(\S+)\s+sha256=([0-9a-f]{64})((?:\s+[A-Za-z0-9_-]+=\S*)*)\s*$`)
+	markerEndPattern     = regexp.MustCompile(`^--\s*WCI:END\s+(\S+)\s*$`)
+	scriptVersionPattern = regexp.MustCompile(`^--\s*@version\s+(\S+)\s*$`)
+)
+
+// InjectionBlock describes a marker-delimited script block inside a Lua file.
+// Offsets are byte positions in the content the block was parsed from.
+type InjectionBlock struct {
+	Name       string
+	Version    string
+	Hash       string
+	Attributes map[string]string // optional key=value pairs after the hash, e.g. strategy=require
+	Body       string
+	Start      int // start of the BEGIN marker line
+	End        int // end of the END marker line, including its line break
+	BodyStart  int
+	BodyEnd    int
+	BeginLine  int // 1-based line number of the BEGIN marker
+	EndLine    int // 1-based line number of the END marker
+}
+
+// Modified reports whether the block body no longer matches the hash recorded in its BEGIN marker.
+func (b InjectionBlock) Modified() bool {
+	return HashScript(b.Body) != b.Hash
+}
+
+// HashScript returns the hex encoded SHA-256 hash of a script body.
+func HashScript(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// ScriptNameFromFile derives the injection name of a script from its file name (e.g. "lua_injections/biter_killer.lua" -> "biter_killer").
+// The entry module of a script package is named after the package directory ("radar/init.lua" -> "radar"), and
+// the variant of a script for a Factorio version after the script ("biter_killer.2.0.lua" -> "biter_killer").
+func ScriptNameFromFile(fileName string) string {
+	name, _ := splitScriptVariant(scriptFileBase(fileName))
+	return name
+}
+
+// scriptFileBase returns the name of a script file without extension, or the directory of a package entry.
+func scriptFileBase(fileName string) string {
+	fileName = strings.ReplaceAll(fileName, "\\", "/")
+	if IsPackageEntry(fileName) {
+		return path.Base(path.Dir(fileName))
+	}
+	base := path.Base(fileName)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// ParseScriptVersion reads the "-- @version x.y.z" header from the leading comment lines of a script.
+// Scripts without a version header are treated as version 0.0.0.
+func ParseScriptVersion(code []byte) string {
+	for _, line := range strings.Split(string(code), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		if match := scriptVersionPattern.FindStringSubmatch(line); match != nil {
+			return match[1]
+		}
+	}
+	return defaultScriptVersion
+}
+
+// normalizeBlockBody ensures a script body ends with exactly one line break so hashes are stable.
+func normalizeBlockBody(code string) string {
+	code = strings.ReplaceAll(code, "\r\n", "\n")
+	return strings.TrimRight(code, "\n") + "\n"
+}
+
+// BuildInjectionBlock wraps the given code in WCI BEGIN/END markers recording its name, version and hash.
+// Optional attributes are written as key=value pairs after the hash, sorted by key.
+func BuildInjectionBlock(name, version, code string, attributes map[string]string) string {
+	body := normalizeBlockBody(code)
+
+	var begin strings.Builder
+	fmt.Fprintf(&begin, "%s %s v%s sha256=%s", markerBeginPrefix, name, version, HashScript(body))
+	for _, key := range sortedKeys(attributes) {
+		fmt.Fprintf(&begin, " %s=%s", key, attributes[key])
+	}
+
+	return begin.String() + "\n" + body + markerEndPrefix + " " + name + "\n"
+}
+
+// ParseInjectionBlocks finds all WCI marker blocks in the given Lua source.
+// It returns an error for nested, unterminated or mismatched markers.
+func ParseInjectionBlocks(content string) ([]InjectionBlock, error) {
+	var blocks []InjectionBlock
+	var current *InjectionBlock
+
+	offset := 0
+	lineNumber := 0
+	for offset < len(content) {
+		lineNumber++
+		lineEnd := strings.IndexByte(content[offset:], '\n')
+		next := len(content)
+		if lineEnd >= 0 {
+			next = offset + lineEnd + 1
+		}
+		line := strings.TrimSpace(content[offset:next])
+
+		switch {
+		case strings.HasPrefix(line, markerBeginPrefix):
+			match := markerBeginPattern.FindStringSubmatch(line)
+			if match == nil {
+				return nil, fmt.Errorf("malformed WCI:BEGIN marker on line %d", lineNumber)
+			}
+			if current != nil {
+				return nil, fmt.Errorf("WCI:BEGIN marker for '%s' on line %d is nested inside block '%s' opened on line %d",
+					match[1], lineNumber, current.Name, current.BeginLine)
+			}
+			current = &InjectionBlock{
+				Name:       match[1],
+				Version:    match[2],
+				Hash:       match[3],
+				Attributes: parseMarkerAttributes(match[4]),
+				Start:      offset,
+				BodyStart:  next,
+				BeginLine:  lineNumber,
+			}
+		case strings.HasPrefix(line, markerEndPrefix):
+			match := markerEndPattern.FindStringSubmatch(line)
+			if match == nil {
+				return nil, fmt.Errorf("malformed WCI:END marker on line %d", lineNumber)
+			}
+			if current == nil {
+				return nil, fmt.Errorf("WCI:END marker for '%s' on line %d has no matching WCI:BEGIN", match[1], lineNumber)
+			}
+			if match[1] != current.Name {
+				return nil, fmt.Errorf("WCI:END marker for '%s' on line %d does not match block '%s' opened on line %d",
+					match[1], lineNumber, current.Name, current.BeginLine)
+			}
+			current.BodyEnd = offset
+			current.Body = content[current.BodyStart:offset]
+			current.End = next
+			current.EndLine = lineNumber
+			blocks = append(blocks, *current)
+			current = nil
+		}
+
+		offset = next
+	}
+
+	if current != nil {
+		return nil, fmt.Errorf("WCI:BEGIN marker for '%s' on line %d is never closed", current.Name, current.BeginLine)
+	}
+
+	log.Trace().Int("blockCount", len(blocks)).Msg("Parsed injection blocks")
+	return blocks, nil
+}
+
+// FindInjectionBlock returns the block with the given script name, or nil if the script is not present.
+func FindInjectionBlock(content, name string) (*InjectionBlock, error) {
+	blocks, err := ParseInjectionBlocks(content)
+	if err != nil {
+		return nil, err
+	}
+	for i := range blocks {
+		if blocks[i].Name == name {
+			return &blocks[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// CutInjectionBlock removes a parsed block from the content it was parsed from.
+// The blank lines that AppendToFileInZip puts around a block are removed together with it,
+// blocks inserted between existing lines are cut out exactly.
+func CutInjectionBlock(content string, block InjectionBlock) string {
+	before := content[:block.Start]
+	after := content[block.End:]
+
+	if strings.HasSuffix(before, "\n\n") && strings.HasPrefix(after, "\n") {
+		after = after[1:]
+	}
+	if strings.TrimSpace(after) == "" && strings.TrimSpace(before) != "" {
+		return strings.TrimRight(before, "\n") + "\n"
+	}
+	return before + after
+}
+
+// parseMarkerAttributes turns the trailing " key=value" pairs of a BEGIN marker into a map.
+func parseMarkerAttributes(raw string) map[string]string {
+	attributes := make(map[string]string)
+	for _, field := range strings.Fields(raw) {
+		key, value, _ := strings.Cut(field, "=")
+		attributes[key] = value
+	}
+	return attributes
+}
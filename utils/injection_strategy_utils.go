@@ -0,0 +1,530 @@
+package utils
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// InjectionStrategy decides how a script is placed inside a savegame.
+type InjectionStrategy string
+
+const (
+	// StrategyAppend pastes the script at the end of the target file.
+	StrategyAppend InjectionStrategy = "append"
+	// StrategyRequire writes the script as its own module and adds a require call to the target file.
+	StrategyRequire InjectionStrategy = "require"
+	// StrategyEventHandler packages the script as an event_handler lib and registers it with add_lib.
+	StrategyEventHandler InjectionStrategy = "event_handler"
+)
+
+const (
+	// injectedModuleDir is the folder next to the target file that holds injected script modules.
+	injectedModuleDir = "wci"
+	// attributeStrategy records the injection strategy in a BEGIN marker.
+	attributeStrategy = "strategy"
+	// attributeModule marks a loader block and records the module name it requires.
+	attributeModule = "module"
+)
+
+// InjectionStrategies lists all supported strategies in the order they are documented.
+var InjectionStrategies = []InjectionStrategy{StrategyAppend, StrategyRequire, StrategyEventHandler}
+
+var (
+	addLibPattern       = regexp.MustCompile(`(?m)^[ \t]*(?:[A-Za-z_][A-Za-z0-9_.]*|require\s*\(\s*["']event_handler["']\s*\))\.add_lib\s*\(.*$`)
+	eventHandlerPattern = regexp.MustCompile(`(?m)^.*\brequire\s*\(?\s*["']event_handler["']\s*\)?.*$`)
+)
+
+// eventHandlerLibWrapper turns a plain script into an event_handler lib. The script runs with a local "script"
+// proxy that records its registrations in the lib table instead of replacing the scenario's handlers. A lib has no
+// place for event filters, so scripts passing them are refused, see checkEventHandlerFilters.
+const eventHandlerLibWrapper = `local wci_lib = {events = {}, on_nth_tick = {}}
+local script = setmetatable({
+    on_event = function(event, handler)
+        if type(event) == "table" then
+            for _, id in pairs(event) do
+                wci_lib.events[id] = handler
+            end
+        else
+            wci_lib.events[event] = handler
+        end
+    end,
+    on_nth_tick = function(tick, handler)
+        wci_lib.on_nth_tick[tick] = handler
+    end,
+    on_init = function(handler)
+        wci_lib.on_init = handler
+    end,
+    on_load = function(handler)
+        wci_lib.on_load = handler
+    end,
+    on_configuration_changed = function(handler)
+        wci_lib.on_configuration_changed = handler
+    end,
+}, {__index = script})
+
+do
+%s
+end
+
+return wci_lib
+`
+
+// ParseInjectionStrategy converts a user supplied strategy name into an InjectionStrategy.
+// An empty value selects StrategyAppend.
+func ParseInjectionStrategy(value string) (InjectionStrategy, error) {
+	if value == "" {
+		return StrategyAppend, nil
+	}
+	for _, strategy := range InjectionStrategies {
+		if string(strategy) == value {
+			return strategy, nil
+		}
+	}
+
+	names := make([]string, 0, len(InjectionStrategies))
+	for _, strategy := range InjectionStrategies {
+		names = append(names, string(strategy))
+	}
+	return "", fmt.Errorf("unknown injection strategy '%s' (supported: %s)", value, strings.Join(names, ", "))
+}
+
+// ScriptInjection describes a script to place inside a savegame.
+type ScriptInjection struct {
+	Name           string
+	Version        string
+	Code           string
+	Strategy       InjectionStrategy
+	EventConflicts EventConflictMode // what to do when the target already registers the same events
+	CommandRenames map[string]string // console commands of the script to register under a different name
+	Anchor         *Anchor           // where the block goes in the target file; nil uses the strategy's default
+	Params         map[string]any    // values for the parameters the script declares, see RenderScriptTemplate
+	Modules        []ScriptModule    // further modules of a script package, written to wci/<name>/
+	Factorio       string            // Factorio version of the save; the rendered code is migrated to it, see MigrateLuaCode
+	Prepared       bool              // Code and Modules are already rendered with Params and migrated, see prepareScript
+}
+
+// InjectedScriptLocation describes a marker block of a script found inside a savegame.
+type InjectedScriptLocation struct {
+	File  string
+	Block InjectionBlock
+}
+
+// IsLoader reports whether the block only loads the script from a separate module.
+func (l InjectedScriptLocation) IsLoader() bool {
+	return l.Block.Attributes[attributeModule] != ""
+}
+
+// IsPart reports whether the block carries a module of a script package.
+func (l InjectedScriptLocation) IsPart() bool {
+	return l.Block.Attributes[attributePart] != ""
+}
+
+// Strategy returns the strategy the block was injected with.
+func (l InjectedScriptLocation) Strategy() InjectionStrategy {
+	if strategy := l.Block.Attributes[attributeStrategy]; strategy != "" {
+		return InjectionStrategy(strategy)
+	}
+	return StrategyAppend
+}
+
+// FindInjectedScript returns every marker block of the named script in the given Lua files, ordered by file name.
+func FindInjectedScript(luaFiles map[string][]byte, scriptName string) ([]InjectedScriptLocation, error) {
+	var locations []InjectedScriptLocation
+	for _, fileName := range SortedFileNames(luaFiles) {
+		blocks, err := ParseInjectionBlocks(string(luaFiles[fileName]))
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("file", fileName).
+				Msg("Failed to parse injection markers")
+			return nil, fmt.Errorf("failed to parse injection markers in '%s': %w", fileName, err)
+		}
+		for _, block := range blocks {
+			if block.Name == scriptName {
+				locations = append(locations, InjectedScriptLocation{File: fileName, Block: block})
+			}
+		}
+	}
+	return locations, nil
+}
+
+// injectedModuleName returns the require name of an injected script module (e.g. "wci.biter_killer").
+func injectedModuleName(scriptName string) string {
+	return injectedModuleDir + "." + scriptName
+}
+
+// injectedModulePath returns the path inside the ZIP of the module file for a script injected next to targetPath.
+func injectedModulePath(targetPath, scriptName string) string {
+	return path.Join(path.Dir(targetPath), injectedModuleDir, scriptName+".lua")
+}
+
+// loaderBody returns the code a loader block uses to run the module of a script.
+func loaderBody(strategy InjectionStrategy, moduleName string) string {
+	if strategy == StrategyEventHandler {
+		return fmt.Sprintf("require(\"event_handler\").add_lib(require(%q))", moduleName)
+	}
+	return fmt.Sprintf("require(%q)", moduleName)
+}
+
+// scriptBody returns the code placed inside the block that carries a script. Renamed commands are applied first,
+// scripts injected with chained events have their registrations routed through wci_dispatch, and event_handler
+// scripts are wrapped as a lib.
+func scriptBody(strategy InjectionStrategy, attributes map[string]string, name, code string) (string, error) {
+	if renames := attributes[attributeRename]; renames != "" {
+		renamed, err := RenameCommands(name+".lua", code, parseRenameAttribute(renames))
+		if err != nil {
+			return "", err
+		}
+		code = renamed
+	}
+	if attributes[attributeEvents] == string(EventConflictChain) {
+		chained, err := ChainEventRegistrations(name+".lua", code)
+		if err != nil {
+			return "", err
+		}
+		code = chained
+	}
+	if strategy == StrategyEventHandler {
+		if err := checkEventHandlerFilters(name+".lua", code); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf(eventHandlerLibWrapper, strings.TrimRight(normalizeBlockBody(code), "\n")), nil
+	}
+	return code, nil
+}
+
+// checkEventHandlerFilters refuses a script that passes event filters to script.on_event. event_handler registers
+// the events of its libs without filters, so the handler would run for every entity instead of the filtered ones.
+func checkEventHandlerFilters(file, code string) error {
+	registrations, err := FindEventRegistrations(file, code)
+	if err != nil {
+		return err
+	}
+	for _, registration := range registrations {
+		if registration.HasFilters {
+			return fmt.Errorf("%s:%s: script.on_event is called with event filters, which the event_handler library "+
+				"cannot register; use the append or require strategy", file, registration.Pos)
+		}
+	}
+	return nil
+}
+
+// insertAfterAddLib places a loader block directly after the last add_lib call of control.lua,
+// or after the line requiring event_handler when no lib is registered yet.
+func insertAfterAddLib(content []byte, block string) ([]byte, error) {
+	text := string(content)
+
+	insertAt := -1
+	if matches := addLibPattern.FindAllStringIndex(text, -1); len(matches) > 0 {
+		insertAt = matches[len(matches)-1][1]
+	} else if match := eventHandlerPattern.FindStringIndex(text); match != nil {
+		insertAt = match[1]
+	}
+	if insertAt < 0 {
+		return nil, fmt.Errorf("the target file does not use the event_handler library, use the require strategy instead")
+	}
+
+	// Move past the line break that ends the matched line
+	if insertAt < len(text) && text[insertAt] == '\n' {
+		insertAt++
+	} else if insertAt == len(text) && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+		insertAt++
+	}
+
+	// Never split another injected block, insert after it instead
+	blocks, err := ParseInjectionBlocks(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, existing := range blocks {
+		if insertAt > existing.Start && insertAt < existing.End {
+			insertAt = existing.End
+		}
+	}
+
+	return []byte(text[:insertAt] + block + text[insertAt:]), nil
+}
+
+// appendBlock appends a marker block to file content, separated by blank lines like AppendToFileInZip.
+func appendBlock(content []byte, block string) []byte {
+	return append(append([]byte{}, content...), []byte("\n"+block+"\n")...)
+}
+
+// PlanInjection computes the changes that place a script into targetPath according to its strategy.
+// luaFiles must contain the current content of every Lua file in the savegame, including targetPath.
+// Scripts declaring parameters are rendered first, everything after that sees the rendered code. Scripts declaring
+// "-- @runtime" get the wci runtime placed in front of them, bound to injection.Version.
+func PlanInjection(luaFiles map[string][]byte, targetPath string, injection ScriptInjection) (ZipChanges, error) {
+	changes := NewZipChanges()
+	targetContent, exists := luaFiles[targetPath]
+	if !exists {
+		return changes, fmt.Errorf("target file '%s' not found in ZIP", targetPath)
+	}
+
+	scriptAttributes := make(map[string]string)
+	if !injection.Prepared {
+		script := ScriptFile{Path: injection.Name + ".lua", Name: injection.Name, Code: []byte(injection.Code), Modules: injection.Modules}
+		rendered, err := renderScript(script, injection.Params, injection.Factorio)
+		if err != nil {
+			return changes, err
+		}
+		injection.Code, injection.Modules = rendered.Code, rendered.Modules
+	}
+	injection.Code = withScriptRuntime(injection.Name, injection.Version, injection.Code)
+	if len(injection.Params) > 0 {
+		encoded, err := formatParamsAttribute(injection.Params)
+		if err != nil {
+			return changes, err
+		}
+		scriptAttributes[attributeParams] = encoded
+	}
+
+	// Console commands and remote interfaces are global, a duplicate name breaks loading the save
+	if _, err := checkNameCollisions(luaFiles, targetPath, injection); err != nil {
+		return changes, err
+	}
+	if len(injection.CommandRenames) > 0 {
+		scriptAttributes[attributeRename] = formatRenameAttribute(injection.CommandRenames)
+	}
+
+	// Scripts packaged for event_handler never replace the scenario's handlers
+	if injection.Strategy != StrategyEventHandler {
+		updated, chained, err := applyEventConflictMode(targetPath, targetContent, injection)
+		if err != nil {
+			return changes, err
+		}
+		targetContent = updated
+		if chained {
+			scriptAttributes[attributeEvents] = string(EventConflictChain)
+		}
+	}
+
+	// An anchor overrides where the block carrying or loading the script goes
+	var placement *anchorPlacement
+	if injection.Anchor != nil {
+		located, err := injection.Anchor.place(targetPath, string(targetContent))
+		if err != nil {
+			return changes, err
+		}
+		placement = &located
+	}
+
+	switch injection.Strategy {
+	case StrategyAppend, "":
+		body, err := scriptBody(StrategyAppend, scriptAttributes, injection.Name, injection.Code)
+		if err != nil {
+			return changes, err
+		}
+		if placement != nil {
+			block := BuildInjectionBlock(injection.Name, injection.Version, body, placement.withAttributes(scriptAttributes))
+			changes.Modified[targetPath] = placement.insert(targetContent, block)
+		} else {
+			block := BuildInjectionBlock(injection.Name, injection.Version, body, scriptAttributes)
+			changes.Modified[targetPath] = appendBlock(targetContent, block)
+		}
+
+	case StrategyRequire, StrategyEventHandler:
+		modulePath := injectedModulePath(targetPath, injection.Name)
+		if _, taken := luaFiles[modulePath]; taken {
+			return changes, fmt.Errorf("module file '%s' already exists in ZIP", modulePath)
+		}
+		moduleName := injectedModuleName(injection.Name)
+
+		loaderAttributes := map[string]string{attributeStrategy: string(injection.Strategy), attributeModule: moduleName}
+		loader := BuildInjectionBlock(injection.Name, injection.Version, loaderBody(injection.Strategy, moduleName), placement.withAttributes(loaderAttributes))
+		if placement != nil {
+			changes.Modified[targetPath] = placement.insert(targetContent, loader)
+		} else if injection.Strategy == StrategyEventHandler {
+			updated, err := insertAfterAddLib(targetContent, loader)
+			if err != nil {
+				return changes, err
+			}
+			changes.Modified[targetPath] = updated
+		} else {
+			changes.Modified[targetPath] = appendBlock(targetContent, loader)
+		}
+
+		scriptAttributes[attributeStrategy] = string(injection.Strategy)
+		body, err := scriptBody(injection.Strategy, scriptAttributes, injection.Name, injection.Code)
+		if err != nil {
+			return changes, err
+		}
+		changes.Modified[modulePath] = []byte(BuildInjectionBlock(injection.Name, injection.Version, body, scriptAttributes))
+
+	default:
+		return changes, fmt.Errorf("unsupported injection strategy '%s'", injection.Strategy)
+	}
+
+	// The further modules of a package go to their own folder, whatever the strategy
+	if err := planPackageModules(luaFiles, targetPath, injection, changes); err != nil {
+		return changes, err
+	}
+
+	log.Debug().
+		Str("script", injection.Name).
+		Str("strategy", string(injection.Strategy)).
+		Str("target", targetPath).
+		Bool("anchored", placement != nil).
+		Int("fileCount", len(changes.Modified)).
+		Msg("Planned script injection")
+	return changes, nil
+}
+
+// renderedScript is a script as it goes into a savegame: rendered with its parameter values and migrated to the
+// Factorio version of the save.
+type renderedScript struct {
+	Code      string
+	Modules   []ScriptModule
+	Migration ScriptMigration // what the migration rewrote and left, without code
+	Findings  []LintFinding   // lint warnings, filled by prepareScript
+}
+
+// renderScript renders the code of a script with its parameter values and migrates it, and the modules of a script
+// package, to the Factorio version factorio; without a version the code is only rendered.
+func renderScript(script ScriptFile, params map[string]any, factorio string) (renderedScript, error) {
+	code, err := RenderScriptTemplate(script.Name, string(script.Code), params)
+	if err != nil {
+		return renderedScript{}, err
+	}
+	rendered := renderedScript{Code: code, Modules: script.Modules}
+	if factorio == "" {
+		return rendered, nil
+	}
+
+	migration, err := MigrateLuaCode(script.Path, code, factorio)
+	if err != nil {
+		return renderedScript{}, err
+	}
+	modules, notes, err := migrateScriptModules(path.Dir(script.Path), script.Modules, factorio)
+	if err != nil {
+		return renderedScript{}, err
+	}
+	rendered.Code, rendered.Modules = migration.Code, modules
+	rendered.Migration = ScriptMigration{
+		Rewritten:  append(migration.Rewritten, notes.Rewritten...),
+		Unmigrated: append(migration.Unmigrated, notes.Unmigrated...),
+	}
+	return rendered, nil
+}
+
+// PlanRemoval computes the changes that cut every block of a script out of the savegame.
+// Module files created by StrategyRequire and the module files of a package are deleted once their block is gone.
+// Removing the last script with chained events also removes the event dispatcher, see removeUnusedDispatcher.
+func PlanRemoval(luaFiles map[string][]byte, scriptName string) (ZipChanges, error) {
+	changes := NewZipChanges()
+	locations, err := FindInjectedScript(luaFiles, scriptName)
+	if err != nil {
+		return changes, err
+	}
+	if len(locations) == 0 {
+		return changes, fmt.Errorf("'%s': %w", scriptName, ErrScriptNotInjected)
+	}
+
+	for _, location := range locations {
+		// Refuse to cut out code that no longer matches what was injected
+		if location.Block.Modified() {
+			return changes, fmt.Errorf("block '%s' in '%s' (lines %d-%d) does not match its recorded hash: %w",
+				scriptName, location.File, location.Block.BeginLine, location.Block.EndLine, ErrScriptModified)
+		}
+
+		if _, seen := changes.Modified[location.File]; seen {
+			return changes, fmt.Errorf("script '%s' is injected more than once into '%s'", scriptName, location.File)
+		}
+		content := string(luaFiles[location.File])
+		remaining, err := cutAnchoredBlock(content, location.Block)
+		if err != nil {
+			return changes, err
+		}
+
+		ownFile := location.IsPart() || (location.Strategy() != StrategyAppend && !location.IsLoader())
+		if ownFile && strings.TrimSpace(remaining) == "" {
+			changes.Removed = append(changes.Removed, location.File)
+			continue
+		}
+		changes.Modified[location.File] = []byte(remaining)
+	}
+
+	// The last chained script takes the event dispatcher with it
+	if err := removeUnusedDispatcher(luaFiles, &changes); err != nil {
+		return changes, err
+	}
+	return changes, nil
+}
+
+// RenderUpgradedBlock rebuilds an injected block for a new script version, keeping its strategy and role.
+// The new version is rendered with the parameter values recorded in the block; values of parameters the new
+// version no longer declares are dropped. With a Factorio version, the rendered code is migrated to it, see
+// MigrateLuaCode. The runtime of a script declaring "-- @runtime" is bound to the new version, so the script's
+// migrations run on its next event.
+func RenderUpgradedBlock(location InjectedScriptLocation, version, code, factorio string) (string, error) {
+	if location.IsLoader() {
+		body := loaderBody(location.Strategy(), location.Block.Attributes[attributeModule])
+		return BuildInjectionBlock(location.Block.Name, version, body, location.Block.Attributes), nil
+	}
+
+	values, err := upgradedParamValues(location.Block.Name, code, location.Block.Attributes[attributeParams])
+	if err != nil {
+		return "", err
+	}
+	script := ScriptFile{Path: location.Block.Name + ".lua", Name: location.Block.Name, Code: []byte(code)}
+	rendered, err := renderScript(script, values, factorio)
+	if err != nil {
+		return "", err
+	}
+	return buildUpgradedBlock(location, version, rendered.Code, values)
+}
+
+// buildUpgradedBlock builds the block of a script for its new version from the code rendered with values and
+// migrated, see renderScript. The values replace the parameters recorded in the block.
+func buildUpgradedBlock(location InjectedScriptLocation, version, code string, values map[string]any) (string, error) {
+	attributes := make(map[string]string, len(location.Block.Attributes))
+	for key, value := range location.Block.Attributes {
+		attributes[key] = value
+	}
+	delete(attributes, attributeParams)
+	if len(values) > 0 {
+		var err error
+		if attributes[attributeParams], err = formatParamsAttribute(values); err != nil {
+			return "", err
+		}
+	}
+	code = withScriptRuntime(location.Block.Name, version, code)
+
+	body, err := scriptBody(location.Strategy(), attributes, location.Block.Name, code)
+	if err != nil {
+		return "", err
+	}
+	return BuildInjectionBlock(location.Block.Name, version, body, attributes), nil
+}
+
+// upgradedParamValues returns the recorded parameter values that the new version of a script still declares.
+func upgradedParamValues(name, code, encoded string) (map[string]any, error) {
+	recorded, err := ParseParamsAttribute(encoded)
+	if err != nil || len(recorded) == 0 {
+		return nil, err
+	}
+	params, err := ParseScriptParams([]byte(code))
+	if err != nil {
+		return nil, fmt.Errorf("script '%s': %w", name, err)
+	}
+
+	values := make(map[string]any, len(recorded))
+	for _, param := range params {
+		if value, ok := recorded[param.Name]; ok {
+			values[param.Name] = value
+			delete(recorded, param.Name)
+		}
+	}
+	for key := range recorded {
+		log.Warn().
+			Str("script", name).
+			Str("param", key).
+			Msg("Dropping value of a parameter the new script version no longer declares")
+	}
+	return values, nil
+}
@@ -0,0 +1,841 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"wci/lua"
+)
+
+// LintSeverity ranks lint findings. Findings of SeverityError stop an injection.
+type LintSeverity string
+
+const (
+	SeverityError   LintSeverity = "error"
+	SeverityWarning LintSeverity = "warning"
+	SeverityInfo    LintSeverity = "info"
+)
+
+// Lint rule groups, selectable with "wci lint --rules".
+const (
+	LintGroupDesync = "desync"
+	LintGroupPerf   = "perf"
+	LintGroupAPI    = "api"
+)
+
+// ErrLintFailed is returned when a script to inject has findings of SeverityError.
+var ErrLintFailed = errors.New("script has lint errors")
+
+// lintIgnorePattern matches a suppression comment: "-- wci-lint: ignore" for every rule, or followed by rule IDs or
+// groups separated by commas or spaces. At the end of a line it applies to that line, on a line of its own to the
+// next line.
+var lintIgnorePattern = regexp.MustCompile(`^--\s*wci-lint:\s*ignore\b(.*)$`)
+
+// LintRule is a check of the Lua linter.
+type LintRule struct {
+	ID          string       `json:"id"`
+	Group       string       `json:"group"`
+	Severity    LintSeverity `json:"severity"`
+	Description string       `json:"description"`
+}
+
+// lintRules lists every rule of the linter.
+var lintRules = []LintRule{
+	{
+		ID: "desync-upvalue", Group: LintGroupDesync, Severity: SeverityError,
+		Description: "A file-level local is changed in a function. Such state is not saved with the map, so a " +
+			"player who joins later, or a reloaded save, starts from a different value than the other peers.",
+	},
+	{
+		ID: "desync-on-load", Group: LintGroupDesync, Severity: SeverityError,
+		Description: "on_load changes storage or uses game. on_load only runs on the peer that loads the save and " +
+			"may only restore local references and metatables.",
+	},
+	{
+		ID: "desync-pairs", Group: LintGroupDesync, Severity: SeverityWarning,
+		Description: "pairs iterates a table kept outside storage. Its order depends on how each peer filled the " +
+			"table, so peers can process the entries in different orders.",
+	},
+	{
+		ID: "perf-tick-search", Group: LintGroupPerf, Severity: SeverityWarning,
+		Description: "An on_tick handler searches the map for entities or tiles, which is slow to do 60 times a second.",
+	},
+	{
+		ID: "perf-concat-loop", Group: LintGroupPerf, Severity: SeverityInfo,
+		Description: "A string is built with '..' in a loop, which copies the whole string on every iteration.",
+	},
+	{
+		ID: "api-event", Group: LintGroupAPI, Severity: SeverityError,
+		Description: "defines.events names an event the targeted Factorio versions do not have.",
+	},
+	{
+		ID: "api-method", Group: LintGroupAPI, Severity: SeverityError,
+		Description: "A LuaSurface or LuaForce method is called that the targeted Factorio versions do not have.",
+	},
+	{
+		ID: "api-filter", Group: LintGroupAPI, Severity: SeverityError,
+		Description: "The table passed to find_entities_filtered or a similar search has a key the targeted Factorio " +
+			"versions do not know, so the filter is silently ignored or the call fails.",
+	},
+}
+
+// paramZeroValues are the values a required parameter is rendered with when a script is only checked.
+var paramZeroValues = map[ParamType]any{ParamString: "", ParamInt: 0, ParamNumber: 0, ParamBool: false}
+
+// storageRoots are the tables Factorio saves with the map: storage since 2.0, global before.
+var storageRoots = map[string]bool{"storage": true, "global": true}
+
+// tableMutators are the functions of the table library that change their first argument.
+var tableMutators = map[string]bool{"table.insert": true, "table.remove": true, "table.sort": true}
+
+// mapSearchMethods are the methods of LuaSurface that search the map.
+var mapSearchMethods = map[string]bool{
+	"find_entities": true, "find_entities_filtered": true, "count_entities_filtered": true,
+	"find_tiles_filtered": true, "count_tiles_filtered": true,
+}
+
+// Runtime API classes the api rules check calls of.
+const (
+	classLuaSurface = "LuaSurface"
+	classLuaForce   = "LuaForce"
+)
+
+// objectClassFields are the fields of Factorio objects that hold a checked class, e.g. player.surface.
+var objectClassFields = map[string]string{"surface": classLuaSurface, "force": classLuaForce}
+
+// objectClassDictionaries are the dictionaries of game that hold objects of a checked class.
+var objectClassDictionaries = map[string]string{"game.surfaces": classLuaSurface, "game.forces": classLuaForce}
+
+// objectClassFunctions are the functions of game that return an object of a checked class.
+var objectClassFunctions = map[string]string{
+	"game.get_surface": classLuaSurface, "game.create_surface": classLuaSurface, "game.create_force": classLuaForce,
+}
+
+// Event handler kinds the rules care about.
+const (
+	handlerOnLoad = "on_load"
+	handlerOnTick = "on_tick"
+)
+
+// LintFinding is a problem the linter found in a Lua file.
+type LintFinding struct {
+	File     string       `json:"file"`
+	Line     int          `json:"line"`
+	Column   int          `json:"column"`
+	Rule     string       `json:"rule"`
+	Severity LintSeverity `json:"severity"`
+	Message  string       `json:"message"`
+}
+
+// String formats the finding as "file:line:column: severity: message [rule]".
+func (f LintFinding) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s [%s]", f.File, f.Line, f.Column, f.Severity, f.Message, f.Rule)
+}
+
+// LintRules returns every rule of the linter.
+func LintRules() []LintRule {
+	return append([]LintRule(nil), lintRules...)
+}
+
+// SelectLintRules returns the rules named by a comma separated list of groups and rule IDs, e.g. "desync,perf"
+// or "desync-upvalue". An empty list selects no rules, leaving only the syntax check.
+func SelectLintRules(list string) ([]LintRule, error) {
+	selected := make(map[string]bool)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		known := false
+		for _, rule := range lintRules {
+			if rule.ID == item || rule.Group == item {
+				selected[rule.ID] = true
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown lint rule '%s' (groups: %s, %s, %s; rules: %s)", item, LintGroupDesync, LintGroupPerf, LintGroupAPI, strings.Join(lintRuleIDs(), ", "))
+		}
+	}
+
+	var rules []LintRule
+	for _, rule := range lintRules {
+		if selected[rule.ID] {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// lintRuleIDs returns the IDs of every rule.
+func lintRuleIDs() []string {
+	ids := make([]string, 0, len(lintRules))
+	for _, rule := range lintRules {
+		ids = append(ids, rule.ID)
+	}
+	return ids
+}
+
+// LintLuaCode parses Lua code and checks it with the given rules; the api rules check it against the runtime APIs
+// of the given Factorio versions, see FactorioAPIsFor. A syntax error is returned as the error; findings
+// suppressed by a "-- wci-lint: ignore" comment are left out. Findings are sorted by position.
+func LintLuaCode(file string, code []byte, rules []LintRule, apis []*FactorioAPI) ([]LintFinding, error) {
+	chunk, err := lua.Parse(file, string(code))
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	tokens, err := lua.Tokenize(file, string(code))
+	if err != nil {
+		return nil, err
+	}
+
+	bundled, err := BundledFactorioAPIs()
+	if err != nil {
+		return nil, err
+	}
+	linter := newLuaLinter(file, chunk, rules)
+	linter.apis, linter.bundledAPIs = apis, bundled
+	linter.walkBlock(chunk.Body)
+
+	suppressions := lintSuppressions(tokens)
+	var findings []LintFinding
+	for _, finding := range linter.findings {
+		if !suppressions.covers(finding) {
+			findings = append(findings, finding)
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Line != findings[j].Line {
+			return findings[i].Line < findings[j].Line
+		}
+		return findings[i].Column < findings[j].Column
+	})
+	return findings, nil
+}
+
+// lintScriptFile checks a script, rendered with its parameter values, and the modules of a script package.
+func lintScriptFile(script ScriptFile, rendered string, rules []LintRule, apis []*FactorioAPI) ([]LintFinding, error) {
+	findings, err := LintLuaCode(script.Path, []byte(rendered), rules, apis)
+	if err != nil {
+		return nil, err
+	}
+	for _, module := range script.Modules {
+		moduleFindings, err := LintLuaCode(path.Join(path.Dir(script.Path), module.Path), module.Code, rules, apis)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, moduleFindings...)
+	}
+	return findings, nil
+}
+
+// LintScript checks the syntax of a catalogue script and of the modules of a script package, and applies the given
+// rules. The api rules check the Factorio versions in factorio, or those the script declares with "-- @factorio"
+// if factorio is empty. Scripts with parameters are checked as rendered with their default values, required
+// parameters with the zero value of their type. Syntax errors and findings name the script's file as shown to users.
+func LintScript(script CatalogueScript, rules []LintRule, factorio VersionRange) ([]LintFinding, error) {
+	file, err := LoadScriptFile(script.source.FS, script.Path)
+	if err != nil {
+		return nil, err
+	}
+	var apis []*FactorioAPI
+	if factorio.Empty() {
+		apis, err = scriptFactorioAPIs(file)
+	} else {
+		apis, err = FactorioAPIsFor(factorio)
+	}
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]any)
+	for _, param := range file.Params {
+		if param.Required() {
+			values[param.Name] = paramZeroValues[param.Type]
+		}
+	}
+	rendered, err := RenderScriptTemplate(file.Name, string(file.Code), values)
+	if err != nil {
+		return nil, err
+	}
+
+	findings, err := lintScriptFile(file, rendered, rules, apis)
+	var syntaxErr *lua.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		syntaxErr.File = displayScriptLocation(script.source, syntaxErr.File)
+	}
+	for i := range findings {
+		findings[i].File = displayScriptLocation(script.source, findings[i].File)
+	}
+	return findings, err
+}
+
+// lintErrors returns the error message for the findings of SeverityError, or nil if there are none.
+func lintErrors(findings []LintFinding) error {
+	var messages []string
+	for _, finding := range findings {
+		if finding.Severity == SeverityError {
+			messages = append(messages, finding.String())
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s (fix the code or add '-- wci-lint: ignore <rule>' to the line)", ErrLintFailed, strings.Join(messages, "; "))
+}
+
+// lintSuppressionSet maps line numbers to the rules and groups suppressed on them; "" suppresses every rule.
+type lintSuppressionSet map[int][]string
+
+// covers reports whether a finding is suppressed.
+func (s lintSuppressionSet) covers(finding LintFinding) bool {
+	for _, name := range s[finding.Line] {
+		if name == "" || name == finding.Rule || strings.HasPrefix(finding.Rule, name+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// lintSuppressions reads the "-- wci-lint: ignore" comments of a file.
+func lintSuppressions(tokens []lua.Token) lintSuppressionSet {
+	suppressions := make(lintSuppressionSet)
+	for i, token := range tokens {
+		if token.Type != lua.TokenComment {
+			continue
+		}
+		match := lintIgnorePattern.FindStringSubmatch(token.Text)
+		if match == nil {
+			continue
+		}
+
+		// A comment after code covers its own line, a comment on a line of its own the next line
+		line := token.Pos.Line
+		if i == 0 || tokens[i-1].Pos.Line != line {
+			line += strings.Count(token.Text, "\n") + 1
+		}
+		names := strings.FieldsFunc(match[1], func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+		if len(names) == 0 {
+			names = []string{""}
+		}
+		suppressions[line] = append(suppressions[line], names...)
+	}
+	return suppressions
+}
+
+// luaLinter walks a chunk, tracking scopes, and applies the rules.
+type luaLinter struct {
+	file          string
+	rules         map[string]LintRule
+	findings      []LintFinding
+	storageBacked map[string]bool              // file-level locals that reference a table in storage
+	handlers      map[*lua.FunctionExpr]string // functions registered for the events the rules care about
+	scopes        []map[string]bool            // local names of each open scope, true if declared at file level
+	fnDepth       int
+	loopDepth     int               // loops around the current statement inside the current function
+	handler       string            // handler kind of the function being walked, inherited by nested functions
+	apis          []*FactorioAPI    // runtime APIs of the targeted Factorio versions
+	bundledAPIs   []*FactorioAPI    // every bundled runtime API, to tell removed API from unknown names
+	objectClasses map[string]string // API class of the values assigned to a name, "" if they are not all of one class
+}
+
+// newLuaLinter prepares the linter for a chunk: it finds the event handlers and the locals that alias storage.
+func newLuaLinter(file string, chunk *lua.Chunk, rules []LintRule) *luaLinter {
+	l := &luaLinter{
+		file:          file,
+		rules:         make(map[string]LintRule),
+		storageBacked: make(map[string]bool),
+		handlers:      make(map[*lua.FunctionExpr]string),
+		objectClasses: make(map[string]string),
+	}
+	for _, rule := range rules {
+		l.rules[rule.ID] = rule
+	}
+
+	// Functions defined at file level by name, so handlers registered by name are found
+	functions := make(map[string]*lua.FunctionExpr)
+	for _, stmt := range chunk.Body.Stmts {
+		switch s := stmt.(type) {
+		case *lua.LocalFunctionStmt:
+			functions[s.Name.Name] = s.Func
+		case *lua.FunctionStmt:
+			if name, ok := s.Name.(*lua.NameExpr); ok {
+				functions[name.Name] = s.Func
+			}
+		case *lua.LocalStmt:
+			for i, name := range s.Names {
+				if i < len(s.Values) {
+					if fn, ok := s.Values[i].(*lua.FunctionExpr); ok {
+						functions[name.Name] = fn
+					}
+				}
+			}
+		}
+	}
+	handlerFunction := func(arg lua.Expr) *lua.FunctionExpr {
+		switch a := arg.(type) {
+		case *lua.FunctionExpr:
+			return a
+		case *lua.NameExpr:
+			return functions[a.Name]
+		}
+		return nil
+	}
+
+	lua.Inspect(chunk.Body, func(node lua.Node) bool {
+		switch n := node.(type) {
+		case *lua.CallExpr:
+			switch name := lua.DottedName(n.Func); {
+			case name == "script.on_load" && len(n.Args) == 1:
+				if fn := handlerFunction(n.Args[0]); fn != nil {
+					l.handlers[fn] = handlerOnLoad
+				}
+			case name == "script.on_event" && len(n.Args) >= 2 && lua.DottedName(n.Args[0]) == "defines.events.on_tick":
+				if fn := handlerFunction(n.Args[1]); fn != nil {
+					l.handlers[fn] = handlerOnTick
+				}
+			}
+		case *lua.LocalStmt:
+			for i, name := range n.Names {
+				if i < len(n.Values) && storageRoots[lua.RootName(n.Values[i])] {
+					l.storageBacked[name.Name] = true
+				}
+				if i < len(n.Values) {
+					l.recordObjectClass(name.Name, n.Values[i])
+				}
+			}
+		case *lua.AssignStmt:
+			for i, target := range n.Targets {
+				if name, ok := target.(*lua.NameExpr); ok && i < len(n.Values) && storageRoots[lua.RootName(n.Values[i])] {
+					l.storageBacked[name.Name] = true
+				}
+				if name, ok := target.(*lua.NameExpr); ok && i < len(n.Values) {
+					l.recordObjectClass(name.Name, n.Values[i])
+				}
+			}
+		}
+		return true
+	})
+	return l
+}
+
+// recordObjectClass notes the API class of a value assigned to a name. A name assigned values of different
+// classes, or of none, gets no class.
+func (l *luaLinter) recordObjectClass(name string, value lua.Expr) {
+	class := l.objectClass(value)
+	if previous, ok := l.objectClasses[name]; ok && previous != class {
+		class = ""
+	}
+	l.objectClasses[name] = class
+}
+
+// objectClass guesses the API class of an expression from how the object was reached: game.surfaces[1],
+// player.force, game.get_surface("nauvis"), or a variable assigned one of those. Parameters and globals named
+// surface or force, or ending in _surface or _force, are taken for what they are named.
+func (l *luaLinter) objectClass(expr lua.Expr) string {
+	switch e := expr.(type) {
+	case *lua.ParenExpr:
+		return l.objectClass(e.Inner)
+	case *lua.IndexExpr:
+		if class, ok := objectClassDictionaries[lua.DottedName(e.Object)]; ok {
+			return class
+		}
+		if key, ok := e.Key.(*lua.StringExpr); ok && e.Dot {
+			return objectClassFields[key.Value]
+		}
+	case *lua.CallExpr:
+		return objectClassFunctions[lua.DottedName(e.Func)]
+	case *lua.NameExpr:
+		if class, ok := l.objectClasses[e.Name]; ok {
+			return class
+		}
+		for field, class := range objectClassFields {
+			if e.Name == field || strings.HasSuffix(e.Name, "_"+field) {
+				return class
+			}
+		}
+	}
+	return ""
+}
+
+// report records a finding if its rule is enabled.
+func (l *luaLinter) report(ruleID string, pos lua.Position, format string, args ...any) {
+	l.reportAs(ruleID, "", pos, format, args...)
+}
+
+// reportAs records a finding of the given severity, or of the rule's if severity is empty, if its rule is enabled.
+func (l *luaLinter) reportAs(ruleID string, severity LintSeverity, pos lua.Position, format string, args ...any) {
+	rule, ok := l.rules[ruleID]
+	if !ok {
+		return
+	}
+	if severity == "" {
+		severity = rule.Severity
+	}
+	l.findings = append(l.findings, LintFinding{
+		File:     l.file,
+		Line:     pos.Line,
+		Column:   pos.Column,
+		Rule:     rule.ID,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// declare adds a local to the innermost scope.
+func (l *luaLinter) declare(name string) {
+	l.scopes[len(l.scopes)-1][name] = l.fnDepth == 0
+}
+
+// isLocal reports whether a name refers to a local variable.
+func (l *luaLinter) isLocal(name string) bool {
+	for i := len(l.scopes) - 1; i >= 0; i-- {
+		if _, ok := l.scopes[i][name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isFileLocal reports whether a name refers to a local declared outside of every function.
+func (l *luaLinter) isFileLocal(name string) bool {
+	for i := len(l.scopes) - 1; i >= 0; i-- {
+		if fileLevel, ok := l.scopes[i][name]; ok {
+			return fileLevel
+		}
+	}
+	return false
+}
+
+// isStorage reports whether an expression indexes into the tables Factorio saves.
+func (l *luaLinter) isStorage(expr lua.Expr) bool {
+	root := lua.RootName(expr)
+	return storageRoots[root] && !l.isLocal(root)
+}
+
+// walkBlock walks the statements of a block in a scope of its own.
+func (l *luaLinter) walkBlock(block *lua.Block) {
+	l.scopes = append(l.scopes, make(map[string]bool))
+	for _, stmt := range block.Stmts {
+		l.walkStmt(stmt)
+	}
+	l.scopes = l.scopes[:len(l.scopes)-1]
+}
+
+// walkLoop walks the body of a loop; declare adds the loop variables to its scope.
+func (l *luaLinter) walkLoop(declare func(), body func()) {
+	l.scopes = append(l.scopes, make(map[string]bool))
+	l.loopDepth++
+	declare()
+	body()
+	l.loopDepth--
+	l.scopes = l.scopes[:len(l.scopes)-1]
+}
+
+// walkStmt applies the rules to a statement and walks its children.
+func (l *luaLinter) walkStmt(stmt lua.Stmt) {
+	switch s := stmt.(type) {
+	case *lua.LocalStmt:
+		l.walkExprs(s.Values)
+		for _, name := range s.Names {
+			l.declare(name.Name)
+		}
+	case *lua.LocalFunctionStmt:
+		l.declare(s.Name.Name)
+		l.walkFunction(s.Func, false)
+	case *lua.FunctionStmt:
+		l.checkAssign(s.Name, s.Func)
+		l.walkFunction(s.Func, s.Method)
+	case *lua.AssignStmt:
+		l.walkExprs(s.Values)
+		for i, target := range s.Targets {
+			var value lua.Expr
+			if i < len(s.Values) {
+				value = s.Values[i]
+			}
+			l.checkAssign(target, value)
+			l.checkConcat(target, value)
+			if index, ok := target.(*lua.IndexExpr); ok {
+				l.walkExpr(index.Object)
+				l.walkExpr(index.Key)
+			}
+		}
+	case *lua.CallStmt:
+		l.walkExpr(s.Call)
+	case *lua.DoStmt:
+		l.walkBlock(s.Body)
+	case *lua.WhileStmt:
+		l.walkExpr(s.Cond)
+		l.walkLoop(func() {}, func() { l.walkBlock(s.Body) })
+	case *lua.RepeatStmt:
+		// The condition sees the locals of the body
+		l.walkLoop(func() {}, func() {
+			for _, stmt := range s.Body.Stmts {
+				l.walkStmt(stmt)
+			}
+			l.walkExpr(s.Cond)
+		})
+	case *lua.IfStmt:
+		for _, clause := range s.Clauses {
+			l.walkExpr(clause.Cond)
+			l.walkBlock(clause.Body)
+		}
+		if s.Else != nil {
+			l.walkBlock(s.Else)
+		}
+	case *lua.NumericForStmt:
+		l.walkExprs([]lua.Expr{s.Start, s.Limit})
+		if s.Step != nil {
+			l.walkExpr(s.Step)
+		}
+		l.walkLoop(func() { l.declare(s.Var.Name) }, func() { l.walkBlock(s.Body) })
+	case *lua.GenericForStmt:
+		l.walkExprs(s.Values)
+		l.checkPairs(s)
+		l.walkLoop(func() {
+			for _, name := range s.Names {
+				l.declare(name.Name)
+			}
+		}, func() { l.walkBlock(s.Body) })
+	case *lua.ReturnStmt:
+		l.walkExprs(s.Values)
+	}
+}
+
+// walkExprs walks a list of expressions.
+func (l *luaLinter) walkExprs(exprs []lua.Expr) {
+	for _, expr := range exprs {
+		l.walkExpr(expr)
+	}
+}
+
+// walkExpr applies the rules to an expression and walks its children.
+func (l *luaLinter) walkExpr(expr lua.Expr) {
+	switch e := expr.(type) {
+	case *lua.NameExpr:
+		if l.handler == handlerOnLoad && e.Name == "game" && !l.isLocal(e.Name) {
+			l.report("desync-on-load", e.Pos(), "on_load uses 'game'; only the peer loading the save runs on_load, "+
+				"so it must not touch the game, use on_init or on_configuration_changed")
+		}
+	case *lua.IndexExpr:
+		l.checkEvent(e)
+		l.walkExpr(e.Object)
+		l.walkExpr(e.Key)
+	case *lua.CallExpr:
+		l.checkTableMutation(e)
+		if index, ok := e.Func.(*lua.IndexExpr); ok && index.Dot {
+			method := index.Key.(*lua.StringExpr)
+			l.checkMapSearch(method.Value, method.Pos())
+			l.checkAPICall(index.Object, method.Value, method.Pos(), e.Args)
+		}
+		l.walkExpr(e.Func)
+		l.walkExprs(e.Args)
+	case *lua.MethodCallExpr:
+		l.checkMapSearch(e.Method, e.MethodPos)
+		l.checkAPICall(e.Object, e.Method, e.MethodPos, e.Args)
+		l.walkExpr(e.Object)
+		l.walkExprs(e.Args)
+	case *lua.FunctionExpr:
+		l.walkFunction(e, false)
+	case *lua.TableExpr:
+		for _, field := range e.Fields {
+			if field.Key != nil {
+				l.walkExpr(field.Key)
+			}
+			l.walkExpr(field.Value)
+		}
+	case *lua.BinaryExpr:
+		l.walkExpr(e.Left)
+		l.walkExpr(e.Right)
+	case *lua.UnaryExpr:
+		l.walkExpr(e.Operand)
+	case *lua.ParenExpr:
+		l.walkExpr(e.Inner)
+	}
+}
+
+// walkFunction walks a function body; method functions take the implicit parameter self.
+func (l *luaLinter) walkFunction(fn *lua.FunctionExpr, method bool) {
+	handler, loopDepth := l.handler, l.loopDepth
+	if kind, ok := l.handlers[fn]; ok {
+		l.handler = kind
+	}
+	l.fnDepth++
+	l.loopDepth = 0
+	l.scopes = append(l.scopes, make(map[string]bool))
+	if method {
+		l.declare("self")
+	}
+	for _, param := range fn.Params {
+		l.declare(param.Name)
+	}
+	l.walkBlock(fn.Body)
+	l.scopes = l.scopes[:len(l.scopes)-1]
+	l.fnDepth--
+	l.handler, l.loopDepth = handler, loopDepth
+}
+
+// checkAssign reports assignments inside functions to file-level state and, in on_load, to storage.
+func (l *luaLinter) checkAssign(target, value lua.Expr) {
+	if l.fnDepth == 0 {
+		return
+	}
+	root := lua.RootName(target)
+	if l.isStorage(target) {
+		if l.handler == handlerOnLoad {
+			l.report("desync-on-load", target.Pos(), "on_load changes '%s'; storage may only be read in on_load, "+
+				"change it in on_init or on_configuration_changed", root)
+		}
+		return
+	}
+	// on_load exists to restore file-level references, and pointing a local at storage is how that is done
+	if !l.isFileLocal(root) || l.handler == handlerOnLoad {
+		return
+	}
+
+	if _, isName := target.(*lua.NameExpr); isName {
+		if value != nil && l.isStorage(value) {
+			return
+		}
+		l.report("desync-upvalue", target.Pos(), "file-level local '%s' is changed in a function; the value is not "+
+			"saved with the map and desyncs multiplayer games, keep it in storage", root)
+		return
+	}
+	if !l.storageBacked[root] {
+		l.report("desync-upvalue", target.Pos(), "the table in file-level local '%s' is changed in a function; "+
+			"it is not saved with the map and desyncs multiplayer games, keep it in storage", root)
+	}
+}
+
+// checkTableMutation reports table.insert, table.remove and table.sort on file-level tables inside functions.
+func (l *luaLinter) checkTableMutation(call *lua.CallExpr) {
+	name := lua.DottedName(call.Func)
+	if !tableMutators[name] || l.isLocal("table") || len(call.Args) == 0 || l.fnDepth == 0 || l.handler == handlerOnLoad {
+		return
+	}
+	root := lua.RootName(call.Args[0])
+	if l.isFileLocal(root) && !l.storageBacked[root] {
+		l.report("desync-upvalue", call.Pos(), "'%s' changes the table in file-level local '%s' in a function; it is "+
+			"not saved with the map and desyncs multiplayer games, keep it in storage", name, root)
+	}
+}
+
+// checkMapSearch reports calls of map searches in on_tick handlers. Factorio objects take their methods with "."
+// as well as ":", so both call forms end up here.
+func (l *luaLinter) checkMapSearch(method string, pos lua.Position) {
+	if l.handler == handlerOnTick && mapSearchMethods[method] {
+		l.report("perf-tick-search", pos, "'%s' searches the map on every tick; run it less often with "+
+			"script.on_nth_tick or keep the result in storage", method)
+	}
+}
+
+// checkPairs reports pairs loops inside functions over file-level tables that are not kept in storage.
+func (l *luaLinter) checkPairs(loop *lua.GenericForStmt) {
+	if l.fnDepth == 0 || len(loop.Values) == 0 {
+		return
+	}
+	call, ok := loop.Values[0].(*lua.CallExpr)
+	if !ok || lua.DottedName(call.Func) != "pairs" || l.isLocal("pairs") || len(call.Args) == 0 {
+		return
+	}
+	root := lua.RootName(call.Args[0])
+	if l.isFileLocal(root) && !l.storageBacked[root] {
+		l.report("desync-pairs", call.Pos(), "'pairs' iterates the table in file-level local '%s', whose order "+
+			"depends on how each peer filled it; keep it in storage or iterate an array with ipairs", root)
+	}
+}
+
+// checkConcat reports "s = s .. x" inside loops.
+func (l *luaLinter) checkConcat(target, value lua.Expr) {
+	name, ok := target.(*lua.NameExpr)
+	concat, isConcat := value.(*lua.BinaryExpr)
+	if l.loopDepth == 0 || !ok || !isConcat || concat.Op != ".." {
+		return
+	}
+	if left, ok := concat.Left.(*lua.NameExpr); ok && left.Name == name.Name {
+		l.report("perf-concat-loop", target.Pos(), "'%s' is built with '..' in a loop, which copies the whole "+
+			"string on every iteration; collect the parts in a table and join them with table.concat", name.Name)
+	}
+}
+
+// checkEvent reports defines.events names the targeted Factorio versions do not have.
+func (l *luaLinter) checkEvent(index *lua.IndexExpr) {
+	name, found := strings.CutPrefix(lua.DottedName(index), "defines.events.")
+	if !found || strings.Contains(name, ".") || l.isLocal("defines") {
+		return
+	}
+	l.checkAPI("api-event", index.Key.Pos(), fmt.Sprintf("event 'defines.events.%s'", name), func(api *FactorioAPI) (bool, bool) {
+		return api.Events[name], true
+	})
+}
+
+// checkAPICall reports calls of LuaSurface and LuaForce methods and filter keys the targeted Factorio versions do
+// not have. Receivers of unknown class are only checked for the filter keys of the map searches.
+func (l *luaLinter) checkAPICall(receiver lua.Expr, method string, pos lua.Position, args []lua.Expr) {
+	class := l.objectClass(receiver)
+	if class == "" && mapSearchMethods[method] {
+		class = classLuaSurface
+	}
+	if class == "" {
+		return
+	}
+	l.checkAPI("api-method", pos, fmt.Sprintf("method '%s.%s'", class, method), func(api *FactorioAPI) (bool, bool) {
+		_, hasClass := api.Classes[class]
+		return api.HasMethod(class, method), hasClass
+	})
+
+	if len(args) != 1 {
+		return
+	}
+	filter, ok := args[0].(*lua.TableExpr)
+	if !ok {
+		return
+	}
+	for _, field := range filter.Fields {
+		key, ok := field.Key.(*lua.StringExpr)
+		if !ok || !field.NameKey {
+			continue
+		}
+		l.checkAPI("api-filter", key.Pos(), fmt.Sprintf("key '%s' of '%s.%s'", key.Value, class, method), func(api *FactorioAPI) (bool, bool) {
+			apiMethod := api.Method(class, method)
+			if apiMethod == nil || !apiMethod.TakesTable {
+				return false, false
+			}
+			return apiMethod.TableKeys[key.Value], true
+		})
+	}
+}
+
+// checkAPI reports a name the targeted runtime APIs lack. has tells whether an API has the name, and whether the
+// API covers it at all, e.g. a filter key is only looked for in versions that have the method. A name that a
+// bundled version has is an error of the rule; one no bundled version has is only a warning, as the bundled APIs
+// are trimmed and may lack it. The warning says so, as the check is partial.
+func (l *luaLinter) checkAPI(ruleID string, pos lua.Position, what string, has func(api *FactorioAPI) (found, applies bool)) {
+	var missing, present []*FactorioAPI
+	for _, api := range l.apis {
+		if found, applies := has(api); applies && !found {
+			missing = append(missing, api)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	for _, api := range l.bundledAPIs {
+		if found, _ := has(api); found {
+			present = append(present, api)
+		}
+	}
+
+	if len(present) > 0 {
+		l.report(ruleID, pos, "%s does not exist in Factorio %s, only in %s", what, factorioVersions(missing), factorioVersions(present))
+		return
+	}
+	l.reportAs(ruleID, SeverityWarning, pos, "unknown %s, it is not in the Factorio %s API bundled with wci, "+
+		"which is a partial subset of runtime-api.json; check the name in the official API documentation", what, factorioVersions(missing))
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"fmt"
+
+	"wci/lua"
+)
+
+// CheckLuaSyntax parses Lua code with the grammar of Factorio's Lua 5.2, "!=" included, and returns the first
+// syntax error, a *lua.SyntaxError naming file, line and column. Errors the Lua compiler reports beyond the
+// grammar, such as a goto into the scope of a local, are syntax errors too, see lua.Parse.
+func CheckLuaSyntax(file string, code []byte) error {
+	_, err := lua.Parse(file, string(code))
+	return err
+}
+
+// checkChangedLuaSyntax parses every Lua file a change set writes, so that no command leaves a savegame behind
+// whose Lua code Factorio refuses to load. A file whose original content did not parse either is not the
+// change's fault and is let through.
+func checkChangedLuaSyntax(original map[string][]byte, changes ZipChanges) error {
+	for _, name := range SortedFileNames(changes.Modified) {
+		if !IsLuaFile(name) {
+			continue
+		}
+		err := CheckLuaSyntax(name, changes.Modified[name])
+		if err == nil {
+			continue
+		}
+		if content, exists := original[name]; exists && CheckLuaSyntax(name, content) != nil {
+			continue
+		}
+		return fmt.Errorf("the resulting Lua code does not parse: %w", err)
+	}
+	return nil
+}
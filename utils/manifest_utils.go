@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"wci/config"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ManifestFileName is the name of the entry that records the modifications of a savegame.
+const ManifestFileName = "wci-manifest.json"
+
+// Manifest actions recorded in the history of a savegame.
+const (
+	ManifestActionInject  = "inject"
+	ManifestActionRemove  = "remove"
+	ManifestActionUpgrade = "upgrade"
+	ManifestActionPatch   = "patch"
+)
+
+// manifestNow returns the time recorded in the manifest. Tests replace it for stable output.
+var manifestNow = func() time.Time { return time.Now().UTC().Truncate(time.Second) }
+
+// Manifest describes the scripts injected into a savegame and the modifications that put them there.
+type Manifest struct {
+	WCIVersion string           `json:"wci_version"` // version of wci that wrote the manifest last
+	UpdatedAt  time.Time        `json:"updated_at"`
+	Scripts    []ManifestScript `json:"scripts"`
+	History    []ManifestEvent  `json:"history"`
+}
+
+// ManifestScript records one injected script.
+type ManifestScript struct {
+	Name       string         `json:"name"`
+	Version    string         `json:"version"`
+	Hash       string         `json:"sha256"`            // hash of the block body, as in the BEGIN marker
+	Strategy   string         `json:"strategy"`          // injection strategy
+	Target     string         `json:"target"`            // file that runs the script: the block itself or its loader
+	Module     string         `json:"module,omitempty"`  // file holding the script for the require and event_handler strategies
+	Params     map[string]any `json:"params,omitempty"`  // parameter values the script was rendered with
+	Modules    []string       `json:"modules,omitempty"` // files holding the further modules of a script package
+	InjectedAt time.Time      `json:"injected_at"`
+	WCIVersion string         `json:"wci_version"` // version of wci that injected or last upgraded the script
+}
+
+// ManifestEvent records one modification of the savegame.
+type ManifestEvent struct {
+	Action     string    `json:"action"`
+	Scripts    []string  `json:"scripts,omitempty"`
+	Files      []string  `json:"files,omitempty"`
+	Profile    string    `json:"profile,omitempty"` // profile the scripts were injected with
+	Timestamp  time.Time `json:"timestamp"`
+	WCIVersion string    `json:"wci_version"`
+}
+
+// ProfileEvents returns the modifications that applied a profile, oldest first.
+func (m *Manifest) ProfileEvents() []ManifestEvent {
+	var events []ManifestEvent
+	for _, event := range m.History {
+		if event.Profile != "" {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// ManifestPath returns where the manifest lives in an archive with the given entries: next to the save's
+// top-level folder when all entries share one, at the root otherwise.
+func ManifestPath(names []string) string {
+	root := ""
+	for i, name := range names {
+		first, _, nested := strings.Cut(name, "/")
+		if !nested || (i > 0 && first != root) {
+			return ManifestFileName
+		}
+		root = first
+	}
+	if root == "" {
+		return ManifestFileName
+	}
+	return path.Join(root, ManifestFileName)
+}
+
+// ReadManifest reads the manifest of a savegame ZIP file. A save without manifest returns nil and no error.
+func ReadManifest(zipPath string) (*Manifest, string, error) {
+	names, err := ListFilesInZip(zipPath)
+	if err != nil {
+		return nil, "", err
+	}
+	manifestPath := ManifestPath(names)
+
+	files, err := ReadFilesFromZip(zipPath, func(name string) bool { return name == manifestPath })
+	if err != nil {
+		return nil, manifestPath, err
+	}
+	data, exists := files[manifestPath]
+	if !exists {
+		return nil, manifestPath, nil
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, manifestPath, fmt.Errorf("failed to parse '%s': %w", manifestPath, err)
+	}
+	return &manifest, manifestPath, nil
+}
+
+// CollectManifestScripts lists the scripts injected into the given Lua files, as they would appear in a manifest.
+// Injection times and wci versions are taken from previous entries of the same script version.
+func CollectManifestScripts(luaFiles map[string][]byte, previous []ManifestScript) ([]ManifestScript, error) {
+	byName := make(map[string]ManifestScript, len(previous))
+	for _, script := range previous {
+		byName[script.Name] = script
+	}
+
+	scripts := make(map[string]*ManifestScript)
+	for _, fileName := range SortedFileNames(luaFiles) {
+		blocks, err := ParseInjectionBlocks(string(luaFiles[fileName]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse injection markers in '%s': %w", fileName, err)
+		}
+		for _, block := range blocks {
+			location := InjectedScriptLocation{File: fileName, Block: block}
+			script, seen := scripts[block.Name]
+			if !seen {
+				script = &ManifestScript{Name: block.Name}
+				scripts[block.Name] = script
+			}
+			if location.IsPart() {
+				script.Modules = append(script.Modules, fileName)
+				continue
+			}
+			if script.Strategy == "" {
+				script.Strategy = string(location.Strategy())
+			}
+			if location.IsLoader() {
+				script.Target = fileName
+				continue
+			}
+			script.Version = block.Version
+			script.Hash = block.Hash
+			if script.Params, err = ParseParamsAttribute(block.Attributes[attributeParams]); err != nil {
+				return nil, fmt.Errorf("script '%s' in '%s': %w", block.Name, fileName, err)
+			}
+			if location.Strategy() == StrategyAppend {
+				script.Target = fileName
+			} else {
+				script.Module = fileName
+			}
+		}
+	}
+
+	collected := make([]ManifestScript, 0, len(scripts))
+	for _, script := range scripts {
+		if old, known := byName[script.Name]; known && old.Version == script.Version && old.Hash == script.Hash {
+			script.InjectedAt = old.InjectedAt
+			script.WCIVersion = old.WCIVersion
+		} else {
+			script.InjectedAt = manifestNow()
+			script.WCIVersion = config.VersionNumber
+		}
+		collected = append(collected, *script)
+	}
+	sort.Slice(collected, func(i, j int) bool { return collected[i].Name < collected[j].Name })
+	return collected, nil
+}
+
+// recordManifest adds the updated manifest of a savegame to changes. The scripts are taken from the Lua files as
+// they will be once changes are written, and the modification is appended to the history.
+func recordManifest(zipPath string, changes *ZipChanges, event ManifestEvent) error {
+	manifest, manifestPath, err := ReadManifest(zipPath)
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		manifest = &Manifest{}
+	}
+
+	luaFiles, err := ReadFilesFromZip(zipPath, IsLuaFile)
+	if err != nil {
+		return fmt.Errorf("failed to read Lua files from '%s': %w", zipPath, err)
+	}
+	changes.ApplyTo(luaFiles)
+	for name := range changes.Modified {
+		if !IsLuaFile(name) {
+			delete(luaFiles, name)
+		}
+	}
+
+	scripts, err := CollectManifestScripts(luaFiles, manifest.Scripts)
+	if err != nil {
+		return err
+	}
+
+	now := manifestNow()
+	event.Timestamp = now
+	event.WCIVersion = config.VersionNumber
+	manifest.WCIVersion = config.VersionNumber
+	manifest.UpdatedAt = now
+	manifest.Scripts = scripts
+	manifest.History = append(manifest.History, event)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	changes.Modified[manifestPath] = append(data, '\n')
+
+	log.Debug().
+		Str("zipPath", zipPath).
+		Str("manifest", manifestPath).
+		Str("action", event.Action).
+		Int("scriptCount", len(scripts)).
+		Msg("Recorded modification in manifest")
+	return nil
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile is a named bundle of scripts that is injected with one command, see Config.
+type Profile struct {
+	Description string          `yaml:"description" json:"description,omitempty"`
+	Strategy    string          `yaml:"strategy" json:"strategy,omitempty"`       // injection strategy, append when empty
+	OnConflict  string          `yaml:"on_conflict" json:"on_conflict,omitempty"` // event conflict mode, refuse when empty
+	Scripts     []ProfileScript `yaml:"scripts" json:"scripts"`
+}
+
+// ProfileScript is a script of a profile together with its parameter values.
+type ProfileScript struct {
+	Name   string         `yaml:"name" json:"name"`
+	Params map[string]any `yaml:"params" json:"params,omitempty"`
+}
+
+// UnmarshalYAML accepts a bare script name as well as a mapping with name and params.
+func (s *ProfileScript) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		s.Name = node.Value
+		return nil
+	}
+	type plain ProfileScript
+	return node.Decode((*plain)(s))
+}
+
+// validate checks the scripts, strategy and conflict mode of a profile.
+func (p Profile) validate() error {
+	if len(p.Scripts) == 0 {
+		return fmt.Errorf("no scripts listed")
+	}
+	if _, err := ParseInjectionStrategy(p.Strategy); err != nil {
+		return err
+	}
+	if _, err := ParseEventConflictMode(p.OnConflict); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(p.Scripts))
+	for _, script := range p.Scripts {
+		if script.Name == "" {
+			return fmt.Errorf("a script has no name")
+		}
+		if seen[script.Name] {
+			return fmt.Errorf("script '%s' is listed more than once", script.Name)
+		}
+		seen[script.Name] = true
+		for key, value := range script.Params {
+			if _, nested := value.(map[string]any); nested {
+				return fmt.Errorf("script '%s': parameter '%s' is nested too deeply", script.Name, key)
+			}
+		}
+	}
+	return nil
+}
+
+// ScriptNames returns the names of the profile's scripts in the order they are listed.
+func (p Profile) ScriptNames() []string {
+	names := make([]string, 0, len(p.Scripts))
+	for _, script := range p.Scripts {
+		names = append(names, script.Name)
+	}
+	return names
+}
+
+// ParamValues returns the parameter values of the profile qualified with their script names, overridden by the
+// given values. An unqualified override replaces the value of every script of the profile.
+func (p Profile) ParamValues(overrides map[string]any) map[string]any {
+	values := make(map[string]any)
+	for _, script := range p.Scripts {
+		for key, value := range script.Params {
+			values[script.Name+"."+key] = value
+		}
+	}
+	for key, value := range overrides {
+		if !strings.Contains(key, ".") {
+			for _, script := range p.Scripts {
+				delete(values, script.Name+"."+key)
+			}
+		}
+		values[key] = value
+	}
+	return values
+}
@@ -41,3 +41,16 @@ func GetSaveGameLocation(osName string) (string, error) {
 	log.Info().Str("directory", saveGameDir).Msg("Savegame directory found")
 	return saveGameDir, nil
 }
+
+// resolveSaveGamePath returns the full path of a savegame ZIP file inside the default savegame location.
+func resolveSaveGamePath(osName, saveGameZipName string) (string, error) {
+	baseDir, err := GetSaveGameLocation(osName)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("osName", osName).
+			Msg("Failed to retrieve savegame directory")
+		return "", fmt.Errorf("failed to retrieve savegame directory for OS '%s': %w", osName, err)
+	}
+	return filepath.Join(baseDir, saveGameZipName), nil
+}
@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ScriptState is the result of cross-checking a script of the manifest with the savegame contents.
+type ScriptState string
+
+const (
+	// ScriptStateOK means the block matches the manifest and its own hash.
+	ScriptStateOK ScriptState = "ok"
+	// ScriptStateModified means the block was edited by hand after injection.
+	ScriptStateModified ScriptState = "modified"
+	// ScriptStateMismatch means the block has another version or hash than the manifest records.
+	ScriptStateMismatch ScriptState = "mismatch"
+	// ScriptStateMissing means the manifest records a script that is not in the savegame.
+	ScriptStateMissing ScriptState = "missing"
+	// ScriptStateUnrecorded means the savegame contains a script the manifest does not know.
+	ScriptStateUnrecorded ScriptState = "unrecorded"
+)
+
+// ScriptStatus describes one script found in the manifest, the savegame or both.
+type ScriptStatus struct {
+	Name     string
+	Version  string // version found in the savegame, or recorded in the manifest if the script is missing
+	Strategy string
+	Target   string
+	State    ScriptState
+	Detail   string
+}
+
+// SaveStatus is the outcome of CheckSaveStatus.
+type SaveStatus struct {
+	Manifest     *Manifest // nil if the savegame has no manifest
+	ManifestPath string
+	Scripts      []ScriptStatus
+}
+
+// Consistent reports whether every script is in the state the manifest records.
+func (s SaveStatus) Consistent() bool {
+	for _, script := range s.Scripts {
+		if script.State != ScriptStateOK {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckSaveStatus reads the manifest of a savegame ZIP file and cross-checks it against the injected blocks of
+// its Lua files. Blocks are also checked against their own hash, so hand edits show up even without a manifest.
+func CheckSaveStatus(osName, saveGameZipName string) (SaveStatus, error) {
+	var status SaveStatus
+	saveGameZipPath, err := resolveSaveGamePath(osName, saveGameZipName)
+	if err != nil {
+		return status, err
+	}
+
+	log.Info().
+		Str("zipPath", saveGameZipPath).
+		Msg("Checking savegame status")
+
+	manifest, manifestPath, err := ReadManifest(saveGameZipPath)
+	if err != nil {
+		return status, fmt.Errorf("failed to read the manifest of '%s': %w", saveGameZipPath, err)
+	}
+	status.Manifest = manifest
+	status.ManifestPath = manifestPath
+
+	luaFiles, err := ReadFilesFromZip(saveGameZipPath, IsLuaFile)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("zipPath", saveGameZipPath).
+			Msg("Failed to read Lua files from ZIP")
+		return status, fmt.Errorf("failed to read Lua files from '%s': %w", saveGameZipPath, err)
+	}
+
+	actual, err := CollectManifestScripts(luaFiles, nil)
+	if err != nil {
+		return status, err
+	}
+	recorded := make(map[string]ManifestScript)
+	if manifest != nil {
+		for _, script := range manifest.Scripts {
+			recorded[script.Name] = script
+		}
+	}
+
+	for _, script := range actual {
+		scriptStatus := ScriptStatus{
+			Name:     script.Name,
+			Version:  script.Version,
+			Strategy: script.Strategy,
+			Target:   script.Target,
+			State:    ScriptStateOK,
+		}
+
+		locations, err := FindInjectedScript(luaFiles, script.Name)
+		if err != nil {
+			return status, err
+		}
+		expected, known := recorded[script.Name]
+		delete(recorded, script.Name)
+
+		switch {
+		case anyBlockModified(locations):
+			scriptStatus.State = ScriptStateModified
+			scriptStatus.Detail = "block content does not match its hash"
+		case !known:
+			scriptStatus.State = ScriptStateUnrecorded
+			scriptStatus.Detail = "not in the manifest"
+		case expected.Version != script.Version:
+			scriptStatus.State = ScriptStateMismatch
+			scriptStatus.Detail = fmt.Sprintf("manifest records version %s", expected.Version)
+		case expected.Hash != script.Hash:
+			scriptStatus.State = ScriptStateMismatch
+			scriptStatus.Detail = "manifest records another hash"
+		case expected.Target != script.Target || expected.Module != script.Module:
+			scriptStatus.State = ScriptStateMismatch
+			scriptStatus.Detail = fmt.Sprintf("manifest records target %s", expected.Target)
+		}
+		status.Scripts = append(status.Scripts, scriptStatus)
+	}
+
+	for _, script := range recorded {
+		status.Scripts = append(status.Scripts, ScriptStatus{
+			Name:     script.Name,
+			Version:  script.Version,
+			Strategy: script.Strategy,
+			Target:   script.Target,
+			State:    ScriptStateMissing,
+			Detail:   "recorded in the manifest but not found in the savegame",
+		})
+	}
+	sort.Slice(status.Scripts, func(i, j int) bool { return status.Scripts[i].Name < status.Scripts[j].Name })
+
+	log.Info().
+		Str("zipPath", saveGameZipPath).
+		Bool("hasManifest", manifest != nil).
+		Int("scriptCount", len(status.Scripts)).
+		Msg("Savegame status checked")
+	return status, nil
+}
+
+// anyBlockModified reports whether one of the blocks of a script no longer matches its hash.
+func anyBlockModified(locations []InjectedScriptLocation) bool {
+	for _, location := range locations {
+		if location.Block.Modified() {
+			return true
+		}
+	}
+	return false
+}
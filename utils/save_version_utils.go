@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrSaveVersionUnknown is returned when a savegame holds no level data to read the Factorio version from.
+var ErrSaveVersionUnknown = errors.New("the savegame does not tell the Factorio version that wrote it")
+
+// saveLevelFiles are the files of a savegame that start with the version of the game that wrote them, in order of
+// preference: the map as saved, split into zlib compressed parts since 0.17; the map of saves before that; the map
+// as it was when the game started, which only tells the version that created the map.
+var saveLevelFiles = []string{"level.dat0", "level.dat", "level-init.dat"}
+
+// ReadSaveGameVersion returns the version of Factorio that wrote a savegame ZIP file, e.g. "2.0.28". The level data
+// starts with the version as four little-endian uint16 values, major, minor, patch and build; the build is left out.
+func ReadSaveGameVersion(zipPath string) (string, error) {
+	zipReader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("zipPath", zipPath).
+			Msg("Failed to open ZIP file")
+		return "", fmt.Errorf("failed to open ZIP file: %w", err)
+	}
+	defer zipReader.Close()
+
+	for _, levelFile := range saveLevelFiles {
+		for _, file := range zipReader.File {
+			// Only the level data at the top of the save counts, not files of the same name in a scenario
+			if path.Base(file.Name) != levelFile || strings.Count(strings.Trim(file.Name, "/"), "/") > 1 {
+				continue
+			}
+			version, err := readLevelVersion(file)
+			if err != nil {
+				return "", fmt.Errorf("failed to read the version from '%s': %w", file.Name, err)
+			}
+			log.Debug().
+				Str("zipPath", zipPath).
+				Str("file", file.Name).
+				Str("version", version).
+				Msg("Read the Factorio version of the savegame")
+			return version, nil
+		}
+	}
+	return "", ErrSaveVersionUnknown
+}
+
+// readLevelVersion reads the version at the start of a level file, which may be zlib compressed.
+func readLevelVersion(file *zip.File) (string, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return "", err
+	}
+	// A zlib stream starts with 0x78 and a second byte that makes the first two a multiple of 31
+	if header[0] == 0x78 && binary.BigEndian.Uint16(header[:2])%31 == 0 {
+		compressed, err := zlib.NewReader(io.MultiReader(bytes.NewReader(header), reader))
+		if err != nil {
+			return "", err
+		}
+		defer compressed.Close()
+		if _, err := io.ReadFull(compressed, header); err != nil {
+			return "", err
+		}
+	}
+
+	major := binary.LittleEndian.Uint16(header[0:2])
+	minor := binary.LittleEndian.Uint16(header[2:4])
+	patch := binary.LittleEndian.Uint16(header[4:6])
+	return fmt.Sprintf("%d.%d.%d", major, minor, patch), nil
+}
@@ -0,0 +1,347 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ScriptPathEnv lists additional script directories, separated like PATH.
+const ScriptPathEnv = "WCI_SCRIPT_PATH"
+
+// Kinds of script sources, from the highest precedence to the lowest.
+const (
+	SourceFile     = "file"     // a single file given with --file
+	SourceFlag     = "flag"     // a directory given with --script-dir
+	SourceEnv      = "env"      // a directory listed in WCI_SCRIPT_PATH
+	SourceUser     = "user"     // the user's script directory, ~/.config/wci/scripts
+	SourceEmbedded = "embedded" // the scripts compiled into wci
+)
+
+// ScriptSource is a directory of scripts that contributes to a catalogue.
+type ScriptSource struct {
+	Kind     string
+	Location string // directory or file shown to users
+	FS       fs.FS
+	Dir      string // directory of the scripts inside FS
+	Only     string // when set, the only file of Dir that belongs to the source
+}
+
+// CatalogueScript is a script of a catalogue together with the source it comes from.
+type CatalogueScript struct {
+	ScriptMetadata
+	Source   string `json:"source"`
+	Location string `json:"location"`
+	source   *ScriptSource
+}
+
+// ShadowedScript is a script hidden by a script of the same name from a source with higher precedence.
+type ShadowedScript struct {
+	Name       string `json:"name"`
+	Source     string `json:"source"`
+	Location   string `json:"location"`
+	ShadowedBy string `json:"shadowed_by"` // location of the script that is used instead
+}
+
+// ScriptCatalogue combines several script sources. For every name the script of the first source wins.
+type ScriptCatalogue struct {
+	Scripts  []CatalogueScript `json:"scripts"`
+	Shadowed []ShadowedScript  `json:"shadowed"`
+}
+
+// UserConfigDir returns the directory of the wci configuration: $XDG_CONFIG_HOME/wci, or ~/.config/wci.
+func UserConfigDir() (string, error) {
+	if configHome := os.Getenv("XDG_CONFIG_HOME"); configHome != "" {
+		return filepath.Join(configHome, "wci"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the home directory: %w", err)
+	}
+	return filepath.Join(home, ".config", "wci"), nil
+}
+
+// UserScriptDir returns the user's script directory, where script packs are installed as well.
+func UserScriptDir() (string, error) {
+	configDir, err := UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "scripts"), nil
+}
+
+// DirScriptSource returns the source for a directory on disk.
+func DirScriptSource(kind, dir string) ScriptSource {
+	return ScriptSource{Kind: kind, Location: dir, FS: os.DirFS(dir), Dir: "."}
+}
+
+// FileScriptSource returns the source for a single Lua file, or a script package directory, on disk.
+func FileScriptSource(file string) (ScriptSource, error) {
+	info, err := os.Stat(file)
+	if err == nil && info.IsDir() {
+		if _, err := os.Stat(filepath.Join(file, PackageEntryFile)); err != nil {
+			return ScriptSource{}, fmt.Errorf("'%s' is a directory without %s", file, PackageEntryFile)
+		}
+		return ScriptSource{
+			Kind:     SourceFile,
+			Location: file,
+			FS:       os.DirFS(filepath.Dir(filepath.Clean(file))),
+			Dir:      ".",
+			Only:     path.Join(filepath.Base(filepath.Clean(file)), PackageEntryFile),
+		}, nil
+	}
+	if !IsLuaFile(file) {
+		return ScriptSource{}, fmt.Errorf("'%s' is not a Lua file", file)
+	}
+	if err != nil {
+		return ScriptSource{}, fmt.Errorf("failed to read script file: %w", err)
+	}
+	return ScriptSource{
+		Kind:     SourceFile,
+		Location: file,
+		FS:       os.DirFS(filepath.Dir(file)),
+		Dir:      ".",
+		Only:     filepath.Base(file),
+	}, nil
+}
+
+// UserScriptSources returns the script directories on disk in order of precedence: the --script-dir flags as given,
+// the WCI_SCRIPT_PATH entries as listed, then the user's script directory. Directories named explicitly must exist;
+// a missing user script directory is skipped.
+func UserScriptSources(flagDirs []string) ([]ScriptSource, error) {
+	var sources []ScriptSource
+	explicit := func(kind, dir string) error {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("script directory '%s': %w", dir, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("script directory '%s' is not a directory", dir)
+		}
+		sources = append(sources, DirScriptSource(kind, dir))
+		return nil
+	}
+
+	for _, dir := range flagDirs {
+		if err := explicit(SourceFlag, dir); err != nil {
+			return nil, err
+		}
+	}
+	for _, dir := range filepath.SplitList(os.Getenv(ScriptPathEnv)) {
+		if dir == "" {
+			continue
+		}
+		if err := explicit(SourceEnv, dir); err != nil {
+			return nil, fmt.Errorf("%s: %w", ScriptPathEnv, err)
+		}
+	}
+
+	userDir, err := UserScriptDir()
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(userDir); err == nil && info.IsDir() {
+		sources = append(sources, DirScriptSource(SourceUser, userDir))
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("script directory '%s': %w", userDir, err)
+	}
+	return sources, nil
+}
+
+// BuildScriptCatalogue reads the scripts of every source, given from the highest precedence to the lowest. A
+// script whose name was already provided by an earlier source is recorded as shadowed.
+func BuildScriptCatalogue(sources []ScriptSource) (*ScriptCatalogue, error) {
+	catalogue := &ScriptCatalogue{}
+	index := make(map[string]int)
+	for i := range sources {
+		source := &sources[i]
+		scripts, err := loadSourceScripts(source)
+		if err != nil {
+			return nil, fmt.Errorf("%s scripts in '%s': %w", source.Kind, source.Location, err)
+		}
+
+		for _, script := range scripts {
+			location := source.Location
+			if source.Only == "" {
+				location = displayScriptLocation(source, script.Path)
+			}
+
+			if winner, taken := index[script.Name]; taken {
+				shadowed := ShadowedScript{
+					Name:       script.Name,
+					Source:     source.Kind,
+					Location:   location,
+					ShadowedBy: catalogue.Scripts[winner].Location,
+				}
+				catalogue.Shadowed = append(catalogue.Shadowed, shadowed)
+				log.Warn().
+					Str("script", script.Name).
+					Str("location", shadowed.Location).
+					Str("shadowedBy", shadowed.ShadowedBy).
+					Msg("Script is shadowed by a script of the same name")
+				continue
+			}
+			index[script.Name] = len(catalogue.Scripts)
+			catalogue.Scripts = append(catalogue.Scripts, CatalogueScript{
+				ScriptMetadata: script,
+				Source:         source.Kind,
+				Location:       location,
+				source:         source,
+			})
+		}
+	}
+	sort.Slice(catalogue.Scripts, func(i, j int) bool { return catalogue.Scripts[i].Name < catalogue.Scripts[j].Name })
+
+	log.Debug().
+		Int("sourceCount", len(sources)).
+		Int("scriptCount", len(catalogue.Scripts)).
+		Int("shadowedCount", len(catalogue.Shadowed)).
+		Msg("Built script catalogue")
+	return catalogue, nil
+}
+
+// loadSourceScripts reads the metadata of the scripts of a source.
+func loadSourceScripts(source *ScriptSource) ([]ScriptMetadata, error) {
+	if source.Only == "" {
+		return LoadScriptCatalogue(source.FS, source.Dir)
+	}
+	scriptPath := path.Join(source.Dir, source.Only)
+	code, err := fs.ReadFile(source.FS, scriptPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script '%s': %w", scriptPath, err)
+	}
+	metadata, err := ParseScriptMetadata(scriptPath, code)
+	if err != nil {
+		return nil, err
+	}
+	return []ScriptMetadata{metadata}, nil
+}
+
+// displayScriptLocation returns where a script of a source lives, as shown to users.
+func displayScriptLocation(source *ScriptSource, scriptPath string) string {
+	switch {
+	case source.Kind == SourceEmbedded:
+		return "embedded:" + scriptPath
+	case source.Only != "":
+		// A single file or package source is read from the directory that holds it
+		return filepath.Join(filepath.Dir(filepath.Clean(source.Location)), filepath.FromSlash(scriptPath))
+	}
+	return filepath.Join(source.Location, filepath.FromSlash(strings.TrimPrefix(scriptPath, source.Dir+"/")))
+}
+
+// Find returns the script with the given name, or with the name of the given file.
+func (c *ScriptCatalogue) Find(name string) (CatalogueScript, error) {
+	name = ScriptNameFromFile(name)
+	for _, script := range c.Scripts {
+		if script.Name == name {
+			return script, nil
+		}
+	}
+	names := make([]string, 0, len(c.Scripts))
+	for _, script := range c.Scripts {
+		names = append(names, script.Name)
+	}
+	return CatalogueScript{}, fmt.Errorf("unknown script '%s' (available: %s)", name, strings.Join(names, ", "))
+}
+
+// ShadowsOf returns the scripts hidden by the script of the given name.
+func (c *ScriptCatalogue) ShadowsOf(name string) []ShadowedScript {
+	var shadowed []ShadowedScript
+	for _, script := range c.Shadowed {
+		if script.Name == name {
+			shadowed = append(shadowed, script)
+		}
+	}
+	return shadowed
+}
+
+// ScriptFileName returns the path of a single-file script inside the file system returned by FS.
+func ScriptFileName(name string) string {
+	return ScriptNameFromFile(name) + ".lua"
+}
+
+// FileName returns the path of the script inside the file system returned by FS: "<name>.lua", or
+// "<name>/init.lua" for a script package.
+func (s CatalogueScript) FileName() string {
+	if IsPackageEntry(s.Path) {
+		return path.Join(s.Name, PackageEntryFile)
+	}
+	return ScriptFileName(s.Name)
+}
+
+// FS returns a flat file system holding the winning script of every name as "<name>.lua", or as the package
+// directory "<name>/", so scripts of one source can depend on scripts of another.
+func (c *ScriptCatalogue) FS() fs.FS {
+	return catalogueFS{catalogue: c}
+}
+
+// catalogueFS serves the scripts of a catalogue by file name.
+type catalogueFS struct {
+	catalogue *ScriptCatalogue
+}
+
+// Open opens "<name>.lua", or a file "<name>/..." of a script package, in the source of the named script. The
+// variants of a script are opened by their own file or directory name, e.g. "<name>.2.0.lua".
+func (f catalogueFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) || !IsLuaFile(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	scriptName, packageFile, inPackage := strings.Cut(name, "/")
+	for _, script := range f.catalogue.Scripts {
+		for _, variant := range script.Variants {
+			isPackage := IsPackageEntry(variant.Path)
+			switch {
+			case inPackage && isPackage && scriptFileBase(variant.Path) == scriptName:
+				return script.source.FS.Open(path.Join(path.Dir(variant.Path), packageFile))
+			case !inPackage && !isPackage && path.Base(variant.Path) == name:
+				return script.source.FS.Open(variant.Path)
+			}
+		}
+
+		isPackage := IsPackageEntry(script.Path)
+		switch {
+		case inPackage && isPackage && script.Name == scriptName:
+			return script.source.FS.Open(path.Join(path.Dir(script.Path), packageFile))
+		case !inPackage && !isPackage && ScriptFileName(script.Name) == name:
+			return script.source.FS.Open(script.Path)
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir lists the script files and package directories served by Open, with every variant of a script, so
+// SelectScriptVariant can choose between them. Only the root directory can be read.
+func (f catalogueFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name != "." {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	var entries []fs.DirEntry
+	for _, script := range f.catalogue.Scripts {
+		scriptPaths := []string{script.Path}
+		if len(script.Variants) > 0 {
+			scriptPaths = scriptPaths[:0]
+			for _, variant := range script.Variants {
+				scriptPaths = append(scriptPaths, variant.Path)
+			}
+		}
+		for _, scriptPath := range scriptPaths {
+			if IsPackageEntry(scriptPath) {
+				scriptPath = path.Dir(scriptPath)
+			}
+			info, err := fs.Stat(script.source.FS, scriptPath)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, fs.FileInfoToDirEntry(info))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
@@ -0,0 +1,196 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	scriptDependsPattern = regexp.MustCompile(`^--\s*@depends\s+(.+)$`)
+	scriptAfterPattern   = regexp.MustCompile(`^--\s*@after\s+(.+)$`)
+)
+
+// ErrDependencyCycle is returned when scripts depend on each other in a circle.
+var ErrDependencyCycle = errors.New("circular script dependency")
+
+// ScriptFile is a script selected for injection, with the dependencies declared in its header.
+type ScriptFile struct {
+	Path       string // path of the script inside its file system
+	Name       string
+	Code       []byte
+	Depends    []string       // scripts that must be injected, and run, before this one
+	After      []string       // scripts that run before this one if they are injected too
+	Dependency bool           // the script was not selected but pulled in by another script's @depends
+	Params     []ScriptParam  // parameters declared with "-- @param", see RenderScriptTemplate
+	Modules    []ScriptModule // modules of a script package, see LoadScriptPackage
+}
+
+// ParseScriptDependencies reads the "-- @depends" and "-- @after" header lines of a script.
+// Both take script names separated by spaces or commas and may be repeated.
+func ParseScriptDependencies(code []byte) (depends, after []string) {
+	for _, line := range strings.Split(string(code), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		if match := scriptDependsPattern.FindStringSubmatch(line); match != nil {
+			depends = append(depends, splitScriptNames(match[1])...)
+		} else if match := scriptAfterPattern.FindStringSubmatch(line); match != nil {
+			after = append(after, splitScriptNames(match[1])...)
+		}
+	}
+	return depends, after
+}
+
+// splitScriptNames splits a list of script names separated by spaces or commas.
+func splitScriptNames(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+}
+
+// LoadScriptFile reads a script and its declared dependencies from a file system.
+func LoadScriptFile(fileSystem fs.FS, scriptFileName string) (ScriptFile, error) {
+	code, err := fs.ReadFile(fileSystem, scriptFileName)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("file", scriptFileName).
+			Msg("Failed to read embedded file")
+		return ScriptFile{}, fmt.Errorf("failed to read embedded file '%s': %w", scriptFileName, err)
+	}
+	depends, after := ParseScriptDependencies(code)
+	params, err := ParseScriptParams(code)
+	if err != nil {
+		return ScriptFile{}, fmt.Errorf("script '%s': %w", scriptFileName, err)
+	}
+	var modules []ScriptModule
+	if IsPackageEntry(scriptFileName) {
+		if code, modules, err = LoadScriptPackage(fileSystem, scriptFileName, code); err != nil {
+			return ScriptFile{}, err
+		}
+	}
+	return ScriptFile{
+		Path:    scriptFileName,
+		Name:    ScriptNameFromFile(scriptFileName),
+		Code:    code,
+		Depends: depends,
+		After:   after,
+		Params:  params,
+		Modules: modules,
+	}, nil
+}
+
+// ResolveScriptOrder loads the selected scripts and the scripts they depend on, and orders them so that every
+// script comes after its dependencies. Dependencies that are not selected are looked up next to the script that
+// declares them; a dependency already injected into the savegame, as reported by injected, needs no file.
+// Apart from that, the selected order is kept. Every script is loaded in the variant for the Factorio version of
+// the savegame, see SelectScriptVariant.
+func ResolveScriptOrder(fileSystem fs.FS, scriptFileNames []string, factorio string, injected func(name string) bool) ([]ScriptFile, error) {
+	var scripts []ScriptFile
+	index := make(map[string]int)
+	for _, scriptFileName := range scriptFileNames {
+		script, err := loadScriptVariant(fileSystem, scriptFileName, factorio)
+		if err != nil {
+			return nil, err
+		}
+		if _, duplicate := index[script.Name]; duplicate {
+			return nil, fmt.Errorf("script '%s' is selected more than once", script.Name)
+		}
+		index[script.Name] = len(scripts)
+		scripts = append(scripts, script)
+	}
+
+	// Pull in missing dependencies, including the dependencies of those
+	for i := 0; i < len(scripts); i++ {
+		for _, dependency := range scripts[i].Depends {
+			if _, loaded := index[dependency]; loaded || injected(dependency) {
+				continue
+			}
+			script, err := loadDependency(fileSystem, scriptDir(scripts[i].Path), dependency, factorio)
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("script '%s' depends on '%s', which is neither available nor injected", scripts[i].Name, dependency)
+			}
+			if err != nil {
+				return nil, err
+			}
+			script.Dependency = true
+			index[script.Name] = len(scripts)
+			scripts = append(scripts, script)
+			log.Debug().
+				Str("script", scripts[i].Name).
+				Str("dependency", dependency).
+				Msg("Added script dependency")
+		}
+	}
+
+	// Order with Kahn's algorithm, always taking the earliest ready script
+	predecessors := make([]map[int]bool, len(scripts))
+	for i, script := range scripts {
+		predecessors[i] = make(map[int]bool)
+		for _, name := range append(append([]string{}, script.Depends...), script.After...) {
+			if j, loaded := index[name]; loaded && j != i {
+				predecessors[i][j] = true
+			}
+		}
+	}
+
+	ordered := make([]ScriptFile, 0, len(scripts))
+	placed := make([]bool, len(scripts))
+	for len(ordered) < len(scripts) {
+		next := -1
+		for i := range scripts {
+			if !placed[i] && allPlaced(predecessors[i], placed) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			var remaining []string
+			for i, script := range scripts {
+				if !placed[i] {
+					remaining = append(remaining, script.Name)
+				}
+			}
+			return nil, fmt.Errorf("%w between %s", ErrDependencyCycle, strings.Join(remaining, ", "))
+		}
+		placed[next] = true
+		ordered = append(ordered, scripts[next])
+	}
+	return ordered, nil
+}
+
+// loadDependency loads a script a dependency names from dir, as a single file or as a package.
+func loadDependency(fileSystem fs.FS, dir, name, factorio string) (ScriptFile, error) {
+	script, err := loadScriptVariant(fileSystem, path.Join(dir, name+".lua"), factorio)
+	if errors.Is(err, fs.ErrNotExist) {
+		return loadScriptVariant(fileSystem, path.Join(dir, name, PackageEntryFile), factorio)
+	}
+	return script, err
+}
+
+// loadScriptVariant loads the variant of a script for the given Factorio version.
+func loadScriptVariant(fileSystem fs.FS, scriptFileName, factorio string) (ScriptFile, error) {
+	variantFileName, err := SelectScriptVariant(fileSystem, scriptFileName, factorio)
+	if err != nil {
+		return ScriptFile{}, err
+	}
+	return LoadScriptFile(fileSystem, variantFileName)
+}
+
+// allPlaced reports whether every script in set is already placed.
+func allPlaced(set map[int]bool, placed []bool) bool {
+	for i := range set {
+		if !placed[i] {
+			return false
+		}
+	}
+	return true
+}
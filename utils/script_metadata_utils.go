@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// scriptTagPattern matches a header tag line of a script, e.g. "-- @author KnightRider2070".
+var scriptTagPattern = regexp.MustCompile(`^--\s*@([a-z]+)(?:\s+(.*?))?\s*$`)
+
+// ScriptCommand is a console command a script declares with "-- @command <name> <help>".
+type ScriptCommand struct {
+	Name string `json:"name"`
+	Help string `json:"help,omitempty"`
+}
+
+// ScriptMetadata describes a script as declared by the tag lines of its header:
+//
+//	-- @name biter_killer                (defaults to the file name, must match it when given)
+//	-- @version 1.1.0
+//	-- @description Destroys all biters.  (repeatable, lines are joined)
+//	-- @author KnightRider2070
+//	-- @command cleanup_biters Destroys all biters on the player's surface.  (repeatable)
+//	-- @events on_tick, on_player_created  (repeatable)
+//	-- @factorio >=1.1 <2.1               (see ParseVersionRange)
+//	-- @runtime                           (the script uses the wci runtime, see UsesScriptRuntime)
+//	-- @param radius:number=0 Search radius.  (see ParseScriptParams)
+//	-- @depends, @after, @changelog       (see ParseScriptDependencies and ParseScriptChangelog)
+type ScriptMetadata struct {
+	Name        string           `json:"name"`
+	Version     string           `json:"version"`
+	Description string           `json:"description,omitempty"`
+	Author      string           `json:"author,omitempty"`
+	Commands    []ScriptCommand  `json:"commands,omitempty"`
+	Events      []string         `json:"events,omitempty"`
+	Factorio    string           `json:"factorio,omitempty"` // supported Factorio versions, empty for any
+	Runtime     bool             `json:"runtime,omitempty"`  // the script uses the wci runtime for its state
+	Params      []ScriptParam    `json:"params,omitempty"`
+	Depends     []string         `json:"depends,omitempty"`
+	After       []string         `json:"after,omitempty"`
+	Changelog   []ChangelogEntry `json:"changelog,omitempty"`
+	Path        string           `json:"path"`               // path of the script inside its file system
+	Variants    []ScriptVariant  `json:"variants,omitempty"` // files for different Factorio versions, see SelectScriptVariant
+}
+
+// FactorioRange returns the supported Factorio versions as a VersionRange.
+func (m ScriptMetadata) FactorioRange() (VersionRange, error) {
+	return ParseVersionRange(m.Factorio)
+}
+
+// ParseScriptMetadata reads the header tags of the script at scriptPath. Tags other than the documented ones
+// are ignored, so plain comments mentioning "@" do not break a script.
+func ParseScriptMetadata(scriptPath string, code []byte) (ScriptMetadata, error) {
+	metadata := ScriptMetadata{
+		Name:      ScriptNameFromFile(scriptPath),
+		Version:   ParseScriptVersion(code),
+		Changelog: ParseScriptChangelog(code),
+		Path:      scriptPath,
+	}
+	metadata.Depends, metadata.After = ParseScriptDependencies(code)
+
+	params, err := ParseScriptParams(code)
+	if err != nil {
+		return metadata, fmt.Errorf("script '%s': %w", scriptPath, err)
+	}
+	metadata.Params = params
+
+	var description []string
+	for _, line := range strings.Split(string(code), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		match := scriptTagPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		tag, value := match[1], match[2]
+		switch tag {
+		case "name":
+			if value != metadata.Name {
+				return metadata, fmt.Errorf("script '%s' declares the name '%s', which does not match its file name", scriptPath, value)
+			}
+		case "description":
+			description = append(description, value)
+		case "author":
+			metadata.Author = value
+		case "command":
+			name, help, _ := strings.Cut(value, " ")
+			if name == "" {
+				return metadata, fmt.Errorf("script '%s': @command needs a command name", scriptPath)
+			}
+			metadata.Commands = append(metadata.Commands, ScriptCommand{Name: name, Help: strings.TrimSpace(help)})
+		case "events":
+			metadata.Events = append(metadata.Events, splitScriptNames(value)...)
+		case scriptRuntimeTag:
+			metadata.Runtime = true
+		case "factorio":
+			if _, err := ParseVersionRange(value); err != nil {
+				return metadata, fmt.Errorf("script '%s': %w", scriptPath, err)
+			}
+			metadata.Factorio = value
+		}
+	}
+	metadata.Description = strings.Join(description, " ")
+	return metadata, nil
+}
+
+// LoadScriptCatalogue reads the metadata of every Lua script in a directory of a file system, sorted by name.
+// A subdirectory holding an init.lua is a script package named after the subdirectory, see LoadScriptPackage.
+// Variants of a script for different Factorio versions are listed once, see SelectScriptVariant.
+func LoadScriptCatalogue(fileSystem fs.FS, dir string) ([]ScriptMetadata, error) {
+	entries, err := fs.ReadDir(fileSystem, dir)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("directory", dir).
+			Msg("Failed to read script directory")
+		return nil, fmt.Errorf("failed to read script directory '%s': %w", dir, err)
+	}
+
+	var catalogue []ScriptMetadata
+	for _, entry := range entries {
+		scriptPath := path.Join(dir, entry.Name())
+		if entry.IsDir() {
+			scriptPath = path.Join(scriptPath, PackageEntryFile)
+			if _, err := fs.Stat(fileSystem, scriptPath); err != nil {
+				continue
+			}
+		} else if !IsLuaFile(entry.Name()) {
+			continue
+		}
+		code, err := fs.ReadFile(fileSystem, scriptPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read script '%s': %w", scriptPath, err)
+		}
+		metadata, err := ParseScriptMetadata(scriptPath, code)
+		if err != nil {
+			return nil, err
+		}
+		catalogue = append(catalogue, metadata)
+	}
+	catalogue = groupScriptVariants(catalogue)
+	sort.Slice(catalogue, func(i, j int) bool { return catalogue[i].Name < catalogue[j].Name })
+
+	log.Debug().
+		Str("directory", dir).
+		Int("scriptCount", len(catalogue)).
+		Msg("Loaded script catalogue")
+	return catalogue, nil
+}
@@ -0,0 +1,417 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"wci/lua"
+)
+
+// ErrNoMigration is returned when no migration leads to the requested Factorio version.
+var ErrNoMigration = errors.New("no script migration to this Factorio version")
+
+// MigrationNote is a change MigrateLuaCode made to a script, or a construct it found but could not migrate.
+type MigrationNote struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+// String formats the note as "file:line:column: message".
+func (n MigrationNote) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", n.File, n.Line, n.Column, n.Message)
+}
+
+// ScriptMigration is the result of migrating the code of one Lua file.
+type ScriptMigration struct {
+	Code       string          `json:"-"`
+	Rewritten  []MigrationNote `json:"rewritten,omitempty"`  // renames that were applied
+	Unmigrated []MigrationNote `json:"unmigrated,omitempty"` // constructs that need to be migrated by hand
+}
+
+// luaMigration rewrites code written for the Factorio versions before Version.
+type luaMigration struct {
+	Version string
+	migrate func(m *luaMigrator)
+}
+
+// luaMigrations lists the migrations in the order they apply.
+var luaMigrations = []luaMigration{
+	{Version: "2.0", migrate: migrateTo20},
+}
+
+// helpersFunctions20 are the functions of game that Factorio 2.0 moved to helpers.
+var helpersFunctions20 = map[string]bool{
+	"write_file": true, "remove_path": true, "table_to_json": true, "json_to_table": true, "encode_string": true,
+	"decode_string": true, "is_valid_sound_path": true, "is_valid_sprite_path": true, "create_profiler": true,
+	"parse_map_exchange_string": true, "check_prototype_translations": true, "direction_to_string": true,
+	"evaluate_expression": true,
+}
+
+// prototypeCategories20 are the prototype tables that Factorio 2.0 moved from game.<category>_prototypes to
+// prototypes.<category>.
+var prototypeCategories20 = map[string]bool{
+	"entity": true, "item": true, "fluid": true, "tile": true, "recipe": true, "technology": true, "equipment": true,
+	"decorative": true, "virtual_signal": true, "achievement": true, "mod_setting": true, "custom_input": true,
+	"ammo_category": true, "item_group": true, "item_subgroup": true, "fuel_category": true, "resource_category": true,
+	"recipe_category": true, "module_category": true, "equipment_category": true, "equipment_grid": true,
+	"damage": true, "trivial_smoke": true, "shortcut": true, "particle": true, "autoplace_control": true, "font": true,
+}
+
+// filteredPrototypeCategories20 are the categories whose game.get_filtered_<category>_prototypes became
+// prototypes.get_<category>_filtered in Factorio 2.0.
+var filteredPrototypeCategories20 = map[string]bool{
+	"entity": true, "item": true, "fluid": true, "tile": true, "recipe": true, "technology": true, "equipment": true,
+	"decorative": true, "achievement": true, "mod_setting": true,
+}
+
+// evolutionFields20 are the LuaForce attributes that Factorio 2.0 replaced by per-surface methods.
+var evolutionFields20 = map[string]bool{
+	"evolution_factor": true, "evolution_factor_by_pollution": true, "evolution_factor_by_time": true,
+	"evolution_factor_by_killing_spawners": true,
+}
+
+// MigrationTargets returns the Factorio versions scripts can be migrated to, oldest first.
+func MigrationTargets() []string {
+	versions := make([]string, 0, len(luaMigrations))
+	for _, migration := range luaMigrations {
+		versions = append(versions, migration.Version)
+	}
+	return versions
+}
+
+// MigrateLuaCode rewrites Lua code written for older Factorio versions for the version to, e.g. "2.0" or the
+// version of a save such as "2.0.28". Only known renames are rewritten, in the syntax tree so strings and comments
+// are left alone; constructs that need more than a rename are listed as Unmigrated. Code that is already migrated
+// comes back unchanged, and code for a version no migration leads to is returned as it is.
+func MigrateLuaCode(file, code, to string) (ScriptMigration, error) {
+	migration := ScriptMigration{Code: code}
+	for _, step := range luaMigrations {
+		if CompareVersions(step.Version, to) > 0 {
+			break
+		}
+		chunk, err := lua.Parse(file, migration.Code)
+		if err != nil {
+			return ScriptMigration{}, err
+		}
+		migrator := newLuaMigrator(file, migration.Code, chunk)
+		step.migrate(migrator)
+		migration.Code = migrator.apply()
+		migration.Rewritten = append(migration.Rewritten, migrator.rewritten...)
+		migration.Unmigrated = append(migration.Unmigrated, migrator.unmigrated...)
+	}
+	return migration, nil
+}
+
+// MigratedFile is a file of a migrated catalogue script.
+type MigratedFile struct {
+	Path string `json:"path"` // as shown to users; the file on disk for scripts that are not embedded
+	ScriptMigration
+}
+
+// MigrateScript migrates a catalogue script, and every module of a script package, to the Factorio version to.
+// The files are migrated as written, before templates are rendered; a script whose template does not parse as
+// Lua can only be migrated by injecting it, which migrates the rendered code.
+func MigrateScript(script CatalogueScript, to string) ([]MigratedFile, error) {
+	if len(luaMigrations) == 0 || CompareVersions(to, luaMigrations[0].Version) < 0 {
+		return nil, fmt.Errorf("%w: '%s' (migrations lead to %s)", ErrNoMigration, to, strings.Join(MigrationTargets(), ", "))
+	}
+	file, err := LoadScriptFile(script.source.FS, script.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Modules are read as written, LoadScriptFile rewrites their requires for the package
+	paths := []string{script.Path}
+	for _, module := range file.Modules {
+		paths = append(paths, path.Join(path.Dir(script.Path), module.Path))
+	}
+	var migrated []MigratedFile
+	for _, filePath := range paths {
+		code, err := fs.ReadFile(script.source.FS, filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read '%s': %w", filePath, err)
+		}
+		shownPath := displayScriptLocation(script.source, filePath)
+		migration, err := MigrateLuaCode(shownPath, string(code), to)
+		var syntaxErr *lua.SyntaxError
+		if errors.As(err, &syntaxErr) && filePath == script.Path && len(file.Params) > 0 {
+			return nil, fmt.Errorf("script '%s' is a template that is no valid Lua before rendering, inject it to "+
+				"migrate the rendered code: %w", script.Name, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		migrated = append(migrated, MigratedFile{Path: shownPath, ScriptMigration: migration})
+	}
+	return migrated, nil
+}
+
+// migrateScriptModules migrates the modules of a script package, found in dir, for the Factorio version to.
+func migrateScriptModules(dir string, scriptModules []ScriptModule, to string) ([]ScriptModule, ScriptMigration, error) {
+	var notes ScriptMigration
+	modules := make([]ScriptModule, 0, len(scriptModules))
+	for _, module := range scriptModules {
+		migration, err := MigrateLuaCode(path.Join(dir, module.Path), string(module.Code), to)
+		if err != nil {
+			return nil, notes, err
+		}
+		module.Code = []byte(migration.Code)
+		modules = append(modules, module)
+		notes.Rewritten = append(notes.Rewritten, migration.Rewritten...)
+		notes.Unmigrated = append(notes.Unmigrated, migration.Unmigrated...)
+	}
+	return modules, notes, nil
+}
+
+// luaEdit replaces the source bytes from start to end.
+type luaEdit struct {
+	start, end int
+	text       string
+}
+
+// luaMigrator collects the edits and notes of one migration of a file.
+type luaMigrator struct {
+	file       string
+	source     string
+	chunk      *lua.Chunk
+	locals     map[string]lua.Position // names declared local anywhere in the file, at their first declaration
+	declared   map[int]bool            // offsets of the names that declare locals
+	edits      []luaEdit
+	rewritten  []MigrationNote
+	unmigrated []MigrationNote
+}
+
+// newLuaMigrator prepares a migration of a parsed file and notes every name it declares local.
+func newLuaMigrator(file, source string, chunk *lua.Chunk) *luaMigrator {
+	m := &luaMigrator{file: file, source: source, chunk: chunk, locals: make(map[string]lua.Position), declared: make(map[int]bool)}
+	declare := func(names ...*lua.NameExpr) {
+		for _, name := range names {
+			if _, ok := m.locals[name.Name]; !ok {
+				m.locals[name.Name] = name.Pos()
+			}
+			m.declared[name.Pos().Offset] = true
+		}
+	}
+	lua.Inspect(chunk.Body, func(node lua.Node) bool {
+		switch n := node.(type) {
+		case *lua.LocalStmt:
+			declare(n.Names...)
+		case *lua.LocalFunctionStmt:
+			declare(n.Name)
+		case *lua.FunctionExpr:
+			declare(n.Params...)
+		case *lua.NumericForStmt:
+			declare(n.Var)
+		case *lua.GenericForStmt:
+			declare(n.Names...)
+		}
+		return true
+	})
+	return m
+}
+
+// replace rewrites a node with text and notes the change.
+func (m *luaMigrator) replace(node lua.Node, text, format string, args ...any) {
+	m.edits = append(m.edits, luaEdit{start: node.Pos().Offset, end: node.End(), text: text})
+	m.rewritten = append(m.rewritten, m.note(node.Pos(), format, args...))
+}
+
+// cannot notes a construct that needs to be migrated by hand.
+func (m *luaMigrator) cannot(pos lua.Position, format string, args ...any) {
+	m.unmigrated = append(m.unmigrated, m.note(pos, format, args...))
+}
+
+// note builds a note at a position of the file.
+func (m *luaMigrator) note(pos lua.Position, format string, args ...any) MigrationNote {
+	return MigrationNote{File: m.file, Line: pos.Line, Column: pos.Column, Message: fmt.Sprintf(format, args...)}
+}
+
+// leftShadowed notes the uses of a global that are left as they are because the file declares a local of the
+// name, at the first use and with the lines of all of them.
+func (m *luaMigrator) leftShadowed(global, local string, uses []lua.Position) {
+	if len(uses) == 0 {
+		return
+	}
+	lines := make([]string, 0, len(uses))
+	for _, use := range uses {
+		if line := fmt.Sprint(use.Line); len(lines) == 0 || lines[len(lines)-1] != line {
+			lines = append(lines, line)
+		}
+	}
+	m.cannot(uses[0], "'%s' on line %s is left as it is, as '%s' is declared local at %s", global,
+		strings.Join(lines, ", "), local, m.locals[local])
+}
+
+// apply returns the source with every edit applied.
+func (m *luaMigrator) apply() string {
+	sort.Slice(m.edits, func(i, j int) bool { return m.edits[i].start < m.edits[j].start })
+	var result strings.Builder
+	offset := 0
+	for _, edit := range m.edits {
+		result.WriteString(m.source[offset:edit.start])
+		result.WriteString(edit.text)
+		offset = edit.end
+	}
+	result.WriteString(m.source[offset:])
+	return result.String()
+}
+
+// migrateTo20 applies the renames of Factorio 2.0: global became storage, file and string functions of game moved
+// to helpers, prototype tables to prototypes, and on_entity_destroyed became on_object_destroyed. Uses of game
+// through a local alias, and places game is handed on, are noted as they cannot be followed.
+func migrateTo20(m *luaMigrator) {
+	globalLocal, gameLocal := "", ""
+	for _, name := range []string{"global", "storage"} {
+		if _, ok := m.locals[name]; ok && globalLocal == "" {
+			globalLocal = name
+		}
+	}
+	if _, ok := m.locals["game"]; ok {
+		gameLocal = "game"
+	}
+	aliases := gameAliases20(m.chunk)
+	var globalUses, gameUses []lua.Position
+
+	lua.Inspect(m.chunk.Body, func(node lua.Node) bool {
+		switch n := node.(type) {
+		case *lua.NameExpr:
+			if n.Name != "global" || m.declared[n.Pos().Offset] {
+				return true
+			}
+			if globalLocal != "" {
+				globalUses = append(globalUses, n.Pos())
+				return true
+			}
+			m.replace(n, "storage", "'global' is 'storage' since Factorio 2.0")
+		case *lua.IndexExpr:
+			key, ok := n.Key.(*lua.StringExpr)
+			if !ok || !n.Dot {
+				return true
+			}
+			object := lua.DottedName(n.Object)
+			switch {
+			case evolutionFields20[key.Value]:
+				m.cannot(key.Pos(), "LuaForce.%s was replaced by get_%s(surface) and set_%s(value, surface) in "+
+					"Factorio 2.0, which need the surface", key.Value, key.Value, key.Value)
+			case object == "defines.events" && key.Value == "on_entity_destroyed":
+				m.replace(key, "on_object_destroyed", "'on_entity_destroyed' is 'on_object_destroyed' since Factorio "+
+					"2.0; its event data has useful_id instead of unit_number")
+			case object == "script" && key.Value == "register_on_entity_destroyed":
+				m.replace(key, "register_on_object_destroyed", "'script.register_on_entity_destroyed' is "+
+					"'script.register_on_object_destroyed' since Factorio 2.0")
+			case key.Value == "get_item_launched" || key.Value == "set_item_launched":
+				m.cannot(key.Pos(), "LuaForce.%s was removed in Factorio 2.0", key.Value)
+			case object == "game" && gameLocal != "":
+				if gameFieldMoved20(key.Value) {
+					gameUses = append(gameUses, n.Pos())
+				}
+			case object == "game":
+				m.migrateGameField20(n, key.Value)
+			case aliases[object] && gameFieldMoved20(key.Value):
+				m.cannot(key.Pos(), "'%s.%s' uses game through the local '%s', which is not migrated; 'game.%s' "+
+					"moved in Factorio 2.0", object, key.Value, object, key.Value)
+			}
+		case *lua.MethodCallExpr:
+			if n.Method == "get_item_launched" || n.Method == "set_item_launched" {
+				m.cannot(n.MethodPos, "LuaForce.%s was removed in Factorio 2.0", n.Method)
+			}
+		}
+		return true
+	})
+
+	m.leftShadowed("global", globalLocal, globalUses)
+	m.leftShadowed("game", gameLocal, gameUses)
+	if gameLocal == "" {
+		for _, pos := range gameHandedOn20(m.chunk) {
+			m.cannot(pos, "game is handed on here; functions of it that moved in Factorio 2.0 are not migrated "+
+				"where they are used through that value")
+		}
+	}
+	sort.SliceStable(m.unmigrated, func(i, j int) bool {
+		a, b := m.unmigrated[i], m.unmigrated[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+}
+
+// gameFieldMoved20 reports whether Factorio 2.0 moved a field of game to helpers, script or prototypes.
+func gameFieldMoved20(field string) bool {
+	return helpersFunctions20[field] || field == "active_mods" || strings.HasSuffix(field, "_prototypes")
+}
+
+// gameAliases20 returns the names of the locals declared with game as their value, "local g = game".
+func gameAliases20(chunk *lua.Chunk) map[string]bool {
+	aliases := make(map[string]bool)
+	lua.Inspect(chunk.Body, func(node lua.Node) bool {
+		if local, ok := node.(*lua.LocalStmt); ok {
+			for i, value := range local.Values {
+				if name, ok := value.(*lua.NameExpr); ok && name.Name == "game" && i < len(local.Names) {
+					aliases[local.Names[i].Name] = true
+				}
+			}
+		}
+		return true
+	})
+	return aliases
+}
+
+// gameHandedOn20 returns the positions where game is assigned, put into a table, passed to a function or returned,
+// other than as the value of a local alias, see gameAliases20.
+func gameHandedOn20(chunk *lua.Chunk) []lua.Position {
+	var positions []lua.Position
+	values := func(exprs ...lua.Expr) {
+		for _, expr := range exprs {
+			if name, ok := expr.(*lua.NameExpr); ok && name.Name == "game" {
+				positions = append(positions, name.Pos())
+			}
+		}
+	}
+	lua.Inspect(chunk.Body, func(node lua.Node) bool {
+		switch n := node.(type) {
+		case *lua.AssignStmt:
+			values(n.Values...)
+		case *lua.ReturnStmt:
+			values(n.Values...)
+		case *lua.CallExpr:
+			values(n.Args...)
+		case *lua.MethodCallExpr:
+			values(n.Args...)
+		case *lua.TableExpr:
+			for _, field := range n.Fields {
+				values(field.Value)
+			}
+		}
+		return true
+	})
+	return positions
+}
+
+// migrateGameField20 migrates a field of game that Factorio 2.0 moved.
+func (m *luaMigrator) migrateGameField20(index *lua.IndexExpr, field string) {
+	switch {
+	case helpersFunctions20[field]:
+		m.replace(index.Object, "helpers", "'game.%s' is 'helpers.%s' since Factorio 2.0", field, field)
+	case field == "active_mods":
+		m.replace(index.Object, "script", "'game.active_mods' is 'script.active_mods' since Factorio 2.0")
+	case strings.HasPrefix(field, "get_filtered_") && strings.HasSuffix(field, "_prototypes"):
+		category := strings.TrimSuffix(strings.TrimPrefix(field, "get_filtered_"), "_prototypes")
+		if !filteredPrototypeCategories20[category] {
+			m.cannot(index.Pos(), "'game.%s' moved to prototypes in Factorio 2.0", field)
+			return
+		}
+		m.replace(index, "prototypes.get_"+category+"_filtered", "'game.%s' is 'prototypes.get_%s_filtered' since "+
+			"Factorio 2.0", field, category)
+	case strings.HasSuffix(field, "_prototypes"):
+		category := strings.TrimSuffix(field, "_prototypes")
+		if !prototypeCategories20[category] {
+			m.cannot(index.Pos(), "'game.%s' moved to prototypes in Factorio 2.0", field)
+			return
+		}
+		m.replace(index, "prototypes."+category, "'game.%s' is 'prototypes.%s' since Factorio 2.0", field, category)
+	}
+}
@@ -0,0 +1,617 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ScriptPackExtension is the file extension of script packs.
+const ScriptPackExtension = ".wcipack"
+
+const (
+	// packManifestFile lists the name, version and file hashes of a pack.
+	packManifestFile = "pack.json"
+	// packSignatureFile holds the base64 ed25519 signature of the manifest.
+	packSignatureFile = "pack.sig"
+	// packScriptsDir holds the scripts of a pack, laid out like a script directory.
+	packScriptsDir = "scripts"
+)
+
+var (
+	// ErrPackUnsigned is returned for a pack without signature.
+	ErrPackUnsigned = errors.New("script pack is not signed")
+	// ErrPackUntrusted is returned when the signature of a pack matches none of the trusted keys.
+	ErrPackUntrusted = errors.New("script pack signature does not match any trusted key")
+	// ErrPackTampered is returned when the files of a pack do not match its manifest.
+	ErrPackTampered = errors.New("script pack content does not match its manifest")
+)
+
+// packNamePattern restricts pack names to what is safe as a file name.
+var packNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// TrustedKey is a public key that may sign script packs, configured under trusted_keys.
+type TrustedKey struct {
+	Name string `yaml:"name" json:"name"`
+	Key  string `yaml:"key" json:"key"` // base64 encoded ed25519 public key
+}
+
+// PublicKey decodes the key.
+func (k TrustedKey) PublicKey() (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(k.Key))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("trusted key '%s' is not a base64 encoded ed25519 public key", k.Name)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// PackManifest is the pack.json of a script pack. Files maps the path of every file of the pack to its SHA-256.
+type PackManifest struct {
+	Name        string            `json:"name"`
+	Version     string            `json:"version"`
+	Description string            `json:"description,omitempty"`
+	Author      string            `json:"author,omitempty"`
+	Files       map[string]string `json:"files"`
+}
+
+// Scripts returns the names of the scripts of a pack: its Lua files and package directories under scripts/.
+func (m PackManifest) Scripts() []string {
+	seen := make(map[string]bool)
+	for file := range m.Files {
+		relative := strings.TrimPrefix(file, packScriptsDir+"/")
+		if first, _, nested := strings.Cut(relative, "/"); nested {
+			seen[first] = true
+		} else if IsLuaFile(relative) {
+			seen[ScriptNameFromFile(relative)] = true
+		}
+	}
+	return sortedKeys(seen)
+}
+
+// ScriptPack is a script pack read from a .wcipack file: a ZIP archive with pack.json, an optional pack.sig and
+// the scripts under scripts/.
+type ScriptPack struct {
+	Manifest     PackManifest
+	Path         string
+	manifestData []byte
+	signature    []byte
+	archive      *zip.Reader
+}
+
+// InstalledPack records a script pack installed into the user's script directory.
+type InstalledPack struct {
+	PackManifest
+	Signer      string    `json:"signer,omitempty"` // trusted key the pack was signed with, empty if installed unsigned
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// ReadScriptPack reads a .wcipack file and checks that its files match the hashes of its manifest. The signature
+// is not checked, see VerifyScriptPack.
+func ReadScriptPack(packPath string) (*ScriptPack, error) {
+	data, err := os.ReadFile(packPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script pack: %w", err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("'%s' is not a script pack: %w", packPath, err)
+	}
+
+	pack := &ScriptPack{Path: packPath, archive: archive}
+	files := make(map[string][]byte)
+	seen := make(map[string]bool)
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		// A second entry of the same name would be checked in place of the first, whichever one gets installed
+		if seen[file.Name] {
+			return nil, fmt.Errorf("%w: '%s' is in the pack more than once", ErrPackTampered, file.Name)
+		}
+		seen[file.Name] = true
+		if file.Name != packManifestFile && file.Name != packSignatureFile {
+			if err := checkPackFileName(file.Name); err != nil {
+				return nil, fmt.Errorf("script pack '%s': %w", packPath, err)
+			}
+		}
+		content, err := ReadZipFile(file)
+		if err != nil {
+			return nil, err
+		}
+		switch file.Name {
+		case packManifestFile:
+			pack.manifestData = content
+		case packSignatureFile:
+			if pack.signature, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(content))); err != nil {
+				return nil, fmt.Errorf("%w: %s is not base64 encoded", ErrPackTampered, packSignatureFile)
+			}
+		default:
+			files[file.Name] = content
+		}
+	}
+
+	if pack.manifestData == nil {
+		return nil, fmt.Errorf("'%s' is not a script pack: %s is missing", packPath, packManifestFile)
+	}
+	if err := json.Unmarshal(pack.manifestData, &pack.Manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse %s of '%s': %w", packManifestFile, packPath, err)
+	}
+	if !packNamePattern.MatchString(pack.Manifest.Name) {
+		return nil, fmt.Errorf("script pack '%s' has an invalid name '%s'", packPath, pack.Manifest.Name)
+	}
+
+	// Every file must be listed with its hash, and every listed file must be there
+	for _, name := range SortedFileNames(files) {
+		expected, listed := pack.Manifest.Files[name]
+		if !listed {
+			return nil, fmt.Errorf("%w: '%s' is not listed", ErrPackTampered, name)
+		}
+		if sum := sha256.Sum256(files[name]); hex.EncodeToString(sum[:]) != expected {
+			return nil, fmt.Errorf("%w: hash of '%s' differs", ErrPackTampered, name)
+		}
+	}
+	for _, name := range sortedKeys(pack.Manifest.Files) {
+		if _, exists := files[name]; !exists {
+			return nil, fmt.Errorf("%w: '%s' is missing", ErrPackTampered, name)
+		}
+	}
+
+	log.Debug().
+		Str("path", packPath).
+		Str("name", pack.Manifest.Name).
+		Str("version", pack.Manifest.Version).
+		Int("fileCount", len(files)).
+		Bool("signed", pack.signature != nil).
+		Msg("Read script pack")
+	return pack, nil
+}
+
+// checkPackFileName checks that a file of a pack stays inside scripts/ once installed, on every platform: the
+// name must be a slash-separated local path without backslashes or drive letters.
+func checkPackFileName(name string) error {
+	relative, inScripts := strings.CutPrefix(name, packScriptsDir+"/")
+	if !inScripts || !fs.ValidPath(name) || strings.ContainsAny(name, `\:`) || !filepath.IsLocal(filepath.FromSlash(relative)) {
+		return fmt.Errorf("'%s' is not a file inside %s/", name, packScriptsDir)
+	}
+	return nil
+}
+
+// Signed reports whether the pack carries a signature.
+func (p *ScriptPack) Signed() bool {
+	return p.signature != nil
+}
+
+// VerifyScriptPack checks the signature of a pack's manifest against the trusted keys and returns the name of the
+// key that signed it. It fails with ErrPackUnsigned or ErrPackUntrusted. Malformed trusted keys are skipped with a
+// warning, so one broken entry does not lock out the others.
+func VerifyScriptPack(pack *ScriptPack, trustedKeys []TrustedKey) (string, error) {
+	if !pack.Signed() {
+		return "", fmt.Errorf("'%s': %w", pack.Manifest.Name, ErrPackUnsigned)
+	}
+	for _, trusted := range trustedKeys {
+		key, err := trusted.PublicKey()
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("key", trusted.Name).
+				Msg("Skipped malformed trusted key")
+			continue
+		}
+		if ed25519.Verify(key, pack.manifestData, pack.signature) {
+			return trusted.Name, nil
+		}
+	}
+	return "", fmt.Errorf("'%s': %w", pack.Manifest.Name, ErrPackUntrusted)
+}
+
+// checkPackScripts loads every script of a pack, so a broken script is refused before anything is installed.
+func checkPackScripts(pack *ScriptPack) error {
+	scripts, err := LoadScriptCatalogue(pack.archive, packScriptsDir)
+	if err != nil {
+		return err
+	}
+	for _, script := range scripts {
+		if _, err := LoadScriptFile(pack.archive, script.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packsDir returns the directory that records the installed script packs.
+func packsDir() (string, error) {
+	configDir, err := UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "packs"), nil
+}
+
+// ListInstalledPacks returns the installed script packs, sorted by name.
+func ListInstalledPacks() ([]InstalledPack, error) {
+	dir, err := packsDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read '%s': %w", dir, err)
+	}
+
+	var packs []InstalledPack
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pack record: %w", err)
+		}
+		var pack InstalledPack
+		if err := json.Unmarshal(data, &pack); err != nil {
+			return nil, fmt.Errorf("failed to parse pack record '%s': %w", entry.Name(), err)
+		}
+		packs = append(packs, pack)
+	}
+	sort.Slice(packs, func(i, j int) bool { return packs[i].Name < packs[j].Name })
+	return packs, nil
+}
+
+// InstallScriptPack unpacks the scripts of a pack into the user's script directory and records the pack. A file
+// that exists but does not belong to an earlier version of the same pack is never overwritten; files of the earlier
+// version that the new one no longer has are removed. signer names the key that verified the pack.
+func InstallScriptPack(pack *ScriptPack, signer string) (InstalledPack, error) {
+	installed := InstalledPack{PackManifest: pack.Manifest, Signer: signer, InstalledAt: time.Now().UTC().Truncate(time.Second)}
+	if err := checkPackScripts(pack); err != nil {
+		return installed, fmt.Errorf("script pack '%s': %w", pack.Manifest.Name, err)
+	}
+
+	scriptDir, err := UserScriptDir()
+	if err != nil {
+		return installed, err
+	}
+	packs, err := ListInstalledPacks()
+	if err != nil {
+		return installed, err
+	}
+	owners := make(map[string]string)
+	var previous *InstalledPack
+	for i, other := range packs {
+		for file := range other.Files {
+			owners[file] = other.Name
+		}
+		if other.Name == pack.Manifest.Name {
+			previous = &packs[i]
+		}
+	}
+
+	// Check every file before writing any
+	for _, file := range sortedKeys(pack.Manifest.Files) {
+		target, err := packFileTarget(scriptDir, file)
+		if err != nil {
+			return installed, fmt.Errorf("script pack '%s': %w", pack.Manifest.Name, err)
+		}
+		if _, err := os.Stat(target); err == nil && owners[file] != pack.Manifest.Name {
+			if owners[file] != "" {
+				return installed, fmt.Errorf("'%s' belongs to the installed pack '%s'", target, owners[file])
+			}
+			return installed, fmt.Errorf("'%s' already exists and was not installed by a pack", target)
+		}
+	}
+
+	// Write every file into a staging directory first and move them into place once all are written, so a
+	// failure leaves the installed scripts as they were
+	if err := os.MkdirAll(scriptDir, 0755); err != nil {
+		return installed, fmt.Errorf("failed to create '%s': %w", scriptDir, err)
+	}
+	staging, err := os.MkdirTemp(scriptDir, ".pack-"+pack.Manifest.Name+"-")
+	if err != nil {
+		return installed, fmt.Errorf("failed to create a staging directory in '%s': %w", scriptDir, err)
+	}
+	defer os.RemoveAll(staging)
+
+	var staged []stagedPackFile
+	for i, file := range sortedKeys(pack.Manifest.Files) {
+		content, err := fs.ReadFile(pack.archive, file)
+		if err != nil {
+			return installed, fmt.Errorf("failed to read '%s' from the pack: %w", file, err)
+		}
+		target, err := packFileTarget(scriptDir, file)
+		if err != nil {
+			return installed, fmt.Errorf("script pack '%s': %w", pack.Manifest.Name, err)
+		}
+		stagedFile := stagedPackFile{
+			staged: filepath.Join(staging, "new", strconv.Itoa(i)),
+			backup: filepath.Join(staging, "replaced", strconv.Itoa(i)),
+			target: target,
+		}
+		if err := os.MkdirAll(filepath.Dir(stagedFile.staged), 0755); err != nil {
+			return installed, fmt.Errorf("failed to create '%s': %w", filepath.Dir(stagedFile.staged), err)
+		}
+		if err := os.WriteFile(stagedFile.staged, content, 0644); err != nil {
+			return installed, fmt.Errorf("failed to write '%s': %w", stagedFile.staged, err)
+		}
+		staged = append(staged, stagedFile)
+	}
+	if err := movePackFiles(staging, staged); err != nil {
+		return installed, fmt.Errorf("script pack '%s': %w", pack.Manifest.Name, err)
+	}
+	if previous != nil {
+		for file := range previous.Files {
+			if _, kept := pack.Manifest.Files[file]; !kept {
+				removePackFile(scriptDir, file)
+			}
+		}
+	}
+
+	if err := writePackRecord(installed); err != nil {
+		return installed, err
+	}
+	log.Info().
+		Str("pack", installed.Name).
+		Str("version", installed.Version).
+		Str("signer", signer).
+		Str("directory", scriptDir).
+		Msg("Installed script pack")
+	return installed, nil
+}
+
+// UninstallScriptPack removes the files of an installed pack from the user's script directory and its record.
+func UninstallScriptPack(name string) (InstalledPack, error) {
+	packs, err := ListInstalledPacks()
+	if err != nil {
+		return InstalledPack{}, err
+	}
+	var names []string
+	for _, pack := range packs {
+		names = append(names, pack.Name)
+		if pack.Name != name {
+			continue
+		}
+
+		scriptDir, err := UserScriptDir()
+		if err != nil {
+			return pack, err
+		}
+		for file := range pack.Files {
+			removePackFile(scriptDir, file)
+		}
+		dir, err := packsDir()
+		if err != nil {
+			return pack, err
+		}
+		if err := os.Remove(filepath.Join(dir, name+".json")); err != nil {
+			return pack, fmt.Errorf("failed to remove the record of pack '%s': %w", name, err)
+		}
+		log.Info().
+			Str("pack", name).
+			Msg("Uninstalled script pack")
+		return pack, nil
+	}
+	if len(names) == 0 {
+		return InstalledPack{}, fmt.Errorf("script pack '%s' is not installed (no packs installed)", name)
+	}
+	return InstalledPack{}, fmt.Errorf("script pack '%s' is not installed (installed: %s)", name, strings.Join(names, ", "))
+}
+
+// packFileTarget returns where a file of a pack is installed, refusing names that would end up outside of the
+// script directory.
+func packFileTarget(scriptDir, file string) (string, error) {
+	if err := checkPackFileName(file); err != nil {
+		return "", err
+	}
+	target := filepath.Join(scriptDir, filepath.FromSlash(strings.TrimPrefix(file, packScriptsDir+"/")))
+	if relative, err := filepath.Rel(scriptDir, target); err != nil || !filepath.IsLocal(relative) {
+		return "", fmt.Errorf("'%s' would be installed outside of '%s'", file, scriptDir)
+	}
+	return target, nil
+}
+
+// stagedPackFile is a file of a pack being installed, written to the staging directory.
+type stagedPackFile struct {
+	staged   string // path in the staging directory
+	backup   string // where the file it replaces is kept until the install is done
+	target   string // path in the script directory
+	replaced bool   // target existed and was moved to backup
+}
+
+// movePackFiles renames staged files onto their targets. The files they replace are moved into the staging
+// directory, and when a rename fails the files moved so far are taken out again and the replaced ones put back.
+func movePackFiles(staging string, files []stagedPackFile) error {
+	if err := os.MkdirAll(filepath.Join(staging, "replaced"), 0755); err != nil {
+		return fmt.Errorf("failed to create '%s': %w", filepath.Join(staging, "replaced"), err)
+	}
+	var moved []stagedPackFile
+	undo := func() {
+		for i := len(moved) - 1; i >= 0; i-- {
+			file := moved[i]
+			if err := os.Rename(file.target, file.staged); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Warn().Err(err).Str("file", file.target).Msg("Failed to take back file of script pack")
+			}
+			if !file.replaced {
+				continue
+			}
+			if err := os.Rename(file.backup, file.target); err != nil {
+				log.Warn().Err(err).Str("file", file.target).Msg("Failed to restore file replaced by script pack")
+			}
+		}
+	}
+
+	for _, file := range files {
+		if err := os.MkdirAll(filepath.Dir(file.target), 0755); err != nil {
+			undo()
+			return fmt.Errorf("failed to create '%s': %w", filepath.Dir(file.target), err)
+		}
+		if _, err := os.Lstat(file.target); err == nil {
+			if err := os.Rename(file.target, file.backup); err != nil {
+				undo()
+				return fmt.Errorf("failed to move '%s' aside: %w", file.target, err)
+			}
+			file.replaced = true
+		}
+		// Recorded before the rename, so undo puts a replaced file back even when this rename fails
+		moved = append(moved, file)
+		if err := os.Rename(file.staged, file.target); err != nil {
+			undo()
+			return fmt.Errorf("failed to move '%s' into place: %w", file.target, err)
+		}
+	}
+	return nil
+}
+
+// removePackFile deletes an installed file of a pack and the directories it leaves empty.
+func removePackFile(scriptDir, file string) {
+	target, err := packFileTarget(scriptDir, file)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("file", file).
+			Msg("Skipped file of script pack outside of the script directory")
+		return
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Warn().
+			Err(err).
+			Str("file", target).
+			Msg("Failed to remove file of script pack")
+		return
+	}
+	for dir := filepath.Dir(target); dir != scriptDir && strings.HasPrefix(dir, scriptDir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+}
+
+// writePackRecord stores the record of an installed pack.
+func writePackRecord(pack InstalledPack) error {
+	dir, err := packsDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create '%s': %w", dir, err)
+	}
+	data, err := json.MarshalIndent(pack, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode pack record: %w", err)
+	}
+	return os.WriteFile(filepath.Join(dir, pack.Name+".json"), append(data, '\n'), 0644)
+}
+
+// BuildScriptPack writes the scripts of a script directory into a .wcipack file. With a private key, the manifest
+// is signed. Hidden files are left out.
+func BuildScriptPack(dir string, manifest PackManifest, privateKey ed25519.PrivateKey, packPath string) error {
+	if !packNamePattern.MatchString(manifest.Name) {
+		return fmt.Errorf("invalid pack name '%s' (letters, digits, '-' and '_' only)", manifest.Name)
+	}
+
+	files := make(map[string][]byte)
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(entry.Name(), ".") && filePath != dir {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		relative, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		files[path.Join(packScriptsDir, filepath.ToSlash(relative))] = content
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read scripts from '%s': %w", dir, err)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no scripts found in '%s'", dir)
+	}
+
+	manifest.Files = make(map[string]string, len(files))
+	for name, content := range files {
+		sum := sha256.Sum256(content)
+		manifest.Files[name] = hex.EncodeToString(sum[:])
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", packManifestFile, err)
+	}
+	files[packManifestFile] = append(manifestData, '\n')
+	if privateKey != nil {
+		signature := ed25519.Sign(privateKey, files[packManifestFile])
+		files[packSignatureFile] = []byte(base64.StdEncoding.EncodeToString(signature) + "\n")
+	}
+
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for _, name := range SortedFileNames(files) {
+		if err := AddFileToZip(writer, name, files[name]); err != nil {
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to finish script pack: %w", err)
+	}
+	if err := os.WriteFile(packPath, buffer.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write script pack: %w", err)
+	}
+
+	log.Info().
+		Str("path", packPath).
+		Str("name", manifest.Name).
+		Int("fileCount", len(manifest.Files)).
+		Bool("signed", privateKey != nil).
+		Msg("Built script pack")
+	return nil
+}
+
+// GenerateSigningKey creates an ed25519 key pair for signing script packs, both base64 encoded. The private key
+// is the 32-byte seed.
+func GenerateSigningKey() (publicKey, privateKey string, err error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(public), base64.StdEncoding.EncodeToString(private.Seed()), nil
+}
+
+// ParseSigningKey decodes a private key written by GenerateSigningKey.
+func ParseSigningKey(privateKey string) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(privateKey))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("not a base64 encoded ed25519 private key")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
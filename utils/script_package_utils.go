@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"wci/lua"
+)
+
+// PackageEntryFile is the entry module of a script package: a directory of Lua modules injected as one script.
+// The entry module carries the script header and is placed like a single-file script; the modules it requires
+// are copied into the folder wci/<package>/ next to the target file.
+const PackageEntryFile = "init.lua"
+
+// attributePart marks a block carrying a module of a script package and records the module name.
+const attributePart = "part"
+
+var (
+	// ErrModuleNotFound is returned when a package module requires a module that does not exist.
+	ErrModuleNotFound = errors.New("module not found")
+	// ErrModuleCycle is returned when the modules of a package require each other in a circle.
+	ErrModuleCycle = errors.New("circular module require")
+)
+
+// factorioLibraryModules lists the modules of Factorio's core lualib that scripts may require without shipping them.
+var factorioLibraryModules = map[string]bool{
+	"util": true, "mod-gui": true, "event_handler": true, "math2d": true, "story": true, "silo-script": true,
+	"production-score": true, "flying_text": true, "camera": true, "noise": true, "dataloader": true,
+	"crash-site": true, "bonus-gui-ordering": true, "kill-score": true,
+}
+
+// ScriptModule is a module of a script package other than its entry module.
+type ScriptModule struct {
+	Name string // require name inside the package, e.g. "gui" or "ui.buttons"
+	Path string // path relative to the package directory, e.g. "ui/buttons.lua"
+	Code []byte // module code with the requires of package modules rewritten
+}
+
+// requireCall is a require of a constant module name in Lua source code.
+type requireCall struct {
+	Module     string
+	Line       int
+	Start, End int // byte offsets of the string literal naming the module
+}
+
+// IsPackageEntry reports whether a script path names the entry module of a script package.
+func IsPackageEntry(scriptPath string) bool {
+	return path.Base(scriptPath) == PackageEntryFile && path.Dir(scriptPath) != "."
+}
+
+// scriptDir returns the directory a script is found in: its own directory, or that of its package.
+func scriptDir(scriptPath string) string {
+	if IsPackageEntry(scriptPath) {
+		return path.Dir(path.Dir(scriptPath))
+	}
+	return path.Dir(scriptPath)
+}
+
+// packageModuleName returns the require name of a package module once it is injected (e.g. "wci.radar.gui").
+func packageModuleName(packageName, moduleName string) string {
+	return injectedModuleName(packageName) + "." + moduleName
+}
+
+// packageModulePath returns the path inside the ZIP of a package module injected next to targetPath.
+func packageModulePath(targetPath, packageName string, module ScriptModule) string {
+	return path.Join(path.Dir(targetPath), injectedModuleDir, packageName, module.Path)
+}
+
+// normalizeModuleName turns a require argument into a dotted module name: "ui/buttons.lua" -> "ui.buttons".
+func normalizeModuleName(name string) string {
+	return strings.ReplaceAll(strings.TrimSuffix(name, ".lua"), "/", ".")
+}
+
+// isExternalModule reports whether a module is provided by Factorio, a mod or an injected script rather than by
+// the package: core lualib modules, "__mod__/..." paths and modules of the wci folder.
+func isExternalModule(name string) bool {
+	return factorioLibraryModules[name] || strings.HasPrefix(name, "__") || strings.HasPrefix(name, injectedModuleDir+".")
+}
+
+// findRequires lists the require calls with a constant module name in Lua source code.
+func findRequires(file, source string) ([]requireCall, error) {
+	tokens, err := codeTokens(file, source)
+	if err != nil {
+		return nil, err
+	}
+
+	var calls []requireCall
+	for i, token := range tokens {
+		if token.Type != lua.TokenName || token.Text != "require" {
+			continue
+		}
+		if i > 0 && (tokens[i-1].Text == "." || tokens[i-1].Text == ":") {
+			continue
+		}
+		next := i + 1
+		if tokens[next].Text == "(" {
+			next++
+		}
+		if tokens[next].Type != lua.TokenString {
+			continue
+		}
+		calls = append(calls, requireCall{
+			Module: normalizeModuleName(tokens[next].Value),
+			Line:   token.Pos.Line,
+			Start:  tokens[next].Pos.Offset,
+			End:    tokens[next].End,
+		})
+	}
+	return calls, nil
+}
+
+// LoadScriptPackage resolves the modules the entry module of a package requires, directly or through other
+// modules, from the package directory. Requires of modules outside the package (see isExternalModule) are left
+// alone; a module that is neither in the package nor external fails with ErrModuleNotFound, modules requiring
+// each other in a circle fail with ErrModuleCycle. The entry code and the modules are returned with their
+// requires of package modules rewritten to the names the modules get inside the savegame.
+func LoadScriptPackage(fileSystem fs.FS, entryPath string, entryCode []byte) ([]byte, []ScriptModule, error) {
+	packageDir := path.Dir(entryPath)
+	packageName := path.Base(packageDir)
+	entryName := normalizeModuleName(PackageEntryFile)
+
+	modules := make(map[string]*ScriptModule)
+	state := make(map[string]int) // 1 while the module's requires are resolved, 2 once they are
+	var stack []string
+
+	var visit func(name, file string, code []byte) error
+	visit = func(name, file string, code []byte) error {
+		state[name] = 1
+		stack = append(stack, name)
+		calls, err := findRequires(file, string(code))
+		if err != nil {
+			return fmt.Errorf("package '%s': %w", packageName, err)
+		}
+
+		for _, call := range calls {
+			if isExternalModule(call.Module) {
+				continue
+			}
+			switch state[call.Module] {
+			case 1:
+				cycle := append(append([]string{}, stack[indexOf(stack, call.Module):]...), call.Module)
+				return fmt.Errorf("%w in package '%s': %s", ErrModuleCycle, packageName, strings.Join(cycle, " -> "))
+			case 2:
+				continue
+			}
+
+			modulePath := strings.ReplaceAll(call.Module, ".", "/") + ".lua"
+			moduleCode, err := fs.ReadFile(fileSystem, path.Join(packageDir, modulePath))
+			if errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("%w: %s:%d of package '%s' requires '%s', which is neither in the package nor a Factorio library",
+					ErrModuleNotFound, file, call.Line, packageName, call.Module)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read module '%s' of package '%s': %w", modulePath, packageName, err)
+			}
+			modules[call.Module] = &ScriptModule{Name: call.Module, Path: modulePath, Code: moduleCode}
+			if err := visit(call.Module, modulePath, moduleCode); err != nil {
+				return err
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[name] = 2
+		return nil
+	}
+	if err := visit(entryName, PackageEntryFile, entryCode); err != nil {
+		return nil, nil, err
+	}
+
+	// Every module, the entry included, requires the package modules by their names inside the savegame
+	rewrittenEntry, err := rewriteModuleRequires(PackageEntryFile, string(entryCode), packageName, modules)
+	if err != nil {
+		return nil, nil, err
+	}
+	resolved := make([]ScriptModule, 0, len(modules))
+	for _, name := range sortedKeys(modules) {
+		module := *modules[name]
+		code, err := rewriteModuleRequires(module.Path, string(module.Code), packageName, modules)
+		if err != nil {
+			return nil, nil, err
+		}
+		module.Code = []byte(code)
+		resolved = append(resolved, module)
+	}
+	sort.Slice(resolved, func(i, j int) bool { return resolved[i].Path < resolved[j].Path })
+	return []byte(rewrittenEntry), resolved, nil
+}
+
+// rewriteModuleRequires replaces the names of package modules in the require calls of source.
+func rewriteModuleRequires(file, source, packageName string, modules map[string]*ScriptModule) (string, error) {
+	calls, err := findRequires(file, source)
+	if err != nil {
+		return "", err
+	}
+	for i := len(calls) - 1; i >= 0; i-- {
+		call := calls[i]
+		if _, local := modules[call.Module]; !local {
+			continue
+		}
+		source = source[:call.Start] + strconv.Quote(packageModuleName(packageName, call.Module)) + source[call.End:]
+	}
+	return source, nil
+}
+
+// planPackageModules adds a file for every module of a package injected next to targetPath to changes. Each file
+// holds one marker block, so removing or upgrading the package finds its modules like its other blocks.
+func planPackageModules(luaFiles map[string][]byte, targetPath string, injection ScriptInjection, changes ZipChanges) error {
+	for _, module := range injection.Modules {
+		modulePath := packageModulePath(targetPath, injection.Name, module)
+		if _, taken := luaFiles[modulePath]; taken {
+			return fmt.Errorf("module file '%s' already exists in ZIP", modulePath)
+		}
+		changes.Modified[modulePath] = []byte(buildModuleBlock(injection.Name, injection.Version, module))
+	}
+	return nil
+}
+
+// buildModuleBlock wraps a package module in the marker block that carries it.
+func buildModuleBlock(packageName, version string, module ScriptModule) string {
+	return BuildInjectionBlock(packageName, version, string(module.Code), map[string]string{attributePart: module.Name})
+}
+
+// indexOf returns the index of value in values, or -1.
+func indexOf(values []string, value string) int {
+	for i, candidate := range values {
+		if candidate == value {
+			return i
+		}
+	}
+	return -1
+}
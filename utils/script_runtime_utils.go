@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"wci/embedded"
+)
+
+// scriptRuntimeTag is the header tag a script declares to use the wci runtime.
+const scriptRuntimeTag = "runtime"
+
+// scriptRuntimeFile names the runtime in lint findings and syntax errors.
+const scriptRuntimeFile = "wci_runtime.lua"
+
+// UsesScriptRuntime reports whether a script declares "-- @runtime" in its header.
+func UsesScriptRuntime(code []byte) bool {
+	for _, line := range strings.Split(string(code), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		if match := scriptTagPattern.FindStringSubmatch(line); match != nil && match[1] == scriptRuntimeTag {
+			return true
+		}
+	}
+	return false
+}
+
+// withScriptRuntime places the wci runtime in front of the rendered code of a script that declares "-- @runtime",
+// bound to the name and version the script is injected with. The runtime gives the script the local table wci,
+// whose wci.data() sets up and migrates the state of the script in storage.wci[name]; see
+// embedded/lua_runtime/wci_runtime.lua. Code of other scripts is returned as it is.
+func withScriptRuntime(name, version, code string) string {
+	if !UsesScriptRuntime([]byte(code)) {
+		return code
+	}
+	return fmt.Sprintf("local wci = (function(...)\n%s\nend)(%q, %q)\n%s",
+		strings.TrimRight(embedded.LuaRuntime, "\n"), name, version, code)
+}
+
+// scriptRuntimeLine maps a line of code returned by withScriptRuntime back to the file it came from: the script for
+// lines after the runtimeLines lines in front of it, scriptRuntimeFile for the others.
+func scriptRuntimeLine(file string, line, runtimeLines int) (string, int) {
+	if line > runtimeLines {
+		return file, line - runtimeLines
+	}
+	// The first line opens the function the runtime runs in
+	return scriptRuntimeFile, max(line-1, 1)
+}
@@ -0,0 +1,430 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// ParamType is the type of a script parameter declared with "-- @param".
+type ParamType string
+
+const (
+	ParamString ParamType = "string"
+	ParamInt    ParamType = "int"
+	ParamNumber ParamType = "number"
+	ParamBool   ParamType = "bool"
+)
+
+// attributeParams records the parameter values a script was rendered with, as base64 encoded JSON.
+const attributeParams = "params"
+
+// ErrInvalidParam is returned for unknown parameters, values of the wrong type and missing required values.
+var ErrInvalidParam = errors.New("invalid script parameter")
+
+var scriptParamPattern = regexp.MustCompile(`^--\s*@param\s+([A-Za-z_][A-Za-z0-9_]*):([A-Za-z]+)(?:(=)("(?:[^"\\]|\\.)*"|\S*))?(?:\s+(.*?))?\s*$`)
+
+// ScriptParam is a parameter a script declares in its header with
+// "-- @param <name>:<type>[=<default>] <description>". A parameter without default is required.
+type ScriptParam struct {
+	Name        string    `json:"name"`
+	Type        ParamType `json:"type"`
+	Default     any       `json:"default,omitempty"` // typed default value, nil for required parameters
+	Description string    `json:"description,omitempty"`
+}
+
+// Required reports whether a value must be given for the parameter.
+func (p ScriptParam) Required() bool {
+	return p.Default == nil
+}
+
+// ParseScriptParams reads the "-- @param" header lines of a script. String defaults containing spaces are
+// written as Go quoted strings, e.g. -- @param greeting:string="Hello there" Message shown on start.
+func ParseScriptParams(code []byte) ([]ScriptParam, error) {
+	var params []ScriptParam
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(string(code), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		if !strings.HasPrefix(strings.TrimSpace(strings.TrimPrefix(line, "--")), "@param") {
+			continue
+		}
+
+		match := scriptParamPattern.FindStringSubmatch(line)
+		if match == nil {
+			return nil, fmt.Errorf("malformed parameter declaration '%s'", line)
+		}
+		param := ScriptParam{Name: match[1], Type: ParamType(match[2]), Description: match[5]}
+		switch param.Type {
+		case ParamString, ParamInt, ParamNumber, ParamBool:
+		default:
+			return nil, fmt.Errorf("parameter '%s' has unknown type '%s' (supported: string, int, number, bool)", param.Name, match[2])
+		}
+		if seen[param.Name] {
+			return nil, fmt.Errorf("parameter '%s' is declared more than once", param.Name)
+		}
+		seen[param.Name] = true
+
+		if match[3] == "=" {
+			raw := match[4]
+			if strings.HasPrefix(raw, `"`) {
+				unquoted, err := strconv.Unquote(raw)
+				if err != nil {
+					return nil, fmt.Errorf("default of parameter '%s' is not a valid quoted string: %w", param.Name, err)
+				}
+				raw = unquoted
+			}
+			value, err := coerceParamValue(param, raw)
+			if err != nil {
+				return nil, fmt.Errorf("default of parameter '%s': %w", param.Name, err)
+			}
+			param.Default = value
+		}
+		params = append(params, param)
+	}
+	return params, nil
+}
+
+// ParseParamAssignments converts "key=value" assignments, as given with --set, into parameter values.
+// Keys may be qualified with a script name ("biter_killer.radius") to address a single script.
+func ParseParamAssignments(assignments []string) (map[string]any, error) {
+	values := make(map[string]any, len(assignments))
+	for _, assignment := range assignments {
+		key, value, found := strings.Cut(assignment, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid parameter '%s', expected key=value", assignment)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// LoadParamValues reads parameter values from a YAML file. Top-level keys set parameters of every script, a
+// top-level map named after a script sets the parameters of that script only:
+//
+//	radius: 500
+//	biter_killer:
+//	  command: purge
+func LoadParamValues(valuesPath string) (map[string]any, error) {
+	data, err := os.ReadFile(valuesPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read values file '%s': %w", valuesPath, err)
+	}
+	var document map[string]any
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse values file '%s': %w", valuesPath, err)
+	}
+
+	values := make(map[string]any, len(document))
+	for key, value := range document {
+		nested, isMap := value.(map[string]any)
+		if !isMap {
+			values[key] = value
+			continue
+		}
+		for nestedKey, nestedValue := range nested {
+			if _, tooDeep := nestedValue.(map[string]any); tooDeep {
+				return nil, fmt.Errorf("values file '%s': '%s.%s' is nested too deeply", valuesPath, key, nestedKey)
+			}
+			values[key+"."+nestedKey] = nestedValue
+		}
+	}
+	return values, nil
+}
+
+// ResolveScriptParams checks the given parameter values against the parameters the scripts declare and returns
+// the typed values for each script by name. Unqualified keys apply to every script declaring them, keys
+// qualified with a script name to that script only; the qualified value wins. Unknown keys and values of the
+// wrong type fail with ErrInvalidParam; missing required values are reported when the script is rendered.
+func ResolveScriptParams(scripts []ScriptFile, values map[string]any) (map[string]map[string]any, error) {
+	resolved := make(map[string]map[string]any)
+	declaredBy := make(map[string][]string)
+	byScript := make(map[string]map[string]ScriptParam)
+	for _, script := range scripts {
+		byScript[script.Name] = make(map[string]ScriptParam, len(script.Params))
+		for _, param := range script.Params {
+			byScript[script.Name][param.Name] = param
+			declaredBy[param.Name] = append(declaredBy[param.Name], script.Name)
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	// Unqualified keys first, so qualified values override them
+	sort.Slice(keys, func(i, j int) bool {
+		qualifiedI, qualifiedJ := strings.Contains(keys[i], "."), strings.Contains(keys[j], ".")
+		if qualifiedI != qualifiedJ {
+			return !qualifiedI
+		}
+		return keys[i] < keys[j]
+	})
+
+	set := func(scriptName string, param ScriptParam, value any) error {
+		typed, err := coerceParamValue(param, value)
+		if err != nil {
+			return fmt.Errorf("%w: '%s' of script '%s': %v", ErrInvalidParam, param.Name, scriptName, err)
+		}
+		if resolved[scriptName] == nil {
+			resolved[scriptName] = make(map[string]any)
+		}
+		resolved[scriptName][param.Name] = typed
+		return nil
+	}
+
+	for _, key := range keys {
+		scriptName, paramName, qualified := strings.Cut(key, ".")
+		if !qualified {
+			paramName = key
+			if len(declaredBy[paramName]) == 0 {
+				return nil, fmt.Errorf("%w: no selected script declares '%s'", ErrInvalidParam, paramName)
+			}
+			for _, name := range declaredBy[paramName] {
+				if err := set(name, byScript[name][paramName], values[key]); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		params, selected := byScript[scriptName]
+		if !selected {
+			return nil, fmt.Errorf("%w: '%s' addresses script '%s', which is not selected", ErrInvalidParam, key, scriptName)
+		}
+		param, declared := params[paramName]
+		if !declared {
+			return nil, fmt.Errorf("%w: script '%s' does not declare '%s'%s", ErrInvalidParam, scriptName, paramName, declaredParamList(params))
+		}
+		if err := set(scriptName, param, values[key]); err != nil {
+			return nil, err
+		}
+	}
+
+	return resolved, nil
+}
+
+// declaredParamList formats the parameters of a script for error messages.
+func declaredParamList(params map[string]ScriptParam) string {
+	if len(params) == 0 {
+		return " (it has no parameters)"
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return " (declared: " + strings.Join(names, ", ") + ")"
+}
+
+// RenderScriptTemplate renders a script that declares parameters as a Go text/template. The template data maps
+// every parameter name to its value, e.g. {{ .radius }}, and {{ lua .command }} writes a value as a Lua literal.
+// Values missing from values take the declared default. Scripts without parameters are returned unchanged, so
+// plain Lua code never has to escape "{{".
+func RenderScriptTemplate(name, code string, values map[string]any) (string, error) {
+	params, err := ParseScriptParams([]byte(code))
+	if err != nil {
+		return "", fmt.Errorf("script '%s': %w", name, err)
+	}
+	if len(params) == 0 {
+		if len(values) > 0 {
+			return "", fmt.Errorf("%w: script '%s' has no parameters", ErrInvalidParam, name)
+		}
+		return code, nil
+	}
+
+	data := make(map[string]any, len(params))
+	declared := make(map[string]ScriptParam, len(params))
+	for _, param := range params {
+		declared[param.Name] = param
+		if param.Default != nil {
+			data[param.Name] = param.Default
+		}
+	}
+	for key, value := range values {
+		param, ok := declared[key]
+		if !ok {
+			return "", fmt.Errorf("%w: script '%s' does not declare '%s'%s", ErrInvalidParam, name, key, declaredParamList(declared))
+		}
+		typed, err := coerceParamValue(param, value)
+		if err != nil {
+			return "", fmt.Errorf("%w: '%s' of script '%s': %v", ErrInvalidParam, key, name, err)
+		}
+		data[key] = typed
+	}
+	for _, param := range params {
+		if _, ok := data[param.Name]; !ok {
+			return "", fmt.Errorf("%w: script '%s' requires a value for '%s'", ErrInvalidParam, name, param.Name)
+		}
+	}
+
+	tmpl, err := template.New(name + ".lua").
+		Option("missingkey=error").
+		Funcs(template.FuncMap{"lua": luaLiteral}).
+		Parse(code)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template of script '%s': %w", name, err)
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", fmt.Errorf("failed to render script '%s': %w", name, err)
+	}
+
+	log.Debug().
+		Str("script", name).
+		Int("paramCount", len(params)).
+		Int("setCount", len(values)).
+		Msg("Rendered script template")
+	return rendered.String(), nil
+}
+
+// coerceParamValue converts a value into the type of a parameter. Strings, as given with --set, are parsed;
+// other values must already have a matching type.
+func coerceParamValue(param ScriptParam, value any) (any, error) {
+	text, isText := value.(string)
+	switch param.Type {
+	case ParamString:
+		if !isText {
+			return nil, fmt.Errorf("expected a string, got %v", value)
+		}
+		return text, nil
+
+	case ParamBool:
+		if isText {
+			parsed, err := strconv.ParseBool(text)
+			if err != nil {
+				return nil, fmt.Errorf("expected a bool, got '%s'", text)
+			}
+			return parsed, nil
+		}
+		if parsed, ok := value.(bool); ok {
+			return parsed, nil
+		}
+		return nil, fmt.Errorf("expected a bool, got %v", value)
+
+	case ParamInt:
+		if isText {
+			parsed, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("expected an int, got '%s'", text)
+			}
+			return parsed, nil
+		}
+		number, ok := numericValue(value)
+		if !ok || number != math.Trunc(number) || math.Abs(number) > 1<<53 {
+			return nil, fmt.Errorf("expected an int, got %v", value)
+		}
+		return int64(number), nil
+
+	case ParamNumber:
+		if isText {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+			if err != nil || math.IsInf(parsed, 0) || math.IsNaN(parsed) {
+				return nil, fmt.Errorf("expected a number, got '%s'", text)
+			}
+			return parsed, nil
+		}
+		number, ok := numericValue(value)
+		if !ok {
+			return nil, fmt.Errorf("expected a number, got %v", value)
+		}
+		return number, nil
+	}
+	return nil, fmt.Errorf("unknown parameter type '%s'", param.Type)
+}
+
+// numericValue returns the value of the number types produced by YAML and JSON decoding.
+func numericValue(value any) (float64, bool) {
+	switch number := value.(type) {
+	case int:
+		return float64(number), true
+	case int64:
+		return float64(number), true
+	case uint64:
+		return float64(number), true
+	case float64:
+		return number, true
+	case json.Number:
+		parsed, err := number.Float64()
+		return parsed, err == nil
+	}
+	return 0, false
+}
+
+// luaLiteral writes a parameter value as a Lua literal. Strings are quoted with decimal escapes for control
+// characters, which Lua 5.2 understands.
+func luaLiteral(value any) (string, error) {
+	switch typed := value.(type) {
+	case string:
+		var quoted strings.Builder
+		quoted.WriteByte('"')
+		for i := 0; i < len(typed); i++ {
+			switch c := typed[i]; {
+			case c == '"' || c == '\\':
+				quoted.WriteByte('\\')
+				quoted.WriteByte(c)
+			case c == '\n':
+				quoted.WriteString(`\n`)
+			case c < 0x20 || c == 0x7f:
+				fmt.Fprintf(&quoted, `\%03d`, c)
+			default:
+				quoted.WriteByte(c)
+			}
+		}
+		quoted.WriteByte('"')
+		return quoted.String(), nil
+	case bool:
+		return strconv.FormatBool(typed), nil
+	case int64:
+		return strconv.FormatInt(typed, 10), nil
+	case float64:
+		return strconv.FormatFloat(typed, 'g', -1, 64), nil
+	}
+	return "", fmt.Errorf("cannot write %v as a Lua literal", value)
+}
+
+// formatParamsAttribute encodes the parameter values a script was rendered with for its BEGIN marker.
+func formatParamsAttribute(values map[string]any) (string, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode script parameters: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// ParseParamsAttribute decodes the parameter values recorded in a BEGIN marker. Numbers are returned as
+// json.Number, which RenderScriptTemplate converts to the declared type.
+func ParseParamsAttribute(encoded string) (map[string]any, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s attribute: %w", attributeParams, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var values map[string]any
+	if err := decoder.Decode(&values); err != nil {
+		return nil, fmt.Errorf("invalid %s attribute: %w", attributeParams, err)
+	}
+	return values, nil
+}
@@ -0,0 +1,220 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// scriptVariantPattern matches the name of a script variant for a Factorio version, without the extension,
+// e.g. "biter_killer.2.0".
+var scriptVariantPattern = regexp.MustCompile(`^(.+?)\.([0-9]+(?:\.[0-9]+)*)$`)
+
+// ErrNoScriptVariant is returned when no variant of a script supports the Factorio version of a savegame.
+var ErrNoScriptVariant = errors.New("no variant of the script supports the savegame's Factorio version")
+
+// ScriptVariant is one file of a script that ships several variants, e.g. biter_killer.1.1.lua and
+// biter_killer.2.0.lua, or a package directory radar.2.0/.
+type ScriptVariant struct {
+	Path     string `json:"path"`               // path of the variant inside its file system
+	Factorio string `json:"factorio,omitempty"` // supported Factorio versions, empty for any
+}
+
+// splitScriptVariant splits a script name as taken from its file into the script name and the Factorio
+// version of the variant, e.g. "biter_killer.2.0" into "biter_killer" and "2.0". Names without a version are
+// returned as they are.
+func splitScriptVariant(name string) (string, string) {
+	if match := scriptVariantPattern.FindStringSubmatch(name); match != nil {
+		return match[1], match[2]
+	}
+	return name, ""
+}
+
+// scriptVariantVersion returns the Factorio version in the file name of a script variant, "" for a plain script.
+func scriptVariantVersion(scriptPath string) string {
+	_, version := splitScriptVariant(scriptFileBase(scriptPath))
+	return version
+}
+
+// newScriptVariant returns the variant of a script: the versions it declares with "-- @factorio", or else the
+// version in its file name.
+func newScriptVariant(metadata ScriptMetadata) ScriptVariant {
+	variant := ScriptVariant{Path: metadata.Path, Factorio: metadata.Factorio}
+	if variant.Factorio == "" {
+		variant.Factorio = scriptVariantVersion(metadata.Path)
+	}
+	return variant
+}
+
+// groupScriptVariants merges the scripts of one directory that are variants of the same script into one entry,
+// which lists them as Variants. The entry describes the plain file, or the variant of the newest version when
+// there is no plain file. Scripts without versioned files are left as they are.
+func groupScriptVariants(scripts []ScriptMetadata) []ScriptMetadata {
+	groups := make(map[string][]ScriptMetadata)
+	for _, script := range scripts {
+		groups[script.Name] = append(groups[script.Name], script)
+	}
+
+	var grouped []ScriptMetadata
+	for _, script := range scripts {
+		group, pending := groups[script.Name]
+		if !pending {
+			continue
+		}
+		delete(groups, script.Name)
+
+		hasVariants := false
+		for _, member := range group {
+			hasVariants = hasVariants || scriptVariantVersion(member.Path) != ""
+		}
+		if !hasVariants {
+			grouped = append(grouped, group...)
+			continue
+		}
+
+		sortScriptVariants(group)
+		merged := group[len(group)-1]
+		if scriptVariantVersion(group[0].Path) == "" {
+			merged = group[0]
+		}
+		merged.Variants = nil
+		for _, member := range group {
+			merged.Variants = append(merged.Variants, newScriptVariant(member))
+		}
+		grouped = append(grouped, merged)
+	}
+	return grouped
+}
+
+// sortScriptVariants sorts the files of a script by the version in their name, the plain file first.
+func sortScriptVariants(scripts []ScriptMetadata) {
+	sort.SliceStable(scripts, func(i, j int) bool {
+		return CompareVersions(scriptVariantVersion(scripts[i].Path), scriptVariantVersion(scripts[j].Path)) < 0
+	})
+}
+
+// SelectScriptVariant returns the file of a script to inject into a savegame written by the given Factorio
+// version. The variants of the script are the files next to scriptFileName that carry its name, plain or with a
+// version ("biter_killer.lua", "biter_killer.1.1.lua", "biter_killer.2.0/init.lua"). A variant supports the
+// versions it declares with "-- @factorio", or else the version in its file name; a plain file without
+// "-- @factorio" supports every version. Of the variants supporting the version, a versioned file wins over the
+// plain one and a newer version over an older one.
+//
+// If the savegame's version is unknown (""), the plain file or the only variant is used. If no variant fits,
+// the error wraps ErrNoScriptVariant and lists the versions the script supports. A script without any file is
+// returned as given, so loading it reports the missing file.
+func SelectScriptVariant(fileSystem fs.FS, scriptFileName, factorio string) (string, error) {
+	name := ScriptNameFromFile(scriptFileName)
+	variants, err := loadScriptVariants(fileSystem, scriptDir(scriptFileName), name)
+	if err != nil {
+		return "", err
+	}
+	if len(variants) == 0 {
+		return scriptFileName, nil
+	}
+
+	var selected *ScriptVariant
+	if factorio == "" {
+		for i := range variants {
+			if scriptVariantVersion(variants[i].Path) == "" {
+				selected = &variants[i]
+			}
+		}
+		if selected == nil && len(variants) == 1 {
+			selected = &variants[0]
+		}
+		if selected == nil {
+			return "", fmt.Errorf("%w: script '%s' has variants for Factorio %s and the savegame does not tell its version",
+				ErrNoScriptVariant, name, describeScriptVariants(variants))
+		}
+	} else {
+		// Variants are sorted by the version in their name, so the last match is the most specific one
+		for i := range variants {
+			versions, err := ParseVersionRange(variants[i].Factorio)
+			if err != nil {
+				return "", fmt.Errorf("script '%s': %w", variants[i].Path, err)
+			}
+			if versions.Contains(factorio) {
+				selected = &variants[i]
+			}
+		}
+		if selected == nil {
+			return "", fmt.Errorf("%w: script '%s' supports Factorio %s, the savegame was written by %s",
+				ErrNoScriptVariant, name, describeScriptVariants(variants), factorio)
+		}
+	}
+
+	if len(variants) > 1 {
+		log.Debug().
+			Str("script", name).
+			Str("factorio", factorio).
+			Str("variant", selected.Path).
+			Msg("Selected script variant for the savegame")
+	}
+	return selected.Path, nil
+}
+
+// loadScriptVariants reads the variants of the named script in a directory of a file system, sorted by the version
+// in their file name. A file system that cannot list the directory has no variants.
+func loadScriptVariants(fileSystem fs.FS, dir, name string) ([]ScriptVariant, error) {
+	entries, err := fs.ReadDir(fileSystem, dir)
+	if err != nil {
+		return nil, nil
+	}
+
+	var scripts []ScriptMetadata
+	for _, entry := range entries {
+		scriptPath := path.Join(dir, entry.Name())
+		if entry.IsDir() {
+			scriptPath = path.Join(scriptPath, PackageEntryFile)
+		} else if !IsLuaFile(entry.Name()) {
+			continue
+		}
+		if ScriptNameFromFile(scriptPath) != name {
+			continue
+		}
+		code, err := fs.ReadFile(fileSystem, scriptPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // a directory without init.lua
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read script '%s': %w", scriptPath, err)
+		}
+		metadata, err := ParseScriptMetadata(scriptPath, code)
+		if err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, metadata)
+	}
+	sortScriptVariants(scripts)
+
+	variants := make([]ScriptVariant, 0, len(scripts))
+	for _, script := range scripts {
+		variants = append(variants, newScriptVariant(script))
+	}
+	return variants, nil
+}
+
+// describeScriptVariants lists the versions the variants support for messages, e.g.
+// "1.1 (biter_killer.1.1.lua), 2.0 (biter_killer.2.0.lua)".
+func describeScriptVariants(variants []ScriptVariant) string {
+	descriptions := make([]string, 0, len(variants))
+	for _, variant := range variants {
+		versions := variant.Factorio
+		if versions == "" {
+			versions = "any version"
+		}
+		if len(variants) == 1 {
+			descriptions = append(descriptions, versions)
+		} else {
+			descriptions = append(descriptions, fmt.Sprintf("%s (%s)", versions, variant.Path))
+		}
+	}
+	return strings.Join(descriptions, ", ")
+}
@@ -0,0 +1,496 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// devNull is the path a unified diff uses for the missing side of a created or deleted file.
+const devNull = "/dev/null"
+
+// ErrPatchRejected is returned when a hunk of a patch cannot be applied.
+var ErrPatchRejected = errors.New("patch does not apply")
+
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// PatchLine is one line of a hunk. Op is ' ' for context, '-' for a removed and '+' for an added line.
+type PatchLine struct {
+	Op        byte
+	Text      string // line content without the line break
+	NoNewline bool   // the line is the last of its file and has no line break
+}
+
+// Hunk is a block of changes of a unified diff.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []PatchLine
+}
+
+// FilePatch holds the hunks a unified diff applies to one file.
+type FilePatch struct {
+	OldPath string
+	NewPath string
+	Hunks   []Hunk
+}
+
+// HunkResult describes where a hunk was applied.
+type HunkResult struct {
+	Line   int // line of the target file the hunk was applied at
+	Offset int // lines between the position in the hunk header and the actual position
+	Fuzz   int // context lines ignored at each end of the hunk
+}
+
+// IsCreation reports whether the patch creates a new file.
+func (p FilePatch) IsCreation() bool {
+	return p.OldPath == devNull
+}
+
+// IsDeletion reports whether the patch deletes a file.
+func (p FilePatch) IsDeletion() bool {
+	return p.NewPath == devNull
+}
+
+// Path returns the path of the file the patch applies to.
+func (p FilePatch) Path() string {
+	if p.IsCreation() {
+		return p.NewPath
+	}
+	return p.OldPath
+}
+
+// Reverse returns the patch that undoes p.
+func (p FilePatch) Reverse() FilePatch {
+	reversed := FilePatch{OldPath: p.NewPath, NewPath: p.OldPath}
+	for _, hunk := range p.Hunks {
+		lines := make([]PatchLine, len(hunk.Lines))
+		for i, line := range hunk.Lines {
+			lines[i] = line
+			switch line.Op {
+			case '-':
+				lines[i].Op = '+'
+			case '+':
+				lines[i].Op = '-'
+			}
+		}
+		reversed.Hunks = append(reversed.Hunks, Hunk{
+			OldStart: hunk.NewStart,
+			OldLines: hunk.NewLines,
+			NewStart: hunk.OldStart,
+			NewLines: hunk.OldLines,
+			Lines:    lines,
+		})
+	}
+	return reversed
+}
+
+// ParsePatch reads the file patches of a unified diff as written by diff -u or git diff.
+// Lines outside of file headers and hunks, such as "diff --git" or "index" lines, are ignored.
+func ParsePatch(data []byte) ([]FilePatch, error) {
+	lines := strings.Split(strings.TrimSuffix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n"), "\n")
+
+	var patches []FilePatch
+	for i := 0; i < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], "--- ") || i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "+++ ") {
+			continue
+		}
+		patch := FilePatch{OldPath: patchPath(lines[i][4:], "a/"), NewPath: patchPath(lines[i+1][4:], "b/")}
+		i += 2
+
+		for i < len(lines) && strings.HasPrefix(lines[i], "@@") {
+			hunk, next, err := parseHunk(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			patch.Hunks = append(patch.Hunks, hunk)
+			i = next
+		}
+		if len(patch.Hunks) == 0 {
+			return nil, fmt.Errorf("patch for '%s' has no hunks", patch.Path())
+		}
+		patches = append(patches, patch)
+		i--
+	}
+
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("no unified diff found")
+	}
+	return patches, nil
+}
+
+// patchPath extracts the file path of a ---/+++ header line, dropping a timestamp and the git side prefix.
+func patchPath(header, prefix string) string {
+	name, _, _ := strings.Cut(header, "\t")
+	name = strings.TrimSpace(name)
+	if name == devNull {
+		return name
+	}
+	return strings.TrimPrefix(name, prefix)
+}
+
+// parseHunk reads the hunk starting at lines[start] and returns it with the index of the line following it.
+func parseHunk(lines []string, start int) (Hunk, int, error) {
+	match := hunkHeaderPattern.FindStringSubmatch(lines[start])
+	if match == nil {
+		return Hunk{}, 0, fmt.Errorf("line %d: malformed hunk header '%s'", start+1, lines[start])
+	}
+	count := func(value string) int {
+		if value == "" {
+			return 1
+		}
+		n, _ := strconv.Atoi(value)
+		return n
+	}
+	hunk := Hunk{
+		OldStart: count(match[1]),
+		OldLines: count(match[2]),
+		NewStart: count(match[3]),
+		NewLines: count(match[4]),
+	}
+
+	oldSeen, newSeen := 0, 0
+	i := start + 1
+	for ; i < len(lines) && (oldSeen < hunk.OldLines || newSeen < hunk.NewLines); i++ {
+		line := lines[i]
+		if line == "" {
+			// Some editors strip the single space of empty context lines
+			line = " "
+		}
+		switch line[0] {
+		case ' ':
+			oldSeen++
+			newSeen++
+		case '-':
+			oldSeen++
+		case '+':
+			newSeen++
+		case '\\':
+			if len(hunk.Lines) > 0 {
+				hunk.Lines[len(hunk.Lines)-1].NoNewline = true
+			}
+			continue
+		default:
+			return Hunk{}, 0, fmt.Errorf("line %d: unexpected line in hunk '%s'", i+1, line)
+		}
+		hunk.Lines = append(hunk.Lines, PatchLine{Op: line[0], Text: line[1:]})
+	}
+	if oldSeen != hunk.OldLines || newSeen != hunk.NewLines {
+		return Hunk{}, 0, fmt.Errorf("line %d: hunk is shorter than its header '%s'", start+1, lines[start])
+	}
+
+	// A missing line break on the last line is noted right after the hunk
+	if i < len(lines) && strings.HasPrefix(lines[i], "\\") {
+		hunk.Lines[len(hunk.Lines)-1].NoNewline = true
+		i++
+	}
+	return hunk, i, nil
+}
+
+// ApplyPatch applies the hunks of a file patch to content. Each hunk is first looked for at the line given in its
+// header, then at growing offsets from it. When the context does not match anywhere, up to fuzz context lines are
+// ignored at each end of the hunk. Hunks must apply in order and must not overlap.
+func ApplyPatch(content []byte, patch FilePatch, fuzz int) ([]byte, []HunkResult, error) {
+	lines := splitLines(string(content))
+	var output strings.Builder
+	var results []HunkResult
+
+	position, offset := 0, 0
+	for index, hunk := range patch.Hunks {
+		result, start, skip, ok := locateHunk(lines, hunk, position, offset, fuzz)
+		if !ok {
+			return nil, nil, fmt.Errorf("hunk #%d (line %d) of '%s': %w", index+1, hunk.OldStart, patch.Path(), ErrPatchRejected)
+		}
+
+		for _, line := range lines[position:start] {
+			output.WriteString(line)
+		}
+		fileLine := start
+		for _, line := range hunk.Lines[skip[0] : len(hunk.Lines)-skip[1]] {
+			switch line.Op {
+			case ' ':
+				output.WriteString(lines[fileLine])
+				fileLine++
+			case '-':
+				fileLine++
+			case '+':
+				output.WriteString(line.Text)
+				if !line.NoNewline {
+					output.WriteString("\n")
+				}
+			}
+		}
+
+		position = fileLine
+		offset = result.Offset
+		results = append(results, result)
+	}
+	for _, line := range lines[position:] {
+		output.WriteString(line)
+	}
+	return []byte(output.String()), results, nil
+}
+
+// locateHunk finds the line where the old side of a hunk matches the file. It returns the result, the index of the
+// first matched line and the number of context lines dropped at the start and end of the hunk.
+func locateHunk(lines []string, hunk Hunk, minimum, offset, fuzz int) (HunkResult, int, [2]int, bool) {
+	leading, trailing := 0, 0
+	for leading < len(hunk.Lines) && hunk.Lines[leading].Op == ' ' {
+		leading++
+	}
+	for trailing < len(hunk.Lines)-leading && hunk.Lines[len(hunk.Lines)-1-trailing].Op == ' ' {
+		trailing++
+	}
+
+	for level := 0; level <= fuzz; level++ {
+		skip := [2]int{min(level, leading), min(level, trailing)}
+		if level > 0 && skip[0] < level && skip[1] < level {
+			break
+		}
+		var old []PatchLine
+		for _, line := range hunk.Lines[skip[0] : len(hunk.Lines)-skip[1]] {
+			if line.Op != '+' {
+				old = append(old, line)
+			}
+		}
+
+		// Line numbers are 1-based, a hunk without old lines inserts after OldStart
+		expected := hunk.OldStart - 1 + skip[0] + offset
+		if hunk.OldLines == 0 {
+			expected = hunk.OldStart + offset
+		}
+		for distance := 0; ; distance++ {
+			before, after := expected-distance, expected+distance
+			if before < minimum && after > len(lines)-len(old) {
+				break
+			}
+			for _, start := range []int{before, after} {
+				if start >= minimum && start <= len(lines)-len(old) && hunkMatches(lines[start:], old) {
+					header := hunk.OldStart - 1 + skip[0]
+					if hunk.OldLines == 0 {
+						header = hunk.OldStart
+					}
+					return HunkResult{Line: start + 1, Offset: start - header, Fuzz: level}, start, skip, true
+				}
+				if distance == 0 {
+					break
+				}
+			}
+		}
+	}
+	return HunkResult{}, 0, [2]int{}, false
+}
+
+// hunkMatches reports whether lines start with the old side of a hunk, ignoring line breaks.
+func hunkMatches(lines []string, old []PatchLine) bool {
+	for i, line := range old {
+		if strings.TrimSuffix(lines[i], "\n") != line.Text {
+			return false
+		}
+	}
+	return true
+}
+
+// splitLines splits text into lines that keep their line break.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffContext is the number of unchanged lines UnifiedDiff shows around each change.
+const diffContext = 3
+
+// diffOp is one line of an edit script: ' ' keeps, '-' removes and '+' adds a line.
+type diffOp struct {
+	op   byte
+	line string
+}
+
+// UnifiedDiff returns a unified diff turning oldContent into newContent, or "" if both are equal.
+// An empty oldName or newName is written as /dev/null, as for created and deleted files.
+func UnifiedDiff(oldName, newName string, oldContent, newContent []byte) string {
+	ops := diffLines(splitLines(string(oldContent)), splitLines(string(newContent)))
+
+	// Collect the ranges of ops that are changes, merging ranges with little context in between
+	var ranges [][2]int
+	for i, op := range ops {
+		if op.op == ' ' {
+			continue
+		}
+		if len(ranges) > 0 && i-ranges[len(ranges)-1][1] <= 2*diffContext {
+			ranges[len(ranges)-1][1] = i + 1
+		} else {
+			ranges = append(ranges, [2]int{i, i + 1})
+		}
+	}
+	if len(ranges) == 0 {
+		return ""
+	}
+
+	var diff strings.Builder
+	fmt.Fprintf(&diff, "--- %s\n+++ %s\n", diffName("a/", oldName), diffName("b/", newName))
+
+	oldLine, newLine, next := 0, 0, 0
+	for _, changes := range ranges {
+		start := max(changes[0]-diffContext, 0)
+		end := min(changes[1]+diffContext, len(ops))
+
+		// Advance the line counters to the start of the hunk
+		for ; next < start; next++ {
+			oldLine, newLine = advanceDiffLines(ops[next].op, oldLine, newLine)
+		}
+		hunkOld, hunkNew := oldLine, newLine
+
+		var body strings.Builder
+		for ; next < end; next++ {
+			op := ops[next]
+			oldLine, newLine = advanceDiffLines(op.op, oldLine, newLine)
+			body.WriteByte(op.op)
+			body.WriteString(strings.TrimSuffix(op.line, "\n"))
+			body.WriteString("\n")
+			if !strings.HasSuffix(op.line, "\n") {
+				body.WriteString("\\ No newline at end of file\n")
+			}
+		}
+
+		fmt.Fprintf(&diff, "@@ -%s +%s @@\n", hunkRange(hunkOld, oldLine-hunkOld), hunkRange(hunkNew, newLine-hunkNew))
+		diff.WriteString(body.String())
+	}
+	return diff.String()
+}
+
+// diffName prefixes a path for a ---/+++ header line.
+func diffName(prefix, name string) string {
+	if name == "" {
+		return devNull
+	}
+	return prefix + name
+}
+
+// hunkRange formats the start and length of one side of a hunk header, "3,0" for an empty side after line 3.
+func hunkRange(start, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if length == 1 {
+		return strconv.Itoa(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
+
+// advanceDiffLines moves the old and new line counters past an op.
+func advanceDiffLines(op byte, oldLine, newLine int) (int, int) {
+	switch op {
+	case ' ':
+		return oldLine + 1, newLine + 1
+	case '-':
+		return oldLine + 1, newLine
+	default:
+		return oldLine, newLine + 1
+	}
+}
+
+// diffLines computes the shortest edit script from a to b with the linear space variant of the Myers algorithm,
+// so that previews of large rewritten files do not need memory quadratic in their length.
+func diffLines(a, b []string) []diffOp {
+	var ops []diffOp
+	compareLines(a, b, &ops)
+	return ops
+}
+
+// compareLines appends the edit script from a to b to ops. Common leading and trailing lines are kept as they are,
+// the rest is split at the middle snake of a shortest edit script and both halves are compared in turn.
+func compareLines(a, b []string, ops *[]diffOp) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	appendDiffOps(ops, ' ', a[:prefix])
+	a, b = a[prefix:], b[prefix:]
+
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	common := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	switch {
+	case len(a) == 0:
+		appendDiffOps(ops, '+', b)
+	case len(b) == 0:
+		appendDiffOps(ops, '-', a)
+	default:
+		x, y, u, v := middleSnake(a, b)
+		compareLines(a[:x], b[:y], ops)
+		appendDiffOps(ops, ' ', a[x:u])
+		compareLines(a[u:], b[v:], ops)
+	}
+	appendDiffOps(ops, ' ', common)
+}
+
+// appendDiffOps appends one operation per line.
+func appendDiffOps(ops *[]diffOp, op byte, lines []string) {
+	for _, line := range lines {
+		*ops = append(*ops, diffOp{op, line})
+	}
+}
+
+// middleSnake returns the middle snake of a shortest edit script from a to b, the run of equal lines from (x, y)
+// to (u, v) that the script passes halfway. It searches forward from the start and backward from the end at once,
+// keeping only the furthest point of each diagonal; the backward search runs on the reversed lines.
+func middleSnake(a, b []string) (x, y, u, v int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	limit := (n + m + 1) / 2
+	offset := limit + 1
+	forward := make([]int, 2*offset+1)
+	backward := make([]int, 2*offset+1)
+
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			x0 := furthestOnDiagonal(forward, offset, k, d)
+			x := x0
+			for x < n && x-k < m && a[x] == b[x-k] {
+				x++
+			}
+			forward[offset+k] = x
+			// The backward search has done d-1 steps; its diagonal k' meets the forward diagonal k
+			if reverse := delta - k; odd && reverse >= -(d-1) && reverse <= d-1 && x+backward[offset+reverse] >= n {
+				return x0, x0 - k, x, x - k
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			x0 := furthestOnDiagonal(backward, offset, k, d)
+			x := x0
+			for x < n && x-k < m && a[n-1-x] == b[m-1-(x-k)] {
+				x++
+			}
+			backward[offset+k] = x
+			if ahead := delta - k; !odd && ahead >= -d && ahead <= d && x+forward[offset+ahead] >= n {
+				return n - x, m - (x - k), n - x0, m - (x0 - k)
+			}
+		}
+	}
+	panic("middleSnake: no overlap between the forward and backward search")
+}
+
+// furthestOnDiagonal returns the x a search step d starts from on diagonal k: one line further down from
+// diagonal k+1 or one line further right from diagonal k-1, whichever got further.
+func furthestOnDiagonal(frontier []int, offset, k, d int) int {
+	if k == -d || (k != d && frontier[offset+k-1] < frontier[offset+k+1]) {
+		return frontier[offset+k+1]
+	}
+	return frontier[offset+k-1] + 1
+}
@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var scriptChangelogPattern = regexp.MustCompile(`^--\s*@changelog\s+(\S+)\s+(.+?)\s*$`)
+
+// ChangelogEntry is a single "-- @changelog <version> <text>" header line of a script.
+type ChangelogEntry struct {
+	Version string `json:"version"`
+	Text    string `json:"text"`
+}
+
+// CompareVersions compares two dotted version strings numerically (e.g. "1.10.0" > "1.9.2").
+// It returns -1 if a < b, 0 if a == b and 1 if a > b. Missing components count as zero and
+// non-numeric components are compared as strings.
+func CompareVersions(a, b string) int {
+	partsA := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	partsB := strings.Split(strings.TrimPrefix(b, "v"), ".")
+
+	for i := 0; i < len(partsA) || i < len(partsB); i++ {
+		partA, partB := "0", "0"
+		if i < len(partsA) {
+			partA = partsA[i]
+		}
+		if i < len(partsB) {
+			partB = partsB[i]
+		}
+
+		numberA, errA := strconv.Atoi(partA)
+		numberB, errB := strconv.Atoi(partB)
+		switch {
+		case errA == nil && errB == nil && numberA != numberB:
+			if numberA < numberB {
+				return -1
+			}
+			return 1
+		case (errA != nil || errB != nil) && partA != partB:
+			if partA < partB {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// ParseScriptChangelog reads the "-- @changelog" header lines from the leading comment lines of a script.
+func ParseScriptChangelog(code []byte) []ChangelogEntry {
+	var entries []ChangelogEntry
+	for _, line := range strings.Split(string(code), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		if match := scriptChangelogPattern.FindStringSubmatch(line); match != nil {
+			entries = append(entries, ChangelogEntry{Version: match[1], Text: match[2]})
+		}
+	}
+	return entries
+}
+
+// ChangelogBetween returns the changelog entries newer than fromVersion and not newer than toVersion.
+func ChangelogBetween(entries []ChangelogEntry, fromVersion, toVersion string) []ChangelogEntry {
+	var selected []ChangelogEntry
+	for _, entry := range entries {
+		if CompareVersions(entry.Version, fromVersion) > 0 && CompareVersions(entry.Version, toVersion) <= 0 {
+			selected = append(selected, entry)
+		}
+	}
+	return selected
+}
+
+// versionConstraintPattern matches one constraint of a version range, e.g. ">=1.1" or "2.0".
+var versionConstraintPattern = regexp.MustCompile(`^(>=|<=|>|<|=)?\s*v?([0-9]+(?:\.[0-9]+)*)$`)
+
+// versionConstraint compares a version against a bound. Without operator, the version must start with the
+// bound's components, so "1.1" matches 1.1.0 and 1.1.110 but not 1.10.
+type versionConstraint struct {
+	operator string
+	version  string
+}
+
+// matches reports whether version satisfies the constraint.
+func (c versionConstraint) matches(version string) bool {
+	if c.operator == "" {
+		bound := strings.Split(c.version, ".")
+		parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+		if len(parts) < len(bound) {
+			return CompareVersions(version, c.version) == 0
+		}
+		return CompareVersions(strings.Join(parts[:len(bound)], "."), c.version) == 0
+	}
+
+	comparison := CompareVersions(version, c.version)
+	switch c.operator {
+	case ">=":
+		return comparison >= 0
+	case "<=":
+		return comparison <= 0
+	case ">":
+		return comparison > 0
+	case "<":
+		return comparison < 0
+	}
+	return comparison == 0
+}
+
+// VersionRange is a set of versions written as alternatives separated by "||" or commas, each alternative being
+// constraints separated by spaces that must all hold: "1.1, 2.0", ">=1.1 <2.1" or ">=2.0".
+type VersionRange struct {
+	expression   string
+	alternatives [][]versionConstraint
+}
+
+// ParseVersionRange parses a version range. An empty expression matches every version.
+func ParseVersionRange(expression string) (VersionRange, error) {
+	versionRange := VersionRange{expression: strings.TrimSpace(expression)}
+	if versionRange.expression == "" {
+		return versionRange, nil
+	}
+
+	normalized := strings.ReplaceAll(versionRange.expression, "||", ",")
+	for _, alternative := range strings.Split(normalized, ",") {
+		// Allow a space between operator and version, e.g. ">= 1.1"
+		fields := strings.Fields(alternative)
+		var constraints []versionConstraint
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			if strings.Trim(field, "<>=") == "" && i+1 < len(fields) {
+				i++
+				field += fields[i]
+			}
+			match := versionConstraintPattern.FindStringSubmatch(field)
+			if match == nil {
+				return VersionRange{}, fmt.Errorf("invalid version constraint '%s' in '%s'", field, expression)
+			}
+			constraints = append(constraints, versionConstraint{operator: match[1], version: match[2]})
+		}
+		if len(constraints) == 0 {
+			return VersionRange{}, fmt.Errorf("empty alternative in version range '%s'", expression)
+		}
+		versionRange.alternatives = append(versionRange.alternatives, constraints)
+	}
+	return versionRange, nil
+}
+
+// Contains reports whether version lies in the range.
+func (r VersionRange) Contains(version string) bool {
+	if len(r.alternatives) == 0 {
+		return true
+	}
+	for _, constraints := range r.alternatives {
+		matched := true
+		for _, constraint := range constraints {
+			if !constraint.matches(version) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Empty reports whether the range places no restriction on the version.
+func (r VersionRange) Empty() bool {
+	return len(r.alternatives) == 0
+}
+
+// String returns the range as it was written.
+func (r VersionRange) String() string {
+	return r.expression
+}
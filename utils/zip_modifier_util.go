@@ -8,13 +8,66 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
+// ZipChanges collects the files to write and the files to delete in a single rewrite of a ZIP archive.
+type ZipChanges struct {
+	Modified map[string][]byte // new content by file name; files not yet in the archive are added
+	Removed  []string          // files to drop from the archive
+}
+
+// NewZipChanges returns an empty change set.
+func NewZipChanges() ZipChanges {
+	return ZipChanges{Modified: make(map[string][]byte)}
+}
+
+// Empty reports whether the change set would leave the archive unchanged.
+func (c ZipChanges) Empty() bool {
+	return len(c.Modified) == 0 && len(c.Removed) == 0
+}
+
+// Merge adds the changes of other on top of c. Later content wins, and a file written again is no longer removed.
+func (c *ZipChanges) Merge(other ZipChanges) {
+	for name, content := range other.Modified {
+		c.Modified[name] = content
+	}
+	kept := c.Removed[:0]
+	for _, name := range c.Removed {
+		if _, written := other.Modified[name]; !written {
+			kept = append(kept, name)
+		}
+	}
+	c.Removed = kept
+	for _, name := range other.Removed {
+		delete(c.Modified, name)
+		c.Removed = append(c.Removed, name)
+	}
+}
+
+// ApplyTo updates an in-memory copy of archive files, keyed by name, with the changes.
+func (c ZipChanges) ApplyTo(files map[string][]byte) {
+	for name, content := range c.Modified {
+		files[name] = content
+	}
+	for _, name := range c.Removed {
+		delete(files, name)
+	}
+}
+
 // ModifyZipFile modifies or replaces files in a ZIP archive.
+// Files in modifiedFiles that do not exist in the archive yet are added at the end.
 func ModifyZipFile(zipPath string, modifiedFiles map[string][]byte, outputZipPath string) error {
+	return RewriteZipFile(zipPath, ZipChanges{Modified: modifiedFiles}, outputZipPath)
+}
+
+// RewriteZipFile writes a copy of a ZIP archive with the given changes applied.
+func RewriteZipFile(zipPath string, changes ZipChanges, outputZipPath string) error {
 	log.Info().
 		Str("zipPath", zipPath).
+		Int("modifiedCount", len(changes.Modified)).
+		Int("removedCount", len(changes.Removed)).
 		Msg("Starting ZIP modification")
 
 	// Open the original ZIP file
@@ -28,11 +81,23 @@ func ModifyZipFile(zipPath string, modifiedFiles map[string][]byte, outputZipPat
 	}
 	defer originalZip.Close()
 
+	removed := make(map[string]bool, len(changes.Removed))
+	for _, name := range changes.Removed {
+		removed[name] = true
+	}
+
 	var buf bytes.Buffer
 	newZip := zip.NewWriter(&buf)
+	written := make(map[string]bool)
 
 	for _, file := range originalZip.File {
-		if newContent, exists := modifiedFiles[file.Name]; exists {
+		if removed[file.Name] {
+			log.Debug().
+				Str("fileName", file.Name).
+				Msg("Removing file from ZIP")
+			continue
+		}
+		if newContent, exists := changes.Modified[file.Name]; exists {
 			log.Debug().
 				Str("fileName", file.Name).
 				Msg("Replacing file with new content")
@@ -43,6 +108,7 @@ func ModifyZipFile(zipPath string, modifiedFiles map[string][]byte, outputZipPat
 					Msg("Failed to add modified file to ZIP")
 				return fmt.Errorf("failed to add modified file '%s': %w", file.Name, err)
 			}
+			written[file.Name] = true
 		} else {
 			log.Trace().
 				Str("fileName", file.Name).
@@ -57,6 +123,23 @@ func ModifyZipFile(zipPath string, modifiedFiles map[string][]byte, outputZipPat
 		}
 	}
 
+	// Add files that did not exist in the original ZIP
+	for _, fileName := range SortedFileNames(changes.Modified) {
+		if written[fileName] || removed[fileName] {
+			continue
+		}
+		log.Debug().
+			Str("fileName", fileName).
+			Msg("Adding new file to ZIP")
+		if err := AddFileToZip(newZip, fileName, changes.Modified[fileName]); err != nil {
+			log.Error().
+				Err(err).
+				Str("fileName", fileName).
+				Msg("Failed to add new file to ZIP")
+			return fmt.Errorf("failed to add new file '%s': %w", fileName, err)
+		}
+	}
+
 	if err := newZip.Close(); err != nil {
 		log.Error().Err(err).Msg("Failed to close ZIP writer")
 		return fmt.Errorf("failed to close ZIP writer: %w", err)
@@ -120,6 +203,11 @@ func AppendToFileInZip(zipPath, fileName, newCode, outputZipPath string) error {
 
 			// Append the new code to the content
 			modifiedContent := append(originalContent, []byte("\n"+newCode+"\n")...)
+			if IsLuaFile(fileName) && CheckLuaSyntax(fileName, originalContent) == nil {
+				if err := CheckLuaSyntax(fileName, modifiedContent); err != nil {
+					return fmt.Errorf("appending to '%s' would break its Lua code: %w", fileName, err)
+				}
+			}
 
 			// Add the modified file to the new ZIP
 			if err := AddFileToZip(newZip, fileName, modifiedContent); err != nil {
@@ -379,3 +467,139 @@ func FindFileInZip(zipPath, targetFileName string) (string, error) {
 		Msg("File not found in ZIP")
 	return "", fmt.Errorf("file '%s' not found in ZIP", targetFileName)
 }
+
+// ResolveFileInZip returns the archive path of a file given by its path inside the save. The path may include
+// the save's top-level folder ("MySave/control.lua") or omit it ("control.lua", "scenario/freeplay.lua").
+// Unlike FindFileInZip, the path must name exactly one file.
+func ResolveFileInZip(zipPath, pathInSave string) (string, error) {
+	names, err := ListFilesInZip(zipPath)
+	if err != nil {
+		return "", err
+	}
+	return resolvePathInSave(names, pathInSave)
+}
+
+// ListFilesInZip returns the names of all entries of a ZIP archive in archive order.
+func ListFilesInZip(zipPath string) ([]string, error) {
+	zipReader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("zipPath", zipPath).
+			Msg("Failed to open ZIP file")
+		return nil, fmt.Errorf("failed to open ZIP file: %w", err)
+	}
+	defer zipReader.Close()
+
+	names := make([]string, 0, len(zipReader.File))
+	for _, file := range zipReader.File {
+		names = append(names, file.Name)
+	}
+	return names, nil
+}
+
+// resolvePathInSave finds the one entry name that equals pathInSave or ends with "/" + pathInSave.
+func resolvePathInSave(names []string, pathInSave string) (string, error) {
+	pathInSave = strings.TrimPrefix(pathInSave, "/")
+	var candidates []string
+	for _, name := range names {
+		if name == pathInSave {
+			return name, nil
+		}
+		if strings.HasSuffix(name, "/"+pathInSave) {
+			candidates = append(candidates, name)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("file '%s' not found in ZIP", pathInSave)
+	case 1:
+		log.Debug().
+			Str("pathInSave", pathInSave).
+			Str("fileName", candidates[0]).
+			Msg("Resolved file in ZIP")
+		return candidates[0], nil
+	default:
+		return "", fmt.Errorf("'%s' matches several files in ZIP (%s), use a longer path", pathInSave, strings.Join(candidates, ", "))
+	}
+}
+
+// ReadFileFromZip reads the content of the named file inside a ZIP archive.
+func ReadFileFromZip(zipPath, fileName string) ([]byte, error) {
+	log.Debug().
+		Str("zipPath", zipPath).
+		Str("fileName", fileName).
+		Msg("Reading file from ZIP")
+
+	zipReader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("zipPath", zipPath).
+			Msg("Failed to open ZIP file")
+		return nil, fmt.Errorf("failed to open ZIP file: %w", err)
+	}
+	defer zipReader.Close()
+
+	for _, file := range zipReader.File {
+		if file.Name == fileName {
+			return ReadZipFile(file)
+		}
+	}
+
+	log.Warn().
+		Str("fileName", fileName).
+		Msg("File not found in ZIP archive")
+	return nil, fmt.Errorf("file '%s' not found in ZIP", fileName)
+}
+
+// ReadFilesFromZip reads every file inside a ZIP archive whose name is accepted by the match function.
+// The returned map is keyed by the full path of the file within the archive.
+func ReadFilesFromZip(zipPath string, match func(name string) bool) (map[string][]byte, error) {
+	log.Debug().
+		Str("zipPath", zipPath).
+		Msg("Reading matching files from ZIP")
+
+	zipReader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("zipPath", zipPath).
+			Msg("Failed to open ZIP file")
+		return nil, fmt.Errorf("failed to open ZIP file: %w", err)
+	}
+	defer zipReader.Close()
+
+	files := make(map[string][]byte)
+	for _, file := range zipReader.File {
+		if file.FileInfo().IsDir() || !match(file.Name) {
+			continue
+		}
+		content, err := ReadZipFile(file)
+		if err != nil {
+			return nil, err
+		}
+		files[file.Name] = content
+	}
+
+	log.Debug().
+		Int("fileCount", len(files)).
+		Msg("Read matching files from ZIP")
+	return files, nil
+}
+
+// IsLuaFile reports whether a ZIP entry name refers to a Lua source file.
+func IsLuaFile(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".lua")
+}
+
+// SortedFileNames returns the keys of a file map in lexical order so files are visited reproducibly.
+func SortedFileNames(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}